@@ -0,0 +1,71 @@
+// filekeys обслуживает шифрование загруженных файлов:
+//
+//	filekeys generate  — печатает новый мастер-ключ;
+//	filekeys rotate    — приводит все файлы к текущему ключу APP_FILE_MASTER_KEY(_FILE),
+//	                     используя прежние ключи из APP_FILE_PREVIOUS_KEYS(_FILES).
+//
+// Настройки читаются из тех же переменных окружения, что и у сервера.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/config"
+	"github.com/mvd/taskflow/internal/db"
+	"github.com/mvd/taskflow/internal/filecrypt"
+	"github.com/mvd/taskflow/internal/models"
+	"github.com/mvd/taskflow/internal/repo"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: filekeys generate|rotate")
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "generate":
+		key, err := filecrypt.GenerateKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+	case "rotate":
+		result, err := rotate(context.Background(), config.Load())
+		if err != nil {
+			log.Fatalf("rotate: %v", err)
+		}
+		log.Printf("rotate: rotated %d, already current %d, missing %d, failed %d", result.Rotated, result.Current, result.Missing, result.Failed)
+		if result.Failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: filekeys generate|rotate")
+		os.Exit(2)
+	}
+}
+
+func rotate(ctx context.Context, cfg config.Config) (models.FileKeyRotationResult, error) {
+	files, err := filecrypt.LoadKeyring(cfg.FileMasterKey, cfg.FileMasterKeyFile, cfg.FilePreviousKeys, cfg.FilePreviousKeyFiles)
+	if err != nil {
+		return models.FileKeyRotationResult{}, fmt.Errorf("file encryption init: %w", err)
+	}
+	if files == nil {
+		return models.FileKeyRotationResult{}, errors.New("set APP_FILE_MASTER_KEY or APP_FILE_MASTER_KEY_FILE")
+	}
+
+	// Утилита только читает пути файлов: база открывается без миграций и начальных данных,
+	// чтобы ротация не меняла схему в обход обновления сервера.
+	sqlDB, err := db.OpenExisting(cfg.DBPath)
+	if err != nil {
+		return models.FileKeyRotationResult{}, fmt.Errorf("db init: %w", err)
+	}
+	defer sqlDB.Close()
+
+	repository := repo.New(sqlDB, nil, auth.PasswordPolicy{}, nil, files)
+	return repository.RotateFileKeys(ctx)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/config"
+	"github.com/mvd/taskflow/internal/db"
+	"github.com/mvd/taskflow/internal/filecrypt"
+)
+
+func TestRotateReencryptsStoredFiles(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "taskflow.db")
+	passwords := auth.NewPasswordHasher("test-pepper", auth.Argon2Params{Memory: 1024, Time: 1, Threads: 1})
+	sqlDB, err := db.Open(dbPath, passwords)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer sqlDB.Close()
+
+	oldKey := bytes.Repeat([]byte{1}, filecrypt.KeySize)
+	newKey := bytes.Repeat([]byte{2}, filecrypt.KeySize)
+	oldRing, err := filecrypt.NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("old keyring: %v", err)
+	}
+	content := bytes.Repeat([]byte("отчет "), 20000)
+	filePath := filepath.Join(dir, "report.bin")
+	if err := oldRing.WriteFile(filePath, content, 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := sqlDB.Exec(`INSERT INTO reports (target_type, target_id, author_user_id, title, resolution, file_name, file_path, file_type)
+VALUES ('project', 1, 1, 'Отчет', 'Готово', 'report.txt', ?, 'text/plain')`, filePath); err != nil {
+		t.Fatalf("insert report: %v", err)
+	}
+	// Ротация не мигрирует базу: удаленные начальные данные не должны вернуться.
+	if _, err := sqlDB.Exec(`DELETE FROM department_positions`); err != nil {
+		t.Fatalf("clear department positions: %v", err)
+	}
+
+	cfg := config.Config{
+		DBPath:           dbPath,
+		FileMasterKey:    base64.StdEncoding.EncodeToString(newKey),
+		FilePreviousKeys: []string{base64.StdEncoding.EncodeToString(oldKey)},
+	}
+	result, err := rotate(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if result.Rotated != 1 || result.Failed != 0 {
+		t.Fatalf("rotate result = %+v, want 1 rotated", result)
+	}
+	if result, err := rotate(context.Background(), cfg); err != nil || result.Current != 1 || result.Rotated != 0 {
+		t.Fatalf("second rotate = %+v, %v; want 1 already current", result, err)
+	}
+
+	newRing, err := filecrypt.NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("new keyring: %v", err)
+	}
+	f, err := newRing.Open(filePath)
+	if err != nil {
+		t.Fatalf("open rotated file with new key: %v", err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("rotated file content differs: %v", err)
+	}
+
+	var positions int
+	if err := sqlDB.QueryRow(`SELECT COUNT(*) FROM department_positions`).Scan(&positions); err != nil {
+		t.Fatalf("count department positions: %v", err)
+	}
+	if positions != 0 {
+		t.Fatalf("rotate re-seeded the database: %d department positions", positions)
+	}
+}
+
+func TestRotateRequiresExistingDatabase(t *testing.T) {
+	cfg := config.Config{
+		DBPath:        filepath.Join(t.TempDir(), "missing.db"),
+		FileMasterKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, filecrypt.KeySize)),
+	}
+	if _, err := rotate(context.Background(), cfg); err == nil {
+		t.Fatal("rotate created a new database instead of failing")
+	}
+}
@@ -1,29 +1,218 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/certs"
 	"github.com/mvd/taskflow/internal/config"
 	"github.com/mvd/taskflow/internal/db"
+	"github.com/mvd/taskflow/internal/filecrypt"
 	"github.com/mvd/taskflow/internal/httpapi"
 	"github.com/mvd/taskflow/internal/repo"
+	"github.com/mvd/taskflow/internal/scan"
 )
 
 func main() {
 	cfg := config.Load()
 
-	sqlDB, err := db.Open(cfg.DBPath)
+	passwords := auth.NewPasswordHasher(cfg.AuthPepper, auth.Argon2Params{
+		Memory:  cfg.Argon2Memory,
+		Time:    cfg.Argon2Time,
+		Threads: cfg.Argon2Threads,
+	})
+
+	sqlDB, err := db.Open(cfg.DBPath, passwords)
 	if err != nil {
 		log.Fatalf("db init: %v", err)
 	}
 	defer sqlDB.Close()
 
-	repository := repo.New(sqlDB, cfg.AuthPepper)
-	server := httpapi.New(repository, cfg.StaticPath)
+	var directory *auth.LDAPDirectory
+	if cfg.LDAPURL != "" {
+		directory, err = auth.NewLDAPDirectory(auth.LDAPConfig{
+			URL:                 cfg.LDAPURL,
+			BindDN:              cfg.LDAPBindDN,
+			BindPassword:        cfg.LDAPBindPassword,
+			BaseDN:              cfg.LDAPBaseDN,
+			UserFilter:          cfg.LDAPUserFilter,
+			LoginAttr:           cfg.LDAPLoginAttr,
+			NameAttr:            cfg.LDAPNameAttr,
+			PositionAttr:        cfg.LDAPPositionAttr,
+			GroupAttr:           cfg.LDAPGroupAttr,
+			StartTLS:            cfg.LDAPStartTLS,
+			InsecureSkipVerify:  cfg.LDAPInsecureSkipVerify,
+			DepartmentMap:       cfg.LDAPDepartmentMap,
+			DefaultDepartmentID: cfg.LDAPDefaultDepartmentID,
+		})
+		if err != nil {
+			log.Fatalf("ldap init: %v", err)
+		}
+	}
+
+	policy := auth.PasswordPolicy{
+		MinLength:   cfg.PasswordMinLength,
+		MinClasses:  cfg.PasswordMinClasses,
+		CheckCommon: cfg.PasswordCheckCommon,
+	}
+	files, err := filecrypt.LoadKeyring(cfg.FileMasterKey, cfg.FileMasterKeyFile, cfg.FilePreviousKeys, cfg.FilePreviousKeyFiles)
+	if err != nil {
+		log.Fatalf("file encryption init: %v", err)
+	}
+	repository := repo.New(sqlDB, passwords, policy, directory, files)
+	if directory != nil {
+		go runDirectorySync(repository, cfg.LDAPSyncInterval)
+	}
+	go runTrashPurge(repository, cfg.TrashRetention)
+	var oidcProvider *auth.OIDCProvider
+	if cfg.OIDCIssuer != "" {
+		oidcProvider, err = auth.NewOIDCProvider(auth.OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			Claims: auth.OIDCClaimMapping{
+				Login:      cfg.OIDCLoginClaim,
+				FullName:   cfg.OIDCNameClaim,
+				Department: cfg.OIDCDepartmentClaim,
+				Position:   cfg.OIDCPositionClaim,
+			},
+		})
+		if err != nil {
+			log.Fatalf("oidc init: %v", err)
+		}
+	}
+
+	access, err := repository.AccessPolicy(context.Background())
+	if err != nil {
+		log.Fatalf("load roles: %v", err)
+	}
+
+	var scanner scan.Scanner
+	if cfg.ClamdAddr != "" {
+		scanner, err = scan.NewClamd(cfg.ClamdAddr, cfg.ScanTimeout)
+		if err != nil {
+			log.Fatalf("clamd init: %v", err)
+		}
+	}
+
+	server := httpapi.New(repository, cfg, oidcProvider, access, scanner)
+
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		log.Printf("TaskFlow started at %s", cfg.Addr)
+		if err := http.ListenAndServe(cfg.Addr, server.Handler()); err != nil {
+			log.Fatalf("listen: %v", err)
+		}
+		return
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		log.Fatalf("tls init: APP_TLS_CERT_FILE and APP_TLS_KEY_FILE must be set together")
+	}
+	reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		log.Fatalf("tls init: %v", err)
+	}
+	go reloader.Watch(cfg.TLSReloadInterval, nil)
+	go reloadOnSIGHUP(reloader)
+	if cfg.TLSRedirectAddr != "" {
+		go func() {
+			log.Printf("HTTP redirect started at %s", cfg.TLSRedirectAddr)
+			if err := http.ListenAndServe(cfg.TLSRedirectAddr, httpsRedirect(cfg.Addr)); err != nil {
+				log.Fatalf("listen redirect: %v", err)
+			}
+		}()
+	}
 
-	log.Printf("TaskFlow started at %s", cfg.Addr)
-	if err := http.ListenAndServe(cfg.Addr, server.Handler()); err != nil {
+	httpsServer := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: server.Handler(),
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		},
+	}
+	log.Printf("TaskFlow started at %s (https)", cfg.Addr)
+	if err := httpsServer.ListenAndServeTLS("", ""); err != nil {
 		log.Fatalf("listen: %v", err)
 	}
 }
+
+// reloadOnSIGHUP перечитывает сертификат по SIGHUP, например из хука certbot.
+func reloadOnSIGHUP(reloader *certs.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("tls reload: %v", err)
+			continue
+		}
+		log.Printf("tls reload: certificate reloaded on SIGHUP")
+	}
+}
+
+// httpsRedirect отправляет все запросы на тот же хост и путь по HTTPS. Порт берется из
+// адреса HTTPS-слушателя и опускается, если он стандартный.
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+}
+
+func runDirectorySync(repository *repo.Repository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		result, err := repository.SyncDirectory(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("ldap sync: %v", err)
+		} else {
+			log.Printf("ldap sync: created %d, updated %d, deactivated %d, skipped %d", result.Created, result.Updated, result.Deactivated, result.Skipped)
+		}
+		<-ticker.C
+	}
+}
+
+// runTrashPurge раз в час окончательно удаляет то, что лежит в корзине дольше retention.
+func runTrashPurge(repository *repo.Repository, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		purged, files, err := repository.PurgeExpiredTrash(ctx, retention)
+		cancel()
+		for _, path := range files {
+			_ = os.Remove(path)
+		}
+		if err != nil {
+			log.Printf("trash purge: %v", err)
+		} else if purged > 0 {
+			log.Printf("trash purge: removed %d items", purged)
+		}
+		<-ticker.C
+	}
+}
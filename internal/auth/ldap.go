@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrDirectoryInvalidCredentials = errors.New("invalid directory credentials")
+	ErrDirectoryUserNotFound       = errors.New("directory user not found")
+)
+
+type LDAPConfig struct {
+	URL                string
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	LoginAttr          string
+	NameAttr           string
+	PositionAttr       string
+	GroupAttr          string
+	StartTLS           bool
+	InsecureSkipVerify bool
+
+	// DepartmentMap — правила вида "OU=Support,DC=corp,DC=local:3;CN=Developers,OU=Groups,DC=corp,DC=local:2".
+	// Ключ сравнивается с хвостом DN пользователя (OU) и с его группами.
+	DepartmentMap       string
+	DefaultDepartmentID int64
+}
+
+type DirectoryUser struct {
+	DN           string
+	Login        string
+	FullName     string
+	Position     string
+	DepartmentID int64
+}
+
+type departmentRule struct {
+	key          string
+	departmentID int64
+}
+
+type LDAPDirectory struct {
+	cfg   LDAPConfig
+	rules []departmentRule
+}
+
+func NewLDAPDirectory(cfg LDAPConfig) (*LDAPDirectory, error) {
+	if strings.TrimSpace(cfg.URL) == "" {
+		return nil, errors.New("ldap url is empty")
+	}
+	if strings.TrimSpace(cfg.BaseDN) == "" {
+		return nil, errors.New("ldap base dn is empty")
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, errors.New("ldap user filter must contain %s")
+	}
+	rules, err := parseDepartmentMap(cfg.DepartmentMap)
+	if err != nil {
+		return nil, err
+	}
+	return &LDAPDirectory{cfg: cfg, rules: rules}, nil
+}
+
+func parseDepartmentMap(raw string) ([]departmentRule, error) {
+	rules := make([]departmentRule, 0)
+	for _, item := range strings.Split(raw, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sep := strings.LastIndex(item, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("ldap department map: invalid rule %q", item)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(item[sep+1:]), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("ldap department map: invalid department id in %q", item)
+		}
+		rules = append(rules, departmentRule{key: normalizeDN(item[:sep]), departmentID: id})
+	}
+	return rules, nil
+}
+
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (d *LDAPDirectory) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.cfg.InsecureSkipVerify}
+	conn, err := ldap.DialURL(d.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	if d.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *LDAPDirectory) attributes() []string {
+	attrs := []string{d.cfg.LoginAttr, d.cfg.NameAttr, d.cfg.PositionAttr}
+	if d.cfg.GroupAttr != "" {
+		attrs = append(attrs, d.cfg.GroupAttr)
+	}
+	return attrs
+}
+
+// Authenticate ищет пользователя сервисной учетной записью и проверяет пароль bind'ом от его DN.
+func (d *LDAPDirectory) Authenticate(login, password string) (DirectoryUser, error) {
+	login = strings.TrimSpace(login)
+	// Пустой пароль в LDAP означает анонимный bind, который сервер может принять.
+	if login == "" || password == "" {
+		return DirectoryUser{}, ErrDirectoryInvalidCredentials
+	}
+	conn, err := d.connect()
+	if err != nil {
+		return DirectoryUser{}, err
+	}
+	defer conn.Close()
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(d.cfg.UserFilter, ldap.EscapeFilter(login)),
+		d.attributes(), nil,
+	))
+	if err != nil {
+		return DirectoryUser{}, fmt.Errorf("ldap search user: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return DirectoryUser{}, ErrDirectoryUserNotFound
+	}
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return DirectoryUser{}, ErrDirectoryInvalidCredentials
+		}
+		return DirectoryUser{}, fmt.Errorf("ldap user bind: %w", err)
+	}
+	return d.directoryUser(entry), nil
+}
+
+func (d *LDAPDirectory) Users() ([]DirectoryUser, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	res, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		strings.ReplaceAll(d.cfg.UserFilter, "%s", "*"),
+		d.attributes(), nil,
+	), 500)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search users: %w", err)
+	}
+	items := make([]DirectoryUser, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		user := d.directoryUser(entry)
+		if user.Login == "" {
+			continue
+		}
+		items = append(items, user)
+	}
+	return items, nil
+}
+
+func (d *LDAPDirectory) directoryUser(entry *ldap.Entry) DirectoryUser {
+	user := DirectoryUser{
+		DN:       entry.DN,
+		Login:    strings.TrimSpace(entry.GetAttributeValue(d.cfg.LoginAttr)),
+		FullName: strings.TrimSpace(entry.GetAttributeValue(d.cfg.NameAttr)),
+		Position: strings.TrimSpace(entry.GetAttributeValue(d.cfg.PositionAttr)),
+	}
+	if user.FullName == "" {
+		user.FullName = user.Login
+	}
+	var groups []string
+	if d.cfg.GroupAttr != "" {
+		groups = entry.GetAttributeValues(d.cfg.GroupAttr)
+	}
+	user.DepartmentID = d.departmentFor(entry.DN, groups)
+	return user
+}
+
+func (d *LDAPDirectory) departmentFor(dn string, groups []string) int64 {
+	userDN := normalizeDN(dn)
+	for _, rule := range d.rules {
+		if strings.HasSuffix(userDN, ","+rule.key) {
+			return rule.departmentID
+		}
+		for _, group := range groups {
+			if normalizeDN(group) == rule.key {
+				return rule.departmentID
+			}
+		}
+	}
+	return d.cfg.DefaultDepartmentID
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testServiceDN       = "cn=svc,dc=corp,dc=local"
+	testServicePassword = "svc-secret"
+)
+
+type fakeEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeDirectory — LDAP-сервер в процессе: понимает simple bind, поиск по фильтру (uid=...)
+// и unbind. Этого хватает, чтобы проверить LDAPDirectory без настоящего каталога.
+type fakeDirectory struct {
+	t       *testing.T
+	entries []fakeEntry
+
+	mu      sync.Mutex
+	binds   []string
+	filters []string
+}
+
+func newFakeDirectory(t *testing.T, entries ...fakeEntry) (*fakeDirectory, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	d := &fakeDirectory{t: t, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d, "ldap://" + ln.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			d.mu.Lock()
+			d.binds = append(d.binds, name)
+			d.mu.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if d.checkPassword(name, password) {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResult(messageID, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				conn.Write(ldapResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			d.mu.Lock()
+			d.filters = append(d.filters, filter)
+			d.mu.Unlock()
+			for _, entry := range d.search(filter) {
+				conn.Write(ldapEntry(messageID, entry).Bytes())
+			}
+			conn.Write(ldapResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			d.t.Errorf("unexpected ldap operation %d", op.Tag)
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) checkPassword(dn, password string) bool {
+	if dn == testServiceDN {
+		return password == testServicePassword
+	}
+	for _, entry := range d.entries {
+		if entry.dn == dn {
+			return password != "" && password == entry.password
+		}
+	}
+	return false
+}
+
+// search понимает только фильтр (uid=значение); значение * — все записи.
+func (d *fakeDirectory) search(filter string) []fakeEntry {
+	value, ok := strings.CutPrefix(filter, "(uid=")
+	if !ok {
+		return nil
+	}
+	value = strings.TrimSuffix(value, ")")
+	found := make([]fakeEntry, 0)
+	for _, entry := range d.entries {
+		if value == "*" || (len(entry.attrs["uid"]) > 0 && entry.attrs["uid"][0] == value) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func (d *fakeDirectory) bindDNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.binds...)
+}
+
+func ldapEnvelope(messageID int64, op *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(op)
+	return envelope
+}
+
+func ldapResult(messageID int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return ldapEnvelope(messageID, op)
+}
+
+func ldapEntry(messageID int64, entry fakeEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range entry.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapEnvelope(messageID, op)
+}
+
+func testLDAPDirectory(t *testing.T, url string) *LDAPDirectory {
+	t.Helper()
+	d, err := NewLDAPDirectory(LDAPConfig{
+		URL:                 url,
+		BindDN:              testServiceDN,
+		BindPassword:        testServicePassword,
+		BaseDN:              "dc=corp,dc=local",
+		UserFilter:          "(uid=%s)",
+		LoginAttr:           "uid",
+		NameAttr:            "cn",
+		PositionAttr:        "title",
+		GroupAttr:           "memberOf",
+		DepartmentMap:       "OU=Support,DC=corp,DC=local:3; CN=Developers,OU=Groups,DC=corp,DC=local:2",
+		DefaultDepartmentID: 1,
+	})
+	if err != nil {
+		t.Fatalf("new ldap directory: %v", err)
+	}
+	return d
+}
+
+var testDirectoryEntries = []fakeEntry{
+	{
+		dn:       "uid=ivanov,ou=support,dc=corp,dc=local",
+		password: "ivanov-pass",
+		attrs:    map[string][]string{"uid": {"ivanov"}, "cn": {"Иванов Иван"}, "title": {"Инженер"}},
+	},
+	{
+		dn:       "uid=petrov,ou=staff,dc=corp,dc=local",
+		password: "petrov-pass",
+		attrs:    map[string][]string{"uid": {"petrov"}, "cn": {"Петров Петр"}, "memberOf": {"cn=Developers,ou=Groups,dc=corp,dc=local"}},
+	},
+	{
+		dn:       "uid=sidorov,ou=staff,dc=corp,dc=local",
+		password: "sidorov-pass",
+		attrs:    map[string][]string{"uid": {"sidorov"}},
+	},
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	fake, url := newFakeDirectory(t, testDirectoryEntries...)
+	d := testLDAPDirectory(t, url)
+
+	user, err := d.Authenticate(" ivanov ", "ivanov-pass")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	want := DirectoryUser{DN: "uid=ivanov,ou=support,dc=corp,dc=local", Login: "ivanov", FullName: "Иванов Иван", Position: "Инженер", DepartmentID: 3}
+	if user != want {
+		t.Fatalf("user = %+v, want %+v", user, want)
+	}
+	// Сначала bind сервисной учетной записью для поиска, затем bind от DN пользователя.
+	if binds := fake.bindDNs(); len(binds) != 2 || binds[0] != testServiceDN || binds[1] != want.DN {
+		t.Fatalf("binds = %v, want service bind then user bind", binds)
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     error
+	}{
+		{"wrong password", "ivanov", "wrong", ErrDirectoryInvalidCredentials},
+		{"empty password", "ivanov", "", ErrDirectoryInvalidCredentials},
+		{"unknown user", "nobody", "x", ErrDirectoryUserNotFound},
+		// Звездочка экранируется и не превращает поиск в «все пользователи».
+		{"filter injection", "*", "ivanov-pass", ErrDirectoryUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Authenticate(tt.login, tt.password); !errors.Is(err, tt.want) {
+				t.Fatalf("authenticate = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	_, url := newFakeDirectory(t, testDirectoryEntries...)
+	d := testLDAPDirectory(t, url)
+	d.cfg.BindPassword = "wrong"
+	_, err := d.Authenticate("ivanov", "ivanov-pass")
+	if err == nil || errors.Is(err, ErrDirectoryInvalidCredentials) {
+		t.Fatalf("authenticate = %v, want service bind error distinct from invalid credentials", err)
+	}
+}
+
+func TestLDAPUsers(t *testing.T) {
+	_, url := newFakeDirectory(t, testDirectoryEntries...)
+	users, err := testLDAPDirectory(t, url).Users()
+	if err != nil {
+		t.Fatalf("users: %v", err)
+	}
+	got := make(map[string]DirectoryUser, len(users))
+	for _, user := range users {
+		got[user.Login] = user
+	}
+	if len(got) != 3 {
+		t.Fatalf("users = %+v, want 3", users)
+	}
+	if got["petrov"].DepartmentID != 2 {
+		t.Errorf("petrov department = %d, want 2 by group", got["petrov"].DepartmentID)
+	}
+	if got["sidorov"].DepartmentID != 1 || got["sidorov"].FullName != "sidorov" {
+		t.Errorf("sidorov = %+v, want default department and login as name", got["sidorov"])
+	}
+}
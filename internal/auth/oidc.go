@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Claims       OIDCClaimMapping
+}
+
+// OIDCClaimMapping — имена claim'ов ID-токена, из которых берутся поля пользователя.
+type OIDCClaimMapping struct {
+	Login      string
+	FullName   string
+	Department string
+	Position   string
+}
+
+type OIDCIdentity struct {
+	Subject    string
+	Login      string
+	FullName   string
+	Department string
+	Position   string
+}
+
+type OIDCProvider struct {
+	cfg OIDCConfig
+
+	// Discovery выполняется при первом входе: IdP может быть недоступен при старте сервиса.
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCProvider(cfg OIDCConfig) (*OIDCProvider, error) {
+	if strings.TrimSpace(cfg.Issuer) == "" || strings.TrimSpace(cfg.ClientID) == "" {
+		return nil, errors.New("oidc issuer and client id are required")
+	}
+	if strings.TrimSpace(cfg.RedirectURL) == "" {
+		return nil, errors.New("oidc redirect url is required")
+	}
+	if strings.TrimSpace(cfg.Claims.Login) == "" {
+		return nil, errors.New("oidc login claim is required")
+	}
+	return &OIDCProvider{cfg: cfg}, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+// NewOIDCState возвращает state, nonce и PKCE verifier для одной попытки входа.
+func NewOIDCState() (state, nonce, verifier string, err error) {
+	if state, err = randomToken(); err != nil {
+		return "", "", "", err
+	}
+	if nonce, err = randomToken(); err != nil {
+		return "", "", "", err
+	}
+	return state, nonce, oauth2.GenerateVerifier(), nil
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate oidc state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange обменивает код на токены, проверяет подпись, issuer, audience и nonce ID-токена.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (OIDCIdentity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("oidc code exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return OIDCIdentity{}, errors.New("oidc token response has no id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("oidc verify id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return OIDCIdentity{}, errors.New("oidc nonce mismatch")
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("oidc decode claims: %w", err)
+	}
+	identity := OIDCIdentity{
+		Subject:    idToken.Issuer + "|" + idToken.Subject,
+		Login:      claimString(claims, p.cfg.Claims.Login),
+		FullName:   claimString(claims, p.cfg.Claims.FullName),
+		Department: claimString(claims, p.cfg.Claims.Department),
+		Position:   claimString(claims, p.cfg.Claims.Position),
+	}
+	if identity.Login == "" {
+		return OIDCIdentity{}, fmt.Errorf("oidc claim %q is empty", p.cfg.Claims.Login)
+	}
+	if identity.FullName == "" {
+		identity.FullName = identity.Login
+	}
+	return identity, nil
+}
+
+// claimString поддерживает вложенные claim'ы через точку ("org.department")
+// и берет первый элемент, если значение — массив.
+func claimString(claims map[string]any, name string) string {
+	if name == "" {
+		return ""
+	}
+	var value any = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = obj[part]
+	}
+	if items, ok := value.([]any); ok {
+		if len(items) == 0 {
+			return ""
+		}
+		value = items[0]
+	}
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var errMalformedHash = errors.New("некорректный формат хеша пароля")
+
+type PasswordHasher struct {
+	pepper string
+	params Argon2Params
+}
+
+func NewPasswordHasher(pepper string, params Argon2Params) *PasswordHasher {
+	if params.Memory == 0 {
+		params.Memory = 64 * 1024
+	}
+	if params.Time == 0 {
+		params.Time = 3
+	}
+	if params.Threads == 0 {
+		params.Threads = 2
+	}
+	return &PasswordHasher{pepper: pepper, params: params}
+}
+
+// Hash возвращает строку в формате PHC:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(h.peppered(password)), salt, h.params.Time, h.params.Memory, h.params.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify проверяет пароль по сохраненному хешу. needsRehash = true, если хеш
+// в устаревшем формате (SHA-256) или с параметрами, отличными от текущих.
+func (h *PasswordHasher) Verify(encoded, password string) (ok bool, needsRehash bool) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, "$") {
+		return h.verifyLegacy(encoded, password), true
+	}
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, false
+	}
+	actual := argon2.IDKey([]byte(h.peppered(password)), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false
+	}
+	return true, params != h.params
+}
+
+func (h *PasswordHasher) verifyLegacy(encoded, password string) bool {
+	sum := sha256.Sum256([]byte(h.peppered(password)))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(encoded))) == 1
+}
+
+func (h *PasswordHasher) peppered(password string) string {
+	return password + ":" + h.pepper
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, errMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, errMalformedHash
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2Params{}, nil, nil, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errMalformedHash
+	}
+	return params, salt, key, nil
+}
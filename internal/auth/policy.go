@@ -0,0 +1,92 @@
+package auth
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+func parseCommonPasswords(data string) map[string]struct{} {
+	result := make(map[string]struct{})
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result[strings.ToLower(line)] = struct{}{}
+	}
+	return result
+}
+
+// PasswordPolicy — требования к локальным паролям. MinClasses — сколько из четырех классов
+// символов (строчные, прописные, цифры, прочие) должно встречаться в пароле.
+type PasswordPolicy struct {
+	MinLength   int
+	MinClasses  int
+	CheckCommon bool
+}
+
+// Validate возвращает ошибку с текстом для пользователя, если пароль не соответствует политике.
+func (p PasswordPolicy) Validate(password, login string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("пароль должен быть не короче %d символов", p.MinLength)
+	}
+	if classes := passwordClasses(password); classes < p.MinClasses {
+		return fmt.Errorf("пароль должен содержать символы хотя бы %d типов из четырех: строчные и прописные буквы, цифры, спецсимволы", p.MinClasses)
+	}
+	lower := strings.ToLower(password)
+	login = strings.ToLower(strings.TrimSpace(login))
+	if login != "" && strings.Contains(lower, login) {
+		return errors.New("пароль не должен содержать логин")
+	}
+	if p.CheckCommon && isCommonPassword(lower) {
+		return errors.New("этот пароль слишком распространен или встречался в утечках, выберите другой")
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			count++
+		}
+	}
+	return count
+}
+
+// isCommonPassword проверяет и сам пароль, и его основу без цифр и знаков по краям:
+// "Qwerty123!" отклоняется так же, как "qwerty".
+func isCommonPassword(lower string) bool {
+	if _, ok := commonPasswords[lower]; ok {
+		return true
+	}
+	base := strings.TrimFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if base == "" || base == lower {
+		return false
+	}
+	_, ok := commonPasswords[base]
+	return ok
+}
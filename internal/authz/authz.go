@@ -0,0 +1,359 @@
+// Package authz отвечает на вопрос «может ли пользователь выполнить действие над ресурсом».
+// Права ролей хранятся в БД (roles, role_permissions); обработчики HTTP не сравнивают роли сами.
+package authz
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+type Action string
+
+const (
+	UserList           Action = "user.list"
+	UserCreate         Action = "user.create"
+	UserManage         Action = "user.manage"
+	UserAssignRole     Action = "user.assign_role"
+	UserSessions       Action = "user.sessions"
+	UserLockout        Action = "user.lockout"
+	UserPassword       Action = "user.password"
+	UserTwoFactorReset Action = "user.2fa_reset"
+	LoginLockouts      Action = "login_lockouts"
+	RegistrationReview Action = "registration.review"
+	ServiceAccounts    Action = "service_accounts"
+	ServiceAssignRole  Action = "service_accounts.assign_role"
+	RoleManage         Action = "role.manage"
+	DepartmentList     Action = "department.list"
+	DepartmentManage   Action = "department.manage"
+	AuditRead          Action = "audit.read"
+	QuarantineManage   Action = "quarantine.manage"
+	WorkflowManage     Action = "workflow.manage"
+	TrashManage        Action = "trash.manage"
+
+	ProjectRead   Action = "project.read"
+	ProjectManage Action = "project.manage"
+	ProjectClose  Action = "project.close"
+
+	TaskRead   Action = "task.read"
+	TaskManage Action = "task.manage"
+	TaskClose  Action = "task.close"
+	TaskRoute  Action = "task.route"
+	TaskSplit  Action = "task.split"
+	TaskLink   Action = "task.link"
+
+	ReportRead   Action = "report.read"
+	ReportCreate Action = "report.create"
+	ReportDelete Action = "report.delete"
+
+	DepartmentChat Action = "chat.department"
+	TaskChat       Action = "chat.task"
+	MessageDelete  Action = "chat.message_delete"
+)
+
+var actions = []Action{
+	UserList, UserCreate, UserManage, UserAssignRole, UserSessions, UserLockout, UserPassword,
+	UserTwoFactorReset, LoginLockouts, RegistrationReview, ServiceAccounts, ServiceAssignRole,
+	RoleManage, DepartmentList, DepartmentManage, AuditRead, QuarantineManage, WorkflowManage, TrashManage,
+	ProjectRead, ProjectManage, ProjectClose,
+	TaskRead, TaskManage, TaskClose, TaskRoute, TaskSplit, TaskLink,
+	ReportRead, ReportCreate, ReportDelete,
+	DepartmentChat, TaskChat, MessageDelete,
+}
+
+// Actions возвращает все действия, на которые можно выдать право.
+func Actions() []Action {
+	return append([]Action(nil), actions...)
+}
+
+func ValidAction(action Action) bool {
+	for _, item := range actions {
+		if item == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope — на какие ресурсы распространяется право. Значения упорядочены по ширине.
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	// ScopeOwn — ресурсы, где пользователь участник (куратор, исполнитель), автор или он сам.
+	ScopeOwn
+	// ScopeDepartment — ресурсы своего подразделения, а для руководителя — и всего его поддерева.
+	ScopeDepartment
+	ScopeAll
+)
+
+var scopeNames = map[Scope]string{
+	ScopeOwn:        "own",
+	ScopeDepartment: "department",
+	ScopeAll:        "all",
+}
+
+func (s Scope) String() string {
+	return scopeNames[s]
+}
+
+func ParseScope(value string) (Scope, bool) {
+	for scope, name := range scopeNames {
+		if strings.EqualFold(strings.TrimSpace(value), name) {
+			return scope, true
+		}
+	}
+	return ScopeNone, false
+}
+
+// Resource — факты о ресурсе, нужные правилам. Обработчик заполняет только то, что знает:
+// нулевые значения не дают доступа по соответствующему признаку.
+type Resource struct {
+	DepartmentID int64
+	// OwnerID — автор отчета или сообщения, текущий ответственный по задаче, сам пользователь.
+	OwnerID     int64
+	Participant bool
+	// TargetRole — роль пользователя, над которым выполняется действие, или назначаемая роль.
+	TargetRole string
+	RouteStage int64
+}
+
+// Дополнительные условия права. Хранятся в role_permissions по имени.
+const (
+	CondTargetStaff      = "target_staff"
+	CondTargetNotOwner   = "target_not_owner"
+	CondTargetBelowAdmin = "target_below_admin"
+	CondUnassigned       = "unassigned"
+	CondDepartmentStage  = "department_stage"
+)
+
+var conditions = map[string]func(res Resource) bool{
+	// Начальник отдела управляет только сотрудниками и высшим руководством, не руководящими ролями.
+	CondTargetStaff: func(res Resource) bool {
+		return hasRole([]string{RoleMember, RoleGuest}, res.TargetRole)
+	},
+	CondTargetNotOwner: func(res Resource) bool {
+		return !strings.EqualFold(strings.TrimSpace(res.TargetRole), RoleOwner)
+	},
+	CondTargetBelowAdmin: func(res Resource) bool {
+		return !hasRole([]string{RoleOwner, RoleAdmin}, res.TargetRole)
+	},
+	CondUnassigned: func(res Resource) bool {
+		return res.OwnerID == 0
+	},
+	CondDepartmentStage: func(res Resource) bool {
+		return res.RouteStage >= 3
+	},
+}
+
+// narrowerConditions — для условия перечислены условия, которые оно строже: право с условием
+// target_staff не шире права с target_below_admin или target_not_owner.
+var narrowerConditions = map[string][]string{
+	CondTargetStaff:      {CondTargetBelowAdmin, CondTargetNotOwner},
+	CondTargetBelowAdmin: {CondTargetNotOwner},
+}
+
+// conditionWithin сообщает, что условие cond не мягче условия limit.
+func conditionWithin(cond, limit string) bool {
+	if limit == "" || cond == limit {
+		return true
+	}
+	for _, wider := range narrowerConditions[cond] {
+		if wider == limit {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidCondition(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := conditions[name]
+	return ok
+}
+
+// Grant — право роли на действие в пределах области, возможно с дополнительным условием.
+type Grant struct {
+	Role      string
+	Action    Action
+	Scope     Scope
+	Condition string
+}
+
+// Policy — неизменяемый снимок ролей, прав и оргструктуры. После изменения ролей или
+// подразделений строится новый.
+type Policy struct {
+	roles  map[string]struct{}
+	grants map[string]map[Action][]Grant
+	org    *Org
+}
+
+func NewPolicy(roles []string, grants []Grant, org *Org) *Policy {
+	if org == nil {
+		org = NewOrg(nil)
+	}
+	p := &Policy{
+		roles:  make(map[string]struct{}, len(roles)),
+		grants: make(map[string]map[Action][]Grant),
+		org:    org,
+	}
+	for _, role := range roles {
+		p.roles[roleKey(role)] = struct{}{}
+	}
+	for _, grant := range grants {
+		key := roleKey(grant.Role)
+		if p.grants[key] == nil {
+			p.grants[key] = make(map[Action][]Grant)
+		}
+		p.grants[key][grant.Action] = append(p.grants[key][grant.Action], grant)
+	}
+	return p
+}
+
+// denials — текст отказа для пользователя; для остальных действий — errForbidden.
+var denials = map[Action]string{
+	UserManage:         "можно управлять только пользователями своего отдела, кроме руководящих учетных записей",
+	UserAssignRole:     "начальник отдела может назначать только роли сотрудника отдела или высшего руководства",
+	UserSessions:       "можно управлять сессиями только пользователей своего отдела, кроме руководящих учетных записей",
+	UserLockout:        "можно снимать блокировку только пользователям своего отдела, кроме руководящих учетных записей",
+	UserPassword:       "можно сбрасывать пароль только пользователям своего отдела, кроме руководящих учетных записей; пароль владельца — только владелец",
+	UserTwoFactorReset: "сбросить 2FA владельца может только владелец",
+	ServiceAssignRole:  "нельзя назначить роль выше собственной",
+	ProjectClose:       "закрыть проект может куратор или исполнитель",
+	TaskClose:          "закрыть задачу может куратор или исполнитель",
+	TaskRoute:          "передавать задачу может только текущий ответственный",
+	TaskSplit:          "разбить задачу на подзадачи может ее куратор или исполнитель",
+	TaskLink:           "связывать задачу с другими может ее куратор или исполнитель",
+	ReportRead:         "нет доступа к отчету",
+	ReportCreate:       "закрыть через отчет может куратор или исполнитель",
+	ReportDelete:       "нет прав на удаление отчета",
+	DepartmentChat:     "доступ только к чату своего отдела",
+	TaskChat:           "чат задачи доступен только кураторам и исполнителям этой задачи",
+	MessageDelete:      "можно удалять только свои сообщения",
+}
+
+var errForbidden = errors.New("недостаточно прав")
+
+func (p *Policy) Org() *Org {
+	return p.org
+}
+
+// Departments возвращает подразделения, которые пользователь видит в области department:
+// свое и поддеревья тех, которыми он руководит. По ним строятся выборки списков.
+func (p *Policy) Departments(actor models.User) []int64 {
+	out := make([]int64, 0, 1)
+	seen := make(map[int64]bool)
+	add := func(id int64) {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	add(actor.DepartmentID)
+	for _, unitID := range p.org.HeadedBy(actor.ID) {
+		for _, id := range p.org.Subtree(unitID) {
+			add(id)
+		}
+	}
+	return out
+}
+
+// HasRole сообщает, существует ли роль.
+func (p *Policy) HasRole(role string) bool {
+	_, ok := p.roles[roleKey(role)]
+	return ok && strings.TrimSpace(role) != ""
+}
+
+// Allow сообщает, разрешено ли действие над ресурсом.
+func (p *Policy) Allow(actor models.User, action Action, res Resource) bool {
+	for _, grant := range p.grants[roleKey(actor.Role)][action] {
+		if !p.covers(grant.Scope, actor, res) {
+			continue
+		}
+		if cond, ok := conditions[grant.Condition]; grant.Condition == "" || (ok && cond(res)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check — то же, что Allow, но возвращает ошибку с текстом отказа.
+func (p *Policy) Check(actor models.User, action Action, res Resource) error {
+	if p.Allow(actor, action, res) {
+		return nil
+	}
+	if msg, ok := denials[action]; ok && p.ScopeOf(actor, action) != ScopeNone {
+		return errors.New(msg)
+	}
+	return errForbidden
+}
+
+// CanGrant сообщает, может ли пользователь выдать роли право grant: у его собственной роли
+// должно быть то же действие в не меньшей области и с не более строгим условием. Иначе через
+// новую роль можно было бы обойти условия встроенных ролей.
+func (p *Policy) CanGrant(actor models.User, grant Grant) bool {
+	for _, own := range p.grants[roleKey(actor.Role)][grant.Action] {
+		if own.Scope >= grant.Scope && conditionWithin(grant.Condition, own.Condition) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeOf возвращает самую широкую область, в которой роль пользователя вообще может
+// выполнять действие. По ней строятся выборки списков.
+func (p *Policy) ScopeOf(actor models.User, action Action) Scope {
+	scope := ScopeNone
+	for _, grant := range p.grants[roleKey(actor.Role)][action] {
+		if grant.Scope > scope {
+			scope = grant.Scope
+		}
+	}
+	return scope
+}
+
+func (p *Policy) covers(scope Scope, actor models.User, res Resource) bool {
+	switch scope {
+	case ScopeAll:
+		return true
+	case ScopeDepartment:
+		if res.DepartmentID <= 0 {
+			return false
+		}
+		return res.DepartmentID == actor.DepartmentID || p.org.heads(actor.ID, res.DepartmentID)
+	case ScopeOwn:
+		return res.Participant || (res.OwnerID > 0 && res.OwnerID == actor.ID)
+	default:
+		return false
+	}
+}
+
+func roleKey(role string) string {
+	return strings.ToLower(strings.TrimSpace(role))
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, item := range roles {
+		if roleKey(item) == roleKey(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSuper — руководство УЦС: Owner, Admin, Deputy Admin. Используется маршрутом СЭД, а не для прав.
+func IsSuper(role string) bool {
+	return hasRole(superRoles, role)
+}
+
+func IsDepartmentHead(role string) bool {
+	return roleKey(role) == roleKey(RoleProjectManager)
+}
+
+// IsBuiltIn сообщает, что роль поставляется с системой.
+func IsBuiltIn(role string) bool {
+	return hasRole(allRoles, role)
+}
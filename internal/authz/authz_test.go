@@ -0,0 +1,330 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Оргструктура для тестов: управление 1 (руководитель 10) с отделами 2 (руководитель 20)
+// и 4 (без руководителя); в отделе 2 — группа 3 без своего руководителя.
+func testPolicy() *Policy {
+	org := NewOrg([]OrgUnit{
+		{ID: 1, HeadUserID: 10},
+		{ID: 2, ParentID: 1, HeadUserID: 20},
+		{ID: 3, ParentID: 2},
+		{ID: 4, ParentID: 1},
+	})
+	roles := make([]string, 0, len(BuiltInRoles))
+	for _, role := range BuiltInRoles {
+		roles = append(roles, role.Name)
+	}
+	return NewPolicy(roles, DefaultGrants(), org)
+}
+
+var (
+	owner       = models.User{ID: 1, Role: RoleOwner, DepartmentID: 1}
+	admin       = models.User{ID: 2, Role: RoleAdmin, DepartmentID: 1}
+	deputy      = models.User{ID: 3, Role: RoleDeputyAdmin, DepartmentID: 1}
+	headPM      = models.User{ID: 20, Role: RoleProjectManager, DepartmentID: 2}
+	plainPM     = models.User{ID: 40, Role: RoleProjectManager, DepartmentID: 4}
+	member      = models.User{ID: 30, Role: RoleMember, DepartmentID: 3}
+	guest       = models.User{ID: 50, Role: RoleGuest, DepartmentID: 2}
+	unknownRole = models.User{ID: 60, Role: "Contractor", DepartmentID: 2}
+)
+
+func TestPolicyAllow(t *testing.T) {
+	p := testPolicy()
+	tests := []struct {
+		name   string
+		actor  models.User
+		action Action
+		res    Resource
+		want   bool
+	}{
+		// Область all и роли без прав.
+		{"owner manages trash", owner, TrashManage, Resource{}, true},
+		{"admin manages workflows", admin, WorkflowManage, Resource{}, true},
+		{"pm cannot manage trash", headPM, TrashManage, Resource{}, false},
+		{"member cannot read audit", member, AuditRead, Resource{}, false},
+		{"unknown role has no grants", unknownRole, TaskRead, Resource{DepartmentID: 2, Participant: true}, false},
+
+		// CondTargetStaff: начальник отдела управляет только сотрудниками и высшим руководством.
+		{"pm manages member of own department", headPM, UserManage, Resource{DepartmentID: 2, TargetRole: RoleMember}, true},
+		{"pm manages guest of own department", headPM, UserManage, Resource{DepartmentID: 2, TargetRole: RoleGuest}, true},
+		{"pm cannot manage another pm", headPM, UserManage, Resource{DepartmentID: 2, TargetRole: RoleProjectManager}, false},
+		{"pm cannot manage admin", headPM, UserManage, Resource{DepartmentID: 2, TargetRole: RoleAdmin}, false},
+		{"pm cannot manage member of other department", headPM, UserManage, Resource{DepartmentID: 4, TargetRole: RoleMember}, false},
+		{"pm assigns member role anywhere", headPM, UserAssignRole, Resource{DepartmentID: 4, TargetRole: RoleMember}, true},
+		{"pm cannot assign admin role", headPM, UserAssignRole, Resource{DepartmentID: 2, TargetRole: RoleAdmin}, false},
+		{"pm resets password of member", headPM, UserPassword, Resource{DepartmentID: 2, TargetRole: RoleMember}, true},
+		{"pm cannot reset password of pm", headPM, UserPassword, Resource{DepartmentID: 2, TargetRole: RoleProjectManager}, false},
+		{"pm manages own sessions", headPM, UserSessions, Resource{DepartmentID: 2, OwnerID: 20, TargetRole: RoleProjectManager}, true},
+
+		// CondTargetNotOwner и CondTargetBelowAdmin.
+		{"owner resets owner password", owner, UserPassword, Resource{TargetRole: RoleOwner}, true},
+		{"admin cannot reset owner password", admin, UserPassword, Resource{TargetRole: RoleOwner}, false},
+		{"admin resets member password", admin, UserPassword, Resource{TargetRole: RoleMember}, true},
+		{"deputy cannot reset owner 2fa", deputy, UserTwoFactorReset, Resource{TargetRole: RoleOwner}, false},
+		{"owner resets owner 2fa", owner, UserTwoFactorReset, Resource{TargetRole: RoleOwner}, true},
+		{"pm cannot reset 2fa", headPM, UserTwoFactorReset, Resource{TargetRole: RoleMember}, false},
+		{"admin cannot give service account owner role", admin, ServiceAssignRole, Resource{TargetRole: RoleOwner}, false},
+		{"deputy cannot give service account admin role", deputy, ServiceAssignRole, Resource{TargetRole: RoleAdmin}, false},
+		{"deputy gives service account pm role", deputy, ServiceAssignRole, Resource{TargetRole: RoleProjectManager}, true},
+
+		// Область department и поддерево руководителя (heads).
+		{"pm reads task of own department", headPM, TaskRead, Resource{DepartmentID: 2}, true},
+		{"head pm reads task of nested group", headPM, TaskRead, Resource{DepartmentID: 3}, true},
+		{"head pm cannot read sibling department", headPM, TaskRead, Resource{DepartmentID: 4}, false},
+		{"pm without unit reads only own department", plainPM, TaskRead, Resource{DepartmentID: 3}, false},
+		{"department scope needs department", headPM, TaskRead, Resource{}, false},
+		{"head pm manages member of nested group", headPM, UserManage, Resource{DepartmentID: 3, TargetRole: RoleMember}, true},
+		{"member in department chat", member, DepartmentChat, Resource{DepartmentID: 3}, true},
+		{"member not in other department chat", member, DepartmentChat, Resource{DepartmentID: 2}, false},
+		{"head pm in nested group chat", headPM, DepartmentChat, Resource{DepartmentID: 3}, true},
+
+		// Область own.
+		{"member reads task as participant", member, TaskRead, Resource{DepartmentID: 3, Participant: true}, true},
+		{"member cannot read task of own department", member, TaskRead, Resource{DepartmentID: 3}, false},
+		{"member deletes own message", member, MessageDelete, Resource{DepartmentID: 3, OwnerID: 30}, true},
+		{"member cannot delete foreign message", member, MessageDelete, Resource{DepartmentID: 3, OwnerID: 31}, false},
+		{"owner outside task chat", owner, TaskChat, Resource{DepartmentID: 2}, false},
+		{"guest in task chat as participant", guest, TaskChat, Resource{DepartmentID: 2, Participant: true}, true},
+		{"guest cannot split task", guest, TaskSplit, Resource{DepartmentID: 2, Participant: true}, false},
+
+		// Проекты.
+		{"pm reads project of own department", headPM, ProjectRead, Resource{DepartmentID: 2}, true},
+		{"member reads project as participant", member, ProjectRead, Resource{DepartmentID: 3, Participant: true}, true},
+		{"member cannot read project of own department", member, ProjectRead, Resource{DepartmentID: 3}, false},
+		{"deputy manages any project", deputy, ProjectManage, Resource{DepartmentID: 4}, true},
+		{"pm cannot manage project of own department", headPM, ProjectManage, Resource{DepartmentID: 2, Participant: true}, false},
+		{"member closes project as participant", member, ProjectClose, Resource{DepartmentID: 3, Participant: true}, true},
+		{"pm cannot close foreign project of own department", headPM, ProjectClose, Resource{DepartmentID: 2}, false},
+
+		// Отчеты и их файлы.
+		{"pm reads report of nested group", headPM, ReportRead, Resource{DepartmentID: 3, OwnerID: 30}, true},
+		{"author reads own report", member, ReportRead, Resource{DepartmentID: 3, OwnerID: 30}, true},
+		{"member cannot read foreign report of own department", member, ReportRead, Resource{DepartmentID: 3, OwnerID: 31}, false},
+		{"guest reads report as participant", guest, ReportRead, Resource{DepartmentID: 2, Participant: true}, true},
+		{"member creates report as participant", member, ReportCreate, Resource{DepartmentID: 3, Participant: true}, true},
+		{"pm cannot create report outside task", headPM, ReportCreate, Resource{DepartmentID: 2}, false},
+		{"admin creates report anywhere", admin, ReportCreate, Resource{DepartmentID: 4}, true},
+		{"pm deletes report of own department", headPM, ReportDelete, Resource{DepartmentID: 2, OwnerID: 31}, true},
+		{"pm cannot delete report of sibling department", plainPM, ReportDelete, Resource{DepartmentID: 2, OwnerID: 31}, false},
+		{"member deletes own report", member, ReportDelete, Resource{DepartmentID: 3, OwnerID: 30}, true},
+		{"member cannot delete foreign report", member, ReportDelete, Resource{DepartmentID: 3, OwnerID: 31}, false},
+
+		// Вложения чатов открываются по праву на чат.
+		{"admin reads file of any department chat", admin, DepartmentChat, Resource{DepartmentID: 4}, true},
+		{"guest reads file of own department chat", guest, DepartmentChat, Resource{DepartmentID: 2}, true},
+		{"admin cannot read file of foreign task chat", admin, TaskChat, Resource{DepartmentID: 4}, false},
+		{"pm cannot read file of department task chat", headPM, TaskChat, Resource{DepartmentID: 2}, false},
+		{"admin deletes any message", admin, MessageDelete, Resource{OwnerID: 31}, true},
+
+		// Маршрут СЭД: CondUnassigned и CondDepartmentStage.
+		{"pm routes own task", headPM, TaskRoute, Resource{DepartmentID: 4, OwnerID: 20, RouteStage: 1}, true},
+		{"pm routes unassigned task anywhere", plainPM, TaskRoute, Resource{DepartmentID: 2, RouteStage: 1}, true},
+		{"pm routes department stage task of own department", headPM, TaskRoute, Resource{DepartmentID: 2, OwnerID: 99, RouteStage: 3}, true},
+		{"head pm routes department stage task of nested group", headPM, TaskRoute, Resource{DepartmentID: 3, OwnerID: 99, RouteStage: 4}, true},
+		{"pm cannot route early stage task of own department", headPM, TaskRoute, Resource{DepartmentID: 2, OwnerID: 99, RouteStage: 2}, false},
+		{"pm cannot route department stage task of other department", headPM, TaskRoute, Resource{DepartmentID: 4, OwnerID: 99, RouteStage: 3}, false},
+		{"member cannot route", member, TaskRoute, Resource{DepartmentID: 3, OwnerID: 30, RouteStage: 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allow(tt.actor, tt.action, tt.res); got != tt.want {
+				t.Errorf("Allow(%s, %s, %+v) = %v, want %v", tt.actor.Role, tt.action, tt.res, got, tt.want)
+			}
+			if err := p.Check(tt.actor, tt.action, tt.res); (err == nil) != tt.want {
+				t.Errorf("Check(%s, %s, %+v) = %v, want allowed %v", tt.actor.Role, tt.action, tt.res, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckDenials(t *testing.T) {
+	p := testPolicy()
+	tests := []struct {
+		name   string
+		actor  models.User
+		action Action
+		res    Resource
+		want   string
+	}{
+		{"denial text when role has the action", headPM, UserManage, Resource{DepartmentID: 2, TargetRole: RoleAdmin}, denials[UserManage]},
+		{"generic error without the action", member, UserList, Resource{DepartmentID: 3}, errForbidden.Error()},
+		{"generic error for action without text", headPM, TaskManage, Resource{DepartmentID: 2}, errForbidden.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.actor, tt.action, tt.res)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Check() = %v, want %q", err, tt.want)
+			}
+			if tt.want == errForbidden.Error() && !errors.Is(err, errForbidden) {
+				t.Errorf("Check() = %v, want errForbidden", err)
+			}
+		})
+	}
+}
+
+func TestPolicyScopeAndDepartments(t *testing.T) {
+	p := testPolicy()
+	scopes := []struct {
+		actor  models.User
+		action Action
+		want   Scope
+	}{
+		{owner, TaskRead, ScopeAll},
+		{headPM, TaskRead, ScopeDepartment},
+		{member, TaskRead, ScopeOwn},
+		{member, UserList, ScopeNone},
+		{headPM, TaskRoute, ScopeAll},
+	}
+	for _, tt := range scopes {
+		if got := p.ScopeOf(tt.actor, tt.action); got != tt.want {
+			t.Errorf("ScopeOf(%s, %s) = %s, want %s", tt.actor.Role, tt.action, got, tt.want)
+		}
+	}
+
+	departments := []struct {
+		actor models.User
+		want  []int64
+	}{
+		{headPM, []int64{2, 3}},
+		{plainPM, []int64{4}},
+		{member, []int64{3}},
+	}
+	for _, tt := range departments {
+		got := p.Departments(tt.actor)
+		if len(got) != len(tt.want) {
+			t.Errorf("Departments(%d) = %v, want %v", tt.actor.ID, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Departments(%d) = %v, want %v", tt.actor.ID, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOrgHeads(t *testing.T) {
+	org := testPolicy().Org()
+	tests := []struct {
+		name   string
+		userID int64
+		unitID int64
+		want   bool
+	}{
+		{"head of own unit", 20, 2, true},
+		{"head of nested unit", 20, 3, true},
+		{"root head covers everything", 10, 4, true},
+		{"head does not cover parent", 20, 1, false},
+		{"head does not cover sibling", 20, 4, false},
+		{"unknown unit", 20, 99, false},
+		{"no user", 0, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := org.heads(tt.userID, tt.unitID); got != tt.want {
+				t.Errorf("heads(%d, %d) = %v, want %v", tt.userID, tt.unitID, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDefaultScopeMatrix фиксирует наибольшую область каждого действия для каждой встроенной роли.
+func TestDefaultScopeMatrix(t *testing.T) {
+	const (
+		a = ScopeAll
+		d = ScopeDepartment
+		o = ScopeOwn
+		n = ScopeNone
+	)
+	roles := []string{RoleOwner, RoleAdmin, RoleDeputyAdmin, RoleProjectManager, RoleMember, RoleGuest}
+	matrix := map[Action][6]Scope{
+		UserList:           {a, a, a, d, n, n},
+		UserCreate:         {a, a, a, d, n, n},
+		UserManage:         {a, a, a, d, n, n},
+		UserAssignRole:     {a, a, a, a, n, n},
+		UserSessions:       {a, a, a, d, n, n},
+		UserLockout:        {a, a, a, d, n, n},
+		UserPassword:       {a, a, a, d, n, n},
+		UserTwoFactorReset: {a, a, a, n, n, n},
+		LoginLockouts:      {a, a, a, n, n, n},
+		RegistrationReview: {a, a, a, d, n, n},
+		ServiceAccounts:    {a, a, a, n, n, n},
+		ServiceAssignRole:  {a, a, a, n, n, n},
+		RoleManage:         {a, a, a, n, n, n},
+		DepartmentList:     {a, a, a, d, d, d},
+		DepartmentManage:   {a, a, a, n, n, n},
+		AuditRead:          {a, a, a, n, n, n},
+		QuarantineManage:   {a, a, a, n, n, n},
+		WorkflowManage:     {a, a, a, n, n, n},
+		TrashManage:        {a, a, a, n, n, n},
+		ProjectRead:        {a, a, a, d, o, o},
+		ProjectManage:      {a, a, a, n, n, n},
+		ProjectClose:       {a, a, a, o, o, o},
+		TaskRead:           {a, a, a, d, o, o},
+		TaskManage:         {a, a, a, n, n, n},
+		TaskClose:          {a, a, a, o, o, o},
+		TaskRoute:          {a, a, a, a, n, n},
+		TaskSplit:          {a, a, a, d, o, n},
+		TaskLink:           {a, a, a, d, o, n},
+		ReportRead:         {a, a, a, d, o, o},
+		ReportCreate:       {a, a, a, o, o, o},
+		ReportDelete:       {a, a, a, d, o, o},
+		DepartmentChat:     {a, a, a, d, d, d},
+		TaskChat:           {o, o, o, o, o, o},
+		MessageDelete:      {a, a, a, o, o, o},
+	}
+	p := testPolicy()
+	for _, action := range Actions() {
+		row, ok := matrix[action]
+		if !ok {
+			t.Errorf("action %s is missing from the matrix", action)
+			continue
+		}
+		for i, role := range roles {
+			if got := p.ScopeOf(models.User{ID: 1, Role: role}, action); got != row[i] {
+				t.Errorf("ScopeOf(%s, %s) = %s, want %s", role, action, got, row[i])
+			}
+		}
+	}
+	if len(matrix) != len(Actions()) {
+		t.Errorf("matrix has %d actions, policy has %d", len(matrix), len(Actions()))
+	}
+}
+
+func TestPolicyCanGrant(t *testing.T) {
+	p := testPolicy()
+	tests := []struct {
+		name  string
+		actor models.User
+		grant Grant
+		want  bool
+	}{
+		{"owner grants unconditional password reset", owner, Grant{Action: UserPassword, Scope: ScopeAll}, true},
+		{"deputy cannot drop not-owner condition", deputy, Grant{Action: UserPassword, Scope: ScopeAll}, false},
+		{"deputy keeps not-owner condition", deputy, Grant{Action: UserPassword, Scope: ScopeAll, Condition: CondTargetNotOwner}, true},
+		{"deputy may narrow to staff", deputy, Grant{Action: UserTwoFactorReset, Scope: ScopeDepartment, Condition: CondTargetStaff}, true},
+		{"deputy cannot drop not-owner condition of 2fa reset", deputy, Grant{Action: UserTwoFactorReset, Scope: ScopeOwn}, false},
+		{"admin cannot swap condition for an unrelated one", admin, Grant{Action: UserPassword, Scope: ScopeAll, Condition: CondUnassigned}, false},
+		{"deputy cannot widen service role condition", deputy, Grant{Action: ServiceAssignRole, Scope: ScopeAll, Condition: CondTargetNotOwner}, false},
+		{"pm grants own department scope", headPM, Grant{Action: TaskRead, Scope: ScopeDepartment}, true},
+		{"pm cannot widen department scope", headPM, Grant{Action: TaskRead, Scope: ScopeAll}, false},
+		{"pm grants unassigned routing", headPM, Grant{Action: TaskRoute, Scope: ScopeAll, Condition: CondUnassigned}, true},
+		{"pm cannot grant unconditional routing", headPM, Grant{Action: TaskRoute, Scope: ScopeAll}, false},
+		{"member cannot grant missing action", member, Grant{Action: AuditRead, Scope: ScopeOwn}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.CanGrant(tt.actor, tt.grant); got != tt.want {
+				t.Errorf("CanGrant(%s, %+v) = %v, want %v", tt.actor.Role, tt.grant, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package authz
+
+const (
+	RoleOwner          = "Owner"
+	RoleAdmin          = "Admin"
+	RoleDeputyAdmin    = "Deputy Admin"
+	RoleProjectManager = "Project Manager"
+	RoleMember         = "Member"
+	RoleGuest          = "Guest"
+)
+
+// BuiltInRole — роль, поставляемая с системой. Ее права берутся из defaultRules
+// и восстанавливаются при каждом запуске, через API они не меняются.
+type BuiltInRole struct {
+	Name  string
+	Title string
+}
+
+var BuiltInRoles = []BuiltInRole{
+	{Name: RoleOwner, Title: "Владелец"},
+	{Name: RoleAdmin, Title: "Начальник УЦС"},
+	{Name: RoleDeputyAdmin, Title: "Заместитель начальника УЦС"},
+	{Name: RoleProjectManager, Title: "Начальник отдела"},
+	{Name: RoleMember, Title: "Сотрудник отдела"},
+	{Name: RoleGuest, Title: "Высшее руководство"},
+}
+
+var (
+	superRoles = []string{RoleOwner, RoleAdmin, RoleDeputyAdmin}
+	allRoles   = []string{RoleOwner, RoleAdmin, RoleDeputyAdmin, RoleProjectManager, RoleMember, RoleGuest}
+)
+
+type rule struct {
+	roles     []string
+	scope     Scope
+	condition string
+}
+
+var defaultRules = map[Action][]rule{
+	UserList: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+	},
+	UserCreate: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+	},
+	UserManage: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment, condition: CondTargetStaff},
+	},
+	UserAssignRole: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeAll, condition: CondTargetStaff},
+	},
+	UserSessions: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment, condition: CondTargetStaff},
+		{roles: []string{RoleProjectManager}, scope: ScopeOwn},
+	},
+	UserLockout: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment, condition: CondTargetStaff},
+		{roles: []string{RoleProjectManager}, scope: ScopeOwn},
+	},
+	UserPassword: {
+		{roles: []string{RoleOwner}, scope: ScopeAll},
+		{roles: []string{RoleAdmin, RoleDeputyAdmin}, scope: ScopeAll, condition: CondTargetNotOwner},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment, condition: CondTargetStaff},
+	},
+	UserTwoFactorReset: {
+		{roles: []string{RoleOwner}, scope: ScopeAll},
+		{roles: []string{RoleAdmin, RoleDeputyAdmin}, scope: ScopeAll, condition: CondTargetNotOwner},
+	},
+	LoginLockouts: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	RegistrationReview: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+	},
+	ServiceAccounts: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	// Сервисной учетной записи нельзя выдать роль владельца или роль выше собственной.
+	ServiceAssignRole: {
+		{roles: []string{RoleOwner, RoleAdmin}, scope: ScopeAll, condition: CondTargetNotOwner},
+		{roles: []string{RoleDeputyAdmin}, scope: ScopeAll, condition: CondTargetBelowAdmin},
+	},
+	RoleManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	DepartmentList: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeDepartment},
+	},
+	DepartmentManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	AuditRead: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	QuarantineManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	WorkflowManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	TrashManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+
+	ProjectRead: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	ProjectManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	ProjectClose: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+
+	TaskRead: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	TaskManage: {
+		{roles: superRoles, scope: ScopeAll},
+	},
+	TaskClose: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	// Подзадачи создает руководство УЦС, начальник отдела в своем отделе и участники задачи.
+	TaskSplit: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: []string{RoleProjectManager, RoleMember}, scope: ScopeOwn},
+	},
+	TaskLink: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: []string{RoleProjectManager, RoleMember}, scope: ScopeOwn},
+	},
+	// Маршрут СЭД: руководство УЦС передает любую задачу, начальник отдела — свою или еще
+	// не распределенную, а на этапах отдела — любую задачу своего отдела.
+	TaskRoute: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeOwn},
+		{roles: []string{RoleProjectManager}, scope: ScopeAll, condition: CondUnassigned},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment, condition: CondDepartmentStage},
+	},
+
+	ReportRead: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	ReportCreate: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	ReportDelete: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: []string{RoleProjectManager}, scope: ScopeDepartment},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+
+	DepartmentChat: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeDepartment},
+	},
+	// Чат задачи закрыт даже для руководства, если оно не участвует в задаче.
+	TaskChat: {
+		{roles: allRoles, scope: ScopeOwn},
+	},
+	MessageDelete: {
+		{roles: superRoles, scope: ScopeAll},
+		{roles: allRoles, scope: ScopeOwn},
+	},
+}
+
+// DefaultGrants разворачивает defaultRules в права встроенных ролей.
+func DefaultGrants() []Grant {
+	grants := make([]Grant, 0)
+	for _, action := range actions {
+		for _, rule := range defaultRules[action] {
+			for _, role := range rule.roles {
+				grants = append(grants, Grant{Role: role, Action: action, Scope: rule.scope, Condition: rule.condition})
+			}
+		}
+	}
+	return grants
+}
+
+// Default — политика только из встроенных ролей, без оргструктуры.
+func Default() *Policy {
+	roles := make([]string, 0, len(BuiltInRoles))
+	for _, role := range BuiltInRoles {
+		roles = append(roles, role.Name)
+	}
+	return NewPolicy(roles, DefaultGrants(), nil)
+}
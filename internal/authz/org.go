@@ -0,0 +1,121 @@
+package authz
+
+// OrgUnit — подразделение оргструктуры: управление, отдел или группа.
+type OrgUnit struct {
+	ID         int64
+	ParentID   int64
+	HeadUserID int64
+}
+
+// Org — неизменяемый снимок дерева подразделений. Руководитель подразделения видит
+// все его поддерево в пределах прав своей роли с областью department.
+type Org struct {
+	units    map[int64]OrgUnit
+	children map[int64][]int64
+	root     int64
+}
+
+func NewOrg(units []OrgUnit) *Org {
+	o := &Org{
+		units:    make(map[int64]OrgUnit, len(units)),
+		children: make(map[int64][]int64),
+	}
+	for _, unit := range units {
+		o.units[unit.ID] = unit
+	}
+	for _, unit := range units {
+		if _, ok := o.units[unit.ParentID]; ok && unit.ParentID != unit.ID {
+			o.children[unit.ParentID] = append(o.children[unit.ParentID], unit.ID)
+			continue
+		}
+		if o.root == 0 || unit.ID < o.root {
+			o.root = unit.ID
+		}
+	}
+	return o
+}
+
+// Root возвращает корневое подразделение (управление) или 0, если дерево пусто.
+func (o *Org) Root() int64 {
+	return o.root
+}
+
+func (o *Org) Exists(unitID int64) bool {
+	_, ok := o.units[unitID]
+	return ok
+}
+
+func (o *Org) Parent(unitID int64) int64 {
+	return o.units[unitID].ParentID
+}
+
+func (o *Org) Head(unitID int64) int64 {
+	return o.units[unitID].HeadUserID
+}
+
+// Path возвращает цепочку подразделений от корня до unitID включительно.
+func (o *Org) Path(unitID int64) []int64 {
+	path := make([]int64, 0, 4)
+	seen := make(map[int64]bool)
+	for id := unitID; o.Exists(id) && !seen[id]; id = o.Parent(id) {
+		seen[id] = true
+		path = append([]int64{id}, path...)
+	}
+	return path
+}
+
+func (o *Org) Depth(unitID int64) int {
+	return len(o.Path(unitID)) - 1
+}
+
+// Contains сообщает, входит ли unitID в поддерево ancestorID (включая его самого).
+func (o *Org) Contains(ancestorID, unitID int64) bool {
+	if ancestorID <= 0 {
+		return false
+	}
+	for _, id := range o.Path(unitID) {
+		if id == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtree возвращает подразделение и все вложенные в него.
+func (o *Org) Subtree(unitID int64) []int64 {
+	if !o.Exists(unitID) {
+		return nil
+	}
+	out := []int64{unitID}
+	for i := 0; i < len(out); i++ {
+		out = append(out, o.children[out[i]]...)
+	}
+	return out
+}
+
+// HeadedBy возвращает подразделения, которыми руководит пользователь.
+func (o *Org) HeadedBy(userID int64) []int64 {
+	out := make([]int64, 0)
+	if userID <= 0 {
+		return out
+	}
+	for id, unit := range o.units {
+		if unit.HeadUserID == userID {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// heads сообщает, руководит ли пользователь подразделением unitID или одним из вышестоящих.
+func (o *Org) heads(userID, unitID int64) bool {
+	if userID <= 0 {
+		return false
+	}
+	for _, id := range o.Path(unitID) {
+		if o.Head(id) == userID {
+			return true
+		}
+	}
+	return false
+}
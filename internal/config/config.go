@@ -2,13 +2,92 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/uploads"
 )
 
+// DefaultContentSecurityPolicy рассчитана на SPA из web/: скрипты и стили только со своего origin,
+// картинки — также data: и blob: (превью аватара до загрузки).
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self'; " +
+	"img-src 'self' data: blob:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
 type Config struct {
 	Addr       string
 	DBPath     string
 	StaticPath string
 	AuthPepper string
+	SessionTTL time.Duration
+
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSRedirectAddr   string
+	TLSReloadInterval time.Duration
+	HSTSMaxAge        time.Duration
+	ContentSecurity   string
+
+	UploadAvatarTypes []string
+	UploadReportTypes []string
+	UploadChatTypes   []string
+
+	ClamdAddr   string
+	ScanTimeout time.Duration
+
+	// TrashRetention — сколько удаленные задачи, проекты и отчеты хранятся в корзине.
+	TrashRetention time.Duration
+
+	FileMasterKey        string
+	FileMasterKeyFile    string
+	FilePreviousKeys     []string
+	FilePreviousKeyFiles []string
+
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+
+	PasswordMinLength   int
+	PasswordMinClasses  int
+	PasswordCheckCommon bool
+	PasswordResetTTL    time.Duration
+
+	TwoFactorIssuer        string
+	TwoFactorRequiredRoles []string
+
+	LDAPURL                 string
+	LDAPBindDN              string
+	LDAPBindPassword        string
+	LDAPBaseDN              string
+	LDAPUserFilter          string
+	LDAPLoginAttr           string
+	LDAPNameAttr            string
+	LDAPPositionAttr        string
+	LDAPGroupAttr           string
+	LDAPStartTLS            bool
+	LDAPInsecureSkipVerify  bool
+	LDAPDepartmentMap       string
+	LDAPDefaultDepartmentID int64
+	LDAPSyncInterval        time.Duration
+
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string
+	OIDCScopes          []string
+	OIDCLabel           string
+	OIDCLoginClaim      string
+	OIDCNameClaim       string
+	OIDCDepartmentClaim string
+	OIDCPositionClaim   string
+	OIDCLinkByLogin     bool
+
+	TrustedProxies        []string
+	LoginMaxFailures      int
+	LoginIPMaxFailures    int
+	LoginFailureWindow    time.Duration
+	LoginLockoutBaseDelay time.Duration
+	LoginLockoutMaxDelay  time.Duration
 }
 
 func Load() Config {
@@ -17,6 +96,74 @@ func Load() Config {
 		DBPath:     envOrDefault("APP_DB_PATH", "./data/taskflow.db"),
 		StaticPath: envOrDefault("APP_STATIC_PATH", "./web"),
 		AuthPepper: envOrDefault("APP_AUTH_PEPPER", "change-me-in-production"),
+		SessionTTL: envDurationOrDefault("APP_SESSION_TTL", 12*time.Hour),
+
+		TLSCertFile:       os.Getenv("APP_TLS_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("APP_TLS_KEY_FILE"),
+		TLSRedirectAddr:   os.Getenv("APP_TLS_REDIRECT_ADDR"),
+		TLSReloadInterval: envDurationOrDefault("APP_TLS_RELOAD_INTERVAL", time.Minute),
+		HSTSMaxAge:        envDurationOrDefault("APP_HSTS_MAX_AGE", 365*24*time.Hour),
+		ContentSecurity:   envOrDefault("APP_CONTENT_SECURITY_POLICY", DefaultContentSecurityPolicy),
+
+		UploadAvatarTypes: envListOrDefault("APP_UPLOAD_AVATAR_TYPES", uploads.AvatarTypes),
+		UploadReportTypes: envListOrDefault("APP_UPLOAD_REPORT_TYPES", uploads.ReportTypes),
+		UploadChatTypes:   envListOrDefault("APP_UPLOAD_CHAT_TYPES", uploads.ChatTypes),
+
+		ClamdAddr:   os.Getenv("APP_CLAMD_ADDR"),
+		ScanTimeout: envDurationOrDefault("APP_SCAN_TIMEOUT", time.Minute),
+
+		TrashRetention: envDurationOrDefault("APP_TRASH_RETENTION", 30*24*time.Hour),
+
+		FileMasterKey:        os.Getenv("APP_FILE_MASTER_KEY"),
+		FileMasterKeyFile:    os.Getenv("APP_FILE_MASTER_KEY_FILE"),
+		FilePreviousKeys:     envListOrDefault("APP_FILE_PREVIOUS_KEYS", nil),
+		FilePreviousKeyFiles: envListOrDefault("APP_FILE_PREVIOUS_KEY_FILES", nil),
+
+		Argon2Memory:  uint32(envIntOrDefault("APP_ARGON2_MEMORY_KB", 64*1024)),
+		Argon2Time:    uint32(envIntOrDefault("APP_ARGON2_TIME", 3)),
+		Argon2Threads: uint8(envIntOrDefault("APP_ARGON2_THREADS", 2)),
+
+		PasswordMinLength:   envIntOrDefault("APP_PASSWORD_MIN_LENGTH", 10),
+		PasswordMinClasses:  envIntOrDefault("APP_PASSWORD_MIN_CLASSES", 3),
+		PasswordCheckCommon: envBoolOrDefault("APP_PASSWORD_CHECK_COMMON", true),
+		PasswordResetTTL:    envDurationOrDefault("APP_PASSWORD_RESET_TTL", 24*time.Hour),
+
+		TwoFactorIssuer:        envOrDefault("APP_2FA_ISSUER", "TaskFlow"),
+		TwoFactorRequiredRoles: envListOrDefault("APP_2FA_REQUIRED_ROLES", nil),
+
+		LDAPURL:                 os.Getenv("APP_LDAP_URL"),
+		LDAPBindDN:              os.Getenv("APP_LDAP_BIND_DN"),
+		LDAPBindPassword:        os.Getenv("APP_LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:              os.Getenv("APP_LDAP_BASE_DN"),
+		LDAPUserFilter:          envOrDefault("APP_LDAP_USER_FILTER", "(&(objectClass=person)(uid=%s))"),
+		LDAPLoginAttr:           envOrDefault("APP_LDAP_LOGIN_ATTR", "uid"),
+		LDAPNameAttr:            envOrDefault("APP_LDAP_NAME_ATTR", "cn"),
+		LDAPPositionAttr:        envOrDefault("APP_LDAP_POSITION_ATTR", "title"),
+		LDAPGroupAttr:           envOrDefault("APP_LDAP_GROUP_ATTR", "memberOf"),
+		LDAPStartTLS:            envBoolOrDefault("APP_LDAP_STARTTLS", false),
+		LDAPInsecureSkipVerify:  envBoolOrDefault("APP_LDAP_INSECURE_SKIP_VERIFY", false),
+		LDAPDepartmentMap:       os.Getenv("APP_LDAP_DEPARTMENT_MAP"),
+		LDAPDefaultDepartmentID: int64(envIntOrDefault("APP_LDAP_DEFAULT_DEPARTMENT_ID", 1)),
+		LDAPSyncInterval:        envDurationOrDefault("APP_LDAP_SYNC_INTERVAL", time.Hour),
+
+		OIDCIssuer:          os.Getenv("APP_OIDC_ISSUER"),
+		OIDCClientID:        os.Getenv("APP_OIDC_CLIENT_ID"),
+		OIDCClientSecret:    os.Getenv("APP_OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:     os.Getenv("APP_OIDC_REDIRECT_URL"),
+		OIDCScopes:          envListOrDefault("APP_OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCLabel:           envOrDefault("APP_OIDC_LABEL", "Войти через SSO"),
+		OIDCLoginClaim:      envOrDefault("APP_OIDC_LOGIN_CLAIM", "preferred_username"),
+		OIDCNameClaim:       envOrDefault("APP_OIDC_NAME_CLAIM", "name"),
+		OIDCDepartmentClaim: envOrDefault("APP_OIDC_DEPARTMENT_CLAIM", "department"),
+		OIDCPositionClaim:   envOrDefault("APP_OIDC_POSITION_CLAIM", "job_title"),
+		OIDCLinkByLogin:     envBoolOrDefault("APP_OIDC_LINK_BY_LOGIN", false),
+
+		TrustedProxies:        envListOrDefault("APP_TRUSTED_PROXIES", []string{"127.0.0.1", "::1"}),
+		LoginMaxFailures:      envIntOrDefault("APP_LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:    envIntOrDefault("APP_LOGIN_IP_MAX_FAILURES", 20),
+		LoginFailureWindow:    envDurationOrDefault("APP_LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginLockoutBaseDelay: envDurationOrDefault("APP_LOGIN_LOCKOUT_BASE", time.Minute),
+		LoginLockoutMaxDelay:  envDurationOrDefault("APP_LOGIN_LOCKOUT_MAX", time.Hour),
 	}
 
 	return cfg
@@ -29,3 +176,42 @@ func envOrDefault(key, fallback string) string {
 	}
 	return v
 }
+
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+func envIntOrDefault(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+func envBoolOrDefault(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envListOrDefault(key string, fallback []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
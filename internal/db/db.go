@@ -2,37 +2,88 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/authz"
 	_ "modernc.org/sqlite"
 )
 
-func Open(path string) (*sql.DB, error) {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+func Open(path string, passwords *auth.PasswordHasher) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(dbFile(path)), 0o755); err != nil {
 		return nil, fmt.Errorf("create db dir: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", path)
+	db, err := connect(path)
 	if err != nil {
-		return nil, fmt.Errorf("open sqlite: %w", err)
-	}
-
-	if _, err := db.Exec("PRAGMA foreign_keys = ON;"); err != nil {
-		return nil, fmt.Errorf("enable foreign keys: %w", err)
+		return nil, err
 	}
 
 	if err := migrate(db); err != nil {
 		return nil, err
 	}
-	if err := seed(db); err != nil {
+	if err := seed(db, passwords); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
+// OpenExisting открывает существующую базу без миграций и начальных данных — для утилит
+// обслуживания, которые не должны менять схему. Отсутствующий файл — ошибка, а не новая база.
+func OpenExisting(path string) (*sql.DB, error) {
+	if _, err := os.Stat(dbFile(path)); err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	return connect(path)
+}
+
+func connect(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn(path))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	return db, nil
+}
+
+// dsn добавляет к пути базы включение внешних ключей. PRAGMA foreign_keys действует только
+// на одно соединение, поэтому задается через DSN для каждого соединения пула: на внешних
+// ключах держатся каскадные удаления. Параметры, уже указанные в APP_DB_PATH, сохраняются.
+func dsn(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_pragma=foreign_keys(1)"
+}
+
+// dbFile — путь к файлу базы без параметров DSN.
+func dbFile(path string) string {
+	file, _, _ := strings.Cut(path, "?")
+	return strings.TrimPrefix(file, "file:")
+}
+
+// quarantinedFilesColumns — столбцы карантина. uploader_user_id намеренно без внешнего ключа:
+// запись карантина — улика и остается после удаления пользователя вместе с файлом.
+const quarantinedFilesColumns = `
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL,
+  scope_type TEXT NOT NULL,
+  scope_id INTEGER NOT NULL,
+  uploader_user_id INTEGER NOT NULL,
+  file_name TEXT NOT NULL,
+  file_path TEXT NOT NULL,
+  file_type TEXT NOT NULL DEFAULT '',
+  file_size INTEGER NOT NULL DEFAULT 0,
+  threat TEXT NOT NULL DEFAULT '',
+  scan_error TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+`
+
 func migrate(db *sql.DB) error {
 	const schema = `
 CREATE TABLE IF NOT EXISTS users (
@@ -50,6 +101,15 @@ CREATE TABLE IF NOT EXISTS departments (
   name TEXT NOT NULL UNIQUE
 );
 
+CREATE TABLE IF NOT EXISTS department_positions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  department_id INTEGER NOT NULL,
+  name TEXT NOT NULL COLLATE NOCASE,
+  is_head INTEGER NOT NULL DEFAULT 0,
+  UNIQUE(department_id, name),
+  FOREIGN KEY(department_id) REFERENCES departments(id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS projects (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   key TEXT NOT NULL UNIQUE,
@@ -134,6 +194,225 @@ CREATE TABLE IF NOT EXISTS chat_messages (
   FOREIGN KEY(author_user_id) REFERENCES users(id)
 );
 CREATE INDEX IF NOT EXISTS idx_chat_scope_created ON chat_messages(scope_type, scope_id, id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  token_hash TEXT NOT NULL UNIQUE,
+  user_id INTEGER NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  code_hash TEXT NOT NULL,
+  used_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  token_hash TEXT NOT NULL UNIQUE,
+  user_id INTEGER NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  token_prefix TEXT NOT NULL,
+  scopes TEXT NOT NULL,
+  expires_at DATETIME,
+  last_used_at DATETIME,
+  last_used_ip TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_states (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  state_hash TEXT NOT NULL UNIQUE,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_by INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  reason TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_login ON login_attempts(login);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_created ON login_attempts(created_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  scope TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL DEFAULT 0,
+  locked_until DATETIME,
+  last_failure_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE(scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+  title TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  built_in INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  role_id INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  condition TEXT NOT NULL DEFAULT '',
+  UNIQUE(role_id, action, scope, condition),
+  FOREIGN KEY(role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  actor_user_id INTEGER NOT NULL DEFAULT 0,
+  actor_login TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  entity_type TEXT NOT NULL,
+  entity_id INTEGER NOT NULL,
+  before_json TEXT,
+  after_json TEXT,
+  ip TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_entity ON audit_events(entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at);
+
+-- Журнал аудита только дописывается: изменить или удалить запись нельзя даже напрямую в БД.
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS quarantined_files (` + quarantinedFilesColumns + `);
+
+CREATE TABLE IF NOT EXISTS notifications (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  title TEXT NOT NULL,
+  body TEXT NOT NULL DEFAULT '',
+  entity_type TEXT NOT NULL DEFAULT '',
+  entity_id INTEGER NOT NULL DEFAULT 0,
+  is_read INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, is_read);
+
+-- История задачи: одна запись на операцию, в task_event_changes — изменившиеся поля.
+CREATE TABLE IF NOT EXISTS task_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  task_id INTEGER NOT NULL,
+  actor_user_id INTEGER NOT NULL DEFAULT 0,
+  action TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_task_events_task ON task_events(task_id, id);
+
+CREATE TABLE IF NOT EXISTS task_event_changes (
+  event_id INTEGER NOT NULL,
+  field TEXT NOT NULL,
+  old_value TEXT NOT NULL DEFAULT '',
+  new_value TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(event_id, field),
+  FOREIGN KEY(event_id) REFERENCES task_events(id) ON DELETE CASCADE
+);
+
+-- Связи задач: source блокирует target, дублирует target или связана с ним (relates_to,
+-- хранится один раз с меньшим id в source).
+CREATE TABLE IF NOT EXISTS task_links (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source_task_id INTEGER NOT NULL,
+  target_task_id INTEGER NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('blocks', 'relates_to', 'duplicates')),
+  created_by INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE(source_task_id, target_task_id, kind),
+  CHECK (source_task_id <> target_task_id),
+  FOREIGN KEY(source_task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY(target_task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_task_links_target ON task_links(target_task_id, kind);
+
+-- Процессы (workflow) задач: статусы, разрешенные переходы между ними и роли, которым
+-- переход доступен (пустой список — любой роли). Процесс назначается проекту.
+CREATE TABLE IF NOT EXISTS workflows (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  is_default INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workflow_statuses (
+  workflow_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  title TEXT NOT NULL DEFAULT '',
+  is_initial INTEGER NOT NULL DEFAULT 0,
+  is_terminal INTEGER NOT NULL DEFAULT 0,
+  position INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY(workflow_id, name),
+  FOREIGN KEY(workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+);
+
+-- Прежние ключи задач (после переименования ключа проекта или переноса задачи): по ним
+-- задача находится и дальше, чтобы ссылки в чатах и отчетах не ломались.
+CREATE TABLE IF NOT EXISTS task_key_aliases (
+  key TEXT PRIMARY KEY,
+  task_id INTEGER NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_task_key_aliases_task ON task_key_aliases(task_id);
+
+CREATE TABLE IF NOT EXISTS workflow_transitions (
+  workflow_id INTEGER NOT NULL,
+  from_status TEXT NOT NULL,
+  to_status TEXT NOT NULL,
+  roles TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(workflow_id, from_status, to_status),
+  FOREIGN KEY(workflow_id) REFERENCES workflows(id) ON DELETE CASCADE
+);
 `
 
 	if _, err := db.Exec(schema); err != nil {
@@ -169,30 +448,120 @@ CREATE INDEX IF NOT EXISTS idx_chat_scope_created ON chat_messages(scope_type, s
 	if err := addColumnIfMissing(db, "tasks", "route_owner_user_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return fmt.Errorf("add tasks.route_owner_user_id: %w", err)
 	}
+	if err := addColumnIfMissing(db, "users", "totp_secret", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add users.totp_secret: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add users.totp_enabled: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add users.totp_last_step: %w", err)
+	}
+	if err := addColumnIfMissing(db, "sessions", "ip", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add sessions.ip: %w", err)
+	}
+	if err := addColumnIfMissing(db, "sessions", "user_agent", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add sessions.user_agent: %w", err)
+	}
+	if err := addColumnIfMissing(db, "sessions", "device", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add sessions.device: %w", err)
+	}
+	if err := addColumnIfMissing(db, "sessions", "last_seen_at", "DATETIME"); err != nil {
+		return fmt.Errorf("add sessions.last_seen_at: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "auth_source", "TEXT NOT NULL DEFAULT 'local'"); err != nil {
+		return fmt.Errorf("add users.auth_source: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "ldap_dn", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add users.ldap_dn: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "is_active", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return fmt.Errorf("add users.is_active: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add users.oidc_subject: %w", err)
+	}
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject) WHERE oidc_subject <> ''`); err != nil {
+		return fmt.Errorf("create users.oidc_subject index: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "is_service", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add users.is_service: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "must_change_password", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add users.must_change_password: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "registration_status", "TEXT NOT NULL DEFAULT 'approved'"); err != nil {
+		return fmt.Errorf("add users.registration_status: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "registration_comment", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add users.registration_comment: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "registration_reviewed_by", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add users.registration_reviewed_by: %w", err)
+	}
+	if err := addColumnIfMissing(db, "users", "registration_reviewed_at", "DATETIME"); err != nil {
+		return fmt.Errorf("add users.registration_reviewed_at: %w", err)
+	}
+	if err := addColumnIfMissing(db, "departments", "parent_id", "INTEGER REFERENCES departments(id)"); err != nil {
+		return fmt.Errorf("add departments.parent_id: %w", err)
+	}
+	if err := addColumnIfMissing(db, "departments", "head_user_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add departments.head_user_id: %w", err)
+	}
+	if err := addColumnIfMissing(db, "tasks", "route_unit_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add tasks.route_unit_id: %w", err)
+	}
+	// Тип вложения по содержимому, пустой у файлов, загруженных до проверки загрузок.
+	if err := addColumnIfMissing(db, "users", "avatar_type", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add users.avatar_type: %w", err)
+	}
+	if err := addColumnIfMissing(db, "reports", "file_type", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add reports.file_type: %w", err)
+	}
+	if err := addColumnIfMissing(db, "chat_messages", "file_type", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("add chat_messages.file_type: %w", err)
+	}
+	if err := addColumnIfMissing(db, "tasks", "parent_task_id", "INTEGER REFERENCES tasks(id)"); err != nil {
+		return fmt.Errorf("add tasks.parent_task_id: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_tasks_parent ON tasks(parent_task_id) WHERE parent_task_id IS NOT NULL`); err != nil {
+		return fmt.Errorf("create tasks.parent_task_id index: %w", err)
+	}
+	if err := addColumnIfMissing(db, "projects", "workflow_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add projects.workflow_id: %w", err)
+	}
+	if err := addColumnIfMissing(db, "projects", "task_seq", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add projects.task_seq: %w", err)
+	}
+	if err := addColumnIfMissing(db, "projects", "deleted_at", "DATETIME"); err != nil {
+		return fmt.Errorf("add projects.deleted_at: %w", err)
+	}
+	if err := addColumnIfMissing(db, "projects", "deleted_by", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add projects.deleted_by: %w", err)
+	}
+	if err := addColumnIfMissing(db, "tasks", "deleted_at", "DATETIME"); err != nil {
+		return fmt.Errorf("add tasks.deleted_at: %w", err)
+	}
+	if err := addColumnIfMissing(db, "tasks", "deleted_by", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add tasks.deleted_by: %w", err)
+	}
+	if err := addColumnIfMissing(db, "reports", "deleted_at", "DATETIME"); err != nil {
+		return fmt.Errorf("add reports.deleted_at: %w", err)
+	}
+	if err := addColumnIfMissing(db, "reports", "deleted_by", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("add reports.deleted_by: %w", err)
+	}
+	if err := dropQuarantineUploaderCascade(db); err != nil {
+		return err
+	}
 	if _, err := db.Exec(`UPDATE projects SET status = 'Активен' WHERE status IS NULL OR status = ''`); err != nil {
 		return fmt.Errorf("normalize projects.status: %w", err)
 	}
-	if _, err := db.Exec(`
-INSERT OR IGNORE INTO departments (id, name) VALUES
-  (1, 'Отдел сопровождения информационных систем'),
-  (2, 'Отдел поддержки и развития инфраструктуры'),
-  (3, 'Отдел технической поддержки'),
-  (4, 'Отдел по обеспечению информационной безопасности');
-`); err != nil {
-		return fmt.Errorf("seed departments: %w", err)
+	if err := seedDepartments(db); err != nil {
+		return err
 	}
-	if _, err := db.Exec(`
-UPDATE departments
-SET name = CASE id
-  WHEN 1 THEN 'Отдел сопровождения информационных систем'
-  WHEN 2 THEN 'Отдел поддержки и развития инфраструктуры'
-  WHEN 3 THEN 'Отдел технической поддержки'
-  WHEN 4 THEN 'Отдел по обеспечению информационной безопасности'
-  ELSE name
-END
-WHERE id IN (1,2,3,4);
-`); err != nil {
-		return fmt.Errorf("normalize departments names: %w", err)
+	if err := syncBuiltInRoles(db); err != nil {
+		return err
 	}
 	if _, err := db.Exec(`UPDATE users SET department_id = 1 WHERE department_id IS NULL OR department_id = 0`); err != nil {
 		return fmt.Errorf("normalize users.department_id: %w", err)
@@ -215,6 +584,160 @@ WHERE route_owner_user_id IS NULL OR route_owner_user_id = 0
 	return nil
 }
 
+// seedDepartmentPositions — начальный справочник отделов и их должностей для пустой базы.
+var seedDepartmentPositions = []struct {
+	id        int64
+	name      string
+	positions []string
+}{
+	{1, "Отдел сопровождения информационных систем", []string{
+		"Начальник Отдела Поддержки текущих сервисов",
+		"Ведущий системный аналитик отдела Поддержки текущих сервисов",
+		"Системный аналитик отдела Поддержки текущих сервисов",
+		"Ведущий разработчик отдела Поддержки текущих сервисов",
+		"Разработчик отдела Поддержки текущих сервисов",
+		"Тестировщик отдела Поддержки текущих сервисов",
+	}},
+	{2, "Отдел поддержки и развития инфраструктуры", []string{
+		"Начальник отдела поддержки и развития инфраструктуры",
+		"Ведущий системный администратор",
+		"Системный администратор",
+		"Ведущий сетевой инженер",
+		"Сетевой инженер",
+		"Главный специалист",
+	}},
+	{3, "Отдел технической поддержки", []string{
+		"Начальник отдела технической поддержки",
+		"Главный специалист технической поддержки",
+		"Специалист технической поддержки",
+	}},
+	{4, "Отдел по обеспечению информационной безопасности", []string{
+		"Начальник отдела ООИБ",
+		"Зам. нач. отдела ООИБ по бумагам",
+		"Зам. нач. отдела ООИБ по тех. части",
+		"Главный инспектор ООИБ",
+		"Инспектор ООИБ",
+	}},
+}
+
+// seedDepartments заполняет справочник отделов и должностей один раз, пока он пуст.
+// Дальше структура меняется только через API, при запуске ничего не переименовывается.
+// Первая должность в списке — должность начальника отдела.
+func seedDepartments(db *sql.DB) error {
+	var positions int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM department_positions`).Scan(&positions); err != nil {
+		return fmt.Errorf("count department positions: %w", err)
+	}
+	if positions > 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, dep := range seedDepartmentPositions {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO departments (id, name) VALUES (?, ?)`, dep.id, dep.name); err != nil {
+			return fmt.Errorf("seed departments: %w", err)
+		}
+		for i, name := range dep.positions {
+			if _, err := tx.Exec(`
+INSERT OR IGNORE INTO department_positions (department_id, name, is_head) VALUES (?, ?, ?)
+`, dep.id, name, boolToInt(i == 0)); err != nil {
+				return fmt.Errorf("seed department positions: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// syncBuiltInRoles создает встроенные роли и перезаписывает их права значениями из кода,
+// чтобы новые действия появлялись у встроенных ролей после обновления.
+func syncBuiltInRoles(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	roleIDs := make(map[string]int64, len(authz.BuiltInRoles))
+	for _, role := range authz.BuiltInRoles {
+		if _, err := tx.Exec(`
+INSERT INTO roles (name, title, built_in) VALUES (?, ?, 1)
+ON CONFLICT(name) DO UPDATE SET built_in = 1
+`, role.Name, role.Title); err != nil {
+			return fmt.Errorf("seed roles: %w", err)
+		}
+		var id int64
+		if err := tx.QueryRow(`SELECT id FROM roles WHERE name = ?`, role.Name).Scan(&id); err != nil {
+			return fmt.Errorf("seed roles: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_id = ?`, id); err != nil {
+			return fmt.Errorf("reset built-in permissions: %w", err)
+		}
+		roleIDs[role.Name] = id
+	}
+	for _, grant := range authz.DefaultGrants() {
+		if _, err := tx.Exec(`
+INSERT OR IGNORE INTO role_permissions (role_id, action, scope, condition) VALUES (?, ?, ?, ?)
+`, roleIDs[grant.Role], string(grant.Action), grant.Scope.String(), grant.Condition); err != nil {
+			return fmt.Errorf("seed role permissions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// dropQuarantineUploaderCascade пересоздает карантин из ранних версий, где записи удалялись
+// каскадом вместе с загрузившим файл пользователем. SQLite не умеет менять внешний ключ на месте.
+func dropQuarantineUploaderCascade(db *sql.DB) error {
+	var ddl string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'quarantined_files'`).Scan(&ddl); err != nil {
+		return fmt.Errorf("read quarantined_files schema: %w", err)
+	}
+	if !strings.Contains(strings.ToUpper(ddl), "REFERENCES") {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const columns = `id, kind, scope_type, scope_id, uploader_user_id, file_name, file_path, file_type, file_size, threat, scan_error, created_at`
+	for _, stmt := range []string{
+		`CREATE TABLE quarantined_files_new (` + quarantinedFilesColumns + `)`,
+		`INSERT INTO quarantined_files_new (` + columns + `) SELECT ` + columns + ` FROM quarantined_files`,
+		`DROP TABLE quarantined_files`,
+		`ALTER TABLE quarantined_files_new RENAME TO quarantined_files`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuild quarantined_files: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
 func addColumnIfMissing(db *sql.DB, table, column, columnDDL string) error {
 	rows, err := db.Query("PRAGMA table_info(" + table + ")")
 	if err != nil {
@@ -248,21 +771,51 @@ func addColumnIfMissing(db *sql.DB, table, column, columnDDL string) error {
 	return err
 }
 
-func seed(db *sql.DB) error {
-	const passwordHash = "f48e4108e20d273af6d593944082a371f505ce406144fa6fc815cb52026fbef4"
+type seedUser struct {
+	login    string
+	fullName string
+	position string
+	role     string
+}
 
-	_, err := db.Exec(`
-INSERT OR IGNORE INTO users (login, password_hash, full_name, position, role) VALUES
-  ('owner', ?, 'Сергей Волков', 'Owner', 'Owner'),
-  ('admin', ?, 'Алексей Смирнов', 'System Administrator', 'Admin'),
-  ('manager', ?, 'Екатерина Петрова', 'Project Manager', 'Project Manager'),
-  ('qa_lead', ?, 'Мария Денисова', 'QA Lead', 'Member');
-`, passwordHash, passwordHash, passwordHash, passwordHash)
-	if err != nil {
-		return fmt.Errorf("seed users: %w", err)
+var seedUsers = []seedUser{
+	{login: "owner", fullName: "Сергей Волков", position: "Owner", role: "Owner"},
+	{login: "admin", fullName: "Алексей Смирнов", position: "System Administrator", role: "Admin"},
+	{login: "manager", fullName: "Екатерина Петрова", position: "Project Manager", role: "Project Manager"},
+	{login: "qa_lead", fullName: "Мария Денисова", position: "QA Lead", role: "Member"},
+}
+
+const seedPassword = "admin123"
+
+func seed(db *sql.DB, passwords *auth.PasswordHasher) error {
+	for _, u := range seedUsers {
+		var userID int64
+		var existingHash string
+		err := db.QueryRow(`SELECT id, password_hash FROM users WHERE login = ?`, u.login).Scan(&userID, &existingHash)
+		if err == nil {
+			// Учетные записи, где все еще стоит пароль по умолчанию, обязаны сменить его при входе.
+			if ok, _ := passwords.Verify(existingHash, seedPassword); ok {
+				if _, err := db.Exec(`UPDATE users SET must_change_password = 1 WHERE id = ?`, userID); err != nil {
+					return fmt.Errorf("seed users: %w", err)
+				}
+			}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("seed users: %w", err)
+		}
+		hash, err := passwords.Hash(seedPassword)
+		if err != nil {
+			return fmt.Errorf("seed users: %w", err)
+		}
+		if _, err := db.Exec(`
+INSERT OR IGNORE INTO users (login, password_hash, full_name, position, role, must_change_password) VALUES (?, ?, ?, ?, ?, 1)
+`, u.login, hash, u.fullName, u.position, u.role); err != nil {
+			return fmt.Errorf("seed users: %w", err)
+		}
 	}
 
-	_, err = db.Exec(`
+	_, err := db.Exec(`
 INSERT OR IGNORE INTO projects (key, name, curator_user_id) VALUES
   ('PRJ', 'Система уведомлений', (SELECT id FROM users WHERE login = 'manager')),
   ('OPS', 'Инфраструктура и мониторинг', (SELECT id FROM users WHERE login = 'owner'));
@@ -328,7 +881,7 @@ SET department_id = CASE
   WHEN login IN ('manager', 'qa_lead') THEN 1
   ELSE 2
 END
-WHERE department_id IS NULL OR department_id = 1;
+WHERE department_id IS NULL;
 `); err != nil {
 		return fmt.Errorf("seed users departments mapping: %w", err)
 	}
@@ -339,10 +892,182 @@ SET department_id = CASE
   WHEN key = 'OPS' THEN 3
   ELSE 2
 END
-WHERE department_id IS NULL OR department_id = 1;
+WHERE department_id IS NULL;
 `); err != nil {
 		return fmt.Errorf("seed projects departments mapping: %w", err)
 	}
+	if err := seedOrgStructure(db); err != nil {
+		return err
+	}
+	if err := seedDefaultWorkflow(db); err != nil {
+		return err
+	}
+	if err := syncTaskKeyCounters(db); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// seedOrgStructure превращает плоский список отделов в дерево: над ними создается
+// управление (УЦС), куда переходят начальник УЦС и заместители, руководителями
+// назначаются начальник УЦС и начальники отделов.
+// Выполняется один раз — пока корневых подразделений больше одного.
+func seedOrgStructure(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var roots int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM departments WHERE parent_id IS NULL`).Scan(&roots); err != nil {
+		return fmt.Errorf("count root departments: %w", err)
+	}
+	if roots > 1 {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO departments (name) VALUES ('Управление Цифровых Сервисов')`); err != nil {
+			return fmt.Errorf("seed org root: %w", err)
+		}
+		var rootID int64
+		if err := tx.QueryRow(`SELECT id FROM departments WHERE name = 'Управление Цифровых Сервисов'`).Scan(&rootID); err != nil {
+			return fmt.Errorf("seed org root: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE departments SET parent_id = ? WHERE parent_id IS NULL AND id <> ?`, rootID, rootID); err != nil {
+			return fmt.Errorf("seed org tree: %w", err)
+		}
+		// Руководство УЦС становится сотрудниками управления, а не одного из отделов.
+		if _, err := tx.Exec(`UPDATE users SET department_id = ? WHERE role IN ('Admin', 'Deputy Admin')`, rootID); err != nil {
+			return fmt.Errorf("seed org root members: %w", err)
+		}
+		if _, err := tx.Exec(`
+INSERT OR IGNORE INTO department_positions (department_id, name, is_head) VALUES
+  (?, 'Начальник УЦС', 1),
+  (?, 'Заместитель начальника УЦС', 0)
+`, rootID, rootID); err != nil {
+			return fmt.Errorf("seed org root positions: %w", err)
+		}
+		if _, err := tx.Exec(`
+UPDATE departments
+SET head_user_id = COALESCE((SELECT id FROM users WHERE role = 'Admin' AND is_active = 1 ORDER BY id LIMIT 1), 0)
+WHERE id = ? AND head_user_id = 0
+`, rootID); err != nil {
+			return fmt.Errorf("seed org root head: %w", err)
+		}
+		if _, err := tx.Exec(`
+UPDATE departments
+SET head_user_id = COALESCE((
+  SELECT MIN(u.id) FROM users u
+  JOIN department_positions dp ON dp.department_id = u.department_id AND dp.is_head = 1 AND dp.name = u.position
+  WHERE u.department_id = departments.id AND u.role = 'Project Manager' AND u.is_active = 1
+), (
+  SELECT MIN(u.id) FROM users u
+  WHERE u.department_id = departments.id AND u.role = 'Project Manager' AND u.is_active = 1
+), 0)
+WHERE parent_id = ? AND head_user_id = 0
+`, rootID); err != nil {
+			return fmt.Errorf("seed org heads: %w", err)
+		}
+	}
+	// Задачи, которые были у руководства УЦС (этапы 1–2), находятся в корне, остальные — в отделе проекта.
+	if _, err := tx.Exec(`
+UPDATE tasks
+SET route_unit_id = CASE
+  WHEN route_stage <= 2 THEN COALESCE((SELECT id FROM departments WHERE parent_id IS NULL ORDER BY id LIMIT 1), 0)
+  ELSE COALESCE((SELECT p.department_id FROM projects p WHERE p.id = tasks.project_id), 0)
+END
+WHERE route_unit_id = 0
+`); err != nil {
+		return fmt.Errorf("normalize tasks.route_unit_id: %w", err)
+	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// Статусы основного процесса в порядке прохождения; первый — начальный, последний — конечный.
+var defaultWorkflowStatuses = []struct {
+	name  string
+	title string
+}{
+	{"To Do", "К выполнению"},
+	{"In Progress", "В работе"},
+	{"Review", "На проверке"},
+	{"Done", "Готово"},
+}
+
+// seedDefaultWorkflow создает основной процесс, пока процессов нет: к стандартным статусам
+// добавляются все статусы, которые уже встречаются у задач, а переходы разрешены между
+// любыми статусами любой роли, чтобы миграция не ограничила существующую работу.
+// Проекты без процесса (в том числе созданные до миграции) получают основной процесс.
+func seedDefaultWorkflow(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var workflows int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM workflows`).Scan(&workflows); err != nil {
+		return fmt.Errorf("count workflows: %w", err)
+	}
+	if workflows == 0 {
+		res, err := tx.Exec(`INSERT INTO workflows (name, is_default) VALUES ('Основной процесс', 1)`)
+		if err != nil {
+			return fmt.Errorf("seed default workflow: %w", err)
+		}
+		workflowID, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("seed default workflow: %w", err)
+		}
+		for i, st := range defaultWorkflowStatuses {
+			if _, err := tx.Exec(`
+INSERT INTO workflow_statuses (workflow_id, name, title, is_initial, is_terminal, position) VALUES (?, ?, ?, ?, ?, ?)
+`, workflowID, st.name, st.title, boolToInt(i == 0), boolToInt(i == len(defaultWorkflowStatuses)-1), i+1); err != nil {
+				return fmt.Errorf("seed default workflow statuses: %w", err)
+			}
+		}
+		if _, err := tx.Exec(`
+INSERT OR IGNORE INTO workflow_statuses (workflow_id, name, title, position)
+SELECT ?, status, status, 100 + MIN(id) FROM tasks WHERE TRIM(status) <> '' GROUP BY status
+`, workflowID); err != nil {
+			return fmt.Errorf("seed workflow statuses from tasks: %w", err)
+		}
+		if _, err := tx.Exec(`
+INSERT INTO workflow_transitions (workflow_id, from_status, to_status)
+SELECT a.workflow_id, a.name, b.name
+FROM workflow_statuses a JOIN workflow_statuses b ON b.workflow_id = a.workflow_id AND b.name <> a.name
+WHERE a.workflow_id = ?
+`, workflowID); err != nil {
+			return fmt.Errorf("seed default workflow transitions: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`
+UPDATE projects SET workflow_id = (SELECT id FROM workflows WHERE is_default = 1 ORDER BY id LIMIT 1)
+WHERE workflow_id = 0 OR workflow_id NOT IN (SELECT id FROM workflows)
+`); err != nil {
+		return fmt.Errorf("normalize projects.workflow_id: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// syncTaskKeyCounters подтягивает счетчик ключей проекта до наибольшего номера среди задач
+// с ключом вида {ключ проекта}-{n}, чтобы новые ключи продолжали существующую нумерацию.
+func syncTaskKeyCounters(db *sql.DB) error {
+	if _, err := db.Exec(`
+UPDATE projects
+SET task_seq = MAX(task_seq, COALESCE((
+  SELECT MAX(CAST(substr(t.key, length(projects.key) + 2) AS INTEGER))
+  FROM tasks t
+  WHERE t.project_id = projects.id AND substr(t.key, 1, length(projects.key) + 1) = projects.key || '-'
+), 0))
+`); err != nil {
+		return fmt.Errorf("sync projects.task_seq: %w", err)
+	}
 	return nil
 }
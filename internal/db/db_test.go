@@ -0,0 +1,48 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/auth"
+)
+
+func TestOpenKeepsDSNParameters(t *testing.T) {
+	passwords := auth.NewPasswordHasher("test-pepper", auth.Argon2Params{Memory: 1024, Time: 1, Threads: 1})
+	path := filepath.Join(t.TempDir(), "data", "taskflow.db") + "?_pragma=busy_timeout(5000)"
+	sqlDB, err := Open(path, passwords)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer sqlDB.Close()
+
+	var foreignKeys, busyTimeout int
+	if err := sqlDB.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Fatalf("foreign_keys = %d, %v; want 1", foreignKeys, err)
+	}
+	if err := sqlDB.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil || busyTimeout != 5000 {
+		t.Fatalf("busy_timeout = %d, %v; want 5000 from the configured DSN", busyTimeout, err)
+	}
+
+	existing, err := OpenExisting(path)
+	if err != nil {
+		t.Fatalf("open existing: %v", err)
+	}
+	defer existing.Close()
+	if err := existing.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Fatalf("existing foreign_keys = %d, %v; want 1", foreignKeys, err)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	tests := map[string]string{
+		"data/taskflow.db":                           "data/taskflow.db?_pragma=foreign_keys(1)",
+		"file:data/taskflow.db?mode=rwc":             "file:data/taskflow.db?mode=rwc&_pragma=foreign_keys(1)",
+		"data/taskflow.db?_pragma=journal_mode(WAL)": "data/taskflow.db?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)",
+	}
+	for path, want := range tests {
+		if got := dsn(path); got != want {
+			t.Errorf("dsn(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
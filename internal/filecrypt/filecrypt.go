@@ -0,0 +1,491 @@
+package filecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Формат зашифрованного файла:
+//
+//	magic(8) | id мастер-ключа(8) | nonce(12) | ключ данных, зашифрованный мастер-ключом(48) | префикс nonce(8) | блоки
+//
+// Содержимое шифруется AES-256-GCM ключом данных, уникальным для файла, блоками по chunkSize.
+// Nonce блока — префикс и номер блока, признак последнего блока входит в AAD, поэтому
+// переставить, удалить или отрезать блоки незаметно нельзя. Блоки позволяют расшифровывать
+// файл потоком и с произвольного места (Range-запросы при скачивании).
+const (
+	KeySize = 32
+
+	chunkSize  = 64 << 10
+	tagSize    = 16
+	keyIDSize  = 8
+	nonceSize  = 12
+	prefixSize = 8
+	wrappedLen = KeySize + tagSize
+	headerSize = len(magic) + keyIDSize + nonceSize + wrappedLen + prefixSize
+)
+
+// magic начинается с байта, недопустимого в начале UTF-8 текста, как сигнатура PNG: проверенные
+// при загрузке файлы не могут случайно с ним совпасть.
+const magic = "\x89TFE1\r\n\x1a"
+
+var ErrUnknownKey = errors.New("file is encrypted with an unknown master key")
+
+type masterKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// Keyring — текущий мастер-ключ, которым шифруются новые файлы, и прежние ключи, которые нужны
+// только для чтения файлов до ротации. nil Keyring означает, что шифрование выключено:
+// файлы пишутся и читаются как есть.
+type Keyring struct {
+	primary *masterKey
+	keys    map[[keyIDSize]byte]*masterKey
+}
+
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[[keyIDSize]byte]*masterKey)}
+	for i, raw := range append([][]byte{primary}, previous...) {
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = key
+		}
+		if _, ok := k.keys[key.id]; !ok {
+			k.keys[key.id] = key
+		}
+	}
+	return k, nil
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	key := &masterKey{aead: aead}
+	sum := sha256.Sum256(raw)
+	copy(key.id[:], sum[:keyIDSize])
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("new gcm: %w", err)
+	}
+	return aead, nil
+}
+
+// ParseKey принимает ключ в base64 (стандартном или URL-safe) или hex.
+func ParseKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	for _, decode := range []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+		hex.DecodeString,
+	} {
+		if raw, err := decode(value); err == nil && len(raw) == KeySize {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("master key must be %d bytes in base64 or hex", KeySize)
+}
+
+// LoadKey берет ключ из значения, а если оно пустое — из файла. Пустые оба — ключа нет.
+func LoadKey(value, file string) ([]byte, error) {
+	if strings.TrimSpace(value) == "" && strings.TrimSpace(file) != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		value = string(raw)
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	return ParseKey(value)
+}
+
+// GenerateKey возвращает новый мастер-ключ в base64.
+func GenerateKey() (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// WriteFile сохраняет содержимое, зашифровав его текущим мастер-ключом.
+func (k *Keyring) WriteFile(path string, content []byte, perm os.FileMode) error {
+	if k == nil {
+		return os.WriteFile(path, content, perm)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := k.encrypt(f, bytes.NewReader(content)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func (k *Keyring) encrypt(w io.Writer, plain io.Reader) error {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("generate data key: %w", err)
+	}
+	header, err := k.header(dataKey, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	prefix := header[headerSize-prefixSize:]
+
+	// Блок шифруется, когда прочитан следующий: только так известно, последний ли он.
+	current := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(plain, current)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("read content: %w", err)
+	}
+	for index := uint32(0); ; index++ {
+		m, err := io.ReadFull(plain, next)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read content: %w", err)
+		}
+		last := m == 0
+		sealed := aead.Seal(nil, chunkNonce(prefix, index), current[:n], chunkAAD(last))
+		if _, err := w.Write(sealed); err != nil {
+			return fmt.Errorf("write chunk: %w", err)
+		}
+		if last {
+			return nil
+		}
+		current, next = next, current
+		n = m
+	}
+}
+
+// header собирает заголовок файла: ключ данных, зашифрованный текущим мастер-ключом, и префикс nonce.
+// prefix равен nil для нового файла.
+func (k *Keyring) header(dataKey, prefix []byte) ([]byte, error) {
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, k.primary.id[:]...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+	header = k.primary.aead.Seal(header, nonce, dataKey, header[:len(magic)+keyIDSize])
+	if prefix == nil {
+		prefix = make([]byte, prefixSize)
+		if _, err := rand.Read(prefix); err != nil {
+			return nil, fmt.Errorf("generate nonce prefix: %w", err)
+		}
+	}
+	return append(header, prefix...), nil
+}
+
+// unwrap расшифровывает ключ данных из заголовка и возвращает id мастер-ключа, которым он был зашифрован.
+func (k *Keyring) unwrap(header []byte) ([]byte, [keyIDSize]byte, error) {
+	var id [keyIDSize]byte
+	copy(id[:], header[len(magic):])
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, id, ErrUnknownKey
+	}
+	nonceStart := len(magic) + keyIDSize
+	wrapped := header[nonceStart+nonceSize : nonceStart+nonceSize+wrappedLen]
+	dataKey, err := key.aead.Open(nil, header[nonceStart:nonceStart+nonceSize], wrapped, header[:nonceStart])
+	if err != nil {
+		return nil, id, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dataKey, id, nil
+}
+
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], index)
+	return nonce
+}
+
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// File — открытый сохраненный файл. Для зашифрованного чтение и Seek работают с исходным
+// содержимым, блоки расшифровываются по мере чтения.
+type File struct {
+	io.ReadSeeker
+	ModTime time.Time
+
+	file *os.File
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// Open открывает файл на чтение. Файлы без заголовка (загруженные до включения шифрования)
+// отдаются как есть.
+func (k *Keyring) Open(path string) (*File, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	result := &File{ReadSeeker: fd, ModTime: stat.ModTime(), file: fd}
+	header, encrypted, err := readHeader(fd, stat.Size())
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if !encrypted {
+		return result, nil
+	}
+	if k == nil {
+		fd.Close()
+		return nil, errors.New("file is encrypted but no master key is configured")
+	}
+	dataKey, _, err := k.unwrap(header)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	reader, err := newReader(fd, stat.Size(), dataKey, header[headerSize-prefixSize:])
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	result.ReadSeeker = reader
+	return result, nil
+}
+
+func readHeader(fd *os.File, size int64) ([]byte, bool, error) {
+	if size < int64(headerSize+tagSize) {
+		return nil, false, nil
+	}
+	header := make([]byte, headerSize)
+	if _, err := fd.ReadAt(header, 0); err != nil {
+		return nil, false, fmt.Errorf("read header: %w", err)
+	}
+	return header, string(header[:len(magic)]) == magic, nil
+}
+
+// reader расшифровывает блоки по запросу и держит в памяти только текущий.
+type reader struct {
+	file   io.ReaderAt
+	aead   cipher.AEAD
+	prefix []byte
+	chunks int64
+	size   int64
+	pos    int64
+
+	cached int64
+	plain  []byte
+}
+
+func newReader(file io.ReaderAt, fileSize int64, dataKey, prefix []byte) (*reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	body := fileSize - int64(headerSize)
+	chunks := (body + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	size := body - chunks*tagSize
+	if chunks == 0 || size < 0 || body-(chunks-1)*(chunkSize+tagSize) < tagSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	return &reader{file: file, aead: aead, prefix: prefix, chunks: chunks, size: size, cached: -1}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	index := r.pos / chunkSize
+	if index != r.cached {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos-index*chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *reader) load(index int64) error {
+	offset := int64(headerSize) + index*(chunkSize+tagSize)
+	length := int64(chunkSize + tagSize)
+	if index == r.chunks-1 {
+		length = int64(headerSize) + r.size + r.chunks*tagSize - offset
+	}
+	sealed := make([]byte, length)
+	if _, err := r.file.ReadAt(sealed, offset); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read chunk: %w", err)
+	}
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(r.prefix, uint32(index)), sealed, chunkAAD(index == r.chunks-1))
+	if err != nil {
+		r.cached = -1
+		return fmt.Errorf("decrypt chunk %d: %w", index, err)
+	}
+	r.plain = plain
+	r.cached = index
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Rotate приводит файл к текущему мастер-ключу: открытый файл шифруется, у зашифрованного
+// прежним ключом перешифровывается только ключ данных. Файл заменяется атомарно.
+// Возвращает false, если файл уже зашифрован текущим ключом.
+func (k *Keyring) Rotate(path string) (bool, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+	stat, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+	header, encrypted, err := readHeader(fd, stat.Size())
+	if err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rotate-*")
+	if err != nil {
+		return false, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if encrypted {
+		dataKey, id, err := k.unwrap(header)
+		if err != nil {
+			return false, err
+		}
+		if id == k.primary.id {
+			return false, nil
+		}
+		newHeader, err := k.header(dataKey, header[headerSize-prefixSize:])
+		if err != nil {
+			return false, err
+		}
+		if _, err := tmp.Write(newHeader); err != nil {
+			return false, fmt.Errorf("write header: %w", err)
+		}
+		if _, err := io.Copy(tmp, io.NewSectionReader(fd, int64(headerSize), stat.Size()-int64(headerSize))); err != nil {
+			return false, fmt.Errorf("copy chunks: %w", err)
+		}
+	} else if err := k.encrypt(tmp, fd); err != nil {
+		return false, err
+	}
+
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return false, fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, fmt.Errorf("replace file: %w", err)
+	}
+	return true, nil
+}
+
+// LoadKeyring собирает ключи из настроек. Если текущий ключ не задан, возвращает nil —
+// шифрование выключено; прежние ключи без текущего не допускаются.
+func LoadKeyring(key, keyFile string, previous, previousFiles []string) (*Keyring, error) {
+	primary, err := LoadKey(key, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	old := make([][]byte, 0, len(previous)+len(previousFiles))
+	for _, value := range previous {
+		raw, err := ParseKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		old = append(old, raw)
+	}
+	for _, file := range previousFiles {
+		raw, err := LoadKey("", file)
+		if err != nil {
+			return nil, fmt.Errorf("previous key %s: %w", file, err)
+		}
+		if raw != nil {
+			old = append(old, raw)
+		}
+	}
+	if primary == nil {
+		if len(old) > 0 {
+			return nil, errors.New("previous master keys are set without a current one")
+		}
+		return nil, nil
+	}
+	return NewKeyring(primary, old...)
+}
@@ -0,0 +1,209 @@
+package filecrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyring(t *testing.T, fill byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(bytes.Repeat([]byte{fill}, KeySize), previous...)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	return k
+}
+
+// pattern — содержимое, в котором у каждого байта своя позиция: перепутанный блок сразу виден.
+func pattern(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/chunkSize)
+	}
+	return content
+}
+
+func readAll(t *testing.T, k *Keyring, path string) []byte {
+	t.Helper()
+	f, err := k.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return got
+}
+
+func TestRoundTripAcrossChunkBoundaries(t *testing.T) {
+	k := testKeyring(t, 1)
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 3*chunkSize + 123} {
+		path := filepath.Join(dir, "file.bin")
+		content := pattern(size)
+		if err := k.WriteFile(path, content, 0o600); err != nil {
+			t.Fatalf("size %d: write: %v", size, err)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("size %d: read raw: %v", size, err)
+		}
+		if size > 0 && bytes.Contains(raw, content) {
+			t.Fatalf("size %d: file stored in plain text", size)
+		}
+		if got := readAll(t, k, path); !bytes.Equal(got, content) {
+			t.Fatalf("size %d: round trip returned %d bytes, want %d", size, len(got), len(content))
+		}
+	}
+}
+
+func TestSeekAtArbitraryOffsets(t *testing.T) {
+	k := testKeyring(t, 1)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	content := pattern(3*chunkSize + 500)
+	if err := k.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f, err := k.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		offset int64
+		whence int
+		pos    int64
+		length int
+	}{
+		{0, io.SeekStart, 0, 10},
+		{chunkSize - 3, io.SeekStart, chunkSize - 3, 10}, // чтение через границу блока
+		{2*chunkSize + 17, io.SeekStart, 2*chunkSize + 17, chunkSize + 200},
+		{-100, io.SeekCurrent, 3*chunkSize + 117, 50},
+		{-20, io.SeekEnd, int64(len(content)) - 20, 20},
+		{5, io.SeekStart, 5, 2 * chunkSize},
+	}
+	for _, tt := range tests {
+		pos, err := f.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("Seek(%d, %d) = %d, %v; want %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		got := make([]byte, tt.length)
+		if _, err := io.ReadFull(f, got); err != nil {
+			t.Fatalf("read at %d: %v", pos, err)
+		}
+		if !bytes.Equal(got, content[pos:pos+int64(tt.length)]) {
+			t.Fatalf("read at %d returned wrong bytes", pos)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("seek end: %v", err)
+	}
+	if n, err := f.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Fatalf("read at end = %d, %v; want EOF", n, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seek before start must fail")
+	}
+}
+
+func TestTamperedChunksAreRejected(t *testing.T) {
+	k := testKeyring(t, 1)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin")
+	if err := k.WriteFile(path, pattern(3*chunkSize), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read raw: %v", err)
+	}
+	sealed := chunkSize + tagSize
+	chunk := func(i int) []byte { return raw[headerSize+i*sealed : headerSize+(i+1)*sealed] }
+	flipped := bytes.Clone(raw)
+	flipped[headerSize+10] ^= 1
+
+	tampered := map[string][]byte{
+		// Последний блок отрезан: предпоследний не помечен как последний.
+		"truncated at chunk boundary": raw[:headerSize+2*sealed],
+		"truncated inside chunk":      raw[:len(raw)-100],
+		"reordered chunks":            bytes.Join([][]byte{raw[:headerSize], chunk(1), chunk(0), chunk(2)}, nil),
+		"flipped byte":                flipped,
+	}
+	for name, content := range tampered {
+		t.Run(name, func(t *testing.T) {
+			bad := filepath.Join(dir, "bad.bin")
+			if err := os.WriteFile(bad, content, 0o600); err != nil {
+				t.Fatalf("write tampered: %v", err)
+			}
+			f, err := k.Open(bad)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			if _, err := io.ReadAll(f); err == nil {
+				t.Fatal("tampered file decrypted without error")
+			}
+		})
+	}
+}
+
+func TestWrongKeyFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := testKeyring(t, 1).WriteFile(path, []byte("секрет"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := testKeyring(t, 2).Open(path); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("open with another key: %v, want ErrUnknownKey", err)
+	}
+	var disabled *Keyring
+	if _, err := disabled.Open(path); err == nil {
+		t.Fatal("encrypted file opened without a key")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, KeySize)
+	dir := t.TempDir()
+	encrypted := filepath.Join(dir, "old.bin")
+	plain := filepath.Join(dir, "plain.txt")
+	content := pattern(chunkSize + 10)
+	if err := testKeyring(t, 1).WriteFile(encrypted, content, 0o640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(plain, []byte("загружен до шифрования"), 0o600); err != nil {
+		t.Fatalf("write plain: %v", err)
+	}
+
+	k := testKeyring(t, 2, oldKey)
+	for _, path := range []string{encrypted, plain} {
+		rotated, err := k.Rotate(path)
+		if err != nil || !rotated {
+			t.Fatalf("rotate %s = %v, %v; want rotated", path, rotated, err)
+		}
+		if rotated, err := k.Rotate(path); err != nil || rotated {
+			t.Fatalf("second rotate %s = %v, %v; want already current", path, rotated, err)
+		}
+	}
+	if stat, err := os.Stat(encrypted); err != nil || stat.Mode().Perm() != 0o640 {
+		t.Fatalf("rotated file mode = %v, %v; want 0640", stat.Mode().Perm(), err)
+	}
+
+	// После ротации файлы читаются одним новым ключом, без прежнего.
+	current := testKeyring(t, 2)
+	if got := readAll(t, current, encrypted); !bytes.Equal(got, content) {
+		t.Fatal("rotated file content changed")
+	}
+	if got := readAll(t, current, plain); string(got) != "загружен до шифрования" {
+		t.Fatalf("encrypted plain file = %q", got)
+	}
+	if _, err := testKeyring(t, 1).Open(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("open rotated file with old key: %v, want ErrUnknownKey", err)
+	}
+}
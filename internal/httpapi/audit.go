@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+	"github.com/mvd/taskflow/internal/repo"
+)
+
+const (
+	auditDefaultPerPage = 50
+	auditMaxPerPage     = 500
+	auditTimeLayout     = "2006-01-02 15:04:05"
+)
+
+// auditMiddleware кладет в контекст запроса исполнителя для журнала аудита. IP известен
+// сразу, пользователь — после аутентификации в actorFromRequest.
+func (s *Server) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := &repo.AuditActor{IP: s.clientIP(r)}
+		next.ServeHTTP(w, r.WithContext(repo.WithAuditActor(r.Context(), actor)))
+	})
+}
+
+func setAuditUser(r *http.Request, userID int64) {
+	if actor := repo.AuditActorFrom(r.Context()); actor != nil {
+		actor.UserID = userID
+	}
+}
+
+// audit — журнал аудита: GET /api/v1/audit отдает страницу событий, GET /api/v1/audit/export —
+// все события по тем же фильтрам в CSV для проверок.
+func (s *Server) audit(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Path != "/api/v1/audit" && r.URL.Path != "/api/v1/audit/export" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.authorize(w, actor, authz.AuditRead, authz.Resource{}) {
+		return
+	}
+	filter, err := auditFilterFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.URL.Path == "/api/v1/audit/export" {
+		s.exportAudit(w, r, filter)
+		return
+	}
+	page, err := readPositiveIntQuery(r, "page", 1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	perPage, err := readPositiveIntQuery(r, "per_page", auditDefaultPerPage)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if perPage > auditMaxPerPage {
+		perPage = auditMaxPerPage
+	}
+	items, total, err := s.repo.AuditEvents(r.Context(), filter, perPage, (page-1)*perPage)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":    items,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+func (s *Server) exportAudit(w http.ResponseWriter, r *http.Request, filter models.AuditFilter) {
+	fileName := fmt.Sprintf("audit_%s.csv", time.Now().UTC().Format("20060102_150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.WriteHeader(http.StatusOK)
+	// BOM нужен, чтобы Excel открыл файл в UTF-8.
+	_, _ = w.Write([]byte("\xEF\xBB\xBF"))
+
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	_ = out.Write([]string{"id", "created_at", "actor_user_id", "actor_login", "ip", "action", "entity_type", "entity_id", "before", "after"})
+	err := s.repo.EachAuditEvent(r.Context(), filter, func(item models.AuditEvent) error {
+		return out.Write([]string{
+			strconv.FormatInt(item.ID, 10),
+			csvCell(item.CreatedAt),
+			strconv.FormatInt(item.ActorUserID, 10),
+			csvCell(item.ActorLogin),
+			csvCell(item.IP),
+			csvCell(item.Action),
+			csvCell(item.EntityType),
+			strconv.FormatInt(item.EntityID, 10),
+			csvCell(string(item.Before)),
+			csvCell(string(item.After)),
+		})
+	})
+	out.Flush()
+	// Заголовки уже отправлены: обрыв выгрузки виден только в логе и по неполному файлу.
+	if err == nil {
+		err = out.Error()
+	}
+	if err != nil {
+		log.Printf("export audit: %v", err)
+	}
+}
+
+// csvCell экранирует значение, которое табличный редактор принял бы за формулу:
+// логин, название или снимок сущности задает пользователь.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func auditFilterFromRequest(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		Action:     strings.TrimSpace(q.Get("action")),
+		EntityType: strings.TrimSpace(q.Get("entity_type")),
+	}
+	actorID, err := readOptionalInt64Query(r, "actor_id")
+	if err != nil {
+		return filter, err
+	}
+	if actorID != nil {
+		filter.ActorUserID = *actorID
+	}
+	entityID, err := readOptionalInt64Query(r, "entity_id")
+	if err != nil {
+		return filter, err
+	}
+	if entityID != nil {
+		filter.EntityID = *entityID
+	}
+	if value := strings.TrimSpace(q.Get("from")); value != "" {
+		from, _, err := parseAuditTime(value)
+		if err != nil {
+			return filter, errors.New("некорректный параметр from: укажите дату YYYY-MM-DD или время RFC 3339")
+		}
+		filter.From = from.UTC().Format(auditTimeLayout)
+	}
+	if value := strings.TrimSpace(q.Get("to")); value != "" {
+		to, dateOnly, err := parseAuditTime(value)
+		if err != nil {
+			return filter, errors.New("некорректный параметр to: укажите дату YYYY-MM-DD или время RFC 3339")
+		}
+		// Дата без времени включает весь день.
+		if dateOnly {
+			to = to.Add(24 * time.Hour)
+		}
+		filter.To = to.UTC().Format(auditTimeLayout)
+	}
+	return filter, nil
+}
+
+func parseAuditTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t, true, err
+}
+
+func readPositiveIntQuery(r *http.Request, key string, fallback int) (int, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("некорректный параметр %s", key)
+	}
+	return v, nil
+}
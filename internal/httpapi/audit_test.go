@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAuditExportEscapesFormulas(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.addUser("auditor", "Owner", 5)
+	env.exec(`DELETE FROM audit_events`)
+	env.exec(`INSERT INTO audit_events (actor_user_id, actor_login, action, entity_type, entity_id, before_json, after_json, ip)
+VALUES (0, '=HYPERLINK("http://evil")', '+cmd', '-entity', 7, '@SUM(A1)', ?, ?)`, "\tafter", "\r10.0.0.1")
+
+	rec := env.do(http.MethodGet, "/api/v1/audit/export", env.session(owner), nil)
+	expect(t, rec, http.StatusOK)
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(rec.Body.String(), "\xEF\xBB\xBF")))
+	reader.Comma = ';'
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	var row []string
+	for _, r := range rows[1:] {
+		if r[7] == "7" {
+			row = r
+		}
+	}
+	if row == nil {
+		t.Fatalf("exported rows = %q, want event with entity_id 7", rows)
+	}
+	want := map[int]string{
+		3: `'=HYPERLINK("http://evil")`,
+		4: "'\r10.0.0.1",
+		5: "'+cmd",
+		6: "'-entity",
+		8: "'@SUM(A1)",
+		9: "'\tafter",
+	}
+	for col, value := range want {
+		if row[col] != value {
+			t.Errorf("column %s = %q, want %q", rows[0][col], row[col], value)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func (s *Server) policy() *authz.Policy {
+	return s.access.Load()
+}
+
+// reloadPolicy перечитывает роли, права и оргструктуру после их изменения.
+func (s *Server) reloadPolicy(ctx context.Context) error {
+	policy, err := s.repo.AccessPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	s.access.Store(policy)
+	return nil
+}
+
+// authorize проверяет действие по правам роли и при отказе отвечает 403.
+func (s *Server) authorize(w http.ResponseWriter, actor models.User, action authz.Action, res authz.Resource) bool {
+	if err := s.policy().Check(actor, action, res); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// requireScope отвечает 403, если роль пользователя не допускает действие ни над одним ресурсом.
+func (s *Server) requireScope(w http.ResponseWriter, actor models.User, action authz.Action) (authz.Scope, bool) {
+	scope := s.policy().ScopeOf(actor, action)
+	if scope == authz.ScopeNone {
+		writeError(w, http.StatusForbidden, "недостаточно прав")
+		return scope, false
+	}
+	return scope, true
+}
+
+func userResource(target models.User) authz.Resource {
+	return authz.Resource{DepartmentID: target.DepartmentID, OwnerID: target.ID, TargetRole: target.Role}
+}
+
+func (s *Server) taskResource(ctx context.Context, actor models.User, taskID int64) (authz.Resource, error) {
+	departmentID, err := s.repo.TaskDepartmentID(ctx, taskID)
+	if err != nil {
+		return authz.Resource{}, err
+	}
+	participant, err := s.repo.IsTaskParticipant(ctx, taskID, actor.ID)
+	if err != nil {
+		return authz.Resource{}, err
+	}
+	return authz.Resource{DepartmentID: departmentID, Participant: participant}, nil
+}
+
+func (s *Server) projectResource(ctx context.Context, actor models.User, projectID int64) (authz.Resource, error) {
+	departmentID, err := s.repo.ProjectDepartmentID(ctx, projectID)
+	if err != nil {
+		return authz.Resource{}, err
+	}
+	participant, err := s.repo.IsProjectParticipant(ctx, projectID, actor.ID)
+	if err != nil {
+		return authz.Resource{}, err
+	}
+	return authz.Resource{DepartmentID: departmentID, Participant: participant}, nil
+}
+
+// teamResource — ресурс создаваемого или изменяемого проекта либо задачи: отдел и команда из запроса.
+func teamResource(actor models.User, departmentID int64, curatorIDs, assigneeIDs []int64) authz.Resource {
+	participant := slices.Contains(curatorIDs, actor.ID) || slices.Contains(assigneeIDs, actor.ID)
+	return authz.Resource{DepartmentID: departmentID, Participant: participant}
+}
+
+// targetResource — ресурс, к которому привязан отчет: задача или проект.
+func (s *Server) targetResource(ctx context.Context, actor models.User, targetType string, targetID int64) (authz.Resource, error) {
+	if strings.EqualFold(targetType, "project") {
+		return s.projectResource(ctx, actor, targetID)
+	}
+	return s.taskResource(ctx, actor, targetID)
+}
+
+// writePolicyChanged перечитывает политику и отвечает сообщением.
+func (s *Server) writePolicyChanged(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if err := s.reloadPolicy(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, status, map[string]string{"message": msg})
+}
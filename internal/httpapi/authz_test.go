@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func TestDepartmentScopedCustomRoleManagesOwnDepartment(t *testing.T) {
+	env := newTestEnv(t)
+	env.addRole("Department Lead",
+		models.RolePermission{Action: "project.read", Scope: "department"},
+		models.RolePermission{Action: "project.manage", Scope: "department"},
+		models.RolePermission{Action: "task.read", Scope: "department"},
+		models.RolePermission{Action: "task.manage", Scope: "department"},
+	)
+	lead := env.addUser("lead", "Department Lead", 1)
+	member := env.addUser("member1", "Member", 1)
+	outsider := env.addUser("member2", "Member", 2)
+	token := env.session(lead)
+
+	project := map[string]any{"key": "DL", "name": "Отдел", "department_id": 1, "curator_ids": []int64{lead.ID}, "assignee_ids": []int64{member.ID}}
+	expect(t, env.do(http.MethodPost, "/api/v1/projects", token, project), http.StatusCreated)
+	var projectID int64
+	if err := env.db.QueryRow(`SELECT id FROM projects WHERE key = 'DL'`).Scan(&projectID); err != nil {
+		t.Fatalf("find project: %v", err)
+	}
+
+	project["name"] = "Отдел (изменен)"
+	expect(t, env.do(http.MethodPut, fmt.Sprintf("/api/v1/projects/%d", projectID), token, project), http.StatusOK)
+
+	task := map[string]any{"title": "Задача", "type": "Задача", "priority": "Средний", "project_id": projectID, "curator_ids": []int64{lead.ID}, "assignee_ids": []int64{member.ID}}
+	expect(t, env.do(http.MethodPost, "/api/v1/tasks", token, task), http.StatusCreated)
+
+	foreign := map[string]any{"key": "XD", "name": "Чужой отдел", "department_id": 2, "curator_ids": []int64{outsider.ID}, "assignee_ids": []int64{outsider.ID}}
+	expect(t, env.do(http.MethodPost, "/api/v1/projects", token, foreign), http.StatusForbidden)
+
+	// Перенос своего проекта в чужой отдел проверяется по новому отделу.
+	expect(t, env.do(http.MethodPut, fmt.Sprintf("/api/v1/projects/%d", projectID), token, foreign), http.StatusForbidden)
+}
+
+// TestResourceAccessByType проверяет, что обработчики строят ресурс из реальных данных:
+// отдел, участие и авторство для пользователей, проектов, задач, отчетов и вложений чатов.
+func TestResourceAccessByType(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	pm := env.addUser("pm1", "Project Manager", 1)
+	peer := env.addUser("pm2", "Project Manager", 1)
+	author := env.addUser("author", "Member", 1)
+	colleague := env.addUser("colleague", "Member", 1)
+	stranger := env.addUser("stranger", "Member", 2)
+
+	project := map[string]any{"key": "RA", "name": "Доступ", "department_id": 1, "curator_ids": []int64{pm.ID}, "assignee_ids": []int64{author.ID}}
+	expect(t, env.do(http.MethodPost, "/api/v1/projects", env.session(admin), project), http.StatusCreated)
+	var projectID, taskID int64
+	if err := env.db.QueryRow(`SELECT id FROM projects WHERE key = 'RA'`).Scan(&projectID); err != nil {
+		t.Fatalf("find project: %v", err)
+	}
+	task := map[string]any{"title": "Доступ", "type": "Задача", "priority": "Средний", "project_id": projectID, "curator_ids": []int64{pm.ID}, "assignee_ids": []int64{author.ID}}
+	expect(t, env.do(http.MethodPost, "/api/v1/tasks", env.session(admin), task), http.StatusCreated)
+	if err := env.db.QueryRow(`SELECT id FROM tasks WHERE project_id = ?`, projectID).Scan(&taskID); err != nil {
+		t.Fatalf("find task: %v", err)
+	}
+	// pm остается куратором проекта, но перестает быть участником задачи и ее чата.
+	env.exec(`DELETE FROM task_curators WHERE task_id = ? AND user_id = ?`, taskID, pm.ID)
+
+	storeFile := func(name string) string {
+		path, _, err := env.repo.SaveReportFile(filepath.Join(t.TempDir(), "files"), name, []byte("содержимое"))
+		if err != nil {
+			t.Fatalf("save file: %v", err)
+		}
+		return path
+	}
+	reportID := env.exec(`INSERT INTO reports (target_type, target_id, author_user_id, title, resolution, file_name, file_path, file_type) VALUES ('task', ?, ?, 'Отчет', 'Готово', 'report.txt', ?, 'text/plain')`,
+		taskID, author.ID, storeFile("report.txt"))
+	taskMessageID := env.exec(`INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_type) VALUES ('task', ?, ?, '', 'chat.txt', ?, 'text/plain')`,
+		taskID, author.ID, storeFile("chat.txt"))
+	departmentMessageID := env.exec(`INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_type) VALUES ('department', 2, ?, '', 'dep.txt', ?, 'text/plain')`,
+		stranger.ID, storeFile("dep.txt"))
+
+	tests := []struct {
+		name  string
+		actor models.User
+		path  string
+		want  int
+	}{
+		{"pm sees sessions of member in own department", pm, fmt.Sprintf("/api/v1/users/%d/sessions", author.ID), http.StatusOK},
+		{"pm cannot see sessions of another pm", pm, fmt.Sprintf("/api/v1/users/%d/sessions", peer.ID), http.StatusForbidden},
+		{"pm cannot see sessions of other department", pm, fmt.Sprintf("/api/v1/users/%d/sessions", stranger.ID), http.StatusForbidden},
+
+		{"participant reads project workflow", author, fmt.Sprintf("/api/v1/projects/%d/workflow", projectID), http.StatusOK},
+		{"pm reads project workflow of own department", peer, fmt.Sprintf("/api/v1/projects/%d/workflow", projectID), http.StatusOK},
+		{"non-participant cannot read project workflow", colleague, fmt.Sprintf("/api/v1/projects/%d/workflow", projectID), http.StatusForbidden},
+		{"other department cannot read project workflow", stranger, fmt.Sprintf("/api/v1/projects/%d/workflow", projectID), http.StatusForbidden},
+
+		{"participant reads task", author, fmt.Sprintf("/api/v1/tasks/%d", taskID), http.StatusOK},
+		{"pm reads task of own department", pm, fmt.Sprintf("/api/v1/tasks/%d", taskID), http.StatusOK},
+		{"colleague cannot read task", colleague, fmt.Sprintf("/api/v1/tasks/%d", taskID), http.StatusForbidden},
+		{"other department cannot read task", stranger, fmt.Sprintf("/api/v1/tasks/%d", taskID), http.StatusForbidden},
+
+		{"author downloads report file", author, fmt.Sprintf("/api/v1/reports/%d/file", reportID), http.StatusOK},
+		{"pm downloads report file of own department", peer, fmt.Sprintf("/api/v1/reports/%d/file", reportID), http.StatusOK},
+		{"colleague cannot download report file", colleague, fmt.Sprintf("/api/v1/reports/%d/file", reportID), http.StatusForbidden},
+		{"other department cannot download report file", stranger, fmt.Sprintf("/api/v1/reports/%d/file", reportID), http.StatusForbidden},
+
+		{"participant downloads task chat file", author, fmt.Sprintf("/api/v1/messages/file/%d", taskMessageID), http.StatusOK},
+		{"pm outside task cannot download task chat file", pm, fmt.Sprintf("/api/v1/messages/file/%d", taskMessageID), http.StatusForbidden},
+		{"admin outside task cannot download task chat file", admin, fmt.Sprintf("/api/v1/messages/file/%d", taskMessageID), http.StatusForbidden},
+		{"department member downloads department chat file", stranger, fmt.Sprintf("/api/v1/messages/file/%d", departmentMessageID), http.StatusOK},
+		{"other department cannot download department chat file", author, fmt.Sprintf("/api/v1/messages/file/%d", departmentMessageID), http.StatusForbidden},
+		{"admin downloads any department chat file", admin, fmt.Sprintf("/api/v1/messages/file/%d", departmentMessageID), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, env.do(http.MethodGet, tt.path, env.session(tt.actor), nil), tt.want)
+		})
+	}
+}
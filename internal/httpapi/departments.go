@@ -0,0 +1,287 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// departments — справочник отделов и их должностей. Список нужен и на странице
+// регистрации, поэтому читается без входа; изменять его могут обладатели department.manage.
+func (s *Server) departments(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/departments" {
+		s.departmentEntity(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.Departments(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if token := sessionTokenFromRequest(r); token != "" {
+			actor, err := s.repo.UserBySession(r.Context(), token)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if s.policy().ScopeOf(actor, authz.DepartmentList) != authz.ScopeAll {
+				filtered := make([]models.Department, 0, 1)
+				for _, d := range items {
+					if s.policy().Allow(actor, authz.DepartmentList, authz.Resource{DepartmentID: d.ID}) {
+						filtered = append(filtered, d)
+					}
+				}
+				items = filtered
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		actor, ok := s.actorFromRequest(w, r)
+		if !ok {
+			return
+		}
+		if !s.authorize(w, actor, authz.DepartmentManage, authz.Resource{}) {
+			return
+		}
+		var input models.DepartmentInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := s.repo.CreateDepartment(r.Context(), input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writePolicyChanged(w, r, http.StatusCreated, "подразделение создано")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) departmentEntity(w http.ResponseWriter, r *http.Request) {
+	departmentID, sub, positionID, ok := parseDepartmentPath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet && sub == "" {
+		if !s.authorize(w, actor, authz.DepartmentList, authz.Resource{DepartmentID: departmentID}) {
+			return
+		}
+		item, err := s.repo.Department(r.Context(), departmentID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"item": item})
+		return
+	}
+	if !s.authorize(w, actor, authz.DepartmentManage, authz.Resource{DepartmentID: departmentID}) {
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodPut:
+		var input models.DepartmentInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.UpdateDepartment(r.Context(), departmentID, input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writePolicyChanged(w, r, http.StatusOK, "подразделение обновлено")
+	case sub == "" && r.Method == http.MethodDelete:
+		if err := s.repo.DeleteDepartment(r.Context(), departmentID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writePolicyChanged(w, r, http.StatusOK, "подразделение удалено")
+	case sub == "merge" && r.Method == http.MethodPost:
+		var input models.DepartmentMergeInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.MergeDepartments(r.Context(), departmentID, input.TargetID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writePolicyChanged(w, r, http.StatusOK, "подразделения объединены")
+	case sub == "positions" && positionID == 0 && r.Method == http.MethodPost:
+		var input models.DepartmentPosition
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id, err := s.repo.CreateDepartmentPosition(r.Context(), departmentID, input)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"id": id, "message": "должность добавлена"})
+	case sub == "positions" && positionID > 0 && r.Method == http.MethodPut:
+		var input models.DepartmentPosition
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.UpdateDepartmentPosition(r.Context(), departmentID, positionID, input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "должность обновлена"})
+	case sub == "positions" && positionID > 0 && r.Method == http.MethodDelete:
+		if err := s.repo.DeleteDepartmentPosition(r.Context(), departmentID, positionID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "должность удалена"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// validateRoleDepartmentPosition проверяет, что должность есть в справочнике выбранного
+// отдела, а начальник отдела занимает должность, отмеченную как должность начальника.
+func (s *Server) validateRoleDepartmentPosition(ctx context.Context, role string, departmentID int64, position string) error {
+	pos := strings.TrimSpace(position)
+	if pos == "" {
+		return errors.New("должность обязательна")
+	}
+	if departmentID <= 0 {
+		return errors.New("выберите отдел/подразделение")
+	}
+	depPositions, err := s.repo.DepartmentPositions(ctx, departmentID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.EqualFold(role, authz.RoleOwner):
+		return nil
+	case strings.EqualFold(role, authz.RoleAdmin), strings.EqualFold(role, authz.RoleDeputyAdmin):
+		// Руководство УЦС занимает должность, совпадающую с названием его роли.
+		title := builtInRoleTitle(role)
+		if !strings.EqualFold(pos, title) {
+			return fmt.Errorf("для роли «%s» нужна одноименная должность", title)
+		}
+		return nil
+	case strings.EqualFold(role, authz.RoleProjectManager):
+		for _, item := range depPositions {
+			if !item.IsHead {
+				continue
+			}
+			if !strings.EqualFold(pos, item.Name) {
+				return fmt.Errorf("для роли «%s» в выбранном отделе нужна должность «%s»", builtInRoleTitle(role), item.Name)
+			}
+			return nil
+		}
+		return errors.New("для начальника отдела не найдено правило должности")
+	default:
+		// Сотрудник, высшее руководство и пользовательские роли занимают должности отдела.
+		for _, item := range depPositions {
+			if strings.EqualFold(item.Name, pos) {
+				return nil
+			}
+		}
+		return errors.New("должность не соответствует выбранному отделу/подразделению")
+	}
+}
+
+// builtInRoleTitle возвращает название встроенной роли или ее код, если роль не встроенная.
+func builtInRoleTitle(role string) string {
+	for _, item := range authz.BuiltInRoles {
+		if strings.EqualFold(item.Name, role) {
+			return item.Title
+		}
+	}
+	return role
+}
+
+// orgChart — схема оргструктуры: подразделения с руководителями, сотрудниками и вложенными
+// подразделениями. Пользователь без права на весь справочник видит свое подразделение
+// и поддеревья тех, которыми руководит.
+func (s *Server) orgChart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.requireScope(w, actor, authz.DepartmentList); !ok {
+		return
+	}
+	departments, err := s.repo.Departments(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	users, err := s.repo.Users(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	members := make(map[int64][]models.OrgMember)
+	byID := make(map[int64]models.OrgMember, len(users))
+	for _, u := range users {
+		m := models.OrgMember{ID: u.ID, FullName: u.FullName, Position: u.Position, Role: u.Role}
+		members[u.DepartmentID] = append(members[u.DepartmentID], m)
+		byID[u.ID] = m
+	}
+	visible := make(map[int64]bool, len(departments))
+	children := make(map[int64][]models.Department)
+	for _, d := range departments {
+		if s.policy().Allow(actor, authz.DepartmentList, authz.Resource{DepartmentID: d.ID}) {
+			visible[d.ID] = true
+		}
+		children[d.ParentID] = append(children[d.ParentID], d)
+	}
+
+	var build func(d models.Department) models.OrgUnit
+	build = func(d models.Department) models.OrgUnit {
+		unit := models.OrgUnit{
+			ID:       d.ID,
+			Name:     d.Name,
+			ParentID: d.ParentID,
+			Members:  members[d.ID],
+			Children: make([]models.OrgUnit, 0),
+		}
+		if unit.Members == nil {
+			unit.Members = make([]models.OrgMember, 0)
+		}
+		if head, ok := byID[d.HeadUserID]; ok {
+			unit.Head = &head
+		}
+		for _, child := range children[d.ID] {
+			if visible[child.ID] {
+				unit.Children = append(unit.Children, build(child))
+			}
+		}
+		return unit
+	}
+	// Видимое подразделение без видимого родителя становится корнем своей ветки.
+	items := make([]models.OrgUnit, 0)
+	for _, d := range departments {
+		if visible[d.ID] && !visible[d.ParentID] {
+			items = append(items, build(d))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
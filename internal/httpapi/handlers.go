@@ -4,55 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/filecrypt"
 	"github.com/mvd/taskflow/internal/models"
+	"github.com/mvd/taskflow/internal/uploads"
 )
 
 const maxTaskChatAttachmentBytes = 25 * 1024 * 1024
 
-var roleDepartmentHeadPosition = map[int64]string{
-	1: "Начальник Отдела Поддержки текущих сервисов",
-	2: "Начальник отдела поддержки и развития инфраструктуры",
-	3: "Начальник отдела технической поддержки",
-	4: "Начальник отдела ООИБ",
-}
-
-var positionsByDepartment = map[int64][]string{
-	1: {
-		"Начальник Отдела Поддержки текущих сервисов",
-		"Ведущий системный аналитик отдела Поддержки текущих сервисов",
-		"Системный аналитик отдела Поддержки текущих сервисов",
-		"Ведущий разработчик отдела Поддержки текущих сервисов",
-		"Разработчик отдела Поддержки текущих сервисов",
-		"Тестировщик отдела Поддержки текущих сервисов",
-	},
-	2: {
-		"Начальник отдела поддержки и развития инфраструктуры",
-		"Ведущий системный администратор",
-		"Системный администратор",
-		"Ведущий сетевой инженер",
-		"Сетевой инженер",
-		"Главный специалист",
-	},
-	3: {
-		"Начальник отдела технической поддержки",
-		"Главный специалист технической поддержки",
-		"Специалист технической поддержки",
-	},
-	4: {
-		"Начальник отдела ООИБ",
-		"Зам. нач. отдела ООИБ по бумагам",
-		"Зам. нач. отдела ООИБ по тех. части",
-		"Главный инспектор ООИБ",
-		"Инспектор ООИБ",
-	},
-}
-
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -90,16 +57,33 @@ func (s *Server) register(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "некорректный отдел")
 		return
 	}
-	if err := validateRoleDepartmentPosition("Member", input.DepartmentID, input.Position); err != nil {
+	if err := s.validateRoleDepartmentPosition(r.Context(), authz.RoleMember, input.DepartmentID, input.Position); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := s.repo.Register(r.Context(), input); err != nil {
+	// Руководитель, заводящий сотрудника из приложения, сразу подтверждает учетную запись.
+	var createdBy int64
+	if token := sessionTokenFromRequest(r); token != "" {
+		actor, err := s.repo.UserBySession(r.Context(), token)
+		if err == nil && s.policy().ScopeOf(actor, authz.UserCreate) != authz.ScopeNone && !actor.MustChangePassword {
+			if !s.policy().Allow(actor, authz.UserCreate, authz.Resource{DepartmentID: input.DepartmentID}) {
+				writeError(w, http.StatusForbidden, "можно регистрировать только сотрудников своего отдела")
+				return
+			}
+			createdBy = actor.ID
+		}
+	}
+
+	if err := s.repo.Register(r.Context(), input, createdBy); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]string{"message": "пользователь зарегистрирован"})
+	if createdBy > 0 {
+		writeJSON(w, http.StatusCreated, map[string]any{"message": "пользователь зарегистрирован, при первом входе он сменит пароль", "pending": false})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"message": "заявка на регистрацию отправлена, вход станет доступен после подтверждения руководителем отдела", "pending": true})
 }
 
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
@@ -114,19 +98,85 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.rejectLockedLogin(w, r, input.Login) {
+		return
+	}
 	user, err := s.repo.Login(r.Context(), input)
 	if err != nil {
-		writeError(w, http.StatusUnauthorized, err.Error())
+		s.loginFailed(w, r, input.Login, err)
+		return
+	}
+	status, err := s.repo.TwoFactorStatus(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if status.Enabled {
+		// Сессия выдается только после проверки второго фактора.
+		challenge, err := s.repo.CreateLoginChallenge(r.Context(), user.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"message":             "требуется код подтверждения",
+			"two_factor_required": true,
+			"challenge":           challenge,
+		})
+		return
+	}
+	s.completeLogin(w, r, user, status)
+}
+
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, user models.User, status models.TwoFactorStatus) {
+	if err := s.repo.ClearLoginFailures(r.Context(), user.Login); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	expiresAt, err := s.startSession(w, r, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	s.decorateUserAvatar(&user)
 
 	writeJSON(w, http.StatusOK, map[string]any{
-		"message": "ok",
-		"user":    user,
+		"message":                   "ok",
+		"user":                      user,
+		"expires_at":                expiresAt,
+		"two_factor_setup_required": !status.Enabled && s.twoFactorRequired(user.Role),
+		"password_change_required":  user.MustChangePassword,
 	})
 }
 
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := s.repo.DeleteSession(r.Context(), sessionTokenFromRequest(r)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.clearSessionCookie(w, r)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "сеанс завершен"})
+}
+
+func (s *Server) refreshSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token, expiresAt, err := s.repo.RefreshSession(r.Context(), sessionTokenFromRequest(r), s.sessionTTL)
+	if err != nil {
+		s.clearSessionCookie(w, r)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	s.setSessionCookie(w, r, token, expiresAt)
+	writeJSON(w, http.StatusOK, map[string]any{"message": "сеанс продлен", "expires_at": expiresAt})
+}
+
 func (s *Server) users(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -136,18 +186,18 @@ func (s *Server) users(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	scope, ok := s.requireScope(w, actor, authz.UserList)
+	if !ok {
+		return
+	}
 	var (
 		users []models.User
 		err   error
 	)
-	switch {
-	case isSuperRole(actor.Role):
+	if scope == authz.ScopeAll {
 		users, err = s.repo.Users(r.Context())
-	case strings.EqualFold(actor.Role, "Project Manager"):
-		users, err = s.repo.UsersByDepartment(r.Context(), actor.DepartmentID)
-	default:
-		writeError(w, http.StatusForbidden, "недостаточно прав")
-		return
+	} else {
+		users, err = s.repo.UsersByDepartment(r.Context(), s.policy().Departments(actor)...)
 	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
@@ -157,37 +207,6 @@ func (s *Server) users(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"items": users})
 }
 
-func (s *Server) departments(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-	actorLogin := strings.TrimSpace(r.Header.Get("X-Actor-Login"))
-	items, err := s.repo.Departments(r.Context())
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if actorLogin != "" {
-		actor, err := s.repo.UserByLogin(r.Context(), actorLogin)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "пользователь не найден")
-			return
-		}
-		if strings.EqualFold(actor.Role, "Project Manager") || strings.EqualFold(actor.Role, "Member") || strings.EqualFold(actor.Role, "Guest") {
-			filtered := make([]models.Department, 0, 1)
-			for _, d := range items {
-				if d.ID == actor.DepartmentID {
-					filtered = append(filtered, d)
-					break
-				}
-			}
-			items = filtered
-		}
-	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
-}
-
 func (s *Server) profile(w http.ResponseWriter, r *http.Request) {
 	actor, ok := s.actorFromRequest(w, r)
 	if !ok {
@@ -211,6 +230,13 @@ func (s *Server) profile(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if strings.TrimSpace(input.Password) != "" {
+			// Смена пароля завершает все сессии; текущему клиенту выдаем новую.
+			if _, err := s.startSession(w, r, actor.ID); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
 		user, err := s.repo.UserByLogin(r.Context(), actor.Login)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
@@ -225,7 +251,10 @@ func (s *Server) profile(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) profileAvatar(w http.ResponseWriter, r *http.Request) {
 	if userID, ok := parseProfileAvatarPath(r.URL.Path); ok && r.Method == http.MethodGet {
-		avatarPath, err := s.repo.UserAvatarPath(r.Context(), userID)
+		if _, ok := s.actorFromRequest(w, r); !ok {
+			return
+		}
+		avatarPath, avatarType, err := s.repo.UserAvatarPath(r.Context(), userID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
@@ -234,18 +263,19 @@ func (s *Server) profileAvatar(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusNotFound, "фото профиля не загружено")
 			return
 		}
-		fd, err := os.Open(avatarPath)
+		fd, err := s.repo.OpenStoredFile(avatarPath)
 		if err != nil {
-			writeError(w, http.StatusNotFound, "фото профиля не найдено")
+			if errors.Is(err, fs.ErrNotExist) {
+				writeError(w, http.StatusNotFound, "фото профиля не найдено")
+			} else {
+				log.Printf("open avatar %s: %v", avatarPath, err)
+				writeError(w, http.StatusInternalServerError, "не удалось прочитать фото профиля")
+			}
 			return
 		}
 		defer fd.Close()
-		stat, err := fd.Stat()
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "не удалось прочитать фото профиля")
-			return
-		}
-		http.ServeContent(w, r, filepath.Base(avatarPath), stat.ModTime(), fd)
+		w.Header().Set("Content-Type", storedContentType(fd, avatarPath, avatarType))
+		http.ServeContent(w, r, filepath.Base(avatarPath), fd.ModTime, fd)
 		return
 	}
 
@@ -282,13 +312,19 @@ func (s *Server) profileAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	checked, err := uploads.Check(header.Filename, data, s.uploadAvatarTypes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	baseDir := filepath.Join(filepath.Dir(s.staticPath), "data", "avatars")
-	savedPath, _, err := s.repo.SaveAvatarFile(baseDir, header.Filename, data)
+	savedPath, _, err := s.repo.SaveAvatarFile(baseDir, checked.Name, data)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if err := s.repo.UpdateUserAvatar(r.Context(), actor.ID, savedPath); err != nil {
+	if err := s.repo.UpdateUserAvatar(r.Context(), actor.ID, savedPath, checked.ContentType); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -310,13 +346,32 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if userID, sessionID, ok := parseUserSessionsPath(r.URL.Path); ok {
+		s.userSessions(w, r, actor, userID, sessionID)
+		return
+	}
+	if userID, ok := parseUserTwoFactorPath(r.URL.Path); ok {
+		s.resetUserTwoFactor(w, r, actor, userID)
+		return
+	}
+	if userID, action, ok := parseUserPasswordPath(r.URL.Path); ok {
+		s.userPassword(w, r, actor, userID, action)
+		return
+	}
+	if lockoutID, ok := parseLockoutsPath(r.URL.Path); ok {
+		s.loginLockouts(w, r, actor, lockoutID)
+		return
+	}
+	if userID, ok := parseUserLockoutPath(r.URL.Path); ok {
+		s.userLockout(w, r, actor, userID)
+		return
+	}
 	if userID, ok := parseUserRolePath(r.URL.Path); ok {
 		if r.Method != http.MethodPatch {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
-		if !canManageUsers(actor.Role) {
-			writeError(w, http.StatusForbidden, "недостаточно прав")
+		if _, ok := s.requireScope(w, actor, authz.UserManage); !ok {
 			return
 		}
 
@@ -325,7 +380,7 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if !isAllowedRole(input.Role) {
+		if !s.policy().HasRole(input.Role) {
 			writeError(w, http.StatusBadRequest, "некорректная роль")
 			return
 		}
@@ -334,21 +389,13 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if strings.EqualFold(actor.Role, "Project Manager") {
-			if target.DepartmentID != actor.DepartmentID {
-				writeError(w, http.StatusForbidden, "можно управлять только пользователями своего отдела")
-				return
-			}
-			if isLeadershipRole(target.Role) {
-				writeError(w, http.StatusForbidden, "нельзя менять роль руководящего пользователя")
-				return
-			}
-			if isLeadershipRole(input.Role) {
-				writeError(w, http.StatusForbidden, "начальник отдела может назначать только роли сотрудника отдела или высшего руководства")
-				return
-			}
+		if !s.authorize(w, actor, authz.UserManage, userResource(target)) {
+			return
 		}
-		if err := validateRoleDepartmentPosition(input.Role, target.DepartmentID, target.Position); err != nil {
+		if !s.authorize(w, actor, authz.UserAssignRole, authz.Resource{TargetRole: input.Role}) {
+			return
+		}
+		if err := s.validateRoleDepartmentPosition(r.Context(), input.Role, target.DepartmentID, target.Position); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -366,8 +413,7 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "not found")
 		return
 	}
-	if !canManageUsers(actor.Role) {
-		writeError(w, http.StatusForbidden, "недостаточно прав")
+	if _, ok := s.requireScope(w, actor, authz.UserManage); !ok {
 		return
 	}
 	target, err := s.repo.UserByID(r.Context(), userID)
@@ -375,15 +421,8 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if strings.EqualFold(actor.Role, "Project Manager") {
-		if target.DepartmentID != actor.DepartmentID {
-			writeError(w, http.StatusForbidden, "можно управлять только пользователями своего отдела")
-			return
-		}
-		if isLeadershipRole(target.Role) {
-			writeError(w, http.StatusForbidden, "нельзя менять руководящие учетные записи")
-			return
-		}
+	if !s.authorize(w, actor, authz.UserManage, userResource(target)) {
+		return
 	}
 
 	switch r.Method {
@@ -393,21 +432,18 @@ func (s *Server) userRole(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if input.Login == "" || input.FullName == "" || input.Position == "" || !isAllowedRole(input.Role) || input.DepartmentID <= 0 {
+		if input.Login == "" || input.FullName == "" || input.Position == "" || !s.policy().HasRole(input.Role) || input.DepartmentID <= 0 {
 			writeError(w, http.StatusBadRequest, "заполните корректные поля")
 			return
 		}
-		if strings.EqualFold(actor.Role, "Project Manager") {
-			if input.DepartmentID != actor.DepartmentID {
-				writeError(w, http.StatusForbidden, "можно назначать только свой отдел")
-				return
-			}
-			if isLeadershipRole(input.Role) {
-				writeError(w, http.StatusForbidden, "начальник отдела может назначать только роли сотрудника отдела или высшего руководства")
-				return
-			}
+		if !s.policy().Allow(actor, authz.UserCreate, authz.Resource{DepartmentID: input.DepartmentID}) {
+			writeError(w, http.StatusForbidden, "можно назначать только свой отдел")
+			return
 		}
-		if err := validateRoleDepartmentPosition(input.Role, input.DepartmentID, input.Position); err != nil {
+		if !s.authorize(w, actor, authz.UserAssignRole, authz.Resource{TargetRole: input.Role}) {
+			return
+		}
+		if err := s.validateRoleDepartmentPosition(r.Context(), input.Role, input.DepartmentID, input.Position); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -451,13 +487,16 @@ func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		var projects []models.Project
-		if isSuperRole(actor.Role) && departmentID != nil {
-			projects, err = s.repo.ProjectsByDepartment(r.Context(), *departmentID)
-		} else if isSuperRole(actor.Role) {
-			projects, err = s.repo.Projects(r.Context())
-		} else if strings.EqualFold(actor.Role, "Project Manager") {
-			projects, err = s.repo.ProjectsByDepartment(r.Context(), actor.DepartmentID)
-		} else {
+		switch s.policy().ScopeOf(actor, authz.ProjectRead) {
+		case authz.ScopeAll:
+			if departmentID != nil {
+				projects, err = s.repo.ProjectsByDepartment(r.Context(), *departmentID)
+			} else {
+				projects, err = s.repo.Projects(r.Context())
+			}
+		case authz.ScopeDepartment:
+			projects, err = s.repo.ProjectsByDepartment(r.Context(), s.policy().Departments(actor)...)
+		default:
 			projects, err = s.repo.ProjectsByUser(r.Context(), actor.ID)
 		}
 		if err != nil {
@@ -466,7 +505,8 @@ func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"items": projects})
 	case http.MethodPost:
-		if !s.requireActorRole(w, r, "Owner", "Admin", "Deputy Admin") {
+		actor, ok := s.actorFromRequest(w, r)
+		if !ok {
 			return
 		}
 		var input models.CreateProjectInput
@@ -478,6 +518,9 @@ func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "заполните обязательные поля")
 			return
 		}
+		if !s.authorize(w, actor, authz.ProjectManage, teamResource(actor, input.DepartmentID, input.CuratorIDs, input.AssigneeIDs)) {
+			return
+		}
 		allIDs := uniqueInt64(append(append([]int64{}, input.CuratorIDs...), input.AssigneeIDs...))
 		teamInDepartment, err := s.repo.UserIDsBelongToDepartment(r.Context(), allIDs, input.DepartmentID)
 		if err != nil {
@@ -499,6 +542,10 @@ func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
+	if projectID, ok := parseProjectWorkflowPath(r.URL.Path); ok {
+		s.projectWorkflow(w, r, projectID)
+		return
+	}
 	if projectID, ok := parseProjectClosePath(r.URL.Path); ok {
 		if r.Method != http.MethodPatch {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -508,18 +555,12 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			return
 		}
-		isManager := strings.EqualFold(actor.Role, "Owner") || strings.EqualFold(actor.Role, "Admin") || strings.EqualFold(actor.Role, "Deputy Admin")
-		allowed := isManager
-		if !allowed {
-			var err error
-			allowed, err = s.repo.IsProjectParticipant(r.Context(), projectID, actor.ID)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
+		res, err := s.projectResource(r.Context(), actor, projectID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		if !allowed {
-			writeError(w, http.StatusForbidden, "закрыть проект может куратор или исполнитель")
+		if !s.authorize(w, actor, authz.ProjectClose, res) {
 			return
 		}
 		if err := s.repo.CloseProject(r.Context(), projectID); err != nil {
@@ -544,15 +585,17 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if strings.EqualFold(actor.Role, "Project Manager") {
+		switch s.policy().ScopeOf(actor, authz.TaskRead) {
+		case authz.ScopeAll:
+		case authz.ScopeDepartment:
 			filtered := make([]models.Task, 0, len(tasks))
 			for _, t := range tasks {
-				if t.DepartmentID == actor.DepartmentID {
+				if s.policy().Allow(actor, authz.TaskRead, authz.Resource{DepartmentID: t.DepartmentID}) {
 					filtered = append(filtered, t)
 				}
 			}
 			tasks = filtered
-		} else if !isSuperRole(actor.Role) {
+		default:
 			filtered := make([]models.Task, 0, len(tasks))
 			for _, t := range tasks {
 				ok, err := s.repo.IsTaskParticipant(r.Context(), t.ID, actor.ID)
@@ -560,7 +603,7 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 					writeError(w, http.StatusInternalServerError, err.Error())
 					return
 				}
-				if ok {
+				if s.policy().Allow(actor, authz.TaskRead, authz.Resource{DepartmentID: t.DepartmentID, Participant: ok}) {
 					filtered = append(filtered, t)
 				}
 			}
@@ -576,7 +619,16 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.requireActorRole(w, r, "Owner", "Admin", "Deputy Admin") {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.projectResource(r.Context(), actor, projectID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.ProjectManage, res) {
 		return
 	}
 	switch r.Method {
@@ -590,6 +642,10 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "заполните обязательные поля")
 			return
 		}
+		// Перенос проекта в другой отдел требует прав и на новый отдел.
+		if !s.authorize(w, actor, authz.ProjectManage, teamResource(actor, input.DepartmentID, input.CuratorIDs, input.AssigneeIDs)) {
+			return
+		}
 		allIDs := uniqueInt64(append(append([]int64{}, input.CuratorIDs...), input.AssigneeIDs...))
 		ok, err := s.repo.UserIDsBelongToDepartment(r.Context(), allIDs, input.DepartmentID)
 		if err != nil {
@@ -606,11 +662,11 @@ func (s *Server) projectTasks(w http.ResponseWriter, r *http.Request) {
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "проект обновлен"})
 	case http.MethodDelete:
-		if err := s.repo.DeleteProject(r.Context(), projectID); err != nil {
+		if err := s.repo.DeleteProject(r.Context(), projectID, actor.ID); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"message": "проект удален"})
+		writeJSON(w, http.StatusOK, map[string]string{"message": "проект перенесен в корзину"})
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
@@ -628,25 +684,33 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		linkedTo, err := readOptionalInt64Query(r, "linked_to")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		var tasks []models.Task
-		if isSuperRole(actor.Role) && departmentID != nil {
-			tasks, err = s.repo.TasksByDepartment(r.Context(), *departmentID)
-		} else if isSuperRole(actor.Role) {
-			tasks, err = s.repo.Tasks(r.Context(), nil)
-		} else if strings.EqualFold(actor.Role, "Project Manager") {
-			tasks, err = s.repo.TasksByDepartment(r.Context(), actor.DepartmentID)
-		} else {
+		switch s.policy().ScopeOf(actor, authz.TaskRead) {
+		case authz.ScopeAll:
+			if departmentID != nil {
+				tasks, err = s.repo.TasksByDepartment(r.Context(), *departmentID)
+			} else {
+				tasks, err = s.repo.Tasks(r.Context(), nil)
+			}
+		case authz.ScopeDepartment:
+			tasks, err = s.repo.TasksByDepartment(r.Context(), s.policy().Departments(actor)...)
+		default:
 			tasks, err = s.repo.TasksByUser(r.Context(), actor.ID)
 		}
+		if err == nil {
+			tasks, err = s.filterTasksByLinks(r, tasks, linkedTo)
+		}
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"items": tasks})
 	case http.MethodPost:
-		if !s.requireActorRole(w, r, "Owner", "Admin", "Deputy Admin") {
-			return
-		}
 		actor, ok := s.actorFromRequest(w, r)
 		if !ok {
 			return
@@ -656,7 +720,7 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if input.Title == "" || input.Type == "" || input.Status == "" || input.Priority == "" || input.ProjectID == 0 || len(input.CuratorIDs) < 1 || len(input.CuratorIDs) > 5 || len(input.AssigneeIDs) < 1 || len(input.AssigneeIDs) > 5 {
+		if input.Title == "" || input.Type == "" || input.Priority == "" || input.ProjectID == 0 || len(input.CuratorIDs) < 1 || len(input.CuratorIDs) > 5 || len(input.AssigneeIDs) < 1 || len(input.AssigneeIDs) > 5 {
 			writeError(w, http.StatusBadRequest, "заполните обязательные поля")
 			return
 		}
@@ -665,6 +729,9 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if !s.authorize(w, actor, authz.TaskManage, teamResource(actor, departmentID, input.CuratorIDs, input.AssigneeIDs)) {
+			return
+		}
 		allIDs := uniqueInt64(append(append([]int64{}, input.CuratorIDs...), input.AssigneeIDs...))
 		teamInDepartment, err := s.repo.UserIDsBelongToDepartment(r.Context(), allIDs, departmentID)
 		if err != nil {
@@ -675,11 +742,18 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "кураторы и исполнители должны быть из отдела проекта")
 			return
 		}
-		if strings.EqualFold(actor.Role, "Owner") || strings.EqualFold(actor.Role, "Admin") || strings.EqualFold(actor.Role, "Deputy Admin") {
-			// Руководство УЦС может сразу распределять задачу, без обязательного шага через заместителя.
-			input.RouteStage = 2
+		if authz.IsSuper(actor.Role) {
+			// Руководство УЦС может сразу распределять задачу, без обязательного шага через заместителя:
+			// задача начинает маршрут у автора, в подразделении, которым он руководит.
+			org := s.policy().Org()
+			input.RouteUnitID = routeUnit(org, actor, departmentID)
+			if !org.Contains(input.RouteUnitID, departmentID) {
+				input.RouteUnitID = org.Root()
+			}
+			input.RouteStage = routeStage(org, actor, input.RouteUnitID)
 			input.RouteOwnerID = actor.ID
 		}
+		input.ActorRole = actor.Role
 		if err := s.repo.CreateTask(r.Context(), input); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
@@ -691,8 +765,29 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
+	if key, ok := parseTaskKeyPath(r.URL.Path); ok {
+		s.taskByKey(w, r, key)
+		return
+	}
+	if taskID, ok := parseTaskHistoryPath(r.URL.Path); ok {
+		s.taskHistory(w, r, taskID)
+		return
+	}
+	if taskID, ok := parseTaskSubtasksPath(r.URL.Path); ok {
+		s.subtasks(w, r, taskID)
+		return
+	}
+	if taskID, ok := parseTaskLinksPath(r.URL.Path); ok {
+		s.taskLinks(w, r, taskID, 0)
+		return
+	}
+	if taskID, linkID, ok := parseTaskLinkPath(r.URL.Path); ok {
+		s.taskLinks(w, r, taskID, linkID)
+		return
+	}
+
 	if taskID, ok := parseTaskRoutePath(r.URL.Path); ok {
-		if r.Method != http.MethodPatch {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
@@ -700,6 +795,31 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			return
 		}
+		stage, currentOwnerID, unitID, departmentID, err := s.repo.TaskRouteState(r.Context(), taskID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.authorize(w, actor, authz.TaskRoute, authz.Resource{DepartmentID: departmentID, OwnerID: currentOwnerID, RouteStage: stage}) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			// Кому можно передать задачу: интерфейс не повторяет правила дерева у себя.
+			users, err := s.repo.Users(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			items := make([]models.User, 0)
+			for _, u := range users {
+				if u.ID != currentOwnerID && s.routeTargetError(actor, u, unitID, departmentID) == nil {
+					items = append(items, u)
+				}
+			}
+			s.decorateUsersAvatar(items)
+			writeJSON(w, http.StatusOK, map[string]any{"items": items})
+			return
+		}
 		var in struct {
 			ToUserID int64 `json:"to_user_id"`
 		}
@@ -711,76 +831,28 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "укажите получателя")
 			return
 		}
-		stage, currentOwnerID, departmentID, err := s.repo.TaskRouteState(r.Context(), taskID)
+		target, err := s.repo.UserByID(r.Context(), in.ToUserID)
 		if err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if !isSuperRole(actor.Role) && currentOwnerID > 0 && actor.ID != currentOwnerID {
-			allowDepartmentHeadOverride := strings.EqualFold(actor.Role, "Project Manager") && stage >= 3 && actor.DepartmentID == departmentID
-			if !allowDepartmentHeadOverride {
-				writeError(w, http.StatusForbidden, "передавать задачу может только текущий ответственный")
-				return
-			}
-		}
-		target, err := s.repo.UserByID(r.Context(), in.ToUserID)
-		if err != nil {
+		if err := s.routeTargetError(actor, target, unitID, departmentID); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		nextStage := stage
-		switch {
-		case strings.EqualFold(actor.Role, "Project Manager") && stage <= 2:
-			if !strings.EqualFold(target.Role, "Member") || target.DepartmentID != actor.DepartmentID {
-				writeError(w, http.StatusBadRequest, "начальник отдела может расписывать только сотрудникам своего отдела")
-				return
-			}
-			nextStage = 4
-		case stage <= 2:
-			if !strings.EqualFold(actor.Role, "Owner") && !strings.EqualFold(actor.Role, "Admin") && !strings.EqualFold(actor.Role, "Deputy Admin") {
-				writeError(w, http.StatusForbidden, "этап УЦС: передача доступна только руководству УЦС")
-				return
-			}
-			if strings.EqualFold(target.Role, "Deputy Admin") {
-				nextStage = 2
-			} else if strings.EqualFold(target.Role, "Project Manager") {
-				nextStage = 3
-			} else if strings.EqualFold(target.Role, "Member") {
-				nextStage = 4
-			} else {
-				writeError(w, http.StatusBadRequest, "на этапе УЦС задачу можно расписать только Заместителю начальника УЦС, Начальнику отдела или сотруднику отдела")
-				return
-			}
-		case stage == 3:
-			if !strings.EqualFold(actor.Role, "Project Manager") && !isSuperRole(actor.Role) {
-				writeError(w, http.StatusForbidden, "на этапе отдела передавать задачу может только Начальник отдела")
-				return
-			}
-			if !strings.EqualFold(target.Role, "Member") || target.DepartmentID != departmentID {
-				writeError(w, http.StatusBadRequest, "задача должна быть передана сотруднику выбранного отдела")
-				return
-			}
-			nextStage = 4
-		default:
-			if !strings.EqualFold(actor.Role, "Project Manager") && !isSuperRole(actor.Role) {
-				writeError(w, http.StatusForbidden, "дальнейшее распределение доступно только начальнику отдела")
-				return
-			}
-			if !strings.EqualFold(target.Role, "Member") || target.DepartmentID != departmentID {
-				writeError(w, http.StatusBadRequest, "можно передавать только сотруднику выбранного отдела")
-				return
-			}
-			nextStage = 4
-		}
+		org := s.policy().Org()
+		nextUnit := routeUnit(org, target, departmentID)
+		nextStage := routeStage(org, target, nextUnit)
 
-		if err := s.repo.UpdateTaskRoute(r.Context(), taskID, target.ID, nextStage); err != nil {
+		if err := s.repo.UpdateTaskRoute(r.Context(), taskID, target.ID, nextStage, nextUnit); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{
-			"message":         "задача передана по маршруту СЭД",
-			"route_stage":     nextStage,
-			"route_owner_id":  target.ID,
+			"message":          "задача передана по маршруту СЭД",
+			"route_stage":      nextStage,
+			"route_unit_id":    nextUnit,
+			"route_owner_id":   target.ID,
 			"route_owner_name": target.FullName,
 		})
 		return
@@ -795,25 +867,21 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			return
 		}
-		isManager := strings.EqualFold(actor.Role, "Owner") || strings.EqualFold(actor.Role, "Admin") || strings.EqualFold(actor.Role, "Deputy Admin")
-		allowed := isManager
-		if !allowed {
-			var err error
-			allowed, err = s.repo.IsTaskParticipant(r.Context(), taskID, actor.ID)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
+		res, err := s.taskResource(r.Context(), actor, taskID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		if !allowed {
-			writeError(w, http.StatusForbidden, "закрыть задачу может куратор или исполнитель")
+		if !s.authorize(w, actor, authz.TaskClose, res) {
 			return
 		}
-		if err := s.repo.CloseTask(r.Context(), taskID); err != nil {
+		force := strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("force")), "true")
+		warnings, err := s.repo.CloseTask(r.Context(), taskID, force, actor.Role)
+		if err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"message": "задача закрыта"})
+		writeJSON(w, http.StatusOK, map[string]any{"message": "задача закрыта", "warnings": warnings})
 		return
 	}
 
@@ -822,8 +890,21 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "not found")
 		return
 	}
+	if r.Method == http.MethodGet {
+		s.taskDetails(w, r, taskID)
+		return
+	}
 
-	if !s.requireActorRole(w, r, "Owner", "Admin", "Deputy Admin") {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.taskResource(r.Context(), actor, taskID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.TaskManage, res) {
 		return
 	}
 	switch r.Method {
@@ -842,6 +923,10 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		// Перенос задачи в проект другого отдела требует прав и на новый отдел.
+		if !s.authorize(w, actor, authz.TaskManage, teamResource(actor, departmentID, input.CuratorIDs, input.AssigneeIDs)) {
+			return
+		}
 		allIDs := uniqueInt64(append(append([]int64{}, input.CuratorIDs...), input.AssigneeIDs...))
 		teamInDepartment, err := s.repo.UserIDsBelongToDepartment(r.Context(), allIDs, departmentID)
 		if err != nil {
@@ -852,132 +937,60 @@ func (s *Server) taskEntity(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "кураторы и исполнители должны быть из отдела проекта")
 			return
 		}
+		input.ActorRole = actor.Role
 		if err := s.repo.UpdateTask(r.Context(), taskID, input); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "задача обновлена"})
 	case http.MethodDelete:
-		if err := s.repo.DeleteTask(r.Context(), taskID); err != nil {
+		if err := s.repo.DeleteTask(r.Context(), taskID, actor.ID); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"message": "задача удалена"})
+		writeJSON(w, http.StatusOK, map[string]string{"message": "задача перенесена в корзину"})
 	default:
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
-func (s *Server) requireActorRole(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
-	actor, ok := s.actorFromRequest(w, r)
-	if !ok {
-		return false
-	}
-
-	for _, role := range allowed {
-		if strings.EqualFold(actor.Role, "Deputy Admin") && strings.EqualFold(role, "Admin") {
-			return true
-		}
-		if strings.EqualFold(actor.Role, role) {
-			return true
+func (s *Server) actorFromRequest(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	if bearer := bearerTokenFromRequest(r); bearer != "" {
+		actor, ok := s.actorFromBearer(w, r, bearer)
+		if ok {
+			setAuditUser(r, actor.ID)
 		}
+		return actor, ok
 	}
-
-	writeError(w, http.StatusForbidden, "недостаточно прав")
-	return false
-}
-
-func (s *Server) actorFromRequest(w http.ResponseWriter, r *http.Request) (models.User, bool) {
-	actorLogin := strings.TrimSpace(r.Header.Get("X-Actor-Login"))
-	if actorLogin == "" {
-		writeError(w, http.StatusUnauthorized, "нужен заголовок X-Actor-Login")
+	token := sessionTokenFromRequest(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "требуется вход в систему")
 		return models.User{}, false
 	}
-	actor, err := s.repo.UserByLogin(r.Context(), actorLogin)
+	actor, err := s.repo.UserBySession(r.Context(), token)
 	if err != nil {
-		writeError(w, http.StatusUnauthorized, "пользователь не найден")
+		s.clearSessionCookie(w, r)
+		writeError(w, http.StatusUnauthorized, err.Error())
 		return models.User{}, false
 	}
-	return actor, true
-}
-
-func isAllowedRole(role string) bool {
-	normalized := strings.TrimSpace(strings.ToLower(role))
-	switch normalized {
-	case "owner", "admin", "deputy admin", "project manager", "member", "guest":
-		return true
-	default:
-		return false
-	}
-}
-
-func canManageUsers(role string) bool {
-	return strings.EqualFold(role, "Owner") || strings.EqualFold(role, "Admin") || strings.EqualFold(role, "Deputy Admin") || strings.EqualFold(role, "Project Manager")
-}
-
-func isLeadershipRole(role string) bool {
-	return strings.EqualFold(role, "Owner") ||
-		strings.EqualFold(role, "Admin") ||
-		strings.EqualFold(role, "Deputy Admin") ||
-		strings.EqualFold(role, "Project Manager")
-}
-
-func isSuperRole(role string) bool {
-	return strings.EqualFold(role, "Owner") || strings.EqualFold(role, "Admin") || strings.EqualFold(role, "Deputy Admin")
-}
-
-func containsPosition(items []string, value string) bool {
-	for _, item := range items {
-		if strings.EqualFold(strings.TrimSpace(item), strings.TrimSpace(value)) {
-			return true
-		}
-	}
-	return false
-}
-
-func validateRoleDepartmentPosition(role string, departmentID int64, position string) error {
-	pos := strings.TrimSpace(position)
-	if pos == "" {
-		return errors.New("должность обязательна")
-	}
-	if departmentID <= 0 {
-		return errors.New("выберите отдел/подразделение")
-	}
-	depPositions, ok := positionsByDepartment[departmentID]
-	if !ok {
-		return errors.New("некорректный отдел/подразделение")
+	// Пока пароль не сменен, доступен только профиль, через который он и меняется.
+	if actor.MustChangePassword && r.URL.Path != "/api/v1/profile" {
+		writeError(w, http.StatusForbidden, errPasswordChangeRequired)
+		return models.User{}, false
 	}
-
-	switch {
-	case strings.EqualFold(role, "Owner"):
-		return nil
-	case strings.EqualFold(role, "Admin"):
-		if !strings.EqualFold(pos, "Начальник УЦС") {
-			return errors.New("для роли «Начальник УЦС» должность должна быть «Начальник УЦС»")
-		}
-		return nil
-	case strings.EqualFold(role, "Deputy Admin"):
-		if !strings.EqualFold(pos, "Заместитель начальника УЦС") {
-			return errors.New("для роли «Заместитель начальника УЦС» нужна одноименная должность")
-		}
-		return nil
-	case strings.EqualFold(role, "Project Manager"):
-		headPosition, ok := roleDepartmentHeadPosition[departmentID]
-		if !ok {
-			return errors.New("для начальника отдела не найдено правило должности")
-		}
-		if !strings.EqualFold(pos, headPosition) {
-			return fmt.Errorf("для роли «Начальник отдела» в выбранном отделе нужна должность «%s»", headPosition)
+	if s.twoFactorRequired(actor.Role) && !isTwoFactorSetupPath(r.URL.Path) {
+		status, err := s.repo.TwoFactorStatus(r.Context(), actor.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return models.User{}, false
 		}
-		return nil
-	case strings.EqualFold(role, "Member"), strings.EqualFold(role, "Guest"):
-		if !containsPosition(depPositions, pos) {
-			return errors.New("должность не соответствует выбранному отделу/подразделению")
+		if !status.Enabled {
+			writeError(w, http.StatusForbidden, "для вашей роли обязательна двухфакторная аутентификация, настройте ее в профиле")
+			return models.User{}, false
 		}
-		return nil
-	default:
-		return errors.New("некорректная роль")
 	}
+	setAuditUser(r, actor.ID)
+	return actor, true
 }
 
 func (s *Server) decorateUserAvatar(user *models.User) {
@@ -1008,11 +1021,12 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 			items []models.Report
 			err   error
 		)
-		if isSuperRole(actor.Role) {
+		switch s.policy().ScopeOf(actor, authz.ReportRead) {
+		case authz.ScopeAll:
 			items, err = s.repo.Reports(r.Context())
-		} else if strings.EqualFold(actor.Role, "Project Manager") {
-			items, err = s.repo.ReportsByDepartment(r.Context(), actor.DepartmentID)
-		} else {
+		case authz.ScopeDepartment:
+			items, err = s.repo.ReportsByDepartment(r.Context(), s.policy().Departments(actor)...)
+		default:
 			items, err = s.repo.ReportsByUser(r.Context(), actor.ID)
 		}
 		if err != nil {
@@ -1021,14 +1035,8 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"items": items})
 	case http.MethodPost:
-		actorLogin := strings.TrimSpace(r.Header.Get("X-Actor-Login"))
-		if actorLogin == "" {
-			writeError(w, http.StatusUnauthorized, "нужен заголовок X-Actor-Login")
-			return
-		}
-		actor, err := s.repo.UserByLogin(r.Context(), actorLogin)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "пользователь не найден")
+		actor, ok := s.actorFromRequest(w, r)
+		if !ok {
 			return
 		}
 
@@ -1043,6 +1051,7 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 		title := strings.TrimSpace(r.FormValue("title"))
 		resolution := strings.TrimSpace(r.FormValue("resolution"))
 		closeItem := strings.EqualFold(strings.TrimSpace(r.FormValue("close_item")), "true")
+		forceClose := strings.EqualFold(strings.TrimSpace(r.FormValue("force_close")), "true")
 		if resultStatus == "" {
 			resultStatus = "Завершено"
 		}
@@ -1058,28 +1067,19 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		isManager := strings.EqualFold(actor.Role, "Owner") || strings.EqualFold(actor.Role, "Admin") || strings.EqualFold(actor.Role, "Deputy Admin")
-		if !isManager {
-			var allowed bool
-			switch targetType {
-			case "task":
-				allowed, err = s.repo.IsTaskParticipant(r.Context(), targetID, actor.ID)
-			case "project":
-				allowed, err = s.repo.IsProjectParticipant(r.Context(), targetID, actor.ID)
-			}
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-			if !allowed {
-				writeError(w, http.StatusForbidden, "закрыть через отчет может куратор или исполнитель")
-				return
-			}
+		res, err := s.targetResource(r.Context(), actor, targetType, targetID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.authorize(w, actor, authz.ReportCreate, res) {
+			return
 		}
 
 		const maxBytes = 50 << 20
 		var fileName string
 		var filePath string
+		var fileType string
 		var fileSize int64
 		file, header, fileErr := r.FormFile("file")
 		if fileErr == nil {
@@ -1097,7 +1097,23 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 				writeError(w, http.StatusBadRequest, "максимальный размер файла 50 МБ")
 				return
 			}
-			fileName = header.Filename
+			checked, err := uploads.Check(header.Filename, data, s.uploadReportTypes)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if !s.scanUpload(w, r, models.QuarantinedFile{
+				Kind:       "report",
+				ScopeType:  targetType,
+				ScopeID:    targetID,
+				UploaderID: actor.ID,
+				FileName:   checked.Name,
+				FileType:   checked.ContentType,
+			}, data) {
+				return
+			}
+			fileName = checked.Name
+			fileType = checked.ContentType
 			baseDir := filepath.Join(filepath.Dir(s.staticPath), "data", "reports")
 			savedPath, size, err := s.repo.SaveReportFile(baseDir, fileName, data)
 			if err != nil {
@@ -1109,16 +1125,19 @@ func (s *Server) reports(w http.ResponseWriter, r *http.Request) {
 		}
 
 		in := models.CreateReportInput{
-			TargetType: targetType,
-			TargetID:   targetID,
+			TargetType:   targetType,
+			TargetID:     targetID,
 			ResultStatus: resultStatus,
-			AuthorID:   actor.ID,
-			Title:      title,
-			Resolution: resolution,
-			FileName:   fileName,
-			FilePath:   filePath,
-			FileSize:   fileSize,
-			CloseItem:  closeItem,
+			AuthorID:     actor.ID,
+			Title:        title,
+			Resolution:   resolution,
+			FileName:     fileName,
+			FilePath:     filePath,
+			FileSize:     fileSize,
+			FileType:     fileType,
+			CloseItem:    closeItem,
+			ForceClose:   forceClose,
+			AuthorRole:   actor.Role,
 		}
 		if err := s.repo.CreateReport(r.Context(), in); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
@@ -1144,14 +1163,13 @@ func (s *Server) departmentMessages(w http.ResponseWriter, r *http.Request) {
 	if departmentID != nil {
 		targetDepartmentID = *departmentID
 	}
-	if !isSuperRole(actor.Role) && targetDepartmentID != actor.DepartmentID {
-		writeError(w, http.StatusForbidden, "доступ только к чату своего отдела")
-		return
-	}
 	if targetDepartmentID <= 0 {
 		writeError(w, http.StatusBadRequest, "выберите отдел")
 		return
 	}
+	if !s.authorize(w, actor, authz.DepartmentChat, authz.Resource{DepartmentID: targetDepartmentID}) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
@@ -1165,6 +1183,7 @@ func (s *Server) departmentMessages(w http.ResponseWriter, r *http.Request) {
 		body := ""
 		fileName := ""
 		filePath := ""
+		fileType := ""
 		var fileSize int64
 
 		contentType := strings.ToLower(r.Header.Get("Content-Type"))
@@ -1199,14 +1218,30 @@ func (s *Server) departmentMessages(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				if len(data) > 0 {
+					checked, err := uploads.Check(header.Filename, data, s.uploadChatTypes)
+					if err != nil {
+						writeError(w, http.StatusBadRequest, err.Error())
+						return
+					}
+					if !s.scanUpload(w, r, models.QuarantinedFile{
+						Kind:       "chat",
+						ScopeType:  "department",
+						ScopeID:    targetDepartmentID,
+						UploaderID: actor.ID,
+						FileName:   checked.Name,
+						FileType:   checked.ContentType,
+					}, data) {
+						return
+					}
 					baseDir := filepath.Join(filepath.Dir(s.staticPath), "data", "messages")
-					savedPath, size, err := s.repo.SaveChatFile(baseDir, header.Filename, data)
+					savedPath, size, err := s.repo.SaveChatFile(baseDir, checked.Name, data)
 					if err != nil {
 						writeError(w, http.StatusInternalServerError, err.Error())
 						return
 					}
-					fileName = header.Filename
+					fileName = checked.Name
 					filePath = savedPath
+					fileType = checked.ContentType
 					fileSize = size
 				}
 			}
@@ -1228,11 +1263,10 @@ func (s *Server) departmentMessages(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "заполните сообщение")
 			return
 		}
-		if !isSuperRole(actor.Role) && targetDepartmentID != actor.DepartmentID {
-			writeError(w, http.StatusForbidden, "доступ только к чату своего отдела")
+		if !s.authorize(w, actor, authz.DepartmentChat, authz.Resource{DepartmentID: targetDepartmentID}) {
 			return
 		}
-		if err := s.repo.CreateDepartmentMessage(r.Context(), targetDepartmentID, actor.ID, body, fileName, filePath, fileSize); err != nil {
+		if err := s.repo.CreateDepartmentMessage(r.Context(), targetDepartmentID, actor.ID, body, fileName, filePath, fileType, fileSize); err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -1252,8 +1286,7 @@ func (s *Server) departmentMessages(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "сообщение не принадлежит выбранному чату отдела")
 			return
 		}
-		if !isSuperRole(actor.Role) && authorID != actor.ID {
-			writeError(w, http.StatusForbidden, "можно удалять только свои сообщения")
+		if !s.authorize(w, actor, authz.MessageDelete, authz.Resource{OwnerID: authorID}) {
 			return
 		}
 		if err := s.repo.DeleteChatMessage(r.Context(), messageID); err != nil {
@@ -1283,13 +1316,12 @@ func (s *Server) taskMessages(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "нужен task_id")
 		return
 	}
-	allowed, err := s.repo.IsTaskParticipant(r.Context(), *taskID, actor.ID)
+	res, err := s.taskResource(r.Context(), actor, *taskID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, http.StatusNotFound, err.Error())
 		return
 	}
-	if !allowed {
-		writeError(w, http.StatusForbidden, "чат задачи доступен только кураторам и исполнителям этой задачи")
+	if !s.authorize(w, actor, authz.TaskChat, res) {
 		return
 	}
 
@@ -1306,6 +1338,7 @@ func (s *Server) taskMessages(w http.ResponseWriter, r *http.Request) {
 		body := ""
 		fileName := ""
 		filePath := ""
+		fileType := ""
 		var fileSize int64
 
 		contentType := strings.ToLower(r.Header.Get("Content-Type"))
@@ -1341,14 +1374,30 @@ func (s *Server) taskMessages(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				if len(data) > 0 {
+					checked, err := uploads.Check(header.Filename, data, s.uploadChatTypes)
+					if err != nil {
+						writeError(w, http.StatusBadRequest, err.Error())
+						return
+					}
+					if !s.scanUpload(w, r, models.QuarantinedFile{
+						Kind:       "chat",
+						ScopeType:  "task",
+						ScopeID:    targetTaskID,
+						UploaderID: actor.ID,
+						FileName:   checked.Name,
+						FileType:   checked.ContentType,
+					}, data) {
+						return
+					}
 					baseDir := filepath.Join(filepath.Dir(s.staticPath), "data", "messages")
-					savedPath, size, err := s.repo.SaveChatFile(baseDir, header.Filename, data)
+					savedPath, size, err := s.repo.SaveChatFile(baseDir, checked.Name, data)
 					if err != nil {
 						writeError(w, http.StatusInternalServerError, err.Error())
 						return
 					}
-					fileName = header.Filename
+					fileName = checked.Name
 					filePath = savedPath
+					fileType = checked.ContentType
 					fileSize = size
 				}
 			}
@@ -1372,16 +1421,17 @@ func (s *Server) taskMessages(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-			allowed, err := s.repo.IsTaskParticipant(r.Context(), targetTaskID, actor.ID)
+		if targetTaskID != *taskID {
+			res, err := s.taskResource(r.Context(), actor, targetTaskID)
 			if err != nil {
-				writeError(w, http.StatusInternalServerError, err.Error())
+				writeError(w, http.StatusNotFound, err.Error())
 				return
 			}
-			if !allowed {
-				writeError(w, http.StatusForbidden, "чат задачи доступен только кураторам и исполнителям этой задачи")
+			if !s.authorize(w, actor, authz.TaskChat, res) {
 				return
 			}
-		if err := s.repo.CreateTaskMessage(r.Context(), targetTaskID, actor.ID, body, fileName, filePath, fileSize); err != nil {
+		}
+		if err := s.repo.CreateTaskMessage(r.Context(), targetTaskID, actor.ID, body, fileName, filePath, fileType, fileSize); err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -1401,8 +1451,7 @@ func (s *Server) taskMessages(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "сообщение не принадлежит выбранному чату задачи")
 			return
 		}
-		if !isSuperRole(actor.Role) && authorID != actor.ID {
-			writeError(w, http.StatusForbidden, "можно удалять только свои сообщения")
+		if !s.authorize(w, actor, authz.MessageDelete, authz.Resource{OwnerID: authorID}) {
 			return
 		}
 		if err := s.repo.DeleteChatMessage(r.Context(), messageID); err != nil {
@@ -1433,46 +1482,32 @@ func (s *Server) messageFile(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "not found")
 		return
 	}
-	scopeType, scopeID, filePath, fileName, _, err := s.repo.MessageFilePath(r.Context(), messageID)
+	scopeType, scopeID, filePath, fileName, fileType, err := s.repo.MessageFilePath(r.Context(), messageID)
 	if err != nil {
 		writeError(w, http.StatusNotFound, err.Error())
 		return
 	}
-	var departmentID int64
-	if strings.EqualFold(scopeType, "task") {
-		allowed, err := s.repo.IsTaskParticipant(r.Context(), scopeID, actor.ID)
-		if err != nil {
-			writeError(w, http.StatusForbidden, "нет доступа")
-			return
-		}
-		if !allowed {
-			writeError(w, http.StatusForbidden, "нет доступа")
-			return
-		}
-	} else if strings.EqualFold(scopeType, "department") {
-		departmentID = scopeID
-	} else {
-		writeError(w, http.StatusForbidden, "нет доступа")
-		return
+	var allowed bool
+	switch {
+	case strings.EqualFold(scopeType, "task"):
+		res, err := s.taskResource(r.Context(), actor, scopeID)
+		allowed = err == nil && s.policy().Allow(actor, authz.TaskChat, res)
+	case strings.EqualFold(scopeType, "department"):
+		allowed = s.policy().Allow(actor, authz.DepartmentChat, authz.Resource{DepartmentID: scopeID})
 	}
-	if strings.EqualFold(scopeType, "department") && !isSuperRole(actor.Role) && actor.DepartmentID != departmentID {
+	if !allowed {
 		writeError(w, http.StatusForbidden, "нет доступа")
 		return
 	}
 
-	fd, err := os.Open(filePath)
-	if err != nil {
-		writeError(w, http.StatusNotFound, "файл не найден")
+	fd, ok := s.openStoredFile(w, filePath)
+	if !ok {
 		return
 	}
 	defer fd.Close()
-	stat, err := fd.Stat()
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "не удалось прочитать файл")
-		return
-	}
+	w.Header().Set("Content-Type", storedContentType(fd, fileName, fileType))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
-	http.ServeContent(w, r, fileName, stat.ModTime(), fd)
+	http.ServeContent(w, r, fileName, fd.ModTime, fd)
 }
 
 func (s *Server) reportFile(w http.ResponseWriter, r *http.Request) {
@@ -1486,31 +1521,20 @@ func (s *Server) reportFile(w http.ResponseWriter, r *http.Request) {
 		if !ok {
 			return
 		}
-		_, _, authorID, departmentID, filePath, _, err := s.repo.ReportMeta(r.Context(), reportID)
+		_, _, authorID, departmentID, _, _, err := s.repo.ReportMeta(r.Context(), reportID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
-		allowed := false
-		if isSuperRole(actor.Role) {
-			allowed = true
-		} else if strings.EqualFold(actor.Role, "Project Manager") {
-			allowed = actor.DepartmentID == departmentID
-		} else {
-			allowed = actor.ID == authorID
-		}
-		if !allowed {
-			writeError(w, http.StatusForbidden, "нет прав на удаление отчета")
+		if !s.authorize(w, actor, authz.ReportDelete, authz.Resource{DepartmentID: departmentID, OwnerID: authorID}) {
 			return
 		}
-		if err := s.repo.DeleteReport(r.Context(), reportID); err != nil {
+		// Файл отчета остается на диске, пока отчет лежит в корзине.
+		if err := s.repo.DeleteReport(r.Context(), reportID, actor.ID); err != nil {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if strings.TrimSpace(filePath) != "" {
-			_ = os.Remove(filePath)
-		}
-		writeJSON(w, http.StatusOK, map[string]string{"message": "отчет удален"})
+		writeJSON(w, http.StatusOK, map[string]string{"message": "отчет перенесен в корзину"})
 		return
 	}
 	if r.Method != http.MethodGet {
@@ -1522,25 +1546,71 @@ func (s *Server) reportFile(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "not found")
 		return
 	}
-	filePath, fileName, err := s.repo.ReportFilePath(r.Context(), reportID)
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	targetType, targetID, authorID, departmentID, _, _, err := s.repo.ReportMeta(r.Context(), reportID)
 	if err != nil {
 		writeError(w, http.StatusNotFound, err.Error())
 		return
 	}
-	fd, err := os.Open(filePath)
+	res, err := s.targetResource(r.Context(), actor, targetType, targetID)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "файл не найден")
+		// Цель отчета могла быть удалена: остаются автор, отдел и руководство.
+		res = authz.Resource{}
+	}
+	res.DepartmentID = departmentID
+	res.OwnerID = authorID
+	if !s.authorize(w, actor, authz.ReportRead, res) {
 		return
 	}
-	defer fd.Close()
-
-	stat, err := fd.Stat()
+	filePath, fileName, fileType, err := s.repo.ReportFilePath(r.Context(), reportID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "не удалось прочитать файл")
+		writeError(w, http.StatusNotFound, err.Error())
 		return
 	}
+	fd, ok := s.openStoredFile(w, filePath)
+	if !ok {
+		return
+	}
+	defer fd.Close()
+	w.Header().Set("Content-Type", storedContentType(fd, fileName, fileType))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
-	http.ServeContent(w, r, fileName, stat.ModTime(), fd)
+	http.ServeContent(w, r, fileName, fd.ModTime, fd)
+}
+
+// openStoredFile открывает вложение для отдачи; зашифрованное расшифровывается потоком.
+func (s *Server) openStoredFile(w http.ResponseWriter, filePath string) (*filecrypt.File, bool) {
+	fd, err := s.repo.OpenStoredFile(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeError(w, http.StatusNotFound, "файл не найден")
+		} else {
+			log.Printf("open stored file %s: %v", filePath, err)
+			writeError(w, http.StatusInternalServerError, "не удалось прочитать файл")
+		}
+		return nil, false
+	}
+	return fd, true
+}
+
+// storedContentType возвращает тип, сохраненный при загрузке. У старых файлов его нет —
+// тогда тип определяется по содержимому так же, как при загрузке.
+func storedContentType(fd io.ReadSeeker, fileName, fileType string) string {
+	if fileType != "" {
+		return fileType
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(fd, head)
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+	contentType := uploads.Detect(head[:n], filepath.Ext(fileName))
+	if contentType == "text/html" || contentType == "text/xml" {
+		return "application/octet-stream"
+	}
+	return contentType
 }
 
 func readOptionalInt64Query(r *http.Request, key string) (*int64, error) {
@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// taskHistory — лента задачи для карточки: история изменений, сообщения чата и отчеты
+// в хронологическом порядке. Чат и отчеты попадают в ленту, только если их можно читать.
+func (s *Server) taskHistory(w http.ResponseWriter, r *http.Request, taskID int64) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.taskResource(r.Context(), actor, taskID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.TaskRead, res) {
+		return
+	}
+
+	events, err := s.repo.TaskEvents(r.Context(), taskID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	items := make([]models.TaskTimelineItem, 0, len(events))
+	for i := range events {
+		items = append(items, models.TaskTimelineItem{Kind: "event", CreatedAt: events[i].CreatedAt, Event: &events[i]})
+	}
+	if s.policy().Allow(actor, authz.ReportRead, res) {
+		reports, err := s.repo.TaskReports(r.Context(), taskID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for i := range reports {
+			items = append(items, models.TaskTimelineItem{Kind: "report", CreatedAt: reports[i].CreatedAt, Report: &reports[i]})
+		}
+	}
+	if s.policy().Allow(actor, authz.TaskChat, res) {
+		messages, err := s.repo.TaskMessages(r.Context(), taskID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for i := range messages {
+			items = append(items, models.TaskTimelineItem{Kind: "message", CreatedAt: messages[i].CreatedAt, Message: &messages[i]})
+		}
+	}
+	// Время хранится с точностью до секунды, поэтому сортировка устойчивая: при совпадении
+	// изменения задачи идут раньше отчетов и сообщений, а внутри вида сохраняется порядок id.
+	sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedAt < items[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// taskDetails — GET /api/v1/tasks/{id}: задача со связями, сводкой по подзадачам и признаком blocked.
+func (s *Server) taskDetails(w http.ResponseWriter, r *http.Request, taskID int64) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.taskResource(r.Context(), actor, taskID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.TaskRead, res) {
+		return
+	}
+	item, err := s.repo.TaskByID(r.Context(), taskID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	links, err := s.repo.TaskLinks(r.Context(), taskID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	item.Links = links
+	writeJSON(w, http.StatusOK, map[string]any{"item": item})
+}
+
+// taskByKey — GET /api/v1/tasks/key/{key}: то же, что GET /api/v1/tasks/{id}, но по ключу задачи,
+// в том числе прежнему — после переименования ключа проекта или переноса задачи.
+func (s *Server) taskByKey(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	taskID, err := s.repo.TaskIDByKey(r.Context(), key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.taskDetails(w, r, taskID)
+}
+
+// taskLinks — связи задачи: GET /api/v1/tasks/{id}/links, POST с {"type", "task_id"}
+// и DELETE /api/v1/tasks/{id}/links/{linkID}.
+func (s *Server) taskLinks(w http.ResponseWriter, r *http.Request, taskID, linkID int64) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.taskResource(r.Context(), actor, taskID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch {
+	case linkID == 0 && r.Method == http.MethodGet:
+		if !s.authorize(w, actor, authz.TaskRead, res) {
+			return
+		}
+		items, err := s.repo.TaskLinks(r.Context(), taskID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case linkID == 0 && r.Method == http.MethodPost:
+		if !s.authorize(w, actor, authz.TaskLink, res) {
+			return
+		}
+		var input models.CreateTaskLinkInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.TaskID <= 0 {
+			writeError(w, http.StatusBadRequest, "укажите связываемую задачу")
+			return
+		}
+		// Связать можно только с задачей, которую пользователь видит.
+		otherRes, err := s.taskResource(r.Context(), actor, input.TaskID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.authorize(w, actor, authz.TaskRead, otherRes) {
+			return
+		}
+		if err := s.repo.CreateTaskLink(r.Context(), taskID, input, actor.ID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"message": "связь добавлена"})
+	case linkID != 0 && r.Method == http.MethodDelete:
+		if !s.authorize(w, actor, authz.TaskLink, res) {
+			return
+		}
+		if err := s.repo.DeleteTaskLink(r.Context(), taskID, linkID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "связь удалена"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// filterTasksByLinks применяет к списку задач фильтры blocked=true|false и linked_to={id}
+// (с необязательным link_type — тип связи со стороны задачи из списка).
+func (s *Server) filterTasksByLinks(r *http.Request, tasks []models.Task, linkedTo *int64) ([]models.Task, error) {
+	blocked := strings.TrimSpace(r.URL.Query().Get("blocked"))
+	if blocked == "" && linkedTo == nil {
+		return tasks, nil
+	}
+	var linked map[int64]bool
+	if linkedTo != nil {
+		links, err := s.repo.TaskLinks(r.Context(), *linkedTo)
+		if err != nil {
+			return nil, err
+		}
+		linkType := strings.TrimSpace(r.URL.Query().Get("link_type"))
+		linked = make(map[int64]bool, len(links))
+		for _, link := range links {
+			// link.Type указан со стороны linked_to, фильтр — со стороны задачи из списка.
+			if linkType == "" || reverseLinkType(link.Type) == linkType {
+				linked[link.TaskID] = true
+			}
+		}
+	}
+
+	filtered := make([]models.Task, 0, len(tasks))
+	for _, t := range tasks {
+		if blocked != "" && t.Blocked != strings.EqualFold(blocked, "true") {
+			continue
+		}
+		if linked != nil && !linked[t.ID] {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered, nil
+}
+
+func reverseLinkType(linkType string) string {
+	switch linkType {
+	case "blocks":
+		return "blocked_by"
+	case "blocked_by":
+		return "blocks"
+	case "duplicates":
+		return "duplicated_by"
+	case "duplicated_by":
+		return "duplicates"
+	}
+	return linkType
+}
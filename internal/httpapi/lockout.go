@@ -0,0 +1,126 @@
+package httpapi
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const loginAttemptsLimit = 100
+
+// rejectLockedLogin отвечает 429, если логин или адрес клиента временно заблокирован.
+// Пароль при этом не проверяется, а попытка только записывается в журнал.
+func (s *Server) rejectLockedLogin(w http.ResponseWriter, r *http.Request, login string) bool {
+	ip := s.clientIP(r)
+	until, locked, err := s.repo.LoginLockedUntil(r.Context(), login, ip)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return true
+	}
+	if !locked {
+		return false
+	}
+	if err := s.repo.LogLoginAttempt(r.Context(), login, ip, strings.TrimSpace(r.UserAgent()), "вход заблокирован"); err != nil {
+		log.Printf("login attempt: %v", err)
+	}
+	writeLockedError(w, until)
+	return true
+}
+
+func (s *Server) loginFailed(w http.ResponseWriter, r *http.Request, login string, loginErr error) {
+	until, locked, err := s.repo.RegisterLoginFailure(r.Context(), s.lockoutPolicy, login, s.clientIP(r), strings.TrimSpace(r.UserAgent()), loginErr.Error())
+	if err != nil {
+		log.Printf("login failure: %v", err)
+	}
+	if locked {
+		writeLockedError(w, until)
+		return
+	}
+	writeError(w, http.StatusUnauthorized, loginErr.Error())
+}
+
+func writeLockedError(w http.ResponseWriter, until time.Time) {
+	wait := time.Until(until)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, fmt.Sprintf("слишком много неудачных попыток входа, повторите через %d мин", int(math.Ceil(wait.Minutes()))))
+}
+
+func (s *Server) loginLockouts(w http.ResponseWriter, r *http.Request, actor models.User, lockoutID int64) {
+	if !s.authorize(w, actor, authz.LoginLockouts, authz.Resource{}) {
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && lockoutID == 0:
+		items, err := s.repo.LoginLockouts(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		attempts, err := s.repo.LoginAttempts(r.Context(), r.URL.Query().Get("login"), loginAttemptsLimit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items, "attempts": attempts})
+	case r.Method == http.MethodDelete && lockoutID > 0:
+		if err := s.repo.ClearLoginLockout(r.Context(), lockoutID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "блокировка снята"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) userLockout(w http.ResponseWriter, r *http.Request, actor models.User, userID int64) {
+	if _, ok := s.requireScope(w, actor, authz.UserLockout); !ok {
+		return
+	}
+	target, err := s.repo.UserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.UserLockout, userResource(target)) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		lockout, found, err := s.repo.LoginLockoutByLogin(r.Context(), target.Login)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		attempts, err := s.repo.LoginAttempts(r.Context(), target.Login, loginAttemptsLimit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp := map[string]any{"locked": found && lockout.LockedUntil != nil, "lockout": nil, "attempts": attempts}
+		if found {
+			resp["lockout"] = lockout
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodDelete:
+		if err := s.repo.ClearUserLockout(r.Context(), target.Login); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "блокировка входа снята"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUserLockoutClearIsAudited(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	member := env.addUser("member1", "Member", 1)
+	lockoutID := env.exec(`INSERT INTO login_lockouts (scope, key, failures, locked_until) VALUES ('login', 'member1', 7, ?)`,
+		time.Now().UTC().Add(time.Hour).Format("2006-01-02 15:04:05"))
+	path := fmt.Sprintf("/api/v1/users/%d/lockout", member.ID)
+
+	expect(t, env.do(http.MethodDelete, path, env.session(admin), nil), http.StatusOK)
+
+	var lockouts, events int
+	if err := env.db.QueryRow(`SELECT COUNT(*) FROM login_lockouts WHERE key = 'member1'`).Scan(&lockouts); err != nil || lockouts != 0 {
+		t.Fatalf("lockouts left = %d, %v; want 0", lockouts, err)
+	}
+	if err := env.db.QueryRow(`
+SELECT COUNT(*) FROM audit_events
+WHERE action = 'login_lockout.clear' AND entity_type = 'login_lockout' AND entity_id = ? AND actor_user_id = ? AND before_json LIKE '%member1%'`,
+		lockoutID, admin.ID).Scan(&events); err != nil || events != 1 {
+		t.Fatalf("lockout clear audit events = %d, %v; want 1", events, err)
+	}
+
+	// Снимать нечего — ответ прежний, но пустое событие в журнал не пишется.
+	expect(t, env.do(http.MethodDelete, path, env.session(admin), nil), http.StatusOK)
+	if err := env.db.QueryRow(`SELECT COUNT(*) FROM audit_events WHERE action = 'login_lockout.clear'`).Scan(&events); err != nil || events != 1 {
+		t.Fatalf("lockout clear audit events = %d, %v; want 1", events, err)
+	}
+}
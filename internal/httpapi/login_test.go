@@ -0,0 +1,23 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServiceAccountCannotLogInWithPassword(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser("member1", "Member", 1)
+	service := env.addUser("robot", "Member", 1)
+	// Даже если у сервисной учетной записи оказался пароль, входить по нему нельзя.
+	env.exec(`UPDATE users SET is_service = 1 WHERE id = ?`, service.ID)
+
+	expect(t, env.do(http.MethodPost, "/api/v1/auth/login", "", map[string]string{"login": "member1", "password": "Passw0rd!test"}), http.StatusOK)
+
+	rec := env.do(http.MethodPost, "/api/v1/auth/login", "", map[string]string{"login": "robot", "password": "Passw0rd!test"})
+	expect(t, rec, http.StatusUnauthorized)
+	if !strings.Contains(rec.Body.String(), "неверный логин или пароль") {
+		t.Fatalf("service login error = %s, want invalid credentials", rec.Body.String())
+	}
+}
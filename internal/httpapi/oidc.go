@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func (s *Server) authProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"oidc":       s.oidc != nil,
+		"oidc_label": s.oidcLabel,
+	})
+}
+
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.oidc == nil {
+		writeError(w, http.StatusNotFound, "вход через SSO не настроен")
+		return
+	}
+	state, nonce, verifier, err := auth.NewOIDCState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.repo.CreateOIDCState(r.Context(), state, nonce, verifier); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	target, err := s.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc login: %v", err)
+		redirectLoginError(w, r, "провайдер входа недоступен, попробуйте позже")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (s *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.oidc == nil {
+		writeError(w, http.StatusNotFound, "вход через SSO не настроен")
+		return
+	}
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		log.Printf("oidc callback: idp error %q: %s", idpErr, q.Get("error_description"))
+		redirectLoginError(w, r, "провайдер входа отклонил запрос")
+		return
+	}
+	nonce, verifier, err := s.repo.ConsumeOIDCState(r.Context(), q.Get("state"))
+	if err != nil {
+		redirectLoginError(w, r, err.Error())
+		return
+	}
+	identity, err := s.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("oidc callback: %v", err)
+		redirectLoginError(w, r, "не удалось подтвердить вход у провайдера")
+		return
+	}
+	user, err := s.oidcUser(r.Context(), identity)
+	if err != nil {
+		redirectLoginError(w, r, err.Error())
+		return
+	}
+	// Второй фактор при SSO проверяет сам IdP.
+	if _, err := s.startSession(w, r, user.ID); err != nil {
+		log.Printf("oidc callback: %v", err)
+		redirectLoginError(w, r, "не удалось создать сессию")
+		return
+	}
+	http.Redirect(w, r, "/login.html?sso=1", http.StatusFound)
+}
+
+func (s *Server) oidcUser(ctx context.Context, identity auth.OIDCIdentity) (models.User, error) {
+	user, found, err := s.repo.UserByOIDCSubject(ctx, identity.Subject)
+	if err != nil || found {
+		return user, err
+	}
+	if s.oidcLinkByLogin {
+		user, linked, err := s.repo.LinkOIDCSubject(ctx, identity.Login, identity.Subject)
+		if err != nil || linked {
+			return user, err
+		}
+	}
+
+	// JIT-учетные записи проходят те же проверки отдела и должности, что и созданные вручную.
+	departmentID, err := s.resolveDepartment(ctx, identity.Department)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := s.validateRoleDepartmentPosition(ctx, authz.RoleMember, departmentID, identity.Position); err != nil {
+		return models.User{}, errors.New("учетную запись нельзя создать автоматически: " + err.Error())
+	}
+	return s.repo.CreateOIDCUser(ctx, identity.Login, identity.FullName, identity.Position, departmentID, identity.Subject)
+}
+
+// resolveDepartment принимает из claim'а как ID отдела, так и его название.
+func (s *Server) resolveDepartment(ctx context.Context, value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, errors.New("провайдер входа не передал отдел пользователя")
+	}
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return id, nil
+	}
+	items, err := s.repo.Departments(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if strings.EqualFold(strings.TrimSpace(item.Name), value) {
+			return item.ID, nil
+		}
+	}
+	return 0, errors.New("отдел «" + value + "» не найден")
+}
+
+func redirectLoginError(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/login.html?sso_error="+url.QueryEscape(msg), http.StatusFound)
+}
@@ -0,0 +1,288 @@
+package httpapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mvd/taskflow/internal/auth"
+)
+
+// fakeIdP — OpenID-провайдер на httptest: discovery, JWKS и token endpoint. Код авторизации
+// выдает сам тест через issue, а ID-токен подписывается ключом провайдера.
+type fakeIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]map[string]any
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	idp := &fakeIdP{t: t, key: key, codes: make(map[string]map[string]any)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		claims, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+		if !ok || r.FormValue("code_verifier") == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access", "token_type": "Bearer", "expires_in": 300,
+			"id_token": idp.sign(claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// issue регистрирует код авторизации, за который token endpoint выдаст ID-токен с claims.
+func (idp *fakeIdP) issue(code string, claims map[string]any) {
+	now := time.Now()
+	token := map[string]any{"iss": idp.server.URL, "aud": "taskflow", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+	for name, value := range claims {
+		token[name] = value
+	}
+	idp.mu.Lock()
+	idp.codes[code] = token
+	idp.mu.Unlock()
+}
+
+func (idp *fakeIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Errorf("marshal claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Errorf("sign id token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (e *testEnv) enableOIDC(idp *fakeIdP, linkByLogin bool) {
+	e.t.Helper()
+	provider, err := auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    "taskflow",
+		RedirectURL: "https://taskflow.test/api/v1/auth/oidc/callback",
+		Claims:      auth.OIDCClaimMapping{Login: "preferred_username", FullName: "name", Department: "org.department", Position: "title"},
+	})
+	if err != nil {
+		e.t.Fatalf("oidc provider: %v", err)
+	}
+	e.server.oidc = provider
+	e.server.oidcLinkByLogin = linkByLogin
+}
+
+// staffPosition возвращает название отдела и первую должность в нем, кроме руководителя.
+func (e *testEnv) staffPosition(departmentID int64) (string, string) {
+	e.t.Helper()
+	var department, position string
+	if err := e.db.QueryRow(`
+SELECT d.name, p.name FROM departments d JOIN department_positions p ON p.department_id = d.id
+WHERE d.id = ? AND p.is_head = 0 ORDER BY p.id LIMIT 1`, departmentID).Scan(&department, &position); err != nil {
+		e.t.Fatalf("find position: %v", err)
+	}
+	return department, position
+}
+
+// oidcStart начинает вход и возвращает state и nonce из перенаправления на IdP.
+func (e *testEnv) oidcStart() (state, nonce string) {
+	e.t.Helper()
+	rec := e.do(http.MethodGet, "/api/v1/auth/oidc/login", "", nil)
+	expect(e.t, rec, http.StatusFound)
+	target, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		e.t.Fatalf("parse idp redirect: %v", err)
+	}
+	q := target.Query()
+	if q.Get("state") == "" || q.Get("nonce") == "" || q.Get("code_challenge_method") != "S256" {
+		e.t.Fatalf("idp redirect = %s, want state, nonce and PKCE", target)
+	}
+	return q.Get("state"), q.Get("nonce")
+}
+
+// oidcCallback возвращает сообщение об ошибке входа (пустое при успехе) и cookie сессии.
+func (e *testEnv) oidcCallback(state, code string) (string, string) {
+	e.t.Helper()
+	rec := e.do(http.MethodGet, "/api/v1/auth/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), "", nil)
+	expect(e.t, rec, http.StatusFound)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		e.t.Fatalf("parse callback redirect: %v", err)
+	}
+	var session string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = cookie.Value
+		}
+	}
+	if msg := location.Query().Get("sso_error"); msg != "" {
+		if session != "" {
+			e.t.Fatalf("failed sso login %q set a session cookie", msg)
+		}
+		return msg, ""
+	}
+	if location.Query().Get("sso") != "1" || session == "" {
+		e.t.Fatalf("callback redirect = %s without session, want sso=1", location)
+	}
+	return "", session
+}
+
+func TestOIDCCallbackCreatesAndReusesUser(t *testing.T) {
+	env := newTestEnv(t)
+	idp := newFakeIdP(t)
+	env.enableOIDC(idp, false)
+	department, position := env.staffPosition(1)
+	claims := map[string]any{"sub": "u-100", "preferred_username": "sso.user", "name": "Сергеев Сергей",
+		"org": map[string]any{"department": strings.ToUpper(department)}, "title": position}
+
+	state, nonce := env.oidcStart()
+	claims["nonce"] = nonce
+	idp.issue("code-1", claims)
+	if msg, session := env.oidcCallback(state, "code-1"); msg != "" || session == "" {
+		t.Fatalf("first sso login failed: %s", msg)
+	}
+	var id, departmentID int64
+	var login, fullName, role, source, subject string
+	if err := env.db.QueryRow(`SELECT id, login, full_name, role, department_id, auth_source, oidc_subject FROM users WHERE login = 'sso.user'`).
+		Scan(&id, &login, &fullName, &role, &departmentID, &source, &subject); err != nil {
+		t.Fatalf("find sso user: %v", err)
+	}
+	if fullName != "Сергеев Сергей" || role != "Member" || departmentID != 1 || source != "oidc" || subject != idp.server.URL+"|u-100" {
+		t.Fatalf("sso user = %s %s %s dept %d %s %s", login, fullName, role, departmentID, source, subject)
+	}
+
+	// Повторный вход находит пользователя по subject, даже если логин в IdP сменился.
+	state, nonce = env.oidcStart()
+	claims["nonce"] = nonce
+	claims["preferred_username"] = "renamed"
+	idp.issue("code-2", claims)
+	if msg, session := env.oidcCallback(state, "code-2"); msg != "" || session == "" {
+		t.Fatalf("second sso login failed: %s", msg)
+	}
+	var users int
+	if err := env.db.QueryRow(`SELECT COUNT(*) FROM users WHERE oidc_subject <> ''`).Scan(&users); err != nil || users != 1 {
+		t.Fatalf("sso users = %d, %v; want 1", users, err)
+	}
+}
+
+func TestOIDCCallbackRejectsBadStateAndNonce(t *testing.T) {
+	env := newTestEnv(t)
+	idp := newFakeIdP(t)
+	env.enableOIDC(idp, false)
+	claims := map[string]any{"sub": "u-200", "preferred_username": "sso.other", "org": map[string]any{"department": "1"}}
+
+	if msg, _ := env.oidcCallback("forged-state", "code"); msg == "" {
+		t.Fatal("callback accepted an unknown state")
+	}
+
+	state, _ := env.oidcStart()
+	claims["nonce"] = "other-nonce"
+	idp.issue("code-nonce", claims)
+	if msg, _ := env.oidcCallback(state, "code-nonce"); msg == "" {
+		t.Fatal("callback accepted an id token with a foreign nonce")
+	}
+	// state одноразовый: повтор того же ответа IdP отклоняется.
+	idp.issue("code-replay", claims)
+	if msg, _ := env.oidcCallback(state, "code-replay"); msg == "" {
+		t.Fatal("callback accepted a replayed state")
+	}
+
+	var users int
+	if err := env.db.QueryRow(`SELECT COUNT(*) FROM users WHERE login = 'sso.other'`).Scan(&users); err != nil || users != 0 {
+		t.Fatalf("rejected logins created %d users, %v", users, err)
+	}
+}
+
+func TestOIDCCallbackUnknownUser(t *testing.T) {
+	env := newTestEnv(t)
+	idp := newFakeIdP(t)
+	local := env.addUser("local.user", "Project Manager", 1)
+	_, position := env.staffPosition(1)
+
+	tests := []struct {
+		name        string
+		linkByLogin bool
+		claims      map[string]any
+		wantError   string
+		wantUserID  int64
+	}{
+		{"unknown department", false, map[string]any{"sub": "u-1", "preferred_username": "new.user", "org": map[string]any{"department": "Нет такого отдела"}}, "не найден", 0},
+		{"no department claim", false, map[string]any{"sub": "u-2", "preferred_username": "new.user"}, "не передал отдел", 0},
+		// Без связывания по логину чужая локальная учетная запись не захватывается.
+		{"local login without linking", false, map[string]any{"sub": "u-3", "preferred_username": "local.user", "org": map[string]any{"department": "1"}, "title": position}, "уже существует", 0},
+		{"local login linked", true, map[string]any{"sub": "u-4", "preferred_username": "local.user"}, "", local.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.enableOIDC(idp, tt.linkByLogin)
+			state, nonce := env.oidcStart()
+			tt.claims["nonce"] = nonce
+			idp.issue(tt.name, tt.claims)
+			msg, session := env.oidcCallback(state, tt.name)
+			if tt.wantError != "" {
+				if !strings.Contains(msg, tt.wantError) {
+					t.Fatalf("sso error = %q, want %q", msg, tt.wantError)
+				}
+				return
+			}
+			if msg != "" {
+				t.Fatalf("sso login failed: %s", msg)
+			}
+			user, err := env.repo.UserBySession(context.Background(), session)
+			if err != nil || user.ID != tt.wantUserID {
+				t.Fatalf("session user = %d, %v; want %d", user.ID, err, tt.wantUserID)
+			}
+		})
+	}
+	var created int
+	if err := env.db.QueryRow(`SELECT COUNT(*) FROM users WHERE login = 'new.user'`).Scan(&created); err != nil || created != 0 {
+		t.Fatalf("unknown users created: %d, %v", created, err)
+	}
+}
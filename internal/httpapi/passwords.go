@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const errPasswordChangeRequired = "необходимо сменить пароль: откройте профиль и задайте новый пароль"
+
+func (s *Server) userPassword(w http.ResponseWriter, r *http.Request, actor models.User, userID int64, action string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, ok := s.requireScope(w, actor, authz.UserPassword); !ok {
+		return
+	}
+	target, err := s.repo.UserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if target.ID == actor.ID {
+		writeError(w, http.StatusBadRequest, "свой пароль меняется в профиле")
+		return
+	}
+	if !s.authorize(w, actor, authz.UserPassword, userResource(target)) {
+		return
+	}
+
+	if action == "password-reset" {
+		token, expiresAt, err := s.repo.CreatePasswordReset(r.Context(), target.ID, actor.ID, s.passwordResetTTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Токен передается во фрагменте URL: он не попадает в логи прокси и в Referer.
+		scheme := "http"
+		if s.isHTTPS(r) {
+			scheme = "https"
+		}
+		link := scheme + "://" + r.Host + "/reset-password.html#token=" + url.QueryEscape(token)
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"message":    "ссылка для сброса пароля создана, передайте ее пользователю",
+			"url":        link,
+			"token":      token,
+			"expires_at": expiresAt,
+		})
+		return
+	}
+
+	var input models.SetPasswordInput
+	if err := decodeJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Password == "" {
+		writeError(w, http.StatusBadRequest, "укажите временный пароль")
+		return
+	}
+	if err := s.repo.SetTemporaryPassword(r.Context(), target.ID, input.Password); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "пароль сброшен, пользователь сменит его при следующем входе"})
+}
+
+// passwordReset — публичная часть одноразовой ссылки: GET проверяет токен, POST задает пароль.
+func (s *Server) passwordReset(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		login, err := s.repo.PasswordResetLogin(r.Context(), r.URL.Query().Get("token"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"login": login})
+	case http.MethodPost:
+		var input models.PasswordResetInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.Password == "" || input.Password != input.RepeatPassword {
+			writeError(w, http.StatusBadRequest, "пароли не совпадают")
+			return
+		}
+		if err := s.repo.ResetPasswordByToken(r.Context(), input.Token, input.Password); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "пароль изменен, войдите с новым паролем"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// scanUpload проверяет вложение антивирусом до сохранения. Зараженный или непроверенный файл
+// уходит в карантин, загрузившему возвращается ошибка; false означает, что ответ уже записан.
+func (s *Server) scanUpload(w http.ResponseWriter, r *http.Request, in models.QuarantinedFile, data []byte) bool {
+	if s.scanner == nil {
+		return true
+	}
+	result, err := s.scanner.Scan(r.Context(), bytes.NewReader(data))
+	if err == nil && result.Clean {
+		return true
+	}
+	if err != nil {
+		log.Printf("scan upload %q: %v", in.FileName, err)
+		in.ScanError = err.Error()
+	} else {
+		in.Threat = result.Threat
+	}
+
+	baseDir := filepath.Join(filepath.Dir(s.staticPath), "data", "quarantine")
+	savedPath, err := s.repo.SaveQuarantineFile(baseDir, data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	in.FilePath = savedPath
+	in.FileSize = int64(len(data))
+	if _, err := s.repo.QuarantineFile(r.Context(), in); err != nil {
+		_ = os.Remove(savedPath)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if in.Threat != "" {
+		writeError(w, http.StatusUnprocessableEntity, "в файле обнаружена угроза "+in.Threat+", файл помещен в карантин")
+	} else {
+		writeError(w, http.StatusUnprocessableEntity, "файл не удалось проверить антивирусом, он помещен в карантин")
+	}
+	return false
+}
+
+// quarantine — файлы, не прошедшие проверку: GET /api/v1/quarantine отдает список,
+// DELETE /api/v1/quarantine/{id} удаляет файл после разбора.
+func (s *Server) quarantine(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, actor, authz.QuarantineManage, authz.Resource{}) {
+		return
+	}
+
+	if id, ok := parseQuarantinePath(r.URL.Path); ok {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		filePath, err := s.repo.DeleteQuarantinedFile(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if strings.TrimSpace(filePath) != "" {
+			_ = os.Remove(filePath)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "файл удален из карантина"})
+		return
+	}
+	if r.URL.Path != "/api/v1/quarantine" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	items, err := s.repo.QuarantinedFiles(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// notifications — уведомления текущего пользователя: GET /api/v1/notifications[?unread=true],
+// POST /api/v1/notifications/{id}/read и POST /api/v1/notifications/read для всех сразу.
+func (s *Server) notifications(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if id, ok := parseNotificationReadPath(r.URL.Path); ok || r.URL.Path == "/api/v1/notifications/read" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := s.repo.MarkNotificationsRead(r.Context(), actor.ID, id); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "уведомления прочитаны"})
+		return
+	}
+	if r.URL.Path != "/api/v1/notifications" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	unreadOnly := strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("unread")), "true")
+	items, err := s.repo.Notifications(r.Context(), actor.ID, unreadOnly)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
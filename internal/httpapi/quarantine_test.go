@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Запись карантина — улика: она остается после удаления загрузившего пользователя.
+func TestQuarantineSurvivesUploaderDeletion(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	uploader := env.addUser("uploader", "Member", 1)
+
+	id, err := env.repo.QuarantineFile(context.Background(), models.QuarantinedFile{
+		Kind: "chat", ScopeType: "department", ScopeID: 1, UploaderID: uploader.ID,
+		FileName: "x.exe", FilePath: "/tmp/q_1.bin", FileType: "application/octet-stream", Threat: "Eicar-Test-Signature",
+	})
+	if err != nil {
+		t.Fatalf("quarantine file: %v", err)
+	}
+
+	expect(t, env.do(http.MethodDelete, fmt.Sprintf("/api/v1/users/%d", uploader.ID), env.session(admin), nil), http.StatusOK)
+
+	items, err := env.repo.QuarantinedFiles(context.Background())
+	if err != nil {
+		t.Fatalf("quarantined files: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != id || items[0].UploaderID != uploader.ID || items[0].Threat != "Eicar-Test-Signature" {
+		t.Fatalf("quarantined files = %+v, want record %d of deleted uploader %d", items, id, uploader.ID)
+	}
+}
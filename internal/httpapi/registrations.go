@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// registrations — очередь заявок: начальник отдела рассматривает заявки своего отдела,
+// Owner/Admin/Deputy Admin — всех отделов.
+func (s *Server) registrations(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	scope, ok := s.requireScope(w, actor, authz.RegistrationReview)
+	if !ok {
+		return
+	}
+	var departmentIDs []int64
+	if scope != authz.ScopeAll {
+		departmentIDs = s.policy().Departments(actor)
+	}
+
+	if userID, action, ok := parseRegistrationPath(r.URL.Path); ok {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		item, err := s.repo.Registration(r.Context(), userID)
+		if err != nil || !s.policy().Allow(actor, authz.RegistrationReview, authz.Resource{DepartmentID: item.DepartmentID}) {
+			writeError(w, http.StatusNotFound, "заявка не найдена")
+			return
+		}
+		if action == "approve" {
+			if err := s.repo.ApproveRegistration(r.Context(), userID, actor.ID); err != nil {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"message": "заявка подтверждена"})
+			return
+		}
+		var input models.ReviewRegistrationInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.TrimSpace(input.Reason) == "" {
+			writeError(w, http.StatusBadRequest, "укажите причину отклонения")
+			return
+		}
+		if err := s.repo.RejectRegistration(r.Context(), userID, actor.ID, input.Reason); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "заявка отклонена"})
+		return
+	}
+	if r.URL.Path != "/api/v1/registrations" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "approved" && status != "rejected" {
+		writeError(w, http.StatusBadRequest, "некорректный статус заявки")
+		return
+	}
+	items, err := s.repo.Registrations(r.Context(), status, departmentIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// roles — справочник ролей и их прав. Список доступен всем (он нужен для выбора роли
+// пользователя), изменять пользовательские роли могут только обладатели role.manage.
+func (s *Server) roles(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if roleID, ok := parseRolePath(r.URL.Path); ok {
+		switch r.Method {
+		case http.MethodGet:
+			item, err := s.repo.Role(r.Context(), roleID)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"item": item})
+		case http.MethodPut:
+			if !s.authorize(w, actor, authz.RoleManage, authz.Resource{}) {
+				return
+			}
+			var input models.RoleInput
+			if err := decodeJSON(r, &input); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if !s.authorizeGrants(w, actor, input.Permissions) {
+				return
+			}
+			if err := s.repo.UpdateRole(r.Context(), roleID, input); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			s.writePolicyChanged(w, r, http.StatusOK, "роль обновлена")
+		case http.MethodDelete:
+			if !s.authorize(w, actor, authz.RoleManage, authz.Resource{}) {
+				return
+			}
+			if err := s.repo.DeleteRole(r.Context(), roleID); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			s.writePolicyChanged(w, r, http.StatusOK, "роль удалена")
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if r.URL.Path != "/api/v1/roles" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.Roles(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// assignable — роли, которые пользователь может назначать; по ним строится выбор роли в интерфейсе.
+		assignable := make([]string, 0, len(items))
+		for _, item := range items {
+			if s.policy().Allow(actor, authz.UserAssignRole, authz.Resource{TargetRole: item.Name}) {
+				assignable = append(assignable, item.Name)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"items":        items,
+			"actions":      authz.Actions(),
+			"scopes":       []string{authz.ScopeAll.String(), authz.ScopeDepartment.String(), authz.ScopeOwn.String()},
+			"assignable":   assignable,
+			"default_role": authz.RoleMember,
+		})
+	case http.MethodPost:
+		if !s.authorize(w, actor, authz.RoleManage, authz.Resource{}) {
+			return
+		}
+		var input models.RoleInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.authorizeGrants(w, actor, input.Permissions) {
+			return
+		}
+		if _, err := s.repo.CreateRole(r.Context(), input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.writePolicyChanged(w, r, http.StatusCreated, "роль создана")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// authorizeGrants отвечает 403, если среди прав роли есть право шире собственного права
+// пользователя. Некорректные права пропускаются: их отклонит проверка в репозитории.
+func (s *Server) authorizeGrants(w http.ResponseWriter, actor models.User, perms []models.RolePermission) bool {
+	for _, perm := range perms {
+		scope, ok := authz.ParseScope(perm.Scope)
+		if !ok || !authz.ValidAction(authz.Action(perm.Action)) || !authz.ValidCondition(perm.Condition) {
+			continue
+		}
+		grant := authz.Grant{Action: authz.Action(perm.Action), Scope: scope, Condition: perm.Condition}
+		if !s.policy().CanGrant(actor, grant) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("нельзя выдать право %s (%s) шире собственного", perm.Action, perm.Scope))
+			return false
+		}
+	}
+	return true
+}
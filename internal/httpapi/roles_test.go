@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func TestRoleGrantsLimitedToActorGrants(t *testing.T) {
+	env := newTestEnv(t)
+	owner := env.addUser("owner1", "Owner", 5)
+	deputy := env.addUser("deputy1", "Deputy Admin", 5)
+	deputyToken := env.session(deputy)
+
+	unconditional := models.RoleInput{Name: "Password Desk", Title: "Сброс паролей", Permissions: []models.RolePermission{
+		{Action: "user.password", Scope: "all"},
+	}}
+	expect(t, env.do(http.MethodPost, "/api/v1/roles", deputyToken, unconditional), http.StatusForbidden)
+
+	twoFactor := models.RoleInput{Name: "2FA Desk", Title: "Сброс 2FA", Permissions: []models.RolePermission{
+		{Action: "user.2fa_reset", Scope: "all", Condition: "target_below_admin"},
+		{Action: "user.list", Scope: "all"},
+	}}
+	expect(t, env.do(http.MethodPost, "/api/v1/roles", deputyToken, twoFactor), http.StatusCreated)
+
+	// Изменение роли проверяется так же, как создание.
+	var roleID int64
+	if err := env.db.QueryRow(`SELECT id FROM roles WHERE name = '2FA Desk'`).Scan(&roleID); err != nil {
+		t.Fatalf("find role: %v", err)
+	}
+	twoFactor.Permissions[0].Condition = ""
+	expect(t, env.do(http.MethodPut, fmt.Sprintf("/api/v1/roles/%d", roleID), deputyToken, twoFactor), http.StatusForbidden)
+
+	expect(t, env.do(http.MethodPost, "/api/v1/roles", env.session(owner), unconditional), http.StatusCreated)
+
+	// Сотрудник с ролью от заместителя не может сбросить 2FA владельца.
+	desk := env.addUser("desk", "2FA Desk", 5)
+	expect(t, env.do(http.MethodDelete, fmt.Sprintf("/api/v1/users/%d/2fa", owner.ID), env.session(desk), nil), http.StatusForbidden)
+}
+
+func TestRoleChangeRevokesSessionsOnlyWhenGrantsShrink(t *testing.T) {
+	env := newTestEnv(t)
+	env.addRole("Reader", models.RolePermission{Action: "task.read", Scope: "own"})
+	env.addRole("Wide Reader",
+		models.RolePermission{Action: "task.read", Scope: "all"},
+		models.RolePermission{Action: "project.read", Scope: "all"},
+		models.RolePermission{Action: "report.read", Scope: "all"},
+		models.RolePermission{Action: "report.create", Scope: "all"},
+		models.RolePermission{Action: "report.delete", Scope: "own"},
+		models.RolePermission{Action: "project.close", Scope: "own"},
+		models.RolePermission{Action: "task.close", Scope: "own"},
+		models.RolePermission{Action: "task.split", Scope: "own"},
+		models.RolePermission{Action: "task.link", Scope: "own"},
+		models.RolePermission{Action: "chat.department", Scope: "department"},
+		models.RolePermission{Action: "chat.task", Scope: "own"},
+		models.RolePermission{Action: "chat.message_delete", Scope: "own"},
+		models.RolePermission{Action: "department.list", Scope: "department"},
+	)
+	ctx := context.Background()
+
+	widened := env.addUser("widened", "Member", 1)
+	token := env.session(widened)
+	if err := env.repo.UpdateUserRole(ctx, widened.ID, "Wide Reader"); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	expect(t, env.do(http.MethodGet, "/api/v1/profile", token, nil), http.StatusOK)
+
+	narrowed := env.addUser("narrowed", "Member", 1)
+	token = env.session(narrowed)
+	if err := env.repo.UpdateUserRole(ctx, narrowed.ID, "Reader"); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	expect(t, env.do(http.MethodGet, "/api/v1/profile", token, nil), http.StatusUnauthorized)
+}
+
+func TestRolesListAssignableRoles(t *testing.T) {
+	env := newTestEnv(t)
+	env.addRole("Reader", models.RolePermission{Action: "task.read", Scope: "own"})
+	tests := []struct {
+		role string
+		want []string
+	}{
+		{"Owner", []string{"Owner", "Admin", "Deputy Admin", "Project Manager", "Member", "Guest", "Reader"}},
+		{"Project Manager", []string{"Member", "Guest"}},
+		{"Member", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			user := env.addUser("u-"+tt.role, tt.role, 1)
+			rec := env.do(http.MethodGet, "/api/v1/roles", env.session(user), nil)
+			expect(t, rec, http.StatusOK)
+			var body struct {
+				Assignable []string `json:"assignable"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !slices.Equal(body.Assignable, tt.want) {
+				t.Errorf("assignable = %v, want %v", body.Assignable, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"errors"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Маршрут СЭД идет по дереву подразделений сверху вниз: от управления через отделы к
+// группам. Этап (route_stage) вычисляется из положения получателя в дереве и нужен
+// для подписи в интерфейсе и условий прав:
+//
+//	1 — руководитель корневого подразделения (начальник УЦС);
+//	2 — другой сотрудник корневого подразделения (заместитель);
+//	3 — руководитель отдела или группы;
+//	4 — сотрудник отдела или группы.
+
+// routeUnit — подразделение, в котором задача окажется у пользователя: то, которым он
+// руководит на пути к отделу задачи, иначе его собственное.
+func routeUnit(org *authz.Org, user models.User, departmentID int64) int64 {
+	var best int64
+	for _, unitID := range org.HeadedBy(user.ID) {
+		if org.Contains(unitID, departmentID) && (best == 0 || org.Depth(unitID) > org.Depth(best)) {
+			best = unitID
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	return user.DepartmentID
+}
+
+func routeStage(org *authz.Org, user models.User, unitID int64) int64 {
+	switch {
+	case unitID == org.Root() && org.Head(unitID) == user.ID:
+		return 1
+	case unitID == org.Root():
+		return 2
+	case org.Head(unitID) == user.ID:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// routeTargetError проверяет, может ли actor передать задачу, находящуюся в подразделении
+// unitID, пользователю target. departmentID — отдел проекта задачи.
+func (s *Server) routeTargetError(actor, target models.User, unitID, departmentID int64) error {
+	org := s.policy().Org()
+	targetUnit := routeUnit(org, target, departmentID)
+	if !org.Contains(unitID, targetUnit) {
+		return errors.New("задачу можно передать только вниз по оргструктуре от подразделения, где она сейчас находится")
+	}
+	if !org.Contains(targetUnit, departmentID) && !org.Contains(departmentID, targetUnit) {
+		return errors.New("получатель должен быть на пути к отделу задачи или в самом отделе")
+	}
+	if !authz.IsSuper(actor.Role) && !containsID(s.policy().Departments(actor), targetUnit) {
+		return errors.New("можно передавать задачу только в пределах своего подразделения")
+	}
+	return nil
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
@@ -3,44 +3,141 @@ package httpapi
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/config"
 	"github.com/mvd/taskflow/internal/repo"
+	"github.com/mvd/taskflow/internal/scan"
 )
 
 type Server struct {
 	repo       *repo.Repository
 	staticPath string
+	sessionTTL time.Duration
 	mux        *http.ServeMux
+
+	twoFactorIssuer        string
+	twoFactorRequiredRoles []string
+
+	oidc            *auth.OIDCProvider
+	oidcLabel       string
+	oidcLinkByLogin bool
+
+	passwordResetTTL time.Duration
+	trashRetention   time.Duration
+
+	trustedProxies []netip.Prefix
+	lockoutPolicy  repo.LockoutPolicy
+
+	hstsMaxAge      time.Duration
+	contentSecurity string
+
+	uploadAvatarTypes []string
+	uploadReportTypes []string
+	uploadChatTypes   []string
+
+	// scanner может быть nil — тогда вложения не проверяются антивирусом.
+	scanner scan.Scanner
+
+	// access заменяется целиком после изменения ролей, обработчики читают его без блокировок.
+	access atomic.Pointer[authz.Policy]
 }
 
-func New(repository *repo.Repository, staticPath string) *Server {
+// oidcProvider может быть nil — тогда вход через SSO отключен, scanner — тогда вложения не проверяются.
+func New(repository *repo.Repository, cfg config.Config, oidcProvider *auth.OIDCProvider, policy *authz.Policy, scanner scan.Scanner) *Server {
 	s := &Server{
 		repo:       repository,
-		staticPath: staticPath,
+		staticPath: cfg.StaticPath,
+		sessionTTL: cfg.SessionTTL,
 		mux:        http.NewServeMux(),
+
+		twoFactorIssuer:        cfg.TwoFactorIssuer,
+		twoFactorRequiredRoles: cfg.TwoFactorRequiredRoles,
+
+		oidc:            oidcProvider,
+		oidcLabel:       cfg.OIDCLabel,
+		oidcLinkByLogin: cfg.OIDCLinkByLogin,
+
+		passwordResetTTL: cfg.PasswordResetTTL,
+		trashRetention:   cfg.TrashRetention,
+
+		trustedProxies: parseTrustedProxies(cfg.TrustedProxies),
+		lockoutPolicy: repo.LockoutPolicy{
+			MaxLoginFailures: cfg.LoginMaxFailures,
+			MaxIPFailures:    cfg.LoginIPMaxFailures,
+			Window:           cfg.LoginFailureWindow,
+			BaseDelay:        cfg.LoginLockoutBaseDelay,
+			MaxDelay:         cfg.LoginLockoutMaxDelay,
+		},
+
+		hstsMaxAge:      cfg.HSTSMaxAge,
+		contentSecurity: cfg.ContentSecurity,
+
+		uploadAvatarTypes: cfg.UploadAvatarTypes,
+		uploadReportTypes: cfg.UploadReportTypes,
+		uploadChatTypes:   cfg.UploadChatTypes,
+
+		scanner: scanner,
 	}
+	s.access.Store(policy)
 	s.routes()
 	return s
 }
 
 func (s *Server) Handler() http.Handler {
-	return loggingMiddleware(s.mux)
+	return loggingMiddleware(s.securityHeaders(s.auditMiddleware(s.mux)))
 }
 
 func (s *Server) routes() {
 	s.mux.HandleFunc("/api/v1/health", s.health)
 	s.mux.HandleFunc("/api/v1/auth/register", s.register)
 	s.mux.HandleFunc("/api/v1/auth/login", s.login)
+	s.mux.HandleFunc("/api/v1/auth/login/2fa", s.loginTwoFactor)
+	s.mux.HandleFunc("/api/v1/auth/providers", s.authProviders)
+	s.mux.HandleFunc("/api/v1/auth/oidc/login", s.oidcLogin)
+	s.mux.HandleFunc("/api/v1/auth/oidc/callback", s.oidcCallback)
+	s.mux.HandleFunc("/api/v1/auth/password-reset", s.passwordReset)
+	s.mux.HandleFunc("/api/v1/auth/logout", s.logout)
+	s.mux.HandleFunc("/api/v1/auth/refresh", s.refreshSession)
 	s.mux.HandleFunc("/api/v1/users", s.users)
+	s.mux.HandleFunc("/api/v1/registrations", s.registrations)
+	s.mux.HandleFunc("/api/v1/registrations/", s.registrations)
 	s.mux.HandleFunc("/api/v1/users/", s.userRole)
 	s.mux.HandleFunc("/api/v1/profile", s.profile)
+	s.mux.HandleFunc("/api/v1/profile/sessions", s.profileSessions)
+	s.mux.HandleFunc("/api/v1/profile/sessions/", s.profileSessions)
+	s.mux.HandleFunc("/api/v1/profile/2fa", s.profileTwoFactor)
+	s.mux.HandleFunc("/api/v1/profile/2fa/", s.profileTwoFactor)
+	s.mux.HandleFunc("/api/v1/profile/tokens", s.profileTokens)
+	s.mux.HandleFunc("/api/v1/profile/tokens/", s.profileTokens)
+	s.mux.HandleFunc("/api/v1/service-accounts", s.serviceAccounts)
+	s.mux.HandleFunc("/api/v1/service-accounts/", s.serviceAccounts)
 	s.mux.HandleFunc("/api/v1/profile/avatar", s.profileAvatar)
 	s.mux.HandleFunc("/api/v1/profile/avatar/", s.profileAvatar)
+	s.mux.HandleFunc("/api/v1/roles", s.roles)
+	s.mux.HandleFunc("/api/v1/roles/", s.roles)
 	s.mux.HandleFunc("/api/v1/departments", s.departments)
+	s.mux.HandleFunc("/api/v1/departments/", s.departments)
+	s.mux.HandleFunc("/api/v1/org-chart", s.orgChart)
+	s.mux.HandleFunc("/api/v1/audit", s.audit)
+	s.mux.HandleFunc("/api/v1/audit/", s.audit)
+	s.mux.HandleFunc("/api/v1/quarantine", s.quarantine)
+	s.mux.HandleFunc("/api/v1/quarantine/", s.quarantine)
+	s.mux.HandleFunc("/api/v1/notifications", s.notifications)
+	s.mux.HandleFunc("/api/v1/notifications/", s.notifications)
+	s.mux.HandleFunc("/api/v1/workflows", s.workflows)
+	s.mux.HandleFunc("/api/v1/workflows/", s.workflows)
+	s.mux.HandleFunc("/api/v1/trash", s.trash)
+	s.mux.HandleFunc("/api/v1/trash/", s.trash)
 	s.mux.HandleFunc("/api/v1/projects", s.projects)
 	s.mux.HandleFunc("/api/v1/projects/", s.projectTasks)
 	s.mux.HandleFunc("/api/v1/tasks", s.tasks)
@@ -62,6 +159,31 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// securityHeaders добавляет к каждому ответу стандартные заголовки защиты браузера. HSTS
+// отправляется только по HTTPS: напрямую или через доверенный прокси.
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		if s.contentSecurity != "" {
+			h.Set("Content-Security-Policy", s.contentSecurity)
+		}
+		if s.hstsMaxAge > 0 && s.isHTTPS(r) {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(s.hstsMaxAge.Seconds())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return s.isTrustedProxy(remoteIP(r)) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
 func decodeJSON(r *http.Request, target any) error {
 	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
 		return errors.New("content-type должен быть application/json")
@@ -118,6 +240,22 @@ func parseProjectClosePath(path string) (int64, bool) {
 	return id, true
 }
 
+func parseProjectWorkflowPath(path string) (int64, bool) {
+	// /api/v1/projects/{id}/workflow
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "projects" || parts[4] != "workflow" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
 func parseProjectEntityID(path string) (int64, bool) {
 	// /api/v1/projects/{id}
 	parts := strings.Split(strings.Trim(path, "/"), "/")
@@ -150,6 +288,285 @@ func parseUserRolePath(path string) (int64, bool) {
 	return id, true
 }
 
+func parseUserSessionsPath(path string) (int64, int64, bool) {
+	// /api/v1/users/{id}/sessions
+	// /api/v1/users/{id}/sessions/{session_id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 && len(parts) != 6 {
+		return 0, 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "users" || parts[4] != "sessions" {
+		return 0, 0, false
+	}
+	userID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(parts) == 5 {
+		return userID, 0, true
+	}
+	sessionID, err := strconv.ParseInt(parts[5], 10, 64)
+	if err != nil || sessionID <= 0 {
+		return 0, 0, false
+	}
+	return userID, sessionID, true
+}
+
+func parseUserTwoFactorPath(path string) (int64, bool) {
+	// /api/v1/users/{id}/2fa
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "users" || parts[4] != "2fa" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseUserPasswordPath(path string) (int64, string, bool) {
+	// /api/v1/users/{id}/password
+	// /api/v1/users/{id}/password-reset
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, "", false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "users" {
+		return 0, "", false
+	}
+	if parts[4] != "password" && parts[4] != "password-reset" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, parts[4], true
+}
+
+func parseRegistrationPath(path string) (int64, string, bool) {
+	// /api/v1/registrations/{id}/approve
+	// /api/v1/registrations/{id}/reject
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, "", false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "registrations" {
+		return 0, "", false
+	}
+	if parts[4] != "approve" && parts[4] != "reject" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "", false
+	}
+	return id, parts[4], true
+}
+
+func parseUserLockoutPath(path string) (int64, bool) {
+	// /api/v1/users/{id}/lockout
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "users" || parts[4] != "lockout" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseLockoutsPath(path string) (int64, bool) {
+	// /api/v1/users/lockouts
+	// /api/v1/users/lockouts/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 && len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "users" || parts[3] != "lockouts" {
+		return 0, false
+	}
+	if len(parts) == 4 {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseProfileTokenPath(path string) (int64, bool) {
+	// /api/v1/profile/tokens/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "profile" || parts[3] != "tokens" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseServiceAccountPath(path string) (int64, bool) {
+	// /api/v1/service-accounts/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "service-accounts" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseRolePath(path string) (int64, bool) {
+	// /api/v1/roles/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "roles" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseWorkflowPath(path string) (int64, bool) {
+	// /api/v1/workflows/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "workflows" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseTrashItemPath(path string) (itemType string, id int64, restore bool, ok bool) {
+	// /api/v1/trash/{type}/{id} или /api/v1/trash/{type}/{id}/restore
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 && len(parts) != 6 {
+		return "", 0, false, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "trash" {
+		return "", 0, false, false
+	}
+	if len(parts) == 6 && parts[5] != "restore" {
+		return "", 0, false, false
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || id <= 0 {
+		return "", 0, false, false
+	}
+	return parts[3], id, len(parts) == 6, true
+}
+
+func parseQuarantinePath(path string) (int64, bool) {
+	// /api/v1/quarantine/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "quarantine" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseNotificationReadPath(path string) (int64, bool) {
+	// /api/v1/notifications/{id}/read
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "notifications" || parts[4] != "read" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseDepartmentPath(path string) (int64, string, int64, bool) {
+	// /api/v1/departments/{id}
+	// /api/v1/departments/{id}/merge
+	// /api/v1/departments/{id}/positions
+	// /api/v1/departments/{id}/positions/{position_id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || len(parts) > 6 {
+		return 0, "", 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "departments" {
+		return 0, "", 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "", 0, false
+	}
+	switch {
+	case len(parts) == 4:
+		return id, "", 0, true
+	case len(parts) == 5 && (parts[4] == "merge" || parts[4] == "positions"):
+		return id, parts[4], 0, true
+	case len(parts) == 6 && parts[4] == "positions":
+		positionID, err := strconv.ParseInt(parts[5], 10, 64)
+		if err != nil || positionID <= 0 {
+			return 0, "", 0, false
+		}
+		return id, parts[4], positionID, true
+	default:
+		return 0, "", 0, false
+	}
+}
+
+func parseProfileSessionPath(path string) (int64, bool) {
+	// /api/v1/profile/sessions/{id}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "profile" || parts[3] != "sessions" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
 func parseUserEntityPath(path string) (int64, bool) {
 	// /api/v1/users/{id}
 	parts := strings.Split(strings.Trim(path, "/"), "/")
@@ -166,6 +583,18 @@ func parseUserEntityPath(path string) (int64, bool) {
 	return id, true
 }
 
+func parseTaskKeyPath(path string) (string, bool) {
+	// /api/v1/tasks/key/{key}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return "", false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "tasks" || parts[3] != "key" || parts[4] == "" {
+		return "", false
+	}
+	return parts[4], true
+}
+
 func parseTaskEntityPath(path string) (int64, bool) {
 	// /api/v1/tasks/{id}
 	parts := strings.Split(strings.Trim(path, "/"), "/")
@@ -214,6 +643,74 @@ func parseTaskRoutePath(path string) (int64, bool) {
 	return id, true
 }
 
+func parseTaskHistoryPath(path string) (int64, bool) {
+	// /api/v1/tasks/{id}/history
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "tasks" || parts[4] != "history" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseTaskSubtasksPath(path string) (int64, bool) {
+	// /api/v1/tasks/{id}/subtasks
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "tasks" || parts[4] != "subtasks" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseTaskLinksPath(path string) (int64, bool) {
+	// /api/v1/tasks/{id}/links
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 {
+		return 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "tasks" || parts[4] != "links" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func parseTaskLinkPath(path string) (int64, int64, bool) {
+	// /api/v1/tasks/{id}/links/{linkID}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 6 {
+		return 0, 0, false
+	}
+	if parts[0] != "api" || parts[1] != "v1" || parts[2] != "tasks" || parts[4] != "links" {
+		return 0, 0, false
+	}
+	taskID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	linkID, err := strconv.ParseInt(parts[5], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return taskID, linkID, true
+}
+
 func parseReportFilePath(path string) (int64, bool) {
 	// /api/v1/reports/{id}/file
 	parts := strings.Split(strings.Trim(path, "/"), "/")
@@ -277,3 +774,37 @@ func parseProfileAvatarPath(path string) (int64, bool) {
 	}
 	return id, true
 }
+
+const sessionCookieName = "taskflow_session"
+
+func sessionTokenFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(cookie.Value)
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   s.isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/config"
+	"github.com/mvd/taskflow/internal/db"
+	"github.com/mvd/taskflow/internal/filecrypt"
+	"github.com/mvd/taskflow/internal/models"
+	"github.com/mvd/taskflow/internal/repo"
+)
+
+// testEnv — сервер на временной базе с начальными данными; файлы пишутся во временный каталог.
+type testEnv struct {
+	t       *testing.T
+	db      *sql.DB
+	repo    *repo.Repository
+	server  *Server
+	handler http.Handler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	dir := t.TempDir()
+	passwords := auth.NewPasswordHasher("test-pepper", auth.Argon2Params{Memory: 1024, Time: 1, Threads: 1})
+	sqlDB, err := db.Open(filepath.Join(dir, "data", "taskflow.db"), passwords)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	files, err := filecrypt.NewKeyring(bytes.Repeat([]byte{7}, filecrypt.KeySize))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	repository := repo.New(sqlDB, passwords, auth.PasswordPolicy{}, nil, files)
+	policy, err := repository.AccessPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("access policy: %v", err)
+	}
+	cfg := config.Config{
+		StaticPath:        filepath.Join(dir, "web"),
+		SessionTTL:        time.Hour,
+		UploadReportTypes: []string{"text/plain"},
+		UploadChatTypes:   []string{"text/plain"},
+	}
+	server := New(repository, cfg, nil, policy, nil)
+	return &testEnv{t: t, db: sqlDB, repo: repository, server: server, handler: server.Handler()}
+}
+
+// exec выполняет SQL напрямую, минуя API: для подготовки данных, которые нельзя создать запросом.
+func (e *testEnv) exec(query string, args ...any) int64 {
+	e.t.Helper()
+	res, err := e.db.Exec(query, args...)
+	if err != nil {
+		e.t.Fatalf("exec %q: %v", query, err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// addUser создает активного пользователя без обязательной смены пароля.
+func (e *testEnv) addUser(login, role string, departmentID int64) models.User {
+	e.t.Helper()
+	hash, err := e.repo.PasswordHash("Passw0rd!test")
+	if err != nil {
+		e.t.Fatalf("hash password: %v", err)
+	}
+	id := e.exec(`INSERT INTO users (login, password_hash, full_name, position, role, department_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		login, hash, login, "Сотрудник", role, departmentID)
+	return models.User{ID: id, Login: login, Role: role, DepartmentID: departmentID}
+}
+
+// addRole создает пользовательскую роль и перечитывает политику сервера.
+func (e *testEnv) addRole(name string, perms ...models.RolePermission) {
+	e.t.Helper()
+	if _, err := e.repo.CreateRole(context.Background(), models.RoleInput{Name: name, Title: name, Permissions: perms}); err != nil {
+		e.t.Fatalf("create role %s: %v", name, err)
+	}
+	if err := e.server.reloadPolicy(context.Background()); err != nil {
+		e.t.Fatalf("reload policy: %v", err)
+	}
+}
+
+// session открывает сессию пользователя и возвращает ее токен.
+func (e *testEnv) session(user models.User) string {
+	e.t.Helper()
+	token, _, err := e.repo.CreateSession(context.Background(), user.ID, time.Hour, "127.0.0.1", "test", "test")
+	if err != nil {
+		e.t.Fatalf("create session: %v", err)
+	}
+	return token
+}
+
+// do отправляет запрос от имени сессии token; body кодируется в JSON, если это не []byte.
+func (e *testEnv) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch v := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(v)
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			e.t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+		contentType = "application/json"
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.RemoteAddr = "127.0.0.1:40000"
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	}
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect проверяет код ответа и выводит тело при расхождении.
+func expect(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+}
@@ -0,0 +1,225 @@
+package httpapi
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userID int64) (time.Time, error) {
+	userAgent := strings.TrimSpace(r.UserAgent())
+	token, expiresAt, err := s.repo.CreateSession(r.Context(), userID, s.sessionTTL, s.clientIP(r), userAgent, describeDevice(userAgent))
+	if err != nil {
+		return time.Time{}, err
+	}
+	s.setSessionCookie(w, r, token, expiresAt)
+	return expiresAt, nil
+}
+
+func (s *Server) profileSessions(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	currentID, err := s.repo.SessionIDByToken(r.Context(), sessionTokenFromRequest(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if sessionID, ok := parseProfileSessionPath(r.URL.Path); ok {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := s.repo.RevokeUserSession(r.Context(), actor.ID, sessionID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if sessionID == currentID {
+			s.clearSessionCookie(w, r)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "сессия завершена"})
+		return
+	}
+	if r.URL.Path != "/api/v1/profile/sessions" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.UserSessions(r.Context(), actor.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for i := range items {
+			items[i].Current = items[i].ID == currentID
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodDelete:
+		// Текущая сессия остается: для выхода из нее есть /api/v1/auth/logout.
+		revoked, err := s.repo.RevokeUserSessions(r.Context(), actor.ID, currentID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"message": "остальные сессии завершены", "revoked": revoked})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) userSessions(w http.ResponseWriter, r *http.Request, actor models.User, userID, sessionID int64) {
+	if _, ok := s.requireScope(w, actor, authz.UserSessions); !ok {
+		return
+	}
+	target, err := s.repo.UserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.UserSessions, userResource(target)) {
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && sessionID == 0:
+		items, err := s.repo.UserSessions(r.Context(), target.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case r.Method == http.MethodDelete && sessionID == 0:
+		revoked, err := s.repo.RevokeUserSessions(r.Context(), target.ID, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"message": "все сессии пользователя завершены", "revoked": revoked})
+	case r.Method == http.MethodDelete:
+		if err := s.repo.RevokeUserSession(r.Context(), target.ID, sessionID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "сессия завершена"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// clientIP учитывает X-Forwarded-For только если запрос пришел от доверенного прокси.
+// Цепочка разбирается справа налево: левые элементы мог подставить сам клиент.
+func (s *Server) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !s.isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			return ip
+		}
+		ip = hop
+		if !s.isTrustedProxy(hop) {
+			return hop
+		}
+	}
+	return ip
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies принимает как подсети (10.0.0.0/8), так и отдельные адреса.
+func parseTrustedProxies(items []string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			result = append(result, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			log.Printf("trusted proxies: skip invalid entry %q", item)
+			continue
+		}
+		addr = addr.Unmap()
+		result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return result
+}
+
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "Неизвестное устройство"
+	}
+
+	browser := "Другой клиент"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "yabrowser"):
+		browser = "Яндекс Браузер"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "chromium/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + ", " + platform
+}
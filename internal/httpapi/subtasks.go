@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// subtasks — подзадачи: GET /api/v1/tasks/{id}/subtasks отдает прямые подзадачи со сводкой
+// по их собственным подзадачам, POST создает подзадачу. Подзадача наследует проект (а с ним
+// отдел) и положение на маршруте СЭД; пустые тип, приоритет и кураторы берутся у родителя,
+// без статуса подзадача получает начальный статус процесса проекта.
+func (s *Server) subtasks(w http.ResponseWriter, r *http.Request, parentID int64) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.taskResource(r.Context(), actor, parentID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorize(w, actor, authz.TaskRead, res) {
+			return
+		}
+		items, err := s.repo.Subtasks(r.Context(), parentID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		if !s.authorize(w, actor, authz.TaskSplit, res) {
+			return
+		}
+		parent, err := s.repo.TaskByID(r.Context(), parentID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		var input models.CreateTaskInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		input.ProjectID = parent.ProjectID
+		input.ParentTaskID = parent.ID
+		if strings.TrimSpace(input.Type) == "" {
+			input.Type = parent.Type
+		}
+		if strings.TrimSpace(input.Priority) == "" {
+			input.Priority = parent.Priority
+		}
+		if len(input.CuratorIDs) == 0 {
+			for _, u := range parent.Curators {
+				input.CuratorIDs = append(input.CuratorIDs, u.ID)
+			}
+		}
+		if strings.TrimSpace(input.Title) == "" || len(input.CuratorIDs) < 1 || len(input.CuratorIDs) > 5 || len(input.AssigneeIDs) < 1 || len(input.AssigneeIDs) > 5 {
+			writeError(w, http.StatusBadRequest, "заполните обязательные поля")
+			return
+		}
+		allIDs := uniqueInt64(append(append([]int64{}, input.CuratorIDs...), input.AssigneeIDs...))
+		teamInDepartment, err := s.repo.UserIDsBelongToDepartment(r.Context(), allIDs, parent.DepartmentID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !teamInDepartment {
+			writeError(w, http.StatusBadRequest, "кураторы и исполнители должны быть из отдела проекта")
+			return
+		}
+		input.RouteStage = parent.RouteStage
+		input.RouteOwnerID = parent.RouteOwnerID
+		input.RouteUnitID = parent.RouteUnitID
+		input.ActorRole = actor.Role
+		if err := s.repo.CreateTask(r.Context(), input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"message": "подзадача создана"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,274 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const (
+	scopeTasksRead     = "tasks:read"
+	scopeTasksWrite    = "tasks:write"
+	scopeReportsWrite  = "reports:write"
+	scopeMessagesWrite = "messages:write"
+)
+
+var apiTokenScopes = []string{scopeTasksRead, scopeTasksWrite, scopeReportsWrite, scopeMessagesWrite}
+
+// requiredTokenScope сопоставляет запрос области действия токена. Маршруты без
+// сопоставления (профиль, пользователи, сессии, сами токены) по токену недоступны.
+func requiredTokenScope(method, path string) (string, bool) {
+	read := method == http.MethodGet || method == http.MethodHead
+	switch {
+	case path == "/api/v1/departments" || strings.HasPrefix(path, "/api/v1/departments/"):
+		return "", read
+	case path == "/api/v1/projects" || strings.HasPrefix(path, "/api/v1/projects/") ||
+		path == "/api/v1/tasks" || strings.HasPrefix(path, "/api/v1/tasks/"):
+		if read {
+			return scopeTasksRead, true
+		}
+		return scopeTasksWrite, true
+	case path == "/api/v1/reports" || strings.HasPrefix(path, "/api/v1/reports/"):
+		if read {
+			return scopeTasksRead, true
+		}
+		return scopeReportsWrite, true
+	case strings.HasPrefix(path, "/api/v1/messages/"):
+		return scopeMessagesWrite, true
+	default:
+		return "", false
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	if scope == "" {
+		return true
+	}
+	for _, item := range scopes {
+		if item == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerTokenFromRequest(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+func (s *Server) actorFromBearer(w http.ResponseWriter, r *http.Request, token string) (models.User, bool) {
+	scope, allowed := requiredTokenScope(r.Method, r.URL.Path)
+	if !allowed {
+		writeError(w, http.StatusForbidden, "этот метод API недоступен по токену доступа")
+		return models.User{}, false
+	}
+	actor, scopes, err := s.repo.UserByAPIToken(r.Context(), token, s.clientIP(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return models.User{}, false
+	}
+	if actor.MustChangePassword {
+		writeError(w, http.StatusForbidden, errPasswordChangeRequired)
+		return models.User{}, false
+	}
+	if !hasScope(scopes, scope) {
+		writeError(w, http.StatusForbidden, "у токена нет области действия "+scope)
+		return models.User{}, false
+	}
+	return actor, true
+}
+
+func (s *Server) profileTokens(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	manageService := s.policy().Allow(actor, authz.ServiceAccounts, authz.Resource{})
+
+	if tokenID, ok := parseProfileTokenPath(r.URL.Path); ok {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		item, err := s.repo.APIToken(r.Context(), tokenID)
+		if err != nil || !(item.UserID == actor.ID || (item.ServiceAccount && manageService)) {
+			writeError(w, http.StatusNotFound, "токен не найден")
+			return
+		}
+		if err := s.repo.RevokeAPIToken(r.Context(), tokenID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "токен отозван"})
+		return
+	}
+	if r.URL.Path != "/api/v1/profile/tokens" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.APITokens(r.Context(), actor.ID, manageService)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items, "scopes": apiTokenScopes})
+	case http.MethodPost:
+		var input models.CreateAPITokenInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.TrimSpace(input.Name) == "" {
+			writeError(w, http.StatusBadRequest, "укажите название токена")
+			return
+		}
+		if len(input.Scopes) == 0 {
+			writeError(w, http.StatusBadRequest, "выберите хотя бы одну область действия")
+			return
+		}
+		for _, scope := range input.Scopes {
+			if !hasScope(apiTokenScopes, scope) {
+				writeError(w, http.StatusBadRequest, "неизвестная область действия: "+scope)
+				return
+			}
+		}
+		var expiresAt *time.Time
+		if strings.TrimSpace(input.ExpiresAt) != "" {
+			parsed, err := parseTokenExpiry(input.ExpiresAt)
+			if err != nil || !parsed.After(time.Now()) {
+				writeError(w, http.StatusBadRequest, "срок действия должен быть датой в будущем (YYYY-MM-DD)")
+				return
+			}
+			expiresAt = &parsed
+		}
+
+		ownerID := actor.ID
+		if input.ServiceAccountID > 0 {
+			if !manageService {
+				writeError(w, http.StatusForbidden, "недостаточно прав")
+				return
+			}
+			service, err := s.repo.IsServiceAccount(r.Context(), input.ServiceAccountID)
+			if err != nil || !service {
+				writeError(w, http.StatusBadRequest, "сервисная учетная запись не найдена")
+				return
+			}
+			ownerID = input.ServiceAccountID
+		}
+
+		item, token, err := s.repo.CreateAPIToken(r.Context(), ownerID, input.Name, input.Scopes, expiresAt)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"message": "токен создан, сохраните его — повторно он не показывается",
+			"token":   token,
+			"item":    item,
+		})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func parseTokenExpiry(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	// Дата без времени действует до конца дня.
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(24*time.Hour - time.Second), nil
+}
+
+func (s *Server) serviceAccounts(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, actor, authz.ServiceAccounts, authz.Resource{}) {
+		return
+	}
+
+	if userID, ok := parseServiceAccountPath(r.URL.Path); ok {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		service, err := s.repo.IsServiceAccount(r.Context(), userID)
+		if err != nil || !service {
+			writeError(w, http.StatusNotFound, "сервисная учетная запись не найдена")
+			return
+		}
+		if _, err := s.repo.DeleteUser(r.Context(), userID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "сервисная учетная запись удалена"})
+		return
+	}
+	if r.URL.Path != "/api/v1/service-accounts" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.ServiceAccounts(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		var input models.CreateServiceAccountInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.TrimSpace(input.Login) == "" || strings.TrimSpace(input.FullName) == "" || input.DepartmentID <= 0 {
+			writeError(w, http.StatusBadRequest, "заполните логин, название и отдел")
+			return
+		}
+		if strings.TrimSpace(input.Role) == "" {
+			input.Role = authz.RoleMember
+		}
+		if !s.policy().HasRole(input.Role) || strings.EqualFold(input.Role, authz.RoleOwner) {
+			writeError(w, http.StatusBadRequest, "некорректная роль сервисной учетной записи")
+			return
+		}
+		if !s.authorize(w, actor, authz.ServiceAssignRole, authz.Resource{TargetRole: input.Role}) {
+			return
+		}
+		exists, err := s.repo.DepartmentExists(r.Context(), input.DepartmentID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			writeError(w, http.StatusBadRequest, "некорректный отдел/подразделение")
+			return
+		}
+		user, err := s.repo.CreateServiceAccount(r.Context(), input)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"message": "сервисная учетная запись создана", "user": user})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
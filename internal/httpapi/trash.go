@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/mvd/taskflow/internal/authz"
+)
+
+// trash — корзина: GET /api/v1/trash отдает удаленные задачи, проекты и отчеты,
+// POST /api/v1/trash/{type}/{id}/restore восстанавливает элемент, DELETE /api/v1/trash/{type}/{id}
+// удаляет его окончательно вместе с файлами.
+func (s *Server) trash(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, actor, authz.TrashManage, authz.Resource{}) {
+		return
+	}
+
+	if r.URL.Path == "/api/v1/trash" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		items, err := s.repo.TrashItems(r.Context(), s.trashRetention)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items, "retention": s.trashRetention.String()})
+		return
+	}
+
+	itemType, itemID, restore, ok := parseTrashItemPath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch {
+	case restore && r.Method == http.MethodPost:
+		if err := s.repo.RestoreTrashItem(r.Context(), itemType, itemID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "восстановлено из корзины"})
+	case !restore && r.Method == http.MethodDelete:
+		files, err := s.repo.PurgeTrashItem(r.Context(), itemType, itemID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, path := range files {
+			_ = os.Remove(path)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "удалено окончательно"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
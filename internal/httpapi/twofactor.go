@@ -0,0 +1,180 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+func (s *Server) twoFactorRequired(role string) bool {
+	for _, item := range s.twoFactorRequiredRoles {
+		if strings.EqualFold(item, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Пока обязательная 2FA не настроена, доступны только профиль и его настройки безопасности.
+func isTwoFactorSetupPath(path string) bool {
+	return path == "/api/v1/profile" ||
+		path == "/api/v1/profile/2fa" || strings.HasPrefix(path, "/api/v1/profile/2fa/") ||
+		path == "/api/v1/profile/sessions" || strings.HasPrefix(path, "/api/v1/profile/sessions/")
+}
+
+func (s *Server) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var input models.TwoFactorLoginInput
+	if err := decodeJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(input.Challenge) == "" {
+		writeError(w, http.StatusBadRequest, "challenge обязателен")
+		return
+	}
+	if strings.TrimSpace(input.Code) == "" && strings.TrimSpace(input.RecoveryCode) == "" {
+		writeError(w, http.StatusBadRequest, "введите код из приложения или код восстановления")
+		return
+	}
+
+	user, err := s.repo.UserByLoginChallenge(r.Context(), input.Challenge)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if s.rejectLockedLogin(w, r, user.Login) {
+		return
+	}
+	if err := s.repo.VerifySecondFactor(r.Context(), user.ID, input.Code, input.RecoveryCode); err != nil {
+		s.loginFailed(w, r, user.Login, err)
+		return
+	}
+	if err := s.repo.DeleteLoginChallenge(r.Context(), input.Challenge); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status, err := s.repo.TwoFactorStatus(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.completeLogin(w, r, user, status)
+}
+
+func (s *Server) profileTwoFactor(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/api/v1/profile/2fa" && r.Method == http.MethodGet:
+		status, err := s.repo.TwoFactorStatus(r.Context(), actor.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		status.Required = s.twoFactorRequired(actor.Role)
+		writeJSON(w, http.StatusOK, status)
+	case r.URL.Path == "/api/v1/profile/2fa/setup" && r.Method == http.MethodPost:
+		secret, err := s.repo.StartTwoFactorSetup(r.Context(), actor.ID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, models.TwoFactorSetup{
+			Secret:          secret,
+			ProvisioningURI: auth.TOTPProvisioningURI(s.twoFactorIssuer, actor.Login, secret),
+		})
+	case r.URL.Path == "/api/v1/profile/2fa/enable" && r.Method == http.MethodPost:
+		var input models.TwoFactorCodeInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		codes, err := s.repo.EnableTwoFactor(r.Context(), actor.ID, input.Code)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"message":        "двухфакторная аутентификация включена",
+			"recovery_codes": codes,
+		})
+	case r.URL.Path == "/api/v1/profile/2fa/disable" && r.Method == http.MethodPost:
+		if s.twoFactorRequired(actor.Role) {
+			writeError(w, http.StatusForbidden, "для вашей роли двухфакторная аутентификация обязательна")
+			return
+		}
+		var input models.TwoFactorCodeInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.VerifySecondFactor(r.Context(), actor.ID, input.Code, ""); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.DisableTwoFactor(r.Context(), actor.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "двухфакторная аутентификация отключена"})
+	case r.URL.Path == "/api/v1/profile/2fa/recovery-codes" && r.Method == http.MethodPost:
+		var input models.TwoFactorCodeInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.repo.VerifySecondFactor(r.Context(), actor.ID, input.Code, ""); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		codes, err := s.repo.RegenerateRecoveryCodes(r.Context(), actor.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"message":        "коды восстановления обновлены",
+			"recovery_codes": codes,
+		})
+	case r.URL.Path == "/api/v1/profile/2fa" || r.URL.Path == "/api/v1/profile/2fa/setup" ||
+		r.URL.Path == "/api/v1/profile/2fa/enable" || r.URL.Path == "/api/v1/profile/2fa/disable" ||
+		r.URL.Path == "/api/v1/profile/2fa/recovery-codes":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) resetUserTwoFactor(w http.ResponseWriter, r *http.Request, actor models.User, userID int64) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, ok := s.requireScope(w, actor, authz.UserTwoFactorReset); !ok {
+		return
+	}
+	target, err := s.repo.UserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !s.authorize(w, actor, authz.UserTwoFactorReset, userResource(target)) {
+		return
+	}
+	if err := s.repo.ResetTwoFactor(r.Context(), target.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "двухфакторная аутентификация пользователя сброшена"})
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// workflows — процессы задач: GET /api/v1/workflows и /api/v1/workflows/{id} доступны всем,
+// создание, правка и удаление — по праву workflow.manage.
+func (s *Server) workflows(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if workflowID, ok := parseWorkflowPath(r.URL.Path); ok {
+		switch r.Method {
+		case http.MethodGet:
+			item, err := s.repo.Workflow(r.Context(), workflowID)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"item": item})
+		case http.MethodPut:
+			if !s.authorize(w, actor, authz.WorkflowManage, authz.Resource{}) {
+				return
+			}
+			var input models.WorkflowInput
+			if err := decodeJSON(r, &input); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := s.repo.UpdateWorkflow(r.Context(), workflowID, input); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"message": "процесс обновлен"})
+		case http.MethodDelete:
+			if !s.authorize(w, actor, authz.WorkflowManage, authz.Resource{}) {
+				return
+			}
+			if err := s.repo.DeleteWorkflow(r.Context(), workflowID); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"message": "процесс удален"})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if r.URL.Path != "/api/v1/workflows" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.repo.Workflows(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		if !s.authorize(w, actor, authz.WorkflowManage, authz.Resource{}) {
+			return
+		}
+		var input models.WorkflowInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id, err := s.repo.CreateWorkflow(r.Context(), input)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"message": "процесс создан", "id": id})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// projectWorkflow — процесс проекта: GET /api/v1/projects/{id}/workflow для тех, кто видит
+// проект, PUT с {"workflow_id"} назначает другой процесс.
+func (s *Server) projectWorkflow(w http.ResponseWriter, r *http.Request, projectID int64) {
+	actor, ok := s.actorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.projectResource(r.Context(), actor, projectID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorize(w, actor, authz.ProjectRead, res) {
+			return
+		}
+		item, err := s.repo.ProjectWorkflow(r.Context(), projectID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"item": item})
+	case http.MethodPut:
+		if !s.authorize(w, actor, authz.WorkflowManage, res) {
+			return
+		}
+		var input models.ProjectWorkflowInput
+		if err := decodeJSON(r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.WorkflowID <= 0 {
+			writeError(w, http.StatusBadRequest, "укажите процесс")
+			return
+		}
+		if err := s.repo.SetProjectWorkflow(r.Context(), projectID, input.WorkflowID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "процесс проекта изменен"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,180 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// workflowProject создает проект отдела 1 с процессом заявок: New → Triage → Resolved/Rejected,
+// из New можно сразу отклонить. Возвращает id проекта.
+func (e *testEnv) workflowProject(admin models.User, key string, team ...int64) int64 {
+	e.t.Helper()
+	workflowID, err := e.repo.CreateWorkflow(context.Background(), models.WorkflowInput{
+		Name: "Заявки " + key,
+		Statuses: []models.WorkflowStatus{
+			{Name: "New", Title: "Новая", Initial: true},
+			{Name: "Triage", Title: "Разбор"},
+			{Name: "Resolved", Title: "Решена", Terminal: true},
+			{Name: "Rejected", Title: "Отклонена", Terminal: true},
+		},
+		Transitions: []models.WorkflowTransition{
+			{From: "New", To: "Triage"},
+			{From: "New", To: "Rejected"},
+			{From: "Triage", To: "Resolved"},
+			{From: "Triage", To: "Rejected"},
+		},
+	})
+	if err != nil {
+		e.t.Fatalf("create workflow: %v", err)
+	}
+	project := map[string]any{"key": key, "name": key, "department_id": 1, "curator_ids": team[:1], "assignee_ids": team[1:]}
+	expect(e.t, e.do(http.MethodPost, "/api/v1/projects", e.session(admin), project), http.StatusCreated)
+	var projectID int64
+	if err := e.db.QueryRow(`SELECT id FROM projects WHERE key = ?`, key).Scan(&projectID); err != nil {
+		e.t.Fatalf("find project: %v", err)
+	}
+	if err := e.repo.SetProjectWorkflow(context.Background(), projectID, workflowID); err != nil {
+		e.t.Fatalf("set project workflow: %v", err)
+	}
+	return projectID
+}
+
+func TestCreateTaskStatusFollowsWorkflow(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	pm := env.addUser("pm1", "Project Manager", 1)
+	member := env.addUser("member1", "Member", 1)
+	projectID := env.workflowProject(admin, "WF", pm.ID, member.ID)
+
+	tests := []struct {
+		status string
+		want   int
+	}{
+		{"", http.StatusCreated},
+		{"New", http.StatusCreated},
+		{"Triage", http.StatusCreated},
+		{"Resolved", http.StatusBadRequest}, // нет перехода New → Resolved
+		{"Rejected", http.StatusBadRequest}, // переход есть, но статус конечный
+		{"Done", http.StatusBadRequest},     // статуса нет в процессе
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("status %q", tt.status), func(t *testing.T) {
+			task := map[string]any{"title": fmt.Sprintf("Заявка %d", i), "type": "Задача", "priority": "Средний", "status": tt.status,
+				"project_id": projectID, "curator_ids": []int64{pm.ID}, "assignee_ids": []int64{member.ID}}
+			expect(t, env.do(http.MethodPost, "/api/v1/tasks", env.session(admin), task), tt.want)
+		})
+	}
+	var statuses []string
+	rows, err := env.db.Query(`SELECT status FROM tasks WHERE project_id = ? ORDER BY id`, projectID)
+	if err != nil {
+		t.Fatalf("query tasks: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			t.Fatalf("scan task: %v", err)
+		}
+		statuses = append(statuses, status)
+	}
+	if fmt.Sprint(statuses) != "[New New Triage]" {
+		t.Fatalf("created statuses = %v, want [New New Triage]", statuses)
+	}
+}
+
+// workflowTask создает задачу проекта projectID и возвращает ее id.
+func (e *testEnv) workflowTask(actor models.User, projectID int64, title string, team ...int64) int64 {
+	e.t.Helper()
+	task := map[string]any{"title": title, "type": "Задача", "priority": "Средний",
+		"project_id": projectID, "curator_ids": team[:1], "assignee_ids": team[1:]}
+	expect(e.t, e.do(http.MethodPost, "/api/v1/tasks", e.session(actor), task), http.StatusCreated)
+	var taskID int64
+	if err := e.db.QueryRow(`SELECT id FROM tasks WHERE project_id = ? AND title = ?`, projectID, title).Scan(&taskID); err != nil {
+		e.t.Fatalf("find task %s: %v", title, err)
+	}
+	return taskID
+}
+
+func TestSubtaskRollupUsesProjectWorkflow(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	pm := env.addUser("pm1", "Project Manager", 1)
+	member := env.addUser("member1", "Member", 1)
+	projectID := env.workflowProject(admin, "RU", pm.ID, member.ID)
+	parentID := env.workflowTask(admin, projectID, "Родитель", pm.ID, member.ID)
+	var subtaskIDs []int64
+	for _, title := range []string{"Первая", "Вторая"} {
+		body := map[string]any{"title": title, "assignee_ids": []int64{member.ID}}
+		expect(t, env.do(http.MethodPost, fmt.Sprintf("/api/v1/tasks/%d/subtasks", parentID), env.session(admin), body), http.StatusCreated)
+		var id int64
+		if err := env.db.QueryRow(`SELECT id FROM tasks WHERE parent_task_id = ? AND title = ?`, parentID, title).Scan(&id); err != nil {
+			t.Fatalf("find subtask: %v", err)
+		}
+		subtaskIDs = append(subtaskIDs, id)
+	}
+
+	rollup := func() models.TaskRollup {
+		t.Helper()
+		task, err := env.repo.TaskByID(context.Background(), parentID)
+		if err != nil {
+			t.Fatalf("task: %v", err)
+		}
+		if task.Subtasks == nil {
+			t.Fatal("task has no subtask rollup")
+		}
+		return *task.Subtasks
+	}
+	if got := rollup(); got.Status != "New" || got.Total != 2 {
+		t.Fatalf("rollup = %+v, want 2 subtasks in New", got)
+	}
+	env.exec(`UPDATE tasks SET status = 'Resolved' WHERE id = ?`, subtaskIDs[0])
+	if got := rollup(); got.Status != "Triage" || got.Done != 1 || got.Progress != 50 {
+		t.Fatalf("rollup = %+v, want Triage with 1 of 2 done", got)
+	}
+	env.exec(`UPDATE tasks SET status = 'Rejected' WHERE id = ?`, subtaskIDs[1])
+	if got := rollup(); got.Status != "Resolved" || got.Done != 2 {
+		t.Fatalf("rollup = %+v, want Resolved with all done", got)
+	}
+}
+
+// Принудительное закрытие проверяет переход каждой подзадачи по ее процессу.
+func TestForceCloseChecksSubtaskTransitions(t *testing.T) {
+	env := newTestEnv(t)
+	admin := env.addUser("admin1", "Admin", 5)
+	pm := env.addUser("pm1", "Project Manager", 1)
+	member := env.addUser("member1", "Member", 1)
+	projectID := env.workflowProject(admin, "FC", pm.ID, member.ID)
+	parentID := env.workflowTask(admin, projectID, "Родитель", pm.ID, member.ID)
+	body := map[string]any{"title": "Подзадача", "assignee_ids": []int64{member.ID}}
+	expect(t, env.do(http.MethodPost, fmt.Sprintf("/api/v1/tasks/%d/subtasks", parentID), env.session(admin), body), http.StatusCreated)
+	var subtaskID int64
+	if err := env.db.QueryRow(`SELECT id FROM tasks WHERE parent_task_id = ?`, parentID).Scan(&subtaskID); err != nil {
+		t.Fatalf("find subtask: %v", err)
+	}
+	env.exec(`UPDATE tasks SET status = 'Triage' WHERE id = ?`, parentID)
+
+	closePath := fmt.Sprintf("/api/v1/tasks/%d/close?force=true", parentID)
+	// Из New в Resolved процесс не пускает — ни саму подзадачу, ни вместе с родителем.
+	rec := env.do(http.MethodPatch, closePath, env.session(admin), nil)
+	expect(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "нельзя закрыть") {
+		t.Fatalf("close error = %s, want subtask transition error", rec.Body.String())
+	}
+	var status string
+	if err := env.db.QueryRow(`SELECT status FROM tasks WHERE id = ?`, parentID).Scan(&status); err != nil || status != "Triage" {
+		t.Fatalf("parent status = %q, %v; want Triage after rejected close", status, err)
+	}
+
+	env.exec(`UPDATE tasks SET status = 'Triage' WHERE id = ?`, subtaskID)
+	expect(t, env.do(http.MethodPatch, closePath, env.session(admin), nil), http.StatusOK)
+	for _, id := range []int64{parentID, subtaskID} {
+		if err := env.db.QueryRow(`SELECT status FROM tasks WHERE id = ?`, id).Scan(&status); err != nil || status != "Resolved" {
+			t.Fatalf("task %d status = %q, %v; want Resolved", id, status, err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type User struct {
 	ID             int64  `json:"id"`
 	Login          string `json:"login"`
@@ -10,44 +12,78 @@ type User struct {
 	DepartmentName string `json:"department_name"`
 	AvatarPath     string `json:"-"`
 	AvatarURL      string `json:"avatar_url,omitempty"`
+
+	MustChangePassword bool `json:"must_change_password,omitempty"`
 }
 
 type Project struct {
-	ID            int64  `json:"id"`
-	Key           string `json:"key"`
-	Name          string `json:"name"`
-	Status        string `json:"status"`
-	DepartmentID  int64  `json:"department_id"`
+	ID             int64  `json:"id"`
+	Key            string `json:"key"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	DepartmentID   int64  `json:"department_id"`
 	DepartmentName string `json:"department_name"`
-	CuratorUserID int64  `json:"curator_user_id"`
-	CuratorName   string `json:"curator_name"`
-	CuratorNames  string `json:"curator_names"`
-	AssigneeNames string `json:"assignee_names"`
-	Curators      []User `json:"curators"`
-	Assignees     []User `json:"assignees"`
+	CuratorUserID  int64  `json:"curator_user_id"`
+	CuratorName    string `json:"curator_name"`
+	CuratorNames   string `json:"curator_names"`
+	AssigneeNames  string `json:"assignee_names"`
+	WorkflowID     int64  `json:"workflow_id"`
+	Curators       []User `json:"curators"`
+	Assignees      []User `json:"assignees"`
 }
 
 type Task struct {
-	ID            int64   `json:"id"`
-	Key           string  `json:"key"`
-	Title         string  `json:"title"`
-	Description   string  `json:"description"`
-	Type          string  `json:"type"`
-	Status        string  `json:"status"`
-	Priority      string  `json:"priority"`
-	ProjectID     int64   `json:"project_id"`
-	ProjectKey    string  `json:"project_key"`
-	ProjectName   string  `json:"project_name"`
-	DepartmentID  int64   `json:"department_id"`
-	DepartmentName string `json:"department_name"`
-	CuratorUserID int64   `json:"curator_user_id"`
-	CuratorName   string  `json:"curator_name"`
-	Curators      []User  `json:"curators"`
-	DueDate       *string `json:"due_date,omitempty"`
-	Assignees     []User  `json:"assignees"`
-	RouteStage    int64   `json:"route_stage"`
-	RouteOwnerID  int64   `json:"route_owner_user_id"`
-	RouteOwnerName string `json:"route_owner_name"`
+	ID             int64       `json:"id"`
+	Key            string      `json:"key"`
+	Title          string      `json:"title"`
+	Description    string      `json:"description"`
+	Type           string      `json:"type"`
+	Status         string      `json:"status"`
+	Priority       string      `json:"priority"`
+	ProjectID      int64       `json:"project_id"`
+	ProjectKey     string      `json:"project_key"`
+	ProjectName    string      `json:"project_name"`
+	DepartmentID   int64       `json:"department_id"`
+	DepartmentName string      `json:"department_name"`
+	CuratorUserID  int64       `json:"curator_user_id"`
+	CuratorName    string      `json:"curator_name"`
+	Curators       []User      `json:"curators"`
+	DueDate        *string     `json:"due_date,omitempty"`
+	Assignees      []User      `json:"assignees"`
+	RouteStage     int64       `json:"route_stage"`
+	RouteOwnerID   int64       `json:"route_owner_user_id"`
+	RouteOwnerName string      `json:"route_owner_name"`
+	RouteUnitID    int64       `json:"route_unit_id"`
+	ParentTaskID   int64       `json:"parent_task_id,omitempty"`
+	Subtasks       *TaskRollup `json:"subtasks,omitempty"`
+	Blocked        bool        `json:"blocked"`
+	Links          []TaskLink  `json:"links,omitempty"`
+}
+
+// TaskLink — связь с другой задачей с точки зрения текущей: blocks, blocked_by, relates_to,
+// duplicates или duplicated_by.
+type TaskLink struct {
+	ID         int64  `json:"id"`
+	Type       string `json:"type"`
+	TaskID     int64  `json:"task_id"`
+	TaskKey    string `json:"task_key"`
+	TaskTitle  string `json:"task_title"`
+	TaskStatus string `json:"task_status"`
+}
+
+type CreateTaskLinkInput struct {
+	Type   string `json:"type"`
+	TaskID int64  `json:"task_id"`
+}
+
+// TaskRollup — сводка по всем подзадачам на любой глубине: сколько их, сколько завершено
+// и в работе, процент готовности и статус, который из этого следует.
+type TaskRollup struct {
+	Total      int    `json:"total"`
+	Done       int    `json:"done"`
+	InProgress int    `json:"in_progress"`
+	Progress   int    `json:"progress"`
+	Status     string `json:"status"`
 }
 
 type RegisterInput struct {
@@ -75,8 +111,12 @@ type CreateTaskInput struct {
 	CuratorIDs   []int64 `json:"curator_ids"`
 	AssigneeIDs  []int64 `json:"assignee_ids"`
 	DueDate      *string `json:"due_date"`
+	ParentTaskID int64   `json:"parent_task_id"`
 	RouteStage   int64   `json:"-"`
 	RouteOwnerID int64   `json:"-"`
+	RouteUnitID  int64   `json:"-"`
+	// ActorRole — роль автора: по ней проверяется переход из начального статуса в заданный.
+	ActorRole string `json:"-"`
 }
 
 type CreateProjectInput struct {
@@ -124,39 +164,109 @@ type UpdateTaskInput struct {
 	CuratorIDs  []int64 `json:"curator_ids"`
 	AssigneeIDs []int64 `json:"assignee_ids"`
 	DueDate     *string `json:"due_date"`
+	// ParentTaskID: nil оставляет родителя как есть, 0 делает задачу самостоятельной.
+	ParentTaskID *int64 `json:"parent_task_id"`
+	// ActorRole — роль автора правки: по ней проверяется переход между статусами.
+	ActorRole string `json:"-"`
 }
 
 type Report struct {
-	ID          int64  `json:"id"`
-	TargetType  string `json:"target_type"`
-	TargetID    int64  `json:"target_id"`
-	TargetLabel string `json:"target_label"`
+	ID           int64  `json:"id"`
+	TargetType   string `json:"target_type"`
+	TargetID     int64  `json:"target_id"`
+	TargetLabel  string `json:"target_label"`
 	ResultStatus string `json:"result_status"`
-	AuthorID    int64  `json:"author_id"`
-	AuthorName  string `json:"author_name"`
-	Title       string `json:"title"`
-	Resolution  string `json:"resolution"`
-	FileName    string `json:"file_name,omitempty"`
-	FileSize    int64  `json:"file_size,omitempty"`
-	CreatedAt   string `json:"created_at"`
+	AuthorID     int64  `json:"author_id"`
+	AuthorName   string `json:"author_name"`
+	Title        string `json:"title"`
+	Resolution   string `json:"resolution"`
+	FileName     string `json:"file_name,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+	CreatedAt    string `json:"created_at"`
 }
 
 type CreateReportInput struct {
-	TargetType string
-	TargetID   int64
+	TargetType   string
+	TargetID     int64
 	ResultStatus string
-	AuthorID   int64
-	Title      string
-	Resolution string
-	FileName   string
-	FilePath   string
-	FileSize   int64
-	CloseItem  bool
+	AuthorID     int64
+	Title        string
+	Resolution   string
+	FileName     string
+	FilePath     string
+	FileSize     int64
+	FileType     string
+	CloseItem    bool
+	// ForceClose закрывает задачу вместе с ее незакрытыми подзадачами.
+	ForceClose bool
+	// AuthorRole — роль автора: по ней проверяется переход задачи в конечный статус.
+	AuthorRole string
 }
 
 type Department struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID         int64                `json:"id"`
+	Name       string               `json:"name"`
+	ParentID   int64                `json:"parent_id"`
+	HeadUserID int64                `json:"head_user_id"`
+	HeadName   string               `json:"head_name"`
+	Positions  []DepartmentPosition `json:"positions"`
+}
+
+type DepartmentPosition struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	IsHead bool   `json:"is_head"`
+}
+
+type DepartmentInput struct {
+	Name       string               `json:"name"`
+	ParentID   int64                `json:"parent_id"`
+	HeadUserID int64                `json:"head_user_id"`
+	Positions  []DepartmentPosition `json:"positions"`
+}
+
+type DepartmentMergeInput struct {
+	TargetID int64 `json:"target_id"`
+}
+
+// OrgUnit — узел оргструктуры для схемы: подразделение, его руководитель, сотрудники и вложенные подразделения.
+type OrgUnit struct {
+	ID       int64       `json:"id"`
+	Name     string      `json:"name"`
+	ParentID int64       `json:"parent_id"`
+	Head     *OrgMember  `json:"head"`
+	Members  []OrgMember `json:"members"`
+	Children []OrgUnit   `json:"children"`
+}
+
+type OrgMember struct {
+	ID       int64  `json:"id"`
+	FullName string `json:"full_name"`
+	Position string `json:"position"`
+	Role     string `json:"role"`
+}
+
+type RolePermission struct {
+	Action    string `json:"action"`
+	Scope     string `json:"scope"`
+	Condition string `json:"condition,omitempty"`
+}
+
+type Role struct {
+	ID          int64            `json:"id"`
+	Name        string           `json:"name"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	BuiltIn     bool             `json:"built_in"`
+	UsersCount  int64            `json:"users_count"`
+	Permissions []RolePermission `json:"permissions"`
+}
+
+type RoleInput struct {
+	Name        string           `json:"name"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Permissions []RolePermission `json:"permissions"`
 }
 
 type ChatMessage struct {
@@ -170,3 +280,253 @@ type ChatMessage struct {
 	FileURL    string `json:"file_url,omitempty"`
 	CreatedAt  string `json:"created_at"`
 }
+
+type Session struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"user_id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorLoginInput struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type DirectorySyncResult struct {
+	Created     int `json:"created"`
+	Updated     int `json:"updated"`
+	Deactivated int `json:"deactivated"`
+	Skipped     int `json:"skipped"`
+}
+
+type FileKeyRotationResult struct {
+	Rotated int `json:"rotated"`
+	Current int `json:"current"`
+	Missing int `json:"missing"`
+	Failed  int `json:"failed"`
+}
+
+type APIToken struct {
+	ID             int64    `json:"id"`
+	UserID         int64    `json:"user_id"`
+	UserLogin      string   `json:"user_login"`
+	ServiceAccount bool     `json:"service_account"`
+	Name           string   `json:"name"`
+	Prefix         string   `json:"prefix"`
+	Scopes         []string `json:"scopes"`
+	ExpiresAt      *string  `json:"expires_at"`
+	LastUsedAt     *string  `json:"last_used_at"`
+	LastUsedIP     string   `json:"last_used_ip"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+type CreateAPITokenInput struct {
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes"`
+	ExpiresAt        string   `json:"expires_at"`
+	ServiceAccountID int64    `json:"service_account_id"`
+}
+
+type CreateServiceAccountInput struct {
+	Login        string `json:"login"`
+	FullName     string `json:"full_name"`
+	Role         string `json:"role"`
+	DepartmentID int64  `json:"department_id"`
+}
+
+type LoginLockout struct {
+	ID            int64   `json:"id"`
+	Scope         string  `json:"scope"`
+	Key           string  `json:"key"`
+	Failures      int     `json:"failures"`
+	LockedUntil   *string `json:"locked_until"`
+	LastFailureAt string  `json:"last_failure_at"`
+}
+
+type LoginAttempt struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+type SetPasswordInput struct {
+	Password string `json:"password"`
+}
+
+type PasswordResetInput struct {
+	Token          string `json:"token"`
+	Password       string `json:"password"`
+	RepeatPassword string `json:"repeat_password"`
+}
+
+type Registration struct {
+	ID             int64   `json:"id"`
+	Login          string  `json:"login"`
+	FullName       string  `json:"full_name"`
+	Position       string  `json:"position"`
+	DepartmentID   int64   `json:"department_id"`
+	DepartmentName string  `json:"department_name"`
+	Status         string  `json:"status"`
+	Comment        string  `json:"comment"`
+	ReviewedBy     int64   `json:"reviewed_by"`
+	ReviewedByName string  `json:"reviewed_by_name"`
+	ReviewedAt     *string `json:"reviewed_at"`
+	CreatedAt      string  `json:"created_at"`
+}
+
+type ReviewRegistrationInput struct {
+	Reason string `json:"reason"`
+}
+
+// AuditEvent — запись журнала аудита. Before и After содержат только измененные поля;
+// при создании Before пуст (null), при удалении — After.
+type AuditEvent struct {
+	ID          int64           `json:"id"`
+	ActorUserID int64           `json:"actor_user_id"`
+	ActorLogin  string          `json:"actor_login"`
+	Action      string          `json:"action"`
+	EntityType  string          `json:"entity_type"`
+	EntityID    int64           `json:"entity_id"`
+	Before      json.RawMessage `json:"before"`
+	After       json.RawMessage `json:"after"`
+	IP          string          `json:"ip"`
+	CreatedAt   string          `json:"created_at"`
+}
+
+type AuditFilter struct {
+	ActorUserID int64
+	Action      string
+	EntityType  string
+	EntityID    int64
+	// From и To — границы по времени в формате БД, To не включается.
+	From string
+	To   string
+}
+
+// QuarantinedFile — вложение, не прошедшее антивирусную проверку. Threat заполнен для
+// зараженных файлов, ScanError — для файлов, которые проверить не удалось.
+type QuarantinedFile struct {
+	ID           int64  `json:"id"`
+	Kind         string `json:"kind"`
+	ScopeType    string `json:"scope_type"`
+	ScopeID      int64  `json:"scope_id"`
+	UploaderID   int64  `json:"uploader_id"`
+	UploaderName string `json:"uploader_name"`
+	FileName     string `json:"file_name"`
+	FileType     string `json:"file_type"`
+	FileSize     int64  `json:"file_size"`
+	Threat       string `json:"threat,omitempty"`
+	ScanError    string `json:"scan_error,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	FilePath     string `json:"-"`
+}
+
+type Notification struct {
+	ID         int64  `json:"id"`
+	Kind       string `json:"kind"`
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	EntityType string `json:"entity_type,omitempty"`
+	EntityID   int64  `json:"entity_id,omitempty"`
+	Read       bool   `json:"read"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// TaskEvent — операция над задачей из ее истории: создание, правка, передача по маршруту, закрытие.
+type TaskEvent struct {
+	ID        int64             `json:"id"`
+	Action    string            `json:"action"`
+	ActorID   int64             `json:"actor_id"`
+	ActorName string            `json:"actor_name"`
+	Changes   []TaskFieldChange `json:"changes"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// TaskFieldChange — значение поля до и после операции. Пользователи указаны именами.
+type TaskFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// TaskTimelineItem — запись ленты задачи: изменение, сообщение чата или отчет.
+type TaskTimelineItem struct {
+	Kind      string       `json:"kind"`
+	CreatedAt string       `json:"created_at"`
+	Event     *TaskEvent   `json:"event,omitempty"`
+	Message   *ChatMessage `json:"message,omitempty"`
+	Report    *Report      `json:"report,omitempty"`
+}
+
+// Workflow — процесс задач проекта: статусы, разрешенные переходы и роли, которым они доступны.
+type Workflow struct {
+	ID          int64                `json:"id"`
+	Name        string               `json:"name"`
+	IsDefault   bool                 `json:"is_default"`
+	Statuses    []WorkflowStatus     `json:"statuses"`
+	Transitions []WorkflowTransition `json:"transitions"`
+	ProjectIDs  []int64              `json:"project_ids"`
+}
+
+// WorkflowStatus — статус процесса. Новая задача получает начальный статус, задача в конечном
+// статусе считается закрытой.
+type WorkflowStatus struct {
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Initial  bool   `json:"initial"`
+	Terminal bool   `json:"terminal"`
+}
+
+// WorkflowTransition — разрешенный переход. Пустой Roles — переход доступен любой роли.
+type WorkflowTransition struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Roles []string `json:"roles"`
+}
+
+type WorkflowInput struct {
+	Name        string               `json:"name"`
+	Statuses    []WorkflowStatus     `json:"statuses"`
+	Transitions []WorkflowTransition `json:"transitions"`
+}
+
+type ProjectWorkflowInput struct {
+	WorkflowID int64 `json:"workflow_id"`
+}
+
+// TrashItem — удаленная задача, проект или отчет в корзине. PurgeAt — когда элемент будет
+// удален окончательно; пусто, если автоочистка отключена.
+type TrashItem struct {
+	Type          string `json:"type"`
+	ID            int64  `json:"id"`
+	Label         string `json:"label"`
+	DeletedAt     string `json:"deleted_at"`
+	DeletedBy     int64  `json:"deleted_by"`
+	DeletedByName string `json:"deleted_by_name"`
+	PurgeAt       string `json:"purge_at,omitempty"`
+}
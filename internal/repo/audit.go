@@ -0,0 +1,423 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Типы сущностей в журнале аудита.
+const (
+	auditUser       = "user"
+	auditProject    = "project"
+	auditTask       = "task"
+	auditReport     = "report"
+	auditMessage    = "message"
+	auditRole       = "role"
+	auditDepartment = "department"
+	auditPosition   = "department_position"
+	auditAPIToken   = "api_token"
+	auditLockout    = "login_lockout"
+	auditQuarantine = "quarantined_file"
+	auditTaskLink   = "task_link"
+	auditWorkflow   = "workflow"
+)
+
+// auditStateQueries — снимок сущности для журнала: строка таблицы вместе со связями,
+// которые меняются той же операцией (кураторы, исполнители, права, должности).
+var auditStateQueries = map[string]string{
+	auditUser: `
+SELECT id, login, full_name, position, role, department_id, avatar_path, avatar_type, auth_source, ldap_dn, oidc_subject,
+       is_active, is_service, must_change_password, registration_status, registration_comment,
+       registration_reviewed_by, totp_enabled, password_hash, totp_secret
+FROM users WHERE id = ?`,
+	auditProject: `
+SELECT p.id, p.key, p.name, p.status, p.department_id, p.curator_user_id, p.workflow_id, p.deleted_at,
+       (SELECT group_concat(user_id) FROM (SELECT user_id FROM project_curators WHERE project_id = p.id ORDER BY user_id)) AS curator_ids,
+       (SELECT group_concat(user_id) FROM (SELECT user_id FROM project_assignees WHERE project_id = p.id ORDER BY user_id)) AS assignee_ids
+FROM projects p WHERE p.id = ?`,
+	auditTask: `
+SELECT t.id, t.key, t.title, t.description, t.type, t.status, t.priority, t.project_id, t.curator_user_id, t.due_date,
+       t.route_stage, t.route_owner_user_id, t.route_unit_id, t.parent_task_id, t.deleted_at,
+       (SELECT group_concat(user_id) FROM (SELECT user_id FROM task_curators WHERE task_id = t.id ORDER BY user_id)) AS curator_ids,
+       (SELECT group_concat(user_id) FROM (SELECT user_id FROM task_assignees WHERE task_id = t.id ORDER BY user_id)) AS assignee_ids
+FROM tasks t WHERE t.id = ?`,
+	auditReport: `
+SELECT id, target_type, target_id, result_status, author_user_id, title, resolution, file_name, file_path, file_size, file_type, deleted_at
+FROM reports WHERE id = ?`,
+	auditMessage: `
+SELECT id, scope_type, scope_id, author_user_id, body, file_name, file_path, file_size, file_type
+FROM chat_messages WHERE id = ?`,
+	auditRole: `
+SELECT ro.id, ro.name, ro.title, ro.description,
+       (SELECT group_concat(perm, ' ') FROM (
+          SELECT action || ':' || scope || CASE WHEN condition <> '' THEN ':' || condition ELSE '' END AS perm
+          FROM role_permissions WHERE role_id = ro.id ORDER BY action, scope, condition)) AS permissions
+FROM roles ro WHERE ro.id = ?`,
+	auditDepartment: `
+SELECT d.id, d.name, d.parent_id, d.head_user_id,
+       (SELECT group_concat(name, '; ') FROM (SELECT name FROM department_positions WHERE department_id = d.id ORDER BY id)) AS positions
+FROM departments d WHERE d.id = ?`,
+	auditPosition: `
+SELECT id, department_id, name, is_head FROM department_positions WHERE id = ?`,
+	auditAPIToken: `
+SELECT id, user_id, name, token_prefix, scopes, expires_at FROM api_tokens WHERE id = ?`,
+	auditLockout: `
+SELECT id, scope, key, failures, locked_until FROM login_lockouts WHERE id = ?`,
+	auditTaskLink: `
+SELECT id, source_task_id, target_task_id, kind, created_by FROM task_links WHERE id = ?`,
+	auditWorkflow: `
+SELECT w.id, w.name, w.is_default,
+       (SELECT group_concat(st, '; ') FROM (
+          SELECT name || CASE WHEN is_initial = 1 THEN ':initial' ELSE '' END || CASE WHEN is_terminal = 1 THEN ':terminal' ELSE '' END AS st
+          FROM workflow_statuses WHERE workflow_id = w.id ORDER BY position, name)) AS statuses,
+       (SELECT group_concat(tr, '; ') FROM (
+          SELECT from_status || ' -> ' || to_status || CASE WHEN roles <> '' THEN ' [' || roles || ']' ELSE '' END AS tr
+          FROM workflow_transitions WHERE workflow_id = w.id ORDER BY from_status, to_status)) AS transitions
+FROM workflows w WHERE w.id = ?`,
+	auditQuarantine: `
+SELECT id, kind, scope_type, scope_id, uploader_user_id, file_name, file_path, file_type, file_size, threat, scan_error
+FROM quarantined_files WHERE id = ?`,
+}
+
+// В журнал попадает только факт изменения секретов, но не их значения.
+var auditSecretColumns = map[string]bool{
+	"password_hash": true,
+	"totp_secret":   true,
+}
+
+const auditSecretMask = "***"
+
+type auditActorKey struct{}
+
+// AuditActor — кто и откуда выполняет запрос. Обработчик HTTP кладет его в контекст,
+// репозиторий записывает в каждое событие аудита. Пользователь заполняется после
+// аутентификации, поэтому в контексте лежит указатель.
+type AuditActor struct {
+	UserID int64
+	IP     string
+}
+
+func WithAuditActor(ctx context.Context, actor *AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFrom возвращает исполнителя из контекста или nil, если его туда не положили.
+func AuditActorFrom(ctx context.Context) *AuditActor {
+	actor, _ := ctx.Value(auditActorKey{}).(*AuditActor)
+	return actor
+}
+
+// querier — общее у *sql.DB и *sql.Tx: событие аудита пишется в той же транзакции, что и изменение.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// auditState возвращает снимок сущности или nil, если ее нет.
+func auditState(ctx context.Context, q querier, entityType string, entityID int64) (map[string]any, error) {
+	query, ok := auditStateQueries[entityType]
+	if !ok {
+		return nil, fmt.Errorf("unknown audit entity %q", entityType)
+	}
+	rows, err := q.QueryContext(ctx, query, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("query audit state of %s: %w", entityType, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("audit state columns: %w", err)
+	}
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("scan audit state of %s: %w", entityType, err)
+	}
+	state := make(map[string]any, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		state[column] = values[i]
+	}
+	return state, nil
+}
+
+// recordAudit дописывает событие в журнал. before — снимок до изменения (nil при создании),
+// состояние после изменения читается здесь же. Для изменений хранятся только отличающиеся поля.
+func recordAudit(ctx context.Context, q querier, action, entityType string, entityID int64, before map[string]any) error {
+	after, err := auditState(ctx, q, entityType, entityID)
+	if err != nil {
+		return err
+	}
+	if before != nil && after != nil {
+		before, after = auditDiff(before, after)
+	}
+	if entityType == auditTask {
+		if err := recordTaskEvent(ctx, q, action, entityID, before, after); err != nil {
+			return err
+		}
+	}
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	var actor AuditActor
+	if current := AuditActorFrom(ctx); current != nil {
+		actor = *current
+	}
+	if _, err := q.ExecContext(ctx, `
+INSERT INTO audit_events (actor_user_id, actor_login, action, entity_type, entity_id, before_json, after_json, ip)
+VALUES (?, COALESCE((SELECT login FROM users WHERE id = ?), ''), ?, ?, ?, ?, ?, ?)
+`, actor.UserID, actor.UserID, action, entityType, entityID, beforeJSON, afterJSON, actor.IP); err != nil {
+		return fmt.Errorf("insert audit event: %w", err)
+	}
+	return nil
+}
+
+// recordAuditFact пишет событие, которое не меняет поля сущности: выдачу ссылки сброса пароля,
+// новые коды восстановления. Before и After в таком событии пусты.
+func recordAuditFact(ctx context.Context, q querier, action, entityType string, entityID int64) error {
+	state, err := auditState(ctx, q, entityType, entityID)
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, q, action, entityType, entityID, state)
+}
+
+func auditDiff(before, after map[string]any) (map[string]any, map[string]any) {
+	changedBefore := make(map[string]any)
+	changedAfter := make(map[string]any)
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changedBefore[key] = before[key]
+			changedAfter[key] = value
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changedBefore[key] = value
+			changedAfter[key] = nil
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+func auditJSON(state map[string]any) (any, error) {
+	if state == nil {
+		return nil, nil
+	}
+	masked := make(map[string]any, len(state))
+	for key, value := range state {
+		if auditSecretColumns[key] {
+			if s, _ := value.(string); s != "" {
+				value = auditSecretMask
+			}
+		}
+		masked[key] = value
+	}
+	data, err := json.Marshal(masked)
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit state: %w", err)
+	}
+	return string(data), nil
+}
+
+type auditSnapshot struct {
+	id    int64
+	state map[string]any
+}
+
+// auditStates снимает состояние всех сущностей, id которых выбирает idsQuery, — перед групповым изменением.
+func auditStates(ctx context.Context, q querier, entityType, idsQuery string, args ...any) ([]auditSnapshot, error) {
+	rows, err := q.QueryContext(ctx, idsQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit ids of %s: %w", entityType, err)
+	}
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan audit id of %s: %w", entityType, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("close rows: %w", err)
+	}
+	snapshots := make([]auditSnapshot, 0, len(ids))
+	for _, id := range ids {
+		state, err := auditState(ctx, q, entityType, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, auditSnapshot{id: id, state: state})
+	}
+	return snapshots, nil
+}
+
+func recordAuditAll(ctx context.Context, q querier, action, entityType string, snapshots []auditSnapshot) error {
+	for _, snapshot := range snapshots {
+		if err := recordAudit(ctx, q, action, entityType, snapshot.id, snapshot.state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditedUpdate выполняет изменение одной существующей сущности в транзакции и пишет событие аудита.
+func (r *Repository) auditedUpdate(ctx context.Context, action, entityType string, entityID int64, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := auditState(ctx, tx, entityType, entityID)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, action, entityType, entityID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// auditedInsert создает сущность в транзакции и пишет событие аудита; возвращает id новой записи.
+func (r *Repository) auditedInsert(ctx context.Context, action, entityType string, fn func(tx *sql.Tx) (sql.Result, error)) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := fn(tx)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("last insert id: %w", err)
+	}
+	if err := recordAudit(ctx, tx, action, entityType, id, nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return id, nil
+}
+
+func auditFilterWhere(f models.AuditFilter) (string, []any) {
+	conds := make([]string, 0)
+	args := make([]any, 0)
+	if f.ActorUserID > 0 {
+		conds = append(conds, `actor_user_id = ?`)
+		args = append(args, f.ActorUserID)
+	}
+	if f.Action != "" {
+		// Фильтр по префиксу: action=task выбирает все действия над задачами.
+		conds = append(conds, `(action = ? OR action LIKE ? || '.%')`)
+		args = append(args, f.Action, f.Action)
+	}
+	if f.EntityType != "" {
+		conds = append(conds, `entity_type = ?`)
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID > 0 {
+		conds = append(conds, `entity_id = ?`)
+		args = append(args, f.EntityID)
+	}
+	if f.From != "" {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, f.To)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), args
+}
+
+// AuditEvents возвращает страницу журнала (новые сначала) и общее число событий по фильтру.
+func (r *Repository) AuditEvents(ctx context.Context, f models.AuditFilter, limit, offset int) ([]models.AuditEvent, int, error) {
+	where, args := auditFilterWhere(f)
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count audit events: %w", err)
+	}
+	items := make([]models.AuditEvent, 0)
+	err := r.eachAuditEvent(ctx, where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset), func(item models.AuditEvent) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// EachAuditEvent перебирает все события по фильтру в хронологическом порядке, не загружая их в память.
+func (r *Repository) EachAuditEvent(ctx context.Context, f models.AuditFilter, fn func(models.AuditEvent) error) error {
+	where, args := auditFilterWhere(f)
+	return r.eachAuditEvent(ctx, where+` ORDER BY id`, args, fn)
+}
+
+func (r *Repository) eachAuditEvent(ctx context.Context, tail string, args []any, fn func(models.AuditEvent) error) error {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, actor_user_id, actor_login, action, entity_type, entity_id,
+       COALESCE(before_json, ''), COALESCE(after_json, ''), ip, datetime(created_at)
+FROM audit_events`+tail, args...)
+	if err != nil {
+		return fmt.Errorf("query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.AuditEvent
+		var before, after string
+		if err := rows.Scan(&item.ID, &item.ActorUserID, &item.ActorLogin, &item.Action, &item.EntityType, &item.EntityID,
+			&before, &after, &item.IP, &item.CreatedAt); err != nil {
+			return fmt.Errorf("scan audit event: %w", err)
+		}
+		if before != "" {
+			item.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			item.After = json.RawMessage(after)
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
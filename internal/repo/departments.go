@@ -0,0 +1,550 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+var errDepartmentNotFound = errors.New("некорректный отдел/подразделение")
+
+func (r *Repository) Departments(ctx context.Context) ([]models.Department, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT d.id, d.name, COALESCE(d.parent_id, 0), d.head_user_id, COALESCE(u.full_name, '')
+FROM departments d
+LEFT JOIN users u ON u.id = d.head_user_id
+ORDER BY d.id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query departments: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.Department, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		var d models.Department
+		if err := rows.Scan(&d.ID, &d.Name, &d.ParentID, &d.HeadUserID, &d.HeadName); err != nil {
+			return nil, fmt.Errorf("scan department: %w", err)
+		}
+		d.Positions = make([]models.DepartmentPosition, 0)
+		index[d.ID] = len(items)
+		items = append(items, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	posRows, err := r.db.QueryContext(ctx, `
+SELECT department_id, id, name, is_head FROM department_positions ORDER BY department_id, is_head DESC, id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query department positions: %w", err)
+	}
+	defer posRows.Close()
+	for posRows.Next() {
+		var departmentID int64
+		var pos models.DepartmentPosition
+		var isHead int
+		if err := posRows.Scan(&departmentID, &pos.ID, &pos.Name, &isHead); err != nil {
+			return nil, fmt.Errorf("scan department position: %w", err)
+		}
+		pos.IsHead = isHead == 1
+		if i, ok := index[departmentID]; ok {
+			items[i].Positions = append(items[i].Positions, pos)
+		}
+	}
+	return items, posRows.Err()
+}
+
+func (r *Repository) Department(ctx context.Context, departmentID int64) (models.Department, error) {
+	items, err := r.Departments(ctx)
+	if err != nil {
+		return models.Department{}, err
+	}
+	for _, item := range items {
+		if item.ID == departmentID {
+			return item, nil
+		}
+	}
+	return models.Department{}, errDepartmentNotFound
+}
+
+// DepartmentPositions возвращает должности отдела; по ним проверяется должность пользователя.
+func (r *Repository) DepartmentPositions(ctx context.Context, departmentID int64) ([]models.DepartmentPosition, error) {
+	item, err := r.Department(ctx, departmentID)
+	if err != nil {
+		return nil, err
+	}
+	return item.Positions, nil
+}
+
+func validateDepartmentPositions(positions []models.DepartmentPosition) error {
+	heads := 0
+	seen := make(map[string]struct{}, len(positions))
+	for _, pos := range positions {
+		name := strings.ToLower(strings.TrimSpace(pos.Name))
+		if name == "" {
+			return errors.New("название должности обязательно")
+		}
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("должность «%s» указана дважды", strings.TrimSpace(pos.Name))
+		}
+		seen[name] = struct{}{}
+		if pos.IsHead {
+			heads++
+		}
+	}
+	if heads > 1 {
+		return errors.New("должность начальника отдела может быть только одна")
+	}
+	return nil
+}
+
+// CreateDepartment создает подразделение внутри существующего: корень оргструктуры один.
+func (r *Repository) CreateDepartment(ctx context.Context, in models.DepartmentInput) (int64, error) {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return 0, errors.New("укажите название отдела")
+	}
+	if in.ParentID <= 0 {
+		return 0, errors.New("укажите вышестоящее подразделение")
+	}
+	if err := validateDepartmentPositions(in.Positions); err != nil {
+		return 0, err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := departmentExistsTx(ctx, tx, in.ParentID); err != nil {
+		return 0, errors.New("вышестоящее подразделение не найдено")
+	}
+	if err := validateDepartmentHeadTx(ctx, tx, in.HeadUserID); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO departments (name, parent_id, head_user_id) VALUES (?, ?, ?)`, name, in.ParentID, in.HeadUserID)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return 0, errors.New("отдел с таким названием уже существует")
+		}
+		return 0, fmt.Errorf("insert department: %w", err)
+	}
+	departmentID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("department id: %w", err)
+	}
+	for _, pos := range in.Positions {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO department_positions (department_id, name, is_head) VALUES (?, ?, ?)
+`, departmentID, strings.TrimSpace(pos.Name), boolToInt(pos.IsHead)); err != nil {
+			return 0, fmt.Errorf("insert department position: %w", err)
+		}
+	}
+	if err := recordAudit(ctx, tx, "department.create", auditDepartment, departmentID, nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return departmentID, nil
+}
+
+// UpdateDepartment меняет название, вышестоящее подразделение и руководителя.
+// Пользователи и проекты ссылаются на подразделение по id, поэтому их менять не нужно.
+func (r *Repository) UpdateDepartment(ctx context.Context, departmentID int64, in models.DepartmentInput) error {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return errors.New("укажите название отдела")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	parentID, err := departmentParentTx(ctx, tx, departmentID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case parentID == 0 && in.ParentID != 0:
+		return errors.New("корневое подразделение нельзя перенести")
+	case parentID != 0 && in.ParentID <= 0:
+		return errors.New("укажите вышестоящее подразделение")
+	case in.ParentID != 0 && in.ParentID != parentID:
+		if err := departmentExistsTx(ctx, tx, in.ParentID); err != nil {
+			return errors.New("вышестоящее подразделение не найдено")
+		}
+		inside, err := departmentWithinTx(ctx, tx, in.ParentID, departmentID)
+		if err != nil {
+			return err
+		}
+		if inside {
+			return errors.New("нельзя перенести подразделение внутрь него самого")
+		}
+	}
+	if err := validateDepartmentHeadTx(ctx, tx, in.HeadUserID); err != nil {
+		return err
+	}
+	before, err := auditState(ctx, tx, auditDepartment, departmentID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE departments SET name = ?, parent_id = NULLIF(?, 0), head_user_id = ? WHERE id = ?
+`, name, in.ParentID, in.HeadUserID, departmentID); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return errors.New("отдел с таким названием уже существует")
+		}
+		return fmt.Errorf("update department: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department.update", auditDepartment, departmentID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// DeleteDepartment удаляет пустой отдел. Отдел с сотрудниками или проектами нужно
+// сначала объединить с другим.
+func (r *Repository) DeleteDepartment(ctx context.Context, departmentID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	parentID, err := departmentParentTx(ctx, tx, departmentID)
+	if err != nil {
+		return err
+	}
+	if parentID == 0 {
+		return errors.New("корневое подразделение удалить нельзя")
+	}
+	var users, projects, children int
+	if err := tx.QueryRowContext(ctx, `
+SELECT (SELECT COUNT(*) FROM users WHERE department_id = ?),
+       (SELECT COUNT(*) FROM projects WHERE department_id = ?),
+       (SELECT COUNT(*) FROM departments WHERE parent_id = ?)
+`, departmentID, departmentID, departmentID).Scan(&users, &projects, &children); err != nil {
+		return fmt.Errorf("count department members: %w", err)
+	}
+	if children > 0 {
+		return fmt.Errorf("в подразделении есть вложенные подразделения (%d), сначала перенесите или удалите их", children)
+	}
+	if users > 0 || projects > 0 {
+		return fmt.Errorf("в отделе есть пользователи (%d) или проекты (%d), объедините его с другим отделом", users, projects)
+	}
+	before, err := auditState(ctx, tx, auditDepartment, departmentID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET route_unit_id = ? WHERE route_unit_id = ?`, parentID, departmentID); err != nil {
+		return fmt.Errorf("move department tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chat_messages WHERE scope_type = 'department' AND scope_id = ?`, departmentID); err != nil {
+		return fmt.Errorf("delete department messages: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM departments WHERE id = ?`, departmentID); err != nil {
+		return fmt.Errorf("delete department: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department.delete", auditDepartment, departmentID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// MergeDepartments переносит в целевой отдел пользователей, проекты, чат, должности и
+// вложенные подразделения исходного отдела, после чего удаляет исходный. Должности
+// переносятся без признака начальника: начальник у целевого отдела уже есть.
+func (r *Repository) MergeDepartments(ctx context.Context, sourceID, targetID int64) error {
+	if sourceID == targetID {
+		return errors.New("нельзя объединить отдел с самим собой")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	sourceParentID, err := departmentParentTx(ctx, tx, sourceID)
+	if err != nil {
+		return err
+	}
+	if sourceParentID == 0 {
+		return errors.New("корневое подразделение нельзя объединить с другим")
+	}
+	if err := departmentExistsTx(ctx, tx, targetID); err != nil {
+		return errors.New("целевой отдел не найден")
+	}
+	inside, err := departmentWithinTx(ctx, tx, targetID, sourceID)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return errors.New("нельзя объединить подразделение с вложенным в него")
+	}
+	sourceBefore, err := auditState(ctx, tx, auditDepartment, sourceID)
+	if err != nil {
+		return err
+	}
+	targetBefore, err := auditState(ctx, tx, auditDepartment, targetID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE departments SET parent_id = ? WHERE parent_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department children: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET route_unit_id = ? WHERE route_unit_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT OR IGNORE INTO department_positions (department_id, name, is_head)
+SELECT ?, name, 0 FROM department_positions WHERE department_id = ?
+`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department positions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET department_id = ? WHERE department_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department users: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE projects SET department_id = ? WHERE department_id = ?`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department projects: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE chat_messages SET scope_id = ? WHERE scope_type = 'department' AND scope_id = ?
+`, targetID, sourceID); err != nil {
+		return fmt.Errorf("merge department messages: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM departments WHERE id = ?`, sourceID); err != nil {
+		return fmt.Errorf("delete merged department: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department.merge", auditDepartment, sourceID, sourceBefore); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "department.merge", auditDepartment, targetID, targetBefore); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) CreateDepartmentPosition(ctx context.Context, departmentID int64, in models.DepartmentPosition) (int64, error) {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return 0, errors.New("название должности обязательно")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := departmentExistsTx(ctx, tx, departmentID); err != nil {
+		return 0, err
+	}
+	if in.IsHead {
+		if err := clearDepartmentHeadTx(ctx, tx, departmentID); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO department_positions (department_id, name, is_head) VALUES (?, ?, ?)
+`, departmentID, name, boolToInt(in.IsHead))
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return 0, errors.New("такая должность в отделе уже есть")
+		}
+		return 0, fmt.Errorf("insert department position: %w", err)
+	}
+	positionID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("department position id: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department_position.create", auditPosition, positionID, nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return positionID, nil
+}
+
+// UpdateDepartmentPosition переименовывает должность вместе с пользователями отдела,
+// которые ее занимают, и при необходимости делает ее должностью начальника.
+func (r *Repository) UpdateDepartmentPosition(ctx context.Context, departmentID, positionID int64, in models.DepartmentPosition) error {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return errors.New("название должности обязательно")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	oldName, err := departmentPositionTx(ctx, tx, departmentID, positionID)
+	if err != nil {
+		return err
+	}
+	before, err := auditState(ctx, tx, auditPosition, positionID)
+	if err != nil {
+		return err
+	}
+	if in.IsHead {
+		if err := clearDepartmentHeadTx(ctx, tx, departmentID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE department_positions SET name = ?, is_head = ? WHERE id = ?
+`, name, boolToInt(in.IsHead), positionID); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return errors.New("такая должность в отделе уже есть")
+		}
+		return fmt.Errorf("update department position: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE users SET position = ? WHERE department_id = ? AND position = ? COLLATE NOCASE
+`, name, departmentID, oldName); err != nil {
+		return fmt.Errorf("rename users position: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department_position.update", auditPosition, positionID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteDepartmentPosition(ctx context.Context, departmentID, positionID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	name, err := departmentPositionTx(ctx, tx, departmentID, positionID)
+	if err != nil {
+		return err
+	}
+	var users int
+	if err := tx.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM users WHERE department_id = ? AND position = ? COLLATE NOCASE
+`, departmentID, name).Scan(&users); err != nil {
+		return fmt.Errorf("count position users: %w", err)
+	}
+	if users > 0 {
+		return fmt.Errorf("должность занимают пользователи (%d), сначала смените им должность", users)
+	}
+	before, err := auditState(ctx, tx, auditPosition, positionID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM department_positions WHERE id = ?`, positionID); err != nil {
+		return fmt.Errorf("delete department position: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "department_position.delete", auditPosition, positionID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func departmentExistsTx(ctx context.Context, tx *sql.Tx, departmentID int64) error {
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT 1 FROM departments WHERE id = ?`, departmentID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errDepartmentNotFound
+		}
+		return fmt.Errorf("query department: %w", err)
+	}
+	return nil
+}
+
+func departmentParentTx(ctx context.Context, tx *sql.Tx, departmentID int64) (int64, error) {
+	var parentID int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(parent_id, 0) FROM departments WHERE id = ?`, departmentID).Scan(&parentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errDepartmentNotFound
+		}
+		return 0, fmt.Errorf("query department: %w", err)
+	}
+	return parentID, nil
+}
+
+// departmentWithinTx сообщает, входит ли unitID в поддерево ancestorID (включая его самого).
+func departmentWithinTx(ctx context.Context, tx *sql.Tx, unitID, ancestorID int64) (bool, error) {
+	var found int
+	err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE chain(id) AS (
+  SELECT ?
+  UNION
+  SELECT d.parent_id FROM departments d JOIN chain c ON d.id = c.id WHERE d.parent_id IS NOT NULL
+)
+SELECT COUNT(*) FROM chain WHERE id = ?
+`, unitID, ancestorID).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("query department chain: %w", err)
+	}
+	return found > 0, nil
+}
+
+func validateDepartmentHeadTx(ctx context.Context, tx *sql.Tx, userID int64) error {
+	if userID == 0 {
+		return nil
+	}
+	var exists int
+	if err := tx.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM users WHERE id = ? AND is_active = 1 AND is_service = 0 AND registration_status = 'approved'
+`, userID).Scan(&exists); err != nil {
+		return fmt.Errorf("query department head: %w", err)
+	}
+	if exists == 0 {
+		return errors.New("руководитель подразделения не найден")
+	}
+	return nil
+}
+
+func departmentPositionTx(ctx context.Context, tx *sql.Tx, departmentID, positionID int64) (string, error) {
+	var name string
+	if err := tx.QueryRowContext(ctx, `
+SELECT name FROM department_positions WHERE id = ? AND department_id = ?
+`, positionID, departmentID).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("должность не найдена")
+		}
+		return "", fmt.Errorf("query department position: %w", err)
+	}
+	return name, nil
+}
+
+func clearDepartmentHeadTx(ctx context.Context, tx *sql.Tx, departmentID int64) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE department_positions SET is_head = 0 WHERE department_id = ?`, departmentID); err != nil {
+		return fmt.Errorf("reset department head: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const authSourceLDAP = "ldap"
+
+func (r *Repository) loginDirectory(ctx context.Context, in models.LoginInput) (models.User, error) {
+	entry, err := r.directory.Authenticate(in.Login, in.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrDirectoryInvalidCredentials) || errors.Is(err, auth.ErrDirectoryUserNotFound) {
+			return models.User{}, errors.New("неверный логин или пароль")
+		}
+		log.Printf("ldap login %q: %v", strings.TrimSpace(in.Login), err)
+		return models.User{}, errors.New("каталог пользователей недоступен, попробуйте позже")
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, _, _, err := upsertDirectoryUserTx(ctx, tx, entry)
+	if err != nil {
+		log.Printf("ldap login %q: %v", entry.Login, err)
+		return models.User{}, errors.New("не удалось создать учетную запись из каталога, обратитесь к администратору")
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, fmt.Errorf("commit tx: %w", err)
+	}
+	return r.UserByID(ctx, userID)
+}
+
+// SyncDirectory переносит пользователей каталога в users и отключает тех, кого в каталоге больше нет.
+func (r *Repository) SyncDirectory(ctx context.Context) (models.DirectorySyncResult, error) {
+	var result models.DirectorySyncResult
+	if r.directory == nil {
+		return result, errors.New("синхронизация с каталогом не настроена")
+	}
+	entries, err := r.directory.Users()
+	if err != nil {
+		return result, err
+	}
+	// Пустой ответ скорее говорит об ошибке фильтра, чем об увольнении всех сотрудников.
+	if len(entries) == 0 {
+		return result, errors.New("каталог вернул пустой список пользователей, синхронизация пропущена")
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		seen[strings.ToLower(entry.Login)] = true
+		_, created, updated, err := upsertDirectoryUserTx(ctx, tx, entry)
+		if err != nil {
+			log.Printf("ldap sync %q: %v", entry.Login, err)
+			result.Skipped++
+			continue
+		}
+		if created {
+			result.Created++
+		} else if updated {
+			result.Updated++
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, login FROM users WHERE auth_source = ? AND is_active = 1`, authSourceLDAP)
+	if err != nil {
+		return result, fmt.Errorf("query directory users: %w", err)
+	}
+	gone := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		var login string
+		if err := rows.Scan(&id, &login); err != nil {
+			rows.Close()
+			return result, fmt.Errorf("scan directory user: %w", err)
+		}
+		if !seen[strings.ToLower(login)] {
+			gone = append(gone, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return result, fmt.Errorf("close rows: %w", err)
+	}
+	for _, id := range gone {
+		before, err := auditState(ctx, tx, auditUser, id)
+		if err != nil {
+			return result, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = 0 WHERE id = ?`, id); err != nil {
+			return result, fmt.Errorf("deactivate user: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+			return result, fmt.Errorf("revoke sessions of deactivated user: %w", err)
+		}
+		if err := recordAudit(ctx, tx, "user.directory_deactivate", auditUser, id, before); err != nil {
+			return result, err
+		}
+		result.Deactivated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("commit tx: %w", err)
+	}
+	return result, nil
+}
+
+func upsertDirectoryUserTx(ctx context.Context, tx *sql.Tx, entry auth.DirectoryUser) (userID int64, created, updated bool, err error) {
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM departments WHERE id = ?`, entry.DepartmentID).Scan(&exists); err != nil {
+		return 0, false, false, fmt.Errorf("query department: %w", err)
+	}
+	if exists == 0 {
+		return 0, false, false, fmt.Errorf("department %d from directory mapping does not exist", entry.DepartmentID)
+	}
+
+	if entry.Position, err = directoryPositionTx(ctx, tx, entry.DepartmentID, entry.Position); err != nil {
+		return 0, false, false, err
+	}
+
+	var authSource string
+	err = tx.QueryRowContext(ctx, `SELECT id, auth_source FROM users WHERE login = ?`, entry.Login).Scan(&userID, &authSource)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Роль новым пользователям каталога выдается минимальная, дальше ей управляют администраторы.
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO users (login, password_hash, full_name, position, role, department_id, auth_source, ldap_dn, is_active)
+VALUES (?, '', ?, ?, 'Member', ?, ?, ?, 1)
+`, entry.Login, entry.FullName, entry.Position, entry.DepartmentID, authSourceLDAP, entry.DN)
+		if err != nil {
+			return 0, false, false, fmt.Errorf("insert directory user: %w", err)
+		}
+		userID, err = res.LastInsertId()
+		if err != nil {
+			return 0, false, false, fmt.Errorf("last insert id: %w", err)
+		}
+		if err := recordAudit(ctx, tx, "user.directory_create", auditUser, userID, nil); err != nil {
+			return 0, false, false, err
+		}
+		return userID, true, false, nil
+	case err != nil:
+		return 0, false, false, fmt.Errorf("query user by login: %w", err)
+	}
+	if authSource != authSourceLDAP {
+		return 0, false, false, errors.New("login is taken by a local account")
+	}
+
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return 0, false, false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+UPDATE users
+SET full_name = ?, position = ?, department_id = ?, ldap_dn = ?, is_active = 1
+WHERE id = ? AND (full_name <> ? OR position <> ? OR COALESCE(department_id, 0) <> ? OR ldap_dn <> ? OR is_active <> 1)
+`, entry.FullName, entry.Position, entry.DepartmentID, entry.DN, userID, entry.FullName, entry.Position, entry.DepartmentID, entry.DN)
+	if err != nil {
+		return 0, false, false, fmt.Errorf("update directory user: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, false, fmt.Errorf("rows affected: %w", err)
+	}
+	if affected > 0 {
+		if err := recordAudit(ctx, tx, "user.directory_update", auditUser, userID, before); err != nil {
+			return 0, false, false, err
+		}
+	}
+	return userID, false, affected > 0, nil
+}
+
+// directoryPositionTx сопоставляет должность из каталога со справочником должностей отдела.
+// Должность руководителя из каталога не берется — руководителей назначают через API; если
+// совпадения нет, пользователь получает первую обычную должность отдела.
+func directoryPositionTx(ctx context.Context, tx *sql.Tx, departmentID int64, position string) (string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM department_positions WHERE department_id = ? AND is_head = 0 ORDER BY id`, departmentID)
+	if err != nil {
+		return "", fmt.Errorf("query directory positions: %w", err)
+	}
+	defer rows.Close()
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", fmt.Errorf("scan directory position: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("department %d has no positions for directory users", departmentID)
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, strings.TrimSpace(position)) {
+			return name, nil
+		}
+	}
+	return names[0], nil
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"github.com/mvd/taskflow/internal/filecrypt"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// OpenStoredFile открывает загруженный файл; зашифрованный расшифровывается при чтении.
+func (r *Repository) OpenStoredFile(path string) (*filecrypt.File, error) {
+	return r.files.Open(path)
+}
+
+// RotateFileKeys приводит все загруженные файлы к текущему мастер-ключу: открытые файлы
+// шифруются, у зашифрованных прежним ключом перешифровывается ключ данных.
+func (r *Repository) RotateFileKeys(ctx context.Context) (models.FileKeyRotationResult, error) {
+	var result models.FileKeyRotationResult
+	if r.files == nil {
+		return result, errors.New("master key is not configured")
+	}
+	paths, err := r.storedFilePaths(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		rotated, err := r.files.Rotate(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			result.Missing++
+		case err != nil:
+			log.Printf("rotate %s: %v", path, err)
+			result.Failed++
+		case rotated:
+			result.Rotated++
+		default:
+			result.Current++
+		}
+	}
+	return result, nil
+}
+
+func (r *Repository) storedFilePaths(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT avatar_path FROM users WHERE avatar_path <> ''
+UNION SELECT file_path FROM reports WHERE file_path <> ''
+UNION SELECT file_path FROM chat_messages WHERE file_path <> ''
+UNION SELECT file_path FROM quarantined_files WHERE file_path <> ''
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query stored files: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan stored file: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
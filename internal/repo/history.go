@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// taskEventFields — поля снимка задачи, которые попадают в ее историю, в порядке показа.
+// users отмечает поля со списком id пользователей: при чтении они заменяются именами.
+var taskEventFields = []struct {
+	column string
+	field  string
+	users  bool
+}{
+	{column: "key", field: "key"},
+	{column: "title", field: "title"},
+	{column: "status", field: "status"},
+	{column: "priority", field: "priority"},
+	{column: "due_date", field: "due_date"},
+	{column: "curator_ids", field: "curators", users: true},
+	{column: "assignee_ids", field: "assignees", users: true},
+	{column: "route_owner_user_id", field: "route_owner", users: true},
+	{column: "route_stage", field: "route_stage"},
+	{column: "parent_task_id", field: "parent"},
+	{column: "description", field: "description"},
+}
+
+// recordTaskEvent пишет операцию в историю задачи. Вызывается из recordAudit, поэтому история
+// ведется для всех изменений задачи в той же транзакции. before и after — отличающиеся поля
+// снимка; before равен nil при создании, after — при удалении, когда история уже не нужна.
+func recordTaskEvent(ctx context.Context, q querier, action string, taskID int64, before, after map[string]any) error {
+	if after == nil {
+		return nil
+	}
+	changes := make([]models.TaskFieldChange, 0)
+	if before != nil {
+		for _, f := range taskEventFields {
+			if _, changed := after[f.column]; !changed {
+				continue
+			}
+			changes = append(changes, models.TaskFieldChange{
+				Field: f.field,
+				Old:   taskEventValue(before[f.column]),
+				New:   taskEventValue(after[f.column]),
+			})
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+	}
+
+	var actorID int64
+	if actor := AuditActorFrom(ctx); actor != nil {
+		actorID = actor.UserID
+	}
+	res, err := q.ExecContext(ctx, `INSERT INTO task_events (task_id, actor_user_id, action) VALUES (?, ?, ?)`, taskID, actorID, action)
+	if err != nil {
+		return fmt.Errorf("insert task event: %w", err)
+	}
+	eventID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("task event id: %w", err)
+	}
+	for _, c := range changes {
+		if _, err := q.ExecContext(ctx, `
+INSERT INTO task_event_changes (event_id, field, old_value, new_value) VALUES (?, ?, ?, ?)
+`, eventID, c.Field, c.Old, c.New); err != nil {
+			return fmt.Errorf("insert task event change: %w", err)
+		}
+	}
+	return nil
+}
+
+func taskEventValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// TaskEvents возвращает историю задачи от старых операций к новым.
+func (r *Repository) TaskEvents(ctx context.Context, taskID int64) ([]models.TaskEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT e.id, e.action, e.actor_user_id, COALESCE(u.full_name, ''), e.created_at
+FROM task_events e
+LEFT JOIN users u ON u.id = e.actor_user_id
+WHERE e.task_id = ?
+ORDER BY e.id ASC
+`, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query task events: %w", err)
+	}
+	items := make([]models.TaskEvent, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		item := models.TaskEvent{Changes: make([]models.TaskFieldChange, 0)}
+		if err := rows.Scan(&item.ID, &item.Action, &item.ActorID, &item.ActorName, &item.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task event: %w", err)
+		}
+		index[item.ID] = len(items)
+		items = append(items, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("close rows: %w", err)
+	}
+
+	rows, err = r.db.QueryContext(ctx, `
+SELECT c.event_id, c.field, c.old_value, c.new_value
+FROM task_event_changes c
+JOIN task_events e ON e.id = c.event_id
+WHERE e.task_id = ?
+ORDER BY c.event_id, c.rowid
+`, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query task event changes: %w", err)
+	}
+	defer rows.Close()
+	userFields := make(map[string]bool)
+	for _, f := range taskEventFields {
+		userFields[f.field] = f.users
+	}
+	userIDs := make([]int64, 0)
+	for rows.Next() {
+		var eventID int64
+		var c models.TaskFieldChange
+		if err := rows.Scan(&eventID, &c.Field, &c.Old, &c.New); err != nil {
+			return nil, fmt.Errorf("scan task event change: %w", err)
+		}
+		if userFields[c.Field] {
+			userIDs = append(userIDs, parseTaskEventIDs(c.Old)...)
+			userIDs = append(userIDs, parseTaskEventIDs(c.New)...)
+		}
+		if i, ok := index[eventID]; ok {
+			items[i].Changes = append(items[i].Changes, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate task event changes: %w", err)
+	}
+
+	names, err := r.userNames(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		for j, c := range items[i].Changes {
+			if userFields[c.Field] {
+				items[i].Changes[j].Old = taskEventUsers(c.Old, names)
+				items[i].Changes[j].New = taskEventUsers(c.New, names)
+			}
+		}
+	}
+	return items, nil
+}
+
+func parseTaskEventIDs(value string) []int64 {
+	ids := make([]int64, 0)
+	for _, part := range strings.Split(value, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// taskEventUsers заменяет список id именами; удаленные пользователи показываются как #id.
+func taskEventUsers(value string, names map[int64]string) string {
+	ids := parseTaskEventIDs(value)
+	labels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := names[id]; ok {
+			labels = append(labels, name)
+		} else {
+			labels = append(labels, "#"+strconv.FormatInt(id, 10))
+		}
+	}
+	return strings.Join(labels, ", ")
+}
+
+func (r *Repository) userNames(ctx context.Context, ids []int64) (map[int64]string, error) {
+	names := make(map[int64]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+	placeholders, args := int64Placeholders(ids)
+	rows, err := r.db.QueryContext(ctx, `SELECT id, full_name FROM users WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query user names: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("scan user name: %w", err)
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// TaskReports возвращает все отчеты по задаче, включая промежуточные.
+func (r *Repository) TaskReports(ctx context.Context, taskID int64) ([]models.Report, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT r.id, r.target_type, r.target_id, COALESCE(t.title, 'Задача #' || r.target_id), r.result_status,
+       r.author_user_id, u.full_name, r.title, r.resolution, r.file_name, r.file_size, r.created_at
+FROM reports r
+JOIN users u ON u.id = r.author_user_id
+LEFT JOIN tasks t ON t.id = r.target_id
+WHERE lower(r.target_type) = 'task' AND r.target_id = ? AND r.deleted_at IS NULL
+ORDER BY r.id ASC
+`, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query task reports: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Report, 0)
+	for rows.Next() {
+		var item models.Report
+		if err := rows.Scan(&item.ID, &item.TargetType, &item.TargetID, &item.TargetLabel, &item.ResultStatus, &item.AuthorID, &item.AuthorName, &item.Title, &item.Resolution, &item.FileName, &item.FileSize, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan task report: %w", err)
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
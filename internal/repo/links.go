@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Типы связи со стороны задачи, из которой она создается, и как они хранятся:
+// для blocked_by и duplicated_by задачи меняются местами.
+var taskLinkKinds = map[string]struct {
+	kind    string
+	reverse bool
+}{
+	"blocks":        {kind: "blocks"},
+	"blocked_by":    {kind: "blocks", reverse: true},
+	"relates_to":    {kind: "relates_to"},
+	"duplicates":    {kind: "duplicates"},
+	"duplicated_by": {kind: "duplicates", reverse: true},
+}
+
+// TaskLinks возвращает связи задачи; тип указан с ее стороны.
+func (r *Repository) TaskLinks(ctx context.Context, taskID int64) ([]models.TaskLink, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT l.id, l.kind, t.id, t.key, t.title, t.status
+FROM task_links l
+JOIN tasks t ON t.id = l.target_task_id
+WHERE l.source_task_id = ? AND `+liveTaskSQL("t")+`
+UNION ALL
+SELECT l.id,
+       CASE l.kind WHEN 'blocks' THEN 'blocked_by' WHEN 'duplicates' THEN 'duplicated_by' ELSE l.kind END,
+       t.id, t.key, t.title, t.status
+FROM task_links l
+JOIN tasks t ON t.id = l.source_task_id
+WHERE l.target_task_id = ? AND `+liveTaskSQL("t")+`
+ORDER BY 1
+`, taskID, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query task links: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.TaskLink, 0)
+	for rows.Next() {
+		var item models.TaskLink
+		if err := rows.Scan(&item.ID, &item.Type, &item.TaskID, &item.TaskKey, &item.TaskTitle, &item.TaskStatus); err != nil {
+			return nil, fmt.Errorf("scan task link: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// CreateTaskLink связывает задачу taskID с задачей in.TaskID. Связь blocks не может замкнуть
+// цикл: задача не должна, пусть и через другие, блокировать ту, что блокирует ее.
+func (r *Repository) CreateTaskLink(ctx context.Context, taskID int64, in models.CreateTaskLinkInput, actorID int64) error {
+	linkType, ok := taskLinkKinds[strings.TrimSpace(in.Type)]
+	if !ok {
+		return errors.New("неизвестный тип связи")
+	}
+	if in.TaskID == taskID {
+		return errors.New("задачу нельзя связать с ней самой")
+	}
+	source, target := taskID, in.TaskID
+	if linkType.reverse {
+		source, target = target, source
+	}
+	if linkType.kind == "relates_to" && source > target {
+		source, target = target, source
+	}
+
+	_, err := r.auditedInsert(ctx, "task.link", auditTaskLink, func(tx *sql.Tx) (sql.Result, error) {
+		var exists int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks t WHERE t.id IN (?, ?) AND `+liveTaskSQL("t"), source, target).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("query linked tasks: %w", err)
+		}
+		if exists != 2 {
+			return nil, errors.New("задача не найдена")
+		}
+		if linkType.kind == "blocks" {
+			cycle, err := taskBlocksTx(ctx, tx, target, source)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				return nil, errors.New("связь создаст цикл блокировок: задачи будут ждать друг друга")
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO task_links (source_task_id, target_task_id, kind, created_by) VALUES (?, ?, ?, ?)
+`, source, target, linkType.kind, actorID)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				return nil, errors.New("такая связь уже есть")
+			}
+			return nil, fmt.Errorf("insert task link: %w", err)
+		}
+		return res, nil
+	})
+	return err
+}
+
+// DeleteTaskLink удаляет связь, если одна из ее сторон — задача taskID.
+func (r *Repository) DeleteTaskLink(ctx context.Context, taskID, linkID int64) error {
+	return r.auditedUpdate(ctx, "task.unlink", auditTaskLink, linkID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+DELETE FROM task_links WHERE id = ? AND (source_task_id = ? OR target_task_id = ?)
+`, linkID, taskID, taskID)
+		if err != nil {
+			return fmt.Errorf("delete task link: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("связь не найдена")
+		}
+		return nil
+	})
+}
+
+// taskBlocksTx сообщает, блокирует ли fromID задачу toID напрямую или через цепочку задач.
+func taskBlocksTx(ctx context.Context, tx *sql.Tx, fromID, toID int64) (bool, error) {
+	var found int
+	err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE reach(id) AS (
+  SELECT ?
+  UNION
+  SELECT l.target_task_id FROM task_links l JOIN reach r ON l.source_task_id = r.id WHERE l.kind = 'blocks'
+)
+SELECT COUNT(*) FROM reach WHERE id = ?
+`, fromID, toID).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("query blocking chain: %w", err)
+	}
+	return found > 0, nil
+}
+
+// taskCloseWarningsTx — о чем предупредить при закрытии задачи: ее все еще блокируют
+// незакрытые задачи или от нее зависят другие незакрытые задачи.
+func taskCloseWarningsTx(ctx context.Context, tx *sql.Tx, taskID int64) ([]string, error) {
+	blockers, err := linkedTaskKeysTx(ctx, tx, `
+SELECT t.key FROM task_links l JOIN tasks t ON t.id = l.source_task_id
+WHERE l.target_task_id = ? AND l.kind = 'blocks' AND NOT `+taskTerminalSQL("t")+` AND `+liveTaskSQL("t")+`
+ORDER BY t.id
+`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	dependents, err := linkedTaskKeysTx(ctx, tx, `
+SELECT t.key FROM task_links l JOIN tasks t ON t.id = l.target_task_id
+WHERE l.source_task_id = ? AND l.kind = 'blocks' AND NOT `+taskTerminalSQL("t")+` AND `+liveTaskSQL("t")+`
+ORDER BY t.id
+`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	warnings := make([]string, 0, 2)
+	if len(blockers) > 0 {
+		warnings = append(warnings, "задачу блокируют незакрытые задачи: "+strings.Join(blockers, ", "))
+	}
+	if len(dependents) > 0 {
+		warnings = append(warnings, "от задачи зависят незакрытые задачи, проверьте их: "+strings.Join(dependents, ", "))
+	}
+	return warnings, nil
+}
+
+func linkedTaskKeysTx(ctx context.Context, tx *sql.Tx, query string, taskID int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query linked tasks: %w", err)
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan linked task: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
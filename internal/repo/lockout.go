@@ -0,0 +1,283 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const (
+	lockoutScopeLogin = "login"
+	lockoutScopeIP    = "ip"
+)
+
+// LockoutPolicy задает пороги блокировки входа. После MaxLoginFailures ошибок по логину
+// (или MaxIPFailures с одного адреса) вход блокируется на BaseDelay, и каждая следующая
+// ошибка удваивает блокировку вплоть до MaxDelay. Счетчик сбрасывается, если ошибок не было
+// дольше Window.
+type LockoutPolicy struct {
+	MaxLoginFailures int
+	MaxIPFailures    int
+	Window           time.Duration
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+}
+
+func (p LockoutPolicy) delay(failures, limit int) time.Duration {
+	if limit <= 0 || failures < limit {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := limit; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func normalizeLockoutLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// LoginLockedUntil возвращает окончание самой долгой действующей блокировки по логину или IP.
+func (r *Repository) LoginLockedUntil(ctx context.Context, login, ip string) (time.Time, bool, error) {
+	var until sql.NullString
+	err := r.db.QueryRowContext(ctx, `
+SELECT MAX(locked_until) FROM login_lockouts
+WHERE locked_until > CURRENT_TIMESTAMP
+  AND ((scope = ? AND key = ?) OR (scope = ? AND key = ?))
+`, lockoutScopeLogin, normalizeLockoutLogin(login), lockoutScopeIP, ip).Scan(&until)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query login lockout: %w", err)
+	}
+	if !until.Valid {
+		return time.Time{}, false, nil
+	}
+	t, err := time.ParseInLocation(sqliteTimeLayout, until.String, time.UTC)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("parse locked_until: %w", err)
+	}
+	return t, true, nil
+}
+
+// LogLoginAttempt записывает неудачную попытку в журнал, не увеличивая счетчики блокировки.
+func (r *Repository) LogLoginAttempt(ctx context.Context, login, ip, userAgent, reason string) error {
+	if _, err := r.db.ExecContext(ctx, `
+INSERT INTO login_attempts (login, ip, user_agent, reason)
+VALUES (?, ?, ?, ?)
+`, normalizeLockoutLogin(login), ip, userAgent, reason); err != nil {
+		return fmt.Errorf("insert login attempt: %w", err)
+	}
+	return nil
+}
+
+// RegisterLoginFailure записывает попытку и увеличивает счетчики по логину и IP.
+// Возвращает окончание блокировки, если после этой ошибки вход заблокирован.
+func (r *Repository) RegisterLoginFailure(ctx context.Context, policy LockoutPolicy, login, ip, userAgent, reason string) (time.Time, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO login_attempts (login, ip, user_agent, reason)
+VALUES (?, ?, ?, ?)
+`, normalizeLockoutLogin(login), ip, userAgent, reason); err != nil {
+		return time.Time{}, false, fmt.Errorf("insert login attempt: %w", err)
+	}
+
+	now := time.Now().UTC()
+	staleBefore := now.Add(-policy.Window).Format(sqliteTimeLayout)
+	if _, err := tx.ExecContext(ctx, `
+DELETE FROM login_lockouts
+WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until <= CURRENT_TIMESTAMP)
+`, staleBefore); err != nil {
+		return time.Time{}, false, fmt.Errorf("purge login lockouts: %w", err)
+	}
+
+	var lockedUntil time.Time
+	counters := []struct {
+		scope string
+		key   string
+		limit int
+	}{
+		{lockoutScopeLogin, normalizeLockoutLogin(login), policy.MaxLoginFailures},
+		{lockoutScopeIP, ip, policy.MaxIPFailures},
+	}
+	for _, c := range counters {
+		if c.key == "" {
+			continue
+		}
+		var failures int
+		err := tx.QueryRowContext(ctx, `SELECT failures FROM login_lockouts WHERE scope = ? AND key = ?`, c.scope, c.key).Scan(&failures)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, fmt.Errorf("query login lockout: %w", err)
+		}
+		failures++
+
+		var until any
+		if delay := policy.delay(failures, c.limit); delay > 0 {
+			t := now.Add(delay)
+			until = t.Format(sqliteTimeLayout)
+			if t.After(lockedUntil) {
+				lockedUntil = t
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO login_lockouts (scope, key, failures, locked_until, last_failure_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(scope, key) DO UPDATE SET
+  failures = excluded.failures,
+  locked_until = excluded.locked_until,
+  last_failure_at = excluded.last_failure_at
+`, c.scope, c.key, failures, until, now.Format(sqliteTimeLayout)); err != nil {
+			return time.Time{}, false, fmt.Errorf("upsert login lockout: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, false, fmt.Errorf("commit tx: %w", err)
+	}
+	return lockedUntil, !lockedUntil.IsZero(), nil
+}
+
+// ClearLoginFailures сбрасывает счетчик по логину после успешного входа.
+// Счетчик по IP не сбрасывается: иначе подбор можно чередовать со входом в свою учетную запись.
+func (r *Repository) ClearLoginFailures(ctx context.Context, login string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_lockouts WHERE scope = ? AND key = ?`, lockoutScopeLogin, normalizeLockoutLogin(login)); err != nil {
+		return fmt.Errorf("clear login failures: %w", err)
+	}
+	return nil
+}
+
+// ClearUserLockout снимает блокировку входа по логину по решению администратора. В отличие от
+// ClearLoginFailures снятие записывается в журнал аудита той же транзакцией.
+func (r *Repository) ClearUserLockout(ctx context.Context, login string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var lockoutID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM login_lockouts WHERE scope = ? AND key = ?`, lockoutScopeLogin, normalizeLockoutLogin(login)).Scan(&lockoutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("query login lockout: %w", err)
+	}
+	before, err := auditState(ctx, tx, auditLockout, lockoutID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_lockouts WHERE id = ?`, lockoutID); err != nil {
+		return fmt.Errorf("clear login lockout: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "login_lockout.clear", auditLockout, lockoutID, before); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+const loginLockoutColumns = `
+SELECT id, scope, key, failures,
+       CASE WHEN locked_until > CURRENT_TIMESTAMP THEN datetime(locked_until) END,
+       datetime(last_failure_at)
+FROM login_lockouts
+`
+
+func scanLoginLockout(scanner interface{ Scan(...any) error }) (models.LoginLockout, error) {
+	var item models.LoginLockout
+	var lockedUntil sql.NullString
+	if err := scanner.Scan(&item.ID, &item.Scope, &item.Key, &item.Failures, &lockedUntil, &item.LastFailureAt); err != nil {
+		return models.LoginLockout{}, err
+	}
+	if lockedUntil.Valid {
+		item.LockedUntil = &lockedUntil.String
+	}
+	return item, nil
+}
+
+func (r *Repository) LoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	rows, err := r.db.QueryContext(ctx, loginLockoutColumns+` ORDER BY last_failure_at DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query login lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.LoginLockout, 0)
+	for rows.Next() {
+		item, err := scanLoginLockout(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan login lockout: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// LoginLockoutByLogin возвращает found=false, если по логину нет неудачных попыток.
+func (r *Repository) LoginLockoutByLogin(ctx context.Context, login string) (models.LoginLockout, bool, error) {
+	item, err := scanLoginLockout(r.db.QueryRowContext(ctx, loginLockoutColumns+` WHERE scope = ? AND key = ?`, lockoutScopeLogin, normalizeLockoutLogin(login)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginLockout{}, false, nil
+		}
+		return models.LoginLockout{}, false, fmt.Errorf("query login lockout: %w", err)
+	}
+	return item, true, nil
+}
+
+func (r *Repository) ClearLoginLockout(ctx context.Context, lockoutID int64) error {
+	return r.auditedUpdate(ctx, "login_lockout.clear", auditLockout, lockoutID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM login_lockouts WHERE id = ?`, lockoutID)
+		if err != nil {
+			return fmt.Errorf("clear login lockout: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("блокировка не найдена")
+		}
+		return nil
+	})
+}
+
+// LoginAttempts возвращает последние неудачные попытки входа; пустой login — по всем логинам.
+func (r *Repository) LoginAttempts(ctx context.Context, login string, limit int) ([]models.LoginAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, login, ip, user_agent, reason, datetime(created_at)
+FROM login_attempts
+WHERE ? = '' OR login = ?
+ORDER BY id DESC
+LIMIT ?
+`, normalizeLockoutLogin(login), normalizeLockoutLogin(login), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.LoginAttempt, 0)
+	for rows.Next() {
+		var item models.LoginAttempt
+		if err := rows.Scan(&item.ID, &item.Login, &item.IP, &item.UserAgent, &item.Reason, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan login attempt: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Уведомления — служебные записи для конкретного пользователя, в журнал аудита они не пишутся:
+// аудитом покрыто событие, которое их породило.
+func notifyTx(ctx context.Context, tx *sql.Tx, userID int64, n models.Notification) error {
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO notifications (user_id, kind, title, body, entity_type, entity_id) VALUES (?, ?, ?, ?, ?, ?)
+`, userID, n.Kind, n.Title, n.Body, n.EntityType, n.EntityID); err != nil {
+		return fmt.Errorf("insert notification: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) Notifications(ctx context.Context, userID int64, unreadOnly bool) ([]models.Notification, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, kind, title, body, entity_type, entity_id, is_read, created_at
+FROM notifications
+WHERE user_id = ? AND (? = 0 OR is_read = 0)
+ORDER BY id DESC
+LIMIT 200
+`, userID, boolToInt(unreadOnly))
+	if err != nil {
+		return nil, fmt.Errorf("query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.Notification, 0)
+	for rows.Next() {
+		var item models.Notification
+		var read int
+		if err := rows.Scan(&item.ID, &item.Kind, &item.Title, &item.Body, &item.EntityType, &item.EntityID, &read, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan notification: %w", err)
+		}
+		item.Read = read == 1
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// MarkNotificationsRead отмечает прочитанным одно уведомление пользователя или все, если id равен 0.
+func (r *Repository) MarkNotificationsRead(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+UPDATE notifications SET is_read = 1 WHERE user_id = ? AND (? = 0 OR id = ?)
+`, userID, id, id)
+	if err != nil {
+		return fmt.Errorf("mark notifications read: %w", err)
+	}
+	if id == 0 {
+		return nil
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errors.New("уведомление не найдено")
+	}
+	return nil
+}
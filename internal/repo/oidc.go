@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const (
+	authSourceOIDC = "oidc"
+	oidcStateTTL   = 10 * time.Minute
+)
+
+// errOIDCAlreadyLinked — учетную запись успели привязать параллельным входом.
+var errOIDCAlreadyLinked = errors.New("oidc subject already linked")
+
+func (r *Repository) CreateOIDCState(ctx context.Context, state, nonce, verifier string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+		return fmt.Errorf("purge oidc states: %w", err)
+	}
+	expiresAt := time.Now().UTC().Add(oidcStateTTL)
+	if _, err := r.db.ExecContext(ctx, `
+INSERT INTO oidc_states (state_hash, nonce, code_verifier, expires_at)
+VALUES (?, ?, ?, ?)
+`, hashSessionToken(state), nonce, verifier, expiresAt.Format(sqliteTimeLayout)); err != nil {
+		return fmt.Errorf("insert oidc state: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCState одноразово извлекает nonce и PKCE verifier по state из callback'а.
+func (r *Repository) ConsumeOIDCState(ctx context.Context, state string) (string, string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var nonce, verifier string
+	err = tx.QueryRowContext(ctx, `
+SELECT id, nonce, code_verifier FROM oidc_states
+WHERE state_hash = ? AND expires_at > CURRENT_TIMESTAMP
+`, hashSessionToken(state)).Scan(&id, &nonce, &verifier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", errors.New("попытка входа устарела, начните вход заново")
+		}
+		return "", "", fmt.Errorf("query oidc state: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oidc_states WHERE id = ?`, id); err != nil {
+		return "", "", fmt.Errorf("delete oidc state: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", fmt.Errorf("commit tx: %w", err)
+	}
+	return nonce, verifier, nil
+}
+
+// UserByOIDCSubject возвращает found=false, если субъект еще ни к кому не привязан.
+func (r *Repository) UserByOIDCSubject(ctx context.Context, subject string) (models.User, bool, error) {
+	var userID int64
+	var active int
+	err := r.db.QueryRowContext(ctx, `SELECT id, is_active FROM users WHERE oidc_subject = ?`, subject).Scan(&userID, &active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, false, nil
+		}
+		return models.User{}, false, fmt.Errorf("query user by oidc subject: %w", err)
+	}
+	if active != 1 {
+		return models.User{}, true, errors.New("учетная запись отключена")
+	}
+	u, err := r.UserByID(ctx, userID)
+	if err != nil {
+		return models.User{}, true, err
+	}
+	return u, true, nil
+}
+
+// LinkOIDCSubject привязывает субъект IdP к существующей активной учетной записи с тем же логином.
+func (r *Repository) LinkOIDCSubject(ctx context.Context, login, subject string) (models.User, bool, error) {
+	var userID int64
+	err := r.db.QueryRowContext(ctx, `
+SELECT id FROM users
+WHERE login = ? AND oidc_subject = '' AND is_active = 1 AND is_service = 0 AND registration_status = 'approved'
+`, strings.TrimSpace(login)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, false, nil
+		}
+		return models.User{}, false, fmt.Errorf("query user for oidc link: %w", err)
+	}
+	err = r.auditedUpdate(ctx, "user.oidc_link", auditUser, userID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE users SET oidc_subject = ? WHERE id = ? AND oidc_subject = ''`, subject, userID)
+		if err != nil {
+			return fmt.Errorf("link oidc subject: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errOIDCAlreadyLinked
+		}
+		return nil
+	})
+	if errors.Is(err, errOIDCAlreadyLinked) {
+		return models.User{}, false, nil
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+	u, err := r.UserByID(ctx, userID)
+	if err != nil {
+		return models.User{}, false, err
+	}
+	return u, true, nil
+}
+
+func (r *Repository) CreateOIDCUser(ctx context.Context, login, fullName, position string, departmentID int64, subject string) (models.User, error) {
+	id, err := r.auditedInsert(ctx, "user.oidc_create", auditUser, func(tx *sql.Tx) (sql.Result, error) {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO users (login, password_hash, full_name, position, role, department_id, auth_source, oidc_subject)
+VALUES (?, '', ?, ?, 'Member', ?, ?, ?)
+`, strings.TrimSpace(login), strings.TrimSpace(fullName), strings.TrimSpace(position), departmentID, authSourceOIDC, subject)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				return nil, errors.New("учетная запись с таким логином уже существует, обратитесь к администратору")
+			}
+			return nil, fmt.Errorf("insert oidc user: %w", err)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return r.UserByID(ctx, id)
+}
@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errPasswordResetInvalid = errors.New("ссылка для сброса пароля недействительна или истекла")
+
+// localPasswordUser возвращает логин учетной записи, пароль которой хранится в TaskFlow.
+func (r *Repository) localPasswordUser(ctx context.Context, userID int64) (string, error) {
+	var login, authSource string
+	var service int
+	err := r.db.QueryRowContext(ctx, `SELECT login, auth_source, is_service FROM users WHERE id = ? AND is_active = 1`, userID).Scan(&login, &authSource, &service)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("пользователь не найден")
+		}
+		return "", fmt.Errorf("query user: %w", err)
+	}
+	switch {
+	case authSource == authSourceLDAP:
+		return "", errors.New("пароль учетной записи из каталога меняется в Active Directory")
+	case authSource == authSourceOIDC:
+		return "", errors.New("пароль учетной записи SSO меняется у провайдера входа")
+	case service == 1:
+		return "", errors.New("у сервисной учетной записи нет пароля")
+	}
+	return login, nil
+}
+
+// SetTemporaryPassword задает пароль, выданный администратором: пользователь обязан сменить
+// его при следующем входе, а все его сессии и ссылки сброса аннулируются.
+func (r *Repository) SetTemporaryPassword(ctx context.Context, userID int64, password string) error {
+	login, err := r.localPasswordUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := r.policy.Validate(password, login); err != nil {
+		return err
+	}
+	hash, err := r.PasswordHash(password)
+	if err != nil {
+		return err
+	}
+	return r.replacePassword(ctx, userID, login, hash, true, "")
+}
+
+// replacePassword при непустом resetToken сначала расходует ссылку сброса: из двух
+// одновременных запросов с одной ссылкой пароль сменит только один.
+func (r *Repository) replacePassword(ctx context.Context, userID int64, login, hash string, mustChange bool, resetToken string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	action := "user.password_set"
+	if resetToken != "" {
+		action = "user.password_reset"
+	}
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return err
+	}
+	if resetToken != "" {
+		res, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE token_hash = ? AND user_id = ? AND expires_at > CURRENT_TIMESTAMP`, hashSessionToken(resetToken), userID)
+		if err != nil {
+			return fmt.Errorf("consume password reset: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errPasswordResetInvalid
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = ?, must_change_password = ? WHERE id = ?`, hash, boolToInt(mustChange), userID); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("revoke sessions after password reset: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete password resets: %w", err)
+	}
+	// Новый пароль снимает и блокировку входа, накопленную при подборе старого.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_lockouts WHERE scope = ? AND key = ?`, lockoutScopeLogin, normalizeLockoutLogin(login)); err != nil {
+		return fmt.Errorf("clear login lockout: %w", err)
+	}
+	if err := recordAudit(ctx, tx, action, auditUser, userID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordReset выдает одноразовую ссылку сброса. Предыдущие ссылки пользователя аннулируются.
+func (r *Repository) CreatePasswordReset(ctx context.Context, userID, createdBy int64, ttl time.Duration) (string, time.Time, error) {
+	if _, err := r.localPasswordUser(ctx, userID); err != nil {
+		return "", time.Time{}, err
+	}
+	token, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = ? OR expires_at <= CURRENT_TIMESTAMP`, userID); err != nil {
+		return "", time.Time{}, fmt.Errorf("delete password resets: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO password_resets (user_id, token_hash, created_by, expires_at)
+VALUES (?, ?, ?, ?)
+`, userID, hashSessionToken(token), createdBy, expiresAt.Format(sqliteTimeLayout)); err != nil {
+		return "", time.Time{}, fmt.Errorf("insert password reset: %w", err)
+	}
+	if err := recordAuditFact(ctx, tx, "user.password_reset_link", auditUser, userID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, fmt.Errorf("commit tx: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func (r *Repository) passwordResetUser(ctx context.Context, token string) (int64, string, error) {
+	if token == "" {
+		return 0, "", errPasswordResetInvalid
+	}
+	var userID int64
+	var login string
+	err := r.db.QueryRowContext(ctx, `
+SELECT u.id, u.login
+FROM password_resets p
+JOIN users u ON u.id = p.user_id
+WHERE p.token_hash = ? AND p.expires_at > CURRENT_TIMESTAMP AND u.is_active = 1
+`, hashSessionToken(token)).Scan(&userID, &login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", errPasswordResetInvalid
+		}
+		return 0, "", fmt.Errorf("query password reset: %w", err)
+	}
+	return userID, login, nil
+}
+
+// PasswordResetLogin проверяет ссылку, не расходуя ее, и возвращает логин для формы сброса.
+func (r *Repository) PasswordResetLogin(ctx context.Context, token string) (string, error) {
+	_, login, err := r.passwordResetUser(ctx, token)
+	return login, err
+}
+
+// ResetPasswordByToken расходует ссылку и задает пароль, выбранный самим пользователем.
+func (r *Repository) ResetPasswordByToken(ctx context.Context, token, password string) error {
+	userID, login, err := r.passwordResetUser(ctx, token)
+	if err != nil {
+		return err
+	}
+	if _, err := r.localPasswordUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := r.policy.Validate(password, login); err != nil {
+		return err
+	}
+	hash, err := r.PasswordHash(password)
+	if err != nil {
+		return err
+	}
+	return r.replacePassword(ctx, userID, login, hash, false, token)
+}
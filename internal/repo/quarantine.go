@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// SaveQuarantineFile сохраняет непроверенный или зараженный файл без исходного расширения
+// и без прав на чтение для других пользователей системы.
+func (r *Repository) SaveQuarantineFile(baseDir string, content []byte) (string, error) {
+	if err := os.MkdirAll(baseDir, 0o700); err != nil {
+		return "", fmt.Errorf("create quarantine dir: %w", err)
+	}
+	fullPath := filepath.Join(baseDir, fmt.Sprintf("q_%d.bin", time.Now().UnixNano()))
+	if err := r.files.WriteFile(fullPath, content, 0o600); err != nil {
+		return "", fmt.Errorf("save quarantine file: %w", err)
+	}
+	return fullPath, nil
+}
+
+// QuarantineFile регистрирует файл в карантине и уведомляет загрузившего и всех, у кого
+// есть право quarantine.manage.
+func (r *Repository) QuarantineFile(ctx context.Context, in models.QuarantinedFile) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO quarantined_files (kind, scope_type, scope_id, uploader_user_id, file_name, file_path, file_type, file_size, threat, scan_error)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, in.Kind, in.ScopeType, in.ScopeID, in.UploaderID, in.FileName, in.FilePath, in.FileType, in.FileSize, in.Threat, in.ScanError)
+	if err != nil {
+		return 0, fmt.Errorf("insert quarantined file: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("quarantined file id: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "quarantine.create", auditQuarantine, id, nil); err != nil {
+		return 0, err
+	}
+
+	reason := "угроза " + in.Threat
+	if in.Threat == "" {
+		reason = "файл не удалось проверить"
+	}
+	if err := notifyTx(ctx, tx, in.UploaderID, models.Notification{
+		Kind:       "quarantine",
+		Title:      fmt.Sprintf("Файл %q не опубликован", in.FileName),
+		Body:       fmt.Sprintf("Антивирусная проверка: %s. Файл помещен в карантин, обратитесь в отдел информационной безопасности.", reason),
+		EntityType: auditQuarantine,
+		EntityID:   id,
+	}); err != nil {
+		return 0, err
+	}
+	var uploaderName string
+	if err := tx.QueryRowContext(ctx, `SELECT full_name FROM users WHERE id = ?`, in.UploaderID).Scan(&uploaderName); err != nil {
+		return 0, fmt.Errorf("query uploader: %w", err)
+	}
+	recipients, err := usersWithActionTx(ctx, tx, authz.QuarantineManage)
+	if err != nil {
+		return 0, err
+	}
+	for _, userID := range recipients {
+		if userID == in.UploaderID {
+			continue
+		}
+		if err := notifyTx(ctx, tx, userID, models.Notification{
+			Kind:       "quarantine",
+			Title:      fmt.Sprintf("Файл %q помещен в карантин", in.FileName),
+			Body:       fmt.Sprintf("Антивирусная проверка: %s. Загрузил %s (id %d), место загрузки: %s #%d.", reason, uploaderName, in.UploaderID, in.ScopeType, in.ScopeID),
+			EntityType: auditQuarantine,
+			EntityID:   id,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return id, nil
+}
+
+func (r *Repository) QuarantinedFiles(ctx context.Context) ([]models.QuarantinedFile, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT q.id, q.kind, q.scope_type, q.scope_id, q.uploader_user_id, COALESCE(u.full_name, ''),
+       q.file_name, q.file_type, q.file_size, q.threat, q.scan_error, q.created_at
+FROM quarantined_files q
+LEFT JOIN users u ON u.id = q.uploader_user_id
+ORDER BY q.id DESC
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query quarantined files: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.QuarantinedFile, 0)
+	for rows.Next() {
+		var item models.QuarantinedFile
+		if err := rows.Scan(&item.ID, &item.Kind, &item.ScopeType, &item.ScopeID, &item.UploaderID, &item.UploaderName,
+			&item.FileName, &item.FileType, &item.FileSize, &item.Threat, &item.ScanError, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan quarantined file: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteQuarantinedFile удаляет запись карантина и возвращает путь к файлу, который нужно удалить с диска.
+func (r *Repository) DeleteQuarantinedFile(ctx context.Context, id int64) (string, error) {
+	var filePath string
+	err := r.auditedUpdate(ctx, "quarantine.delete", auditQuarantine, id, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, `SELECT file_path FROM quarantined_files WHERE id = ?`, id).Scan(&filePath); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errors.New("файл в карантине не найден")
+			}
+			return fmt.Errorf("query quarantined file: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM quarantined_files WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete quarantined file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// usersWithActionTx возвращает активных пользователей, чья роль содержит право на действие.
+func usersWithActionTx(ctx context.Context, tx *sql.Tx, action authz.Action) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT u.id
+FROM users u
+JOIN roles ro ON ro.name = u.role COLLATE NOCASE
+JOIN role_permissions rp ON rp.role_id = ro.id
+WHERE rp.action = ? AND u.is_active = 1 AND u.registration_status = 'approved'
+ORDER BY u.id
+`, string(action))
+	if err != nil {
+		return nil, fmt.Errorf("query users by permission: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan user id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const (
+	registrationPending  = "pending"
+	registrationApproved = "approved"
+	registrationRejected = "rejected"
+)
+
+const registrationColumns = `
+SELECT u.id, u.login, u.full_name, u.position,
+       COALESCE(u.department_id, 1), COALESCE(d.name, 'Отдел не указан'),
+       u.registration_status, u.registration_comment, u.registration_reviewed_by,
+       COALESCE(rv.full_name, ''), datetime(u.registration_reviewed_at), datetime(u.created_at)
+FROM users u
+LEFT JOIN departments d ON d.id = u.department_id
+LEFT JOIN users rv ON rv.id = u.registration_reviewed_by
+`
+
+func scanRegistration(scanner interface{ Scan(...any) error }) (models.Registration, error) {
+	var item models.Registration
+	var reviewedAt sql.NullString
+	if err := scanner.Scan(&item.ID, &item.Login, &item.FullName, &item.Position, &item.DepartmentID, &item.DepartmentName,
+		&item.Status, &item.Comment, &item.ReviewedBy, &item.ReviewedByName, &reviewedAt, &item.CreatedAt); err != nil {
+		return models.Registration{}, err
+	}
+	if reviewedAt.Valid {
+		item.ReviewedAt = &reviewedAt.String
+	}
+	return item, nil
+}
+
+// Registrations возвращает заявки с указанным статусом; departmentIDs (если не nil) ограничивает выборку этими подразделениями.
+func (r *Repository) Registrations(ctx context.Context, status string, departmentIDs []int64) ([]models.Registration, error) {
+	query := registrationColumns + ` WHERE u.registration_status = ? AND u.is_service = 0`
+	args := []any{status}
+	if departmentIDs != nil {
+		placeholders, ids := int64Placeholders(departmentIDs)
+		query += ` AND u.department_id IN (` + placeholders + `)`
+		args = append(args, ids...)
+	}
+	query += ` ORDER BY u.id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query registrations: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.Registration, 0)
+	for rows.Next() {
+		item, err := scanRegistration(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan registration: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (r *Repository) Registration(ctx context.Context, userID int64) (models.Registration, error) {
+	item, err := scanRegistration(r.db.QueryRowContext(ctx, registrationColumns+` WHERE u.id = ? AND u.is_service = 0`, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Registration{}, errors.New("заявка не найдена")
+		}
+		return models.Registration{}, fmt.Errorf("query registration: %w", err)
+	}
+	return item, nil
+}
+
+func (r *Repository) ApproveRegistration(ctx context.Context, userID, reviewerID int64) error {
+	return r.reviewRegistration(ctx, userID, reviewerID, registrationApproved, "")
+}
+
+func (r *Repository) RejectRegistration(ctx context.Context, userID, reviewerID int64, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("укажите причину отклонения")
+	}
+	return r.reviewRegistration(ctx, userID, reviewerID, registrationRejected, reason)
+}
+
+// Рассмотреть можно только заявку, которая еще ожидает решения.
+func (r *Repository) reviewRegistration(ctx context.Context, userID, reviewerID int64, status, comment string) error {
+	return r.auditedUpdate(ctx, "user.registration_"+status, auditUser, userID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+UPDATE users
+SET registration_status = ?, registration_comment = ?, registration_reviewed_by = ?, registration_reviewed_at = CURRENT_TIMESTAMP
+WHERE id = ? AND registration_status = ?
+`, status, comment, reviewerID, userID, registrationPending)
+		if err != nil {
+			return fmt.Errorf("review registration: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("заявка не найдена или уже рассмотрена")
+		}
+		return nil
+	})
+}
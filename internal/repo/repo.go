@@ -2,87 +2,166 @@ package repo
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/filecrypt"
 	"github.com/mvd/taskflow/internal/models"
 )
 
 type Repository struct {
-	db     *sql.DB
-	pepper string
+	db        *sql.DB
+	passwords *auth.PasswordHasher
+	policy    auth.PasswordPolicy
+	directory *auth.LDAPDirectory
+	files     *filecrypt.Keyring
 }
 
-func New(db *sql.DB, pepper string) *Repository {
-	return &Repository{db: db, pepper: pepper}
+// directory может быть nil — тогда доступны только локальные учетные записи,
+// files — тогда загруженные файлы хранятся на диске без шифрования.
+func New(db *sql.DB, passwords *auth.PasswordHasher, policy auth.PasswordPolicy, directory *auth.LDAPDirectory, files *filecrypt.Keyring) *Repository {
+	return &Repository{db: db, passwords: passwords, policy: policy, directory: directory, files: files}
 }
 
-func (r *Repository) PasswordHash(password string) string {
-	raw := password + ":" + r.pepper
-	sum := sha256.Sum256([]byte(raw))
-	return hex.EncodeToString(sum[:])
+func (r *Repository) PasswordHash(password string) (string, error) {
+	return r.passwords.Hash(password)
 }
 
-func (r *Repository) Register(ctx context.Context, in models.RegisterInput) error {
+// Register создает заявку на регистрацию. Если createdBy > 0, учетную запись заводит
+// руководитель: она подтверждается сразу, но пароль нужно сменить при первом входе.
+func (r *Repository) Register(ctx context.Context, in models.RegisterInput, createdBy int64) error {
 	if in.Password != in.RepeatPassword {
 		return errors.New("пароли не совпадают")
 	}
 	if in.DepartmentID <= 0 {
 		return errors.New("укажите отдел")
 	}
-	hash := r.PasswordHash(in.Password)
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO users (login, password_hash, full_name, position, role, department_id)
-VALUES (?, ?, ?, ?, 'Member', ?)
-`, strings.TrimSpace(in.Login), hash, strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), in.DepartmentID)
+	if err := r.policy.Validate(in.Password, in.Login); err != nil {
+		return err
+	}
+	hash, err := r.PasswordHash(in.Password)
+	if err != nil {
+		return err
+	}
+	status, mustChange, reviewedAt, action := registrationPending, 0, any(nil), "user.register"
+	if createdBy > 0 {
+		status, mustChange, reviewedAt, action = registrationApproved, 1, time.Now().UTC().Format(sqliteTimeLayout), "user.create"
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO users (login, password_hash, full_name, position, role, department_id,
+                   must_change_password, registration_status, registration_reviewed_by, registration_reviewed_at)
+VALUES (?, ?, ?, ?, 'Member', ?, ?, ?, ?, ?)
+`, strings.TrimSpace(in.Login), hash, strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), in.DepartmentID,
+		mustChange, status, createdBy, reviewedAt)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			return errors.New("логин уже существует")
 		}
 		return fmt.Errorf("insert user: %w", err)
 	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("last insert id: %w", err)
+	}
+	if err := recordAudit(ctx, tx, action, auditUser, userID, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
 	return nil
 }
 
 func (r *Repository) Login(ctx context.Context, in models.LoginInput) (models.User, error) {
 	var u models.User
-	var passwordHash string
+	var passwordHash, authSource, registrationStatus, registrationComment string
+	var active, service int
 	err := r.db.QueryRowContext(ctx, `
 SELECT u.id, u.login, u.full_name, u.position, u.role, u.password_hash,
-       COALESCE(u.department_id, 1), COALESCE(d.name, 'Отдел не указан'), COALESCE(u.avatar_path, '')
+       COALESCE(u.department_id, 1), COALESCE(d.name, 'Отдел не указан'), COALESCE(u.avatar_path, ''),
+       u.auth_source, u.is_active, u.is_service, u.must_change_password, u.registration_status, u.registration_comment
 FROM users u
 LEFT JOIN departments d ON d.id = u.department_id
 WHERE u.login = ?
-`, strings.TrimSpace(in.Login)).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &passwordHash, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath)
+`, strings.TrimSpace(in.Login)).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &passwordHash, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath, &authSource, &active, &service, &u.MustChangePassword, &registrationStatus, &registrationComment)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			// Пользователя еще нет локально — он может быть в каталоге.
+			if r.directory != nil {
+				return r.loginDirectory(ctx, in)
+			}
 			return models.User{}, errors.New("неверный логин или пароль")
 		}
 		return models.User{}, fmt.Errorf("query user: %w", err)
 	}
+	// Сервисная учетная запись работает только по API-токену: входа по паролю у нее нет.
+	if service == 1 {
+		return models.User{}, errors.New("неверный логин или пароль")
+	}
+	if authSource == authSourceLDAP {
+		if r.directory == nil {
+			return models.User{}, errors.New("неверный логин или пароль")
+		}
+		return r.loginDirectory(ctx, in)
+	}
 
-	if passwordHash != r.PasswordHash(in.Password) {
+	// Локальные учетные записи проверяются всегда, даже при недоступном каталоге.
+	ok, needsRehash := r.passwords.Verify(passwordHash, in.Password)
+	if !ok {
 		return models.User{}, errors.New("неверный логин или пароль")
 	}
+	if active != 1 {
+		return models.User{}, errors.New("учетная запись отключена")
+	}
+	switch registrationStatus {
+	case registrationPending:
+		return models.User{}, errors.New("заявка на регистрацию ожидает подтверждения руководителем отдела")
+	case registrationRejected:
+		return models.User{}, errors.New("заявка на регистрацию отклонена: " + registrationComment)
+	}
+	if needsRehash {
+		if err := r.rehashPassword(ctx, u.ID, passwordHash, in.Password); err != nil {
+			log.Printf("rehash password for user %d: %v", u.ID, err)
+		}
+	}
 	return u, nil
 }
 
+func (r *Repository) rehashPassword(ctx context.Context, userID int64, oldHash, password string) error {
+	hash, err := r.PasswordHash(password)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?`, hash, userID, oldHash); err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+	return nil
+}
+
 func (r *Repository) Users(ctx context.Context) ([]models.User, error) {
 	return r.usersQuery(ctx, nil)
 }
 
-func (r *Repository) UsersByDepartment(ctx context.Context, departmentID int64) ([]models.User, error) {
-	return r.usersQuery(ctx, &departmentID)
+// UsersByDepartment возвращает пользователей перечисленных подразделений.
+func (r *Repository) UsersByDepartment(ctx context.Context, departmentIDs ...int64) ([]models.User, error) {
+	return r.usersQuery(ctx, departmentIDs)
 }
 
-func (r *Repository) usersQuery(ctx context.Context, departmentID *int64) ([]models.User, error) {
+func (r *Repository) usersQuery(ctx context.Context, departmentIDs []int64) ([]models.User, error) {
 	query := `
 SELECT u.id, u.login, u.full_name, u.position, u.role,
        COALESCE(u.department_id, 1),
@@ -90,11 +169,13 @@ SELECT u.id, u.login, u.full_name, u.position, u.role,
        COALESCE(u.avatar_path, '')
 FROM users u
 LEFT JOIN departments d ON d.id = u.department_id
+WHERE u.is_active = 1 AND u.is_service = 0 AND u.registration_status = 'approved'
 `
-	args := make([]any, 0, 1)
-	if departmentID != nil {
-		query += " WHERE u.department_id = ?"
-		args = append(args, *departmentID)
+	args := make([]any, 0, len(departmentIDs))
+	if departmentIDs != nil {
+		placeholders, ids := int64Placeholders(departmentIDs)
+		query += " AND u.department_id IN (" + placeholders + ")"
+		args = append(args, ids...)
 	}
 	query += " ORDER BY u.id"
 
@@ -121,11 +202,11 @@ func (r *Repository) UserByID(ctx context.Context, userID int64) (models.User, e
 SELECT u.id, u.login, u.full_name, u.position, u.role,
        COALESCE(u.department_id, 1),
        COALESCE(d.name, 'Отдел не указан'),
-       COALESCE(u.avatar_path, '')
+       COALESCE(u.avatar_path, ''), u.must_change_password
 FROM users u
 LEFT JOIN departments d ON d.id = u.department_id
 WHERE u.id = ?
-`, userID).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath)
+`, userID).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath, &u.MustChangePassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, errors.New("пользователь не найден")
@@ -152,11 +233,11 @@ func (r *Repository) UserByLogin(ctx context.Context, login string) (models.User
 SELECT u.id, u.login, u.full_name, u.position, u.role,
        COALESCE(u.department_id, 1),
        COALESCE(d.name, 'Отдел не указан'),
-       COALESCE(u.avatar_path, '')
+       COALESCE(u.avatar_path, ''), u.must_change_password
 FROM users u
 LEFT JOIN departments d ON d.id = u.department_id
 WHERE u.login = ?
-`, strings.TrimSpace(login)).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath)
+`, strings.TrimSpace(login)).Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath, &u.MustChangePassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, errors.New("пользователь не найден")
@@ -167,66 +248,155 @@ WHERE u.login = ?
 }
 
 func (r *Repository) UpdateUserRole(ctx context.Context, userID int64, role string) error {
-	res, err := r.db.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, strings.TrimSpace(role), userID)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("update user role: %w", err)
+		return fmt.Errorf("begin tx: %w", err)
 	}
-	affected, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	var oldRole string
+	if err := tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = ?`, userID).Scan(&oldRole); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("пользователь не найден")
+		}
+		return fmt.Errorf("load user role: %w", err)
+	}
+	before, err := auditState(ctx, tx, auditUser, userID)
 	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
+		return err
 	}
-	if affected == 0 {
-		return errors.New("пользователь не найден")
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, strings.TrimSpace(role), userID); err != nil {
+		return fmt.Errorf("update user role: %w", err)
+	}
+	if err := revokeSessionsOnDemotionTx(ctx, tx, userID, oldRole, role); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "user.role", auditUser, userID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
 	}
 	return nil
 }
 
 func (r *Repository) UpdateUser(ctx context.Context, userID int64, in models.UpdateUserInput) error {
-	res, err := r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldRole string
+	if err := tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = ?`, userID).Scan(&oldRole); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("пользователь не найден")
+		}
+		return fmt.Errorf("load user role: %w", err)
+	}
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
 UPDATE users
 SET login = ?, full_name = ?, position = ?, role = ?, department_id = ?
 WHERE id = ?
-`, strings.TrimSpace(in.Login), strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), strings.TrimSpace(in.Role), in.DepartmentID, userID)
-	if err != nil {
+`, strings.TrimSpace(in.Login), strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), strings.TrimSpace(in.Role), in.DepartmentID, userID); err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			return errors.New("логин уже существует")
 		}
 		return fmt.Errorf("update user: %w", err)
 	}
-	affected, err := res.RowsAffected()
+	if err := revokeSessionsOnDemotionTx(ctx, tx, userID, oldRole, in.Role); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "user.update", auditUser, userID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// revokeSessionsOnDemotionTx завершает сессии пользователя, если новая роль не дает хотя бы
+// одного права старой: открытые сессии не должны сохранять отобранный доступ.
+func revokeSessionsOnDemotionTx(ctx context.Context, tx *sql.Tx, userID int64, oldRole, newRole string) error {
+	demoted, err := roleNarrowsTx(ctx, tx, oldRole, newRole)
 	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
+		return err
 	}
-	if affected == 0 {
-		return errors.New("пользователь не найден")
+	if !demoted {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("revoke sessions of demoted user: %w", err)
 	}
 	return nil
 }
 
 func (r *Repository) UpdateProfile(ctx context.Context, userID int64, in models.UpdateProfileInput) error {
 	if strings.TrimSpace(in.Password) == "" {
-		res, err := r.db.ExecContext(ctx, `
+		return r.auditedUpdate(ctx, "user.profile", auditUser, userID, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, `
 UPDATE users
 SET full_name = ?, position = ?
 WHERE id = ?
 `, strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), userID)
-		if err != nil {
-			return fmt.Errorf("update profile: %w", err)
-		}
-		affected, err := res.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("rows affected: %w", err)
-		}
-		if affected == 0 {
+			if err != nil {
+				return fmt.Errorf("update profile: %w", err)
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("rows affected: %w", err)
+			}
+			if affected == 0 {
+				return errors.New("пользователь не найден")
+			}
+			return nil
+		})
+	}
+	var login, authSource, currentHash string
+	if err := r.db.QueryRowContext(ctx, `SELECT login, auth_source, password_hash FROM users WHERE id = ?`, userID).Scan(&login, &authSource, &currentHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
 			return errors.New("пользователь не найден")
 		}
-		return nil
+		return fmt.Errorf("load auth source: %w", err)
+	}
+	switch authSource {
+	case authSourceLDAP:
+		return errors.New("пароль учетной записи из каталога меняется в Active Directory")
+	case authSourceOIDC:
+		return errors.New("пароль учетной записи SSO меняется у провайдера входа")
 	}
-	res, err := r.db.ExecContext(ctx, `
+	if err := r.policy.Validate(in.Password, login); err != nil {
+		return err
+	}
+	if same, _ := r.passwords.Verify(currentHash, in.Password); same {
+		return errors.New("новый пароль должен отличаться от текущего")
+	}
+	hash, err := r.PasswordHash(in.Password)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
 UPDATE users
-SET full_name = ?, position = ?, password_hash = ?
+SET full_name = ?, position = ?, password_hash = ?, must_change_password = 0
 WHERE id = ?
-`, strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), r.PasswordHash(in.Password), userID)
+`, strings.TrimSpace(in.FullName), strings.TrimSpace(in.Position), hash, userID)
 	if err != nil {
 		return fmt.Errorf("update profile password: %w", err)
 	}
@@ -237,6 +407,19 @@ WHERE id = ?
 	if affected == 0 {
 		return errors.New("пользователь не найден")
 	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("revoke sessions after password change: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete password resets: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "user.profile", auditUser, userID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
 	return nil
 }
 
@@ -254,12 +437,22 @@ func (r *Repository) DeleteUser(ctx context.Context, userID int64) (string, erro
 		}
 		return "", fmt.Errorf("load user department: %w", err)
 	}
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return "", err
+	}
 
 	replacementUserID, replacementName, err := r.pickReplacementUserIDTx(ctx, tx, userID, departmentID)
 	if err != nil {
 		return "", err
 	}
 
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return "", fmt.Errorf("delete sessions by user: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = ?`, userID); err != nil {
+		return "", fmt.Errorf("delete api tokens by user: %w", err)
+	}
 	if _, err := tx.ExecContext(ctx, `DELETE FROM project_curators WHERE user_id = ?`, userID); err != nil {
 		return "", fmt.Errorf("delete project_curators by user: %w", err)
 	}
@@ -297,6 +490,9 @@ func (r *Repository) DeleteUser(ctx context.Context, userID int64) (string, erro
 	if affected == 0 {
 		return "", errors.New("пользователь не найден")
 	}
+	if err := recordAudit(ctx, tx, "user.delete", auditUser, userID, before); err != nil {
+		return "", err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("commit delete user: %w", err)
@@ -310,16 +506,10 @@ func (r *Repository) pickReplacementUserIDTx(ctx context.Context, tx *sql.Tx, de
 	err := tx.QueryRowContext(ctx, `
 SELECT id, COALESCE(full_name, login)
 FROM users
-WHERE id <> ?
+WHERE id <> ? AND registration_status = 'approved'
 ORDER BY
   CASE WHEN COALESCE(department_id, 1) = ? THEN 0 ELSE 1 END,
-  CASE role
-    WHEN 'Owner' THEN 0
-    WHEN 'Admin' THEN 1
-    WHEN 'Deputy Admin' THEN 2
-    WHEN 'Project Manager' THEN 3
-    ELSE 4
-  END,
+  `+roleRankSQL("users.role")+`,
   id
 LIMIT 1
 `, deletingUserID, deletingDepartmentID).Scan(&replacementID, &replacementName)
@@ -348,7 +538,7 @@ FROM reports r
 LEFT JOIN tasks t ON lower(r.target_type) = 'task' AND t.id = r.target_id
 LEFT JOIN projects pt ON pt.id = t.project_id
 LEFT JOIN projects pp ON lower(r.target_type) = 'project' AND pp.id = r.target_id
-WHERE r.id = ?
+WHERE r.id = ? AND `+liveReportSQL("r")+`
 `, reportID).Scan(&targetType, &targetID, &authorID, &departmentID, &filePath, &fileName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -359,35 +549,40 @@ WHERE r.id = ?
 	return targetType, targetID, authorID, departmentID, filePath, fileName, nil
 }
 
-func (r *Repository) DeleteReport(ctx context.Context, reportID int64) error {
-	res, err := r.db.ExecContext(ctx, `DELETE FROM reports WHERE id = ?`, reportID)
-	if err != nil {
-		return fmt.Errorf("delete report: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("отчет не найден")
-	}
-	return nil
+// DeleteReport переносит отчет в корзину; файл остается на диске до очистки корзины.
+func (r *Repository) DeleteReport(ctx context.Context, reportID, actorID int64) error {
+	return r.auditedUpdate(ctx, "report.delete", auditReport, reportID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+UPDATE reports SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ? AND deleted_at IS NULL
+`, actorID, reportID)
+		if err != nil {
+			return fmt.Errorf("delete report: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("отчет не найден")
+		}
+		return nil
+	})
 }
 
 func (r *Repository) Projects(ctx context.Context) ([]models.Project, error) {
 	return r.projectsQuery(ctx, nil)
 }
 
-func (r *Repository) ProjectsByDepartment(ctx context.Context, departmentID int64) ([]models.Project, error) {
-	return r.projectsQuery(ctx, &departmentID)
+func (r *Repository) ProjectsByDepartment(ctx context.Context, departmentIDs ...int64) ([]models.Project, error) {
+	return r.projectsQuery(ctx, departmentIDs)
 }
 
 func (r *Repository) ProjectsByUser(ctx context.Context, userID int64) ([]models.Project, error) {
 	query := `
-SELECT p.id, p.key, p.name, p.status, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), p.curator_user_id
+SELECT p.id, p.key, p.name, p.status, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), p.curator_user_id, p.workflow_id
 FROM projects p
 LEFT JOIN departments d ON d.id = p.department_id
-WHERE EXISTS (
+WHERE p.deleted_at IS NULL AND EXISTS (
   SELECT 1
   FROM (
     SELECT user_id FROM project_assignees WHERE project_id = p.id
@@ -407,7 +602,7 @@ ORDER BY p.id
 	result := make([]models.Project, 0)
 	for rows.Next() {
 		var p models.Project
-		if err := rows.Scan(&p.ID, &p.Key, &p.Name, &p.Status, &p.DepartmentID, &p.DepartmentName, &p.CuratorUserID); err != nil {
+		if err := rows.Scan(&p.ID, &p.Key, &p.Name, &p.Status, &p.DepartmentID, &p.DepartmentName, &p.CuratorUserID, &p.WorkflowID); err != nil {
 			return nil, fmt.Errorf("scan project by user: %w", err)
 		}
 
@@ -432,16 +627,18 @@ ORDER BY p.id
 	return result, rows.Err()
 }
 
-func (r *Repository) projectsQuery(ctx context.Context, departmentID *int64) ([]models.Project, error) {
+func (r *Repository) projectsQuery(ctx context.Context, departmentIDs []int64) ([]models.Project, error) {
 	query := `
-SELECT p.id, p.key, p.name, p.status, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), p.curator_user_id
+SELECT p.id, p.key, p.name, p.status, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), p.curator_user_id, p.workflow_id
 FROM projects p
 LEFT JOIN departments d ON d.id = p.department_id
+WHERE p.deleted_at IS NULL
 `
 	args := make([]any, 0)
-	if departmentID != nil {
-		query += " WHERE p.department_id = ?"
-		args = append(args, *departmentID)
+	if departmentIDs != nil {
+		placeholders, ids := int64Placeholders(departmentIDs)
+		query += " AND p.department_id IN (" + placeholders + ")"
+		args = append(args, ids...)
 	}
 	query += " ORDER BY p.id"
 	rows, err := r.db.QueryContext(ctx, query, args...)
@@ -453,7 +650,7 @@ LEFT JOIN departments d ON d.id = p.department_id
 	result := make([]models.Project, 0)
 	for rows.Next() {
 		var p models.Project
-		if err := rows.Scan(&p.ID, &p.Key, &p.Name, &p.Status, &p.DepartmentID, &p.DepartmentName, &p.CuratorUserID); err != nil {
+		if err := rows.Scan(&p.ID, &p.Key, &p.Name, &p.Status, &p.DepartmentID, &p.DepartmentName, &p.CuratorUserID, &p.WorkflowID); err != nil {
 			return nil, fmt.Errorf("scan project: %w", err)
 		}
 
@@ -486,25 +683,24 @@ func (r *Repository) CreateProject(ctx context.Context, in models.CreateProjectI
 	}
 	defer tx.Rollback()
 
-	projectID, err := nextFreeProjectID(ctx, tx)
-	if err != nil {
-		return err
-	}
-	key := strings.TrimSpace(in.Key)
-	if key == "" {
-		key = fmt.Sprintf("PRJ-%d", projectID)
-	}
-
+	// Ключ по умолчанию — PRJ-{id}: AUTOINCREMENT выдает следующий номер после sqlite_sequence.
 	primaryCuratorID := in.CuratorIDs[0]
-	if _, err := tx.ExecContext(ctx, `
-INSERT INTO projects (id, key, name, status, department_id, curator_user_id)
-VALUES (?, ?, ?, 'Активен', ?, ?)
-`, projectID, key, strings.TrimSpace(in.Name), in.DepartmentID, primaryCuratorID); err != nil {
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO projects (key, name, status, department_id, curator_user_id, workflow_id)
+VALUES (
+  COALESCE(NULLIF(?, ''), 'PRJ-' || (SELECT COALESCE(MAX(seq), 0) + 1 FROM sqlite_sequence WHERE name = 'projects')),
+  ?, 'Активен', ?, ?, (SELECT id FROM workflows WHERE is_default = 1 ORDER BY id LIMIT 1))
+`, strings.TrimSpace(in.Key), strings.TrimSpace(in.Name), in.DepartmentID, primaryCuratorID)
+	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			return errors.New("ключ проекта уже существует")
 		}
 		return fmt.Errorf("insert project: %w", err)
 	}
+	projectID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("last insert project id: %w", err)
+	}
 
 	for _, uid := range in.CuratorIDs {
 		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO project_curators (project_id, user_id) VALUES (?, ?)`, projectID, uid); err != nil {
@@ -516,6 +712,9 @@ VALUES (?, ?, ?, 'Активен', ?, ?)
 			return fmt.Errorf("insert project assignee: %w", err)
 		}
 	}
+	if err := recordAudit(ctx, tx, "project.create", auditProject, projectID, nil); err != nil {
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit tx: %w", err)
@@ -530,12 +729,16 @@ func (r *Repository) UpdateProject(ctx context.Context, projectID int64, in mode
 	}
 	defer tx.Rollback()
 
+	before, err := auditState(ctx, tx, auditProject, projectID)
+	if err != nil {
+		return err
+	}
 	primaryCuratorID := in.CuratorIDs[0]
 	key := strings.TrimSpace(in.Key)
 	res, err := tx.ExecContext(ctx, `
 UPDATE projects
 SET key = COALESCE(NULLIF(?, ''), key), name = ?, department_id = ?, curator_user_id = ?
-WHERE id = ?
+WHERE id = ? AND deleted_at IS NULL
 `, key, strings.TrimSpace(in.Name), in.DepartmentID, primaryCuratorID, projectID)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
@@ -550,6 +753,11 @@ WHERE id = ?
 	if affected == 0 {
 		return errors.New("проект не найден")
 	}
+	if oldKey, _ := before["key"].(string); key != "" && key != oldKey {
+		if err := rekeyProjectTasksTx(ctx, tx, projectID, oldKey, key); err != nil {
+			return err
+		}
+	}
 
 	if _, err := tx.ExecContext(ctx, `DELETE FROM project_curators WHERE project_id = ?`, projectID); err != nil {
 		return fmt.Errorf("clear project curators: %w", err)
@@ -568,6 +776,9 @@ WHERE id = ?
 			return fmt.Errorf("insert project assignee: %w", err)
 		}
 	}
+	if err := recordAudit(ctx, tx, "project.update", auditProject, projectID, before); err != nil {
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit tx: %w", err)
@@ -575,45 +786,25 @@ WHERE id = ?
 	return nil
 }
 
-func (r *Repository) DeleteProject(ctx context.Context, projectID int64) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, `DELETE FROM task_curators WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, projectID); err != nil {
-		return fmt.Errorf("delete task curators by project: %w", err)
-	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM task_assignees WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`, projectID); err != nil {
-		return fmt.Errorf("delete task assignees by project: %w", err)
-	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE project_id = ?`, projectID); err != nil {
-		return fmt.Errorf("delete tasks by project: %w", err)
-	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM project_curators WHERE project_id = ?`, projectID); err != nil {
-		return fmt.Errorf("delete project curators: %w", err)
-	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM project_assignees WHERE project_id = ?`, projectID); err != nil {
-		return fmt.Errorf("delete project assignees: %w", err)
-	}
-
-	res, err := tx.ExecContext(ctx, `DELETE FROM projects WHERE id = ?`, projectID)
-	if err != nil {
-		return fmt.Errorf("delete project: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("проект не найден")
-	}
-
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
-	}
-	return nil
+// DeleteProject переносит проект в корзину. Задачи и отчеты проекта остаются как есть и
+// скрываются вместе с ним, а после восстановления возвращаются.
+func (r *Repository) DeleteProject(ctx context.Context, projectID, actorID int64) error {
+	return r.auditedUpdate(ctx, "project.delete", auditProject, projectID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+UPDATE projects SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ? AND deleted_at IS NULL
+`, actorID, projectID)
+		if err != nil {
+			return fmt.Errorf("delete project: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("проект не найден")
+		}
+		return nil
+	})
 }
 
 func (r *Repository) IsProjectAssignee(ctx context.Context, projectID, userID int64) (bool, error) {
@@ -655,32 +846,58 @@ LIMIT 1
 }
 
 func (r *Repository) Tasks(ctx context.Context, projectID *int64) ([]models.Task, error) {
-	return r.tasksQuery(ctx, projectID, nil)
+	return r.tasksQuery(ctx, taskFilter{projectID: projectID})
 }
 
-func (r *Repository) TasksByDepartment(ctx context.Context, departmentID int64) ([]models.Task, error) {
-	return r.tasksQuery(ctx, nil, &departmentID)
+func (r *Repository) TasksByDepartment(ctx context.Context, departmentIDs ...int64) ([]models.Task, error) {
+	return r.tasksQuery(ctx, taskFilter{departmentIDs: departmentIDs})
+}
+
+// Subtasks возвращает прямые подзадачи задачи.
+func (r *Repository) Subtasks(ctx context.Context, parentID int64) ([]models.Task, error) {
+	return r.tasksQuery(ctx, taskFilter{parentID: &parentID})
+}
+
+// TaskByID возвращает одну задачу со связями и сводкой по подзадачам.
+func (r *Repository) TaskByID(ctx context.Context, taskID int64) (models.Task, error) {
+	tasks, err := r.tasksQuery(ctx, taskFilter{taskID: &taskID})
+	if err != nil {
+		return models.Task{}, err
+	}
+	if len(tasks) == 0 {
+		return models.Task{}, errors.New("задача не найдена")
+	}
+	return tasks[0], nil
 }
 
 func (r *Repository) TasksByUser(ctx context.Context, userID int64) ([]models.Task, error) {
+	rollups, err := r.taskRollups(ctx)
+	if err != nil {
+		return nil, err
+	}
 	query := `
 SELECT t.id, t.key, t.title, t.description, t.type, t.status, t.priority,
        t.project_id, p.key, p.name, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), t.curator_user_id, t.due_date,
-       COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), COALESCE(ru.full_name, '')
+       COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), COALESCE(ru.full_name, ''), t.route_unit_id,
+       COALESCE(t.parent_task_id, 0),
+       EXISTS (SELECT 1 FROM task_links l JOIN tasks b ON b.id = l.source_task_id
+               WHERE l.target_task_id = t.id AND l.kind = 'blocks' AND NOT ` + taskTerminalSQL("b") + `
+                 AND ` + liveTaskSQL("b") + `)
 FROM tasks t
 JOIN projects p ON p.id = t.project_id
 LEFT JOIN departments d ON d.id = p.department_id
 LEFT JOIN users ru ON ru.id = t.route_owner_user_id
-WHERE EXISTS (
-  SELECT 1
-  FROM (
-    SELECT user_id FROM task_assignees WHERE task_id = t.id
-    UNION
-    SELECT user_id FROM task_curators WHERE task_id = t.id
-  ) x
-  WHERE x.user_id = ?
-)
-OR COALESCE(t.route_owner_user_id, 0) = ?
+WHERE t.deleted_at IS NULL AND p.deleted_at IS NULL
+  AND (EXISTS (
+    SELECT 1
+    FROM (
+      SELECT user_id FROM task_assignees WHERE task_id = t.id
+      UNION
+      SELECT user_id FROM task_curators WHERE task_id = t.id
+    ) x
+    WHERE x.user_id = ?
+  )
+  OR COALESCE(t.route_owner_user_id, 0) = ?)
 ORDER BY t.id
 `
 	rows, err := r.db.QueryContext(ctx, query, userID, userID)
@@ -693,7 +910,7 @@ ORDER BY t.id
 	for rows.Next() {
 		var t models.Task
 		var due sql.NullString
-		if err := rows.Scan(&t.ID, &t.Key, &t.Title, &t.Description, &t.Type, &t.Status, &t.Priority, &t.ProjectID, &t.ProjectKey, &t.ProjectName, &t.DepartmentID, &t.DepartmentName, &t.CuratorUserID, &due, &t.RouteStage, &t.RouteOwnerID, &t.RouteOwnerName); err != nil {
+		if err := rows.Scan(&t.ID, &t.Key, &t.Title, &t.Description, &t.Type, &t.Status, &t.Priority, &t.ProjectID, &t.ProjectKey, &t.ProjectName, &t.DepartmentID, &t.DepartmentName, &t.CuratorUserID, &due, &t.RouteStage, &t.RouteOwnerID, &t.RouteOwnerName, &t.RouteUnitID, &t.ParentTaskID, &t.Blocked); err != nil {
 			return nil, fmt.Errorf("scan task by user: %w", err)
 		}
 		if due.Valid {
@@ -721,34 +938,61 @@ ORDER BY t.id
 			return nil, err
 		}
 		t.Assignees = assignees
+		if rollup, ok := rollups[t.ID]; ok {
+			t.Subtasks = &rollup
+		}
 		result = append(result, t)
 	}
 	return result, rows.Err()
 }
 
-func (r *Repository) tasksQuery(ctx context.Context, projectID *int64, departmentID *int64) ([]models.Task, error) {
+// taskFilter — условия выборки задач; пустой фильтр выбирает все задачи, кроме лежащих в корзине.
+type taskFilter struct {
+	projectID     *int64
+	parentID      *int64
+	taskID        *int64
+	departmentIDs []int64
+}
+
+func (r *Repository) tasksQuery(ctx context.Context, f taskFilter) ([]models.Task, error) {
+	rollups, err := r.taskRollups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 SELECT t.id, t.key, t.title, t.description, t.type, t.status, t.priority,
        t.project_id, p.key, p.name, COALESCE(p.department_id, 1), COALESCE(d.name, 'Отдел не указан'), t.curator_user_id, t.due_date,
-       COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), COALESCE(ru.full_name, '')
+       COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), COALESCE(ru.full_name, ''), t.route_unit_id,
+       COALESCE(t.parent_task_id, 0),
+       EXISTS (SELECT 1 FROM task_links l JOIN tasks b ON b.id = l.source_task_id
+               WHERE l.target_task_id = t.id AND l.kind = 'blocks' AND NOT ` + taskTerminalSQL("b") + `
+                 AND ` + liveTaskSQL("b") + `)
 FROM tasks t
 JOIN projects p ON p.id = t.project_id
 LEFT JOIN departments d ON d.id = p.department_id
 LEFT JOIN users ru ON ru.id = t.route_owner_user_id
 `
 	args := make([]any, 0)
-	conds := make([]string, 0, 2)
-	if projectID != nil {
+	conds := []string{"t.deleted_at IS NULL", "p.deleted_at IS NULL"}
+	if f.projectID != nil {
 		conds = append(conds, "t.project_id = ?")
-		args = append(args, *projectID)
+		args = append(args, *f.projectID)
 	}
-	if departmentID != nil {
-		conds = append(conds, "p.department_id = ?")
-		args = append(args, *departmentID)
+	if f.parentID != nil {
+		conds = append(conds, "t.parent_task_id = ?")
+		args = append(args, *f.parentID)
 	}
-	if len(conds) > 0 {
-		query += " WHERE " + strings.Join(conds, " AND ")
+	if f.taskID != nil {
+		conds = append(conds, "t.id = ?")
+		args = append(args, *f.taskID)
 	}
+	if f.departmentIDs != nil {
+		placeholders, ids := int64Placeholders(f.departmentIDs)
+		conds = append(conds, "p.department_id IN ("+placeholders+")")
+		args = append(args, ids...)
+	}
+	query += " WHERE " + strings.Join(conds, " AND ")
 	query += " ORDER BY t.id"
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
@@ -761,7 +1005,7 @@ LEFT JOIN users ru ON ru.id = t.route_owner_user_id
 	for rows.Next() {
 		var t models.Task
 		var due sql.NullString
-		if err := rows.Scan(&t.ID, &t.Key, &t.Title, &t.Description, &t.Type, &t.Status, &t.Priority, &t.ProjectID, &t.ProjectKey, &t.ProjectName, &t.DepartmentID, &t.DepartmentName, &t.CuratorUserID, &due, &t.RouteStage, &t.RouteOwnerID, &t.RouteOwnerName); err != nil {
+		if err := rows.Scan(&t.ID, &t.Key, &t.Title, &t.Description, &t.Type, &t.Status, &t.Priority, &t.ProjectID, &t.ProjectKey, &t.ProjectName, &t.DepartmentID, &t.DepartmentName, &t.CuratorUserID, &due, &t.RouteStage, &t.RouteOwnerID, &t.RouteOwnerName, &t.RouteUnitID, &t.ParentTaskID, &t.Blocked); err != nil {
 			return nil, fmt.Errorf("scan task: %w", err)
 		}
 		if due.Valid {
@@ -789,6 +1033,9 @@ LEFT JOIN users ru ON ru.id = t.route_owner_user_id
 			return nil, err
 		}
 		t.Assignees = assignees
+		if rollup, ok := rollups[t.ID]; ok {
+			t.Subtasks = &rollup
+		}
 		result = append(result, t)
 	}
 
@@ -802,13 +1049,15 @@ func (r *Repository) CreateTask(ctx context.Context, in models.CreateTaskInput)
 	}
 	defer tx.Rollback()
 
-	taskID, err := nextFreeTaskID(ctx, tx)
-	if err != nil {
-		return err
-	}
 	key := strings.TrimSpace(in.Key)
 	if key == "" {
-		key = fmt.Sprintf("TSK-%d", taskID)
+		if key, err = nextTaskKeyTx(ctx, tx, in.ProjectID); err != nil {
+			return err
+		}
+	} else if taken, err := taskKeyTakenTx(ctx, tx, key, 0); err != nil {
+		return err
+	} else if taken {
+		return errors.New("ключ задачи уже существует")
 	}
 
 	primaryCuratorID := in.CuratorIDs[0]
@@ -817,15 +1066,48 @@ func (r *Repository) CreateTask(ctx context.Context, in models.CreateTaskInput)
 		routeStage = 4
 	}
 	routeOwnerID := in.RouteOwnerID
-	if _, err := tx.ExecContext(ctx, `
-INSERT INTO tasks (id, key, title, description, type, status, priority, project_id, curator_user_id, due_date, route_stage, route_owner_user_id)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-`, taskID, key, strings.TrimSpace(in.Title), strings.TrimSpace(in.Description), strings.TrimSpace(in.Type), strings.TrimSpace(in.Status), strings.TrimSpace(in.Priority), in.ProjectID, primaryCuratorID, in.DueDate, routeStage, routeOwnerID); err != nil {
+	routeUnitID := in.RouteUnitID
+	var parentID any
+	if in.ParentTaskID > 0 {
+		if err := validateTaskParentTx(ctx, tx, 0, in.ParentTaskID, in.ProjectID); err != nil {
+			return err
+		}
+		parentID = in.ParentTaskID
+	}
+	// Задача начинает с начального статуса процесса проекта; другой статус допустим, только
+	// если процесс разрешает автору переход в него из начального. Закрытой задачу не создать:
+	// закрытие проверяет подзадачи и фиксируется отдельно.
+	wf, err := projectWorkflow(ctx, tx, in.ProjectID)
+	if err != nil {
+		return err
+	}
+	status := strings.TrimSpace(in.Status)
+	if status == "" {
+		status = initialStatus(wf)
+	} else if err := checkTransition(wf, initialStatus(wf), status, in.ActorRole); err != nil {
+		return err
+	} else if st, _ := findWorkflowStatus(wf.Statuses, status); st.Terminal {
+		return fmt.Errorf("задачу нельзя создать в конечном статусе %q", status)
+	}
+	if routeUnitID <= 0 {
+		if err := tx.QueryRowContext(ctx, `SELECT COALESCE(department_id, 0) FROM projects WHERE id = ?`, in.ProjectID).Scan(&routeUnitID); err != nil {
+			return fmt.Errorf("task route unit: %w", err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO tasks (key, title, description, type, status, priority, project_id, curator_user_id, due_date, route_stage, route_owner_user_id, route_unit_id, parent_task_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, key, strings.TrimSpace(in.Title), strings.TrimSpace(in.Description), strings.TrimSpace(in.Type), status, strings.TrimSpace(in.Priority), in.ProjectID, primaryCuratorID, in.DueDate, routeStage, routeOwnerID, routeUnitID, parentID)
+	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			return errors.New("ключ задачи уже существует")
 		}
 		return fmt.Errorf("insert task: %w", err)
 	}
+	taskID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("last insert task id: %w", err)
+	}
 
 	for _, uid := range in.CuratorIDs {
 		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO task_curators (task_id, user_id) VALUES (?, ?)`, taskID, uid); err != nil {
@@ -838,6 +1120,9 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			return fmt.Errorf("insert task assignee: %w", err)
 		}
 	}
+	if err := recordAudit(ctx, tx, "task.create", auditTask, taskID, nil); err != nil {
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit tx: %w", err)
@@ -865,39 +1150,43 @@ LIMIT 1
 	return u, nil
 }
 
-func (r *Repository) TaskRouteState(ctx context.Context, taskID int64) (stage int64, ownerID, departmentID int64, err error) {
+// TaskRouteState возвращает этап СЭД, текущего ответственного, подразделение, где сейчас
+// находится задача, и отдел ее проекта.
+func (r *Repository) TaskRouteState(ctx context.Context, taskID int64) (stage, ownerID, unitID, departmentID int64, err error) {
 	err = r.db.QueryRowContext(ctx, `
-SELECT COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), COALESCE(p.department_id, 1)
+SELECT COALESCE(t.route_stage, 4), COALESCE(t.route_owner_user_id, 0), t.route_unit_id, COALESCE(p.department_id, 1)
 FROM tasks t
 JOIN projects p ON p.id = t.project_id
-WHERE t.id = ?
-`, taskID).Scan(&stage, &ownerID, &departmentID)
+WHERE t.id = ? AND t.deleted_at IS NULL AND p.deleted_at IS NULL
+`, taskID).Scan(&stage, &ownerID, &unitID, &departmentID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0, 0, 0, errors.New("задача не найдена")
+			return 0, 0, 0, 0, errors.New("задача не найдена")
 		}
-		return 0, 0, 0, fmt.Errorf("task route state: %w", err)
+		return 0, 0, 0, 0, fmt.Errorf("task route state: %w", err)
 	}
-	return stage, ownerID, departmentID, nil
+	return stage, ownerID, unitID, departmentID, nil
 }
 
-func (r *Repository) UpdateTaskRoute(ctx context.Context, taskID, ownerID, stage int64) error {
-	res, err := r.db.ExecContext(ctx, `
+func (r *Repository) UpdateTaskRoute(ctx context.Context, taskID, ownerID, stage, unitID int64) error {
+	return r.auditedUpdate(ctx, "task.route", auditTask, taskID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
 UPDATE tasks
-SET route_owner_user_id = ?, route_stage = ?
-WHERE id = ?
-`, ownerID, stage, taskID)
-	if err != nil {
-		return fmt.Errorf("update task route: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("task route rows affected: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("задача не найдена")
-	}
-	return nil
+SET route_owner_user_id = ?, route_stage = ?, route_unit_id = ?
+WHERE id = ? AND deleted_at IS NULL
+`, ownerID, stage, unitID, taskID)
+		if err != nil {
+			return fmt.Errorf("update task route: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("task route rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("задача не найдена")
+		}
+		return nil
+	})
 }
 
 func (r *Repository) UpdateTask(ctx context.Context, taskID int64, in models.UpdateTaskInput) error {
@@ -907,17 +1196,29 @@ func (r *Repository) UpdateTask(ctx context.Context, taskID int64, in models.Upd
 	}
 	defer tx.Rollback()
 
+	before, err := auditState(ctx, tx, auditTask, taskID)
+	if err != nil {
+		return err
+	}
+	if before == nil || before["deleted_at"] != nil {
+		return errors.New("задача не найдена")
+	}
+	if err := updateTaskParentTx(ctx, tx, taskID, before, in); err != nil {
+		return err
+	}
+	if err := checkTaskStatusChangeTx(ctx, tx, taskID, before, in); err != nil {
+		return err
+	}
+	if err := updateTaskKeyTx(ctx, tx, taskID, before, in); err != nil {
+		return err
+	}
 	primaryCuratorID := in.CuratorIDs[0]
-	key := strings.TrimSpace(in.Key)
 	res, err := tx.ExecContext(ctx, `
 UPDATE tasks
-SET key = COALESCE(NULLIF(?, ''), key), title = ?, description = ?, type = ?, status = ?, priority = ?, project_id = ?, curator_user_id = ?, due_date = ?
-WHERE id = ?
-`, key, strings.TrimSpace(in.Title), strings.TrimSpace(in.Description), strings.TrimSpace(in.Type), strings.TrimSpace(in.Status), strings.TrimSpace(in.Priority), in.ProjectID, primaryCuratorID, in.DueDate, taskID)
+SET title = ?, description = ?, type = ?, status = ?, priority = ?, project_id = ?, curator_user_id = ?, due_date = ?
+WHERE id = ? AND `+liveTaskSQL("tasks")+`
+`, strings.TrimSpace(in.Title), strings.TrimSpace(in.Description), strings.TrimSpace(in.Type), strings.TrimSpace(in.Status), strings.TrimSpace(in.Priority), in.ProjectID, primaryCuratorID, in.DueDate, taskID)
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique") {
-			return errors.New("ключ задачи уже существует")
-		}
 		return fmt.Errorf("update task: %w", err)
 	}
 	affected, err := res.RowsAffected()
@@ -945,6 +1246,9 @@ WHERE id = ?
 			return fmt.Errorf("insert task assignee: %w", err)
 		}
 	}
+	if err := recordAudit(ctx, tx, "task.update", auditTask, taskID, before); err != nil {
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit tx: %w", err)
@@ -952,36 +1256,31 @@ WHERE id = ?
 	return nil
 }
 
-func (r *Repository) DeleteTask(ctx context.Context, taskID int64) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, `DELETE FROM task_assignees WHERE task_id = ?`, taskID); err != nil {
-		return fmt.Errorf("delete task assignees: %w", err)
-	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM task_curators WHERE task_id = ?`, taskID); err != nil {
-		return fmt.Errorf("delete task curators: %w", err)
-	}
-
-	res, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id = ?`, taskID)
-	if err != nil {
-		return fmt.Errorf("delete task: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("задача не найдена")
-	}
-
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
-	}
-	return nil
+// DeleteTask переносит задачу в корзину. Задачу с подзадачами удалить нельзя.
+func (r *Repository) DeleteTask(ctx context.Context, taskID, actorID int64) error {
+	return r.auditedUpdate(ctx, "task.delete", auditTask, taskID, func(tx *sql.Tx) error {
+		var children int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks WHERE parent_task_id = ? AND deleted_at IS NULL`, taskID).Scan(&children); err != nil {
+			return fmt.Errorf("count subtasks: %w", err)
+		}
+		if children > 0 {
+			return errors.New("у задачи есть подзадачи: удалите их или перенесите к другой задаче")
+		}
+		res, err := tx.ExecContext(ctx, `
+UPDATE tasks SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ? AND `+liveTaskSQL("tasks")+`
+`, actorID, taskID)
+		if err != nil {
+			return fmt.Errorf("delete task: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("задача не найдена")
+		}
+		return nil
+	})
 }
 
 func (r *Repository) IsTaskAssignee(ctx context.Context, taskID, userID int64) (bool, error) {
@@ -1022,19 +1321,27 @@ LIMIT 1
 	return true, nil
 }
 
-func (r *Repository) CloseTask(ctx context.Context, taskID int64) error {
-	res, err := r.db.ExecContext(ctx, `UPDATE tasks SET status = 'Done' WHERE id = ?`, taskID)
+// CloseTask закрывает задачу от имени роли role; force закрывает вместе с ней незакрытые подзадачи.
+// Возвращает предупреждения о незакрытых задачах, связанных с ней блокировкой.
+func (r *Repository) CloseTask(ctx context.Context, taskID int64, force bool, role string) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("close task: %w", err)
+		return nil, fmt.Errorf("begin tx: %w", err)
 	}
-	affected, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	warnings, err := taskCloseWarningsTx(ctx, tx, taskID)
 	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
+		return nil, err
 	}
-	if affected == 0 {
-		return errors.New("задача не найдена")
+	if err := closeTaskTx(ctx, tx, taskID, force, role); err != nil {
+		return nil, err
 	}
-	return nil
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return warnings, nil
 }
 
 func (r *Repository) CloseProject(ctx context.Context, projectID int64) error {
@@ -1044,7 +1351,11 @@ func (r *Repository) CloseProject(ctx context.Context, projectID int64) error {
 	}
 	defer tx.Rollback()
 
-	res, err := tx.ExecContext(ctx, `UPDATE projects SET status = 'Закрыт' WHERE id = ?`, projectID)
+	before, err := auditState(ctx, tx, auditProject, projectID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE projects SET status = 'Закрыт' WHERE id = ? AND deleted_at IS NULL`, projectID)
 	if err != nil {
 		return fmt.Errorf("close project: %w", err)
 	}
@@ -1055,8 +1366,11 @@ func (r *Repository) CloseProject(ctx context.Context, projectID int64) error {
 	if affected == 0 {
 		return errors.New("проект не найден")
 	}
-	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = 'Done' WHERE project_id = ?`, projectID); err != nil {
-		return fmt.Errorf("close project tasks: %w", err)
+	if err := closeProjectTasksTx(ctx, tx, projectID); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "project.close", auditProject, projectID, before); err != nil {
+		return err
 	}
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit tx: %w", err)
@@ -1071,30 +1385,40 @@ func (r *Repository) CreateReport(ctx context.Context, in models.CreateReportInp
 	}
 	defer tx.Rollback()
 
-	reportID, err := nextFreeReportID(ctx, tx)
-	if err != nil {
-		return err
-	}
-
-	if _, err := tx.ExecContext(ctx, `
-INSERT INTO reports (id, target_type, target_id, result_status, author_user_id, title, resolution, file_name, file_path, file_size)
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO reports (target_type, target_id, result_status, author_user_id, title, resolution, file_name, file_path, file_size, file_type)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-`, reportID, strings.TrimSpace(in.TargetType), in.TargetID, strings.TrimSpace(in.ResultStatus), in.AuthorID, strings.TrimSpace(in.Title), strings.TrimSpace(in.Resolution), strings.TrimSpace(in.FileName), strings.TrimSpace(in.FilePath), in.FileSize); err != nil {
+`, strings.TrimSpace(in.TargetType), in.TargetID, strings.TrimSpace(in.ResultStatus), in.AuthorID, strings.TrimSpace(in.Title), strings.TrimSpace(in.Resolution), strings.TrimSpace(in.FileName), strings.TrimSpace(in.FilePath), in.FileSize, in.FileType)
+	if err != nil {
 		return fmt.Errorf("insert report: %w", err)
 	}
+	reportID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("last insert report id: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "report.create", auditReport, reportID, nil); err != nil {
+		return err
+	}
 
 	if in.CloseItem {
 		switch strings.ToLower(strings.TrimSpace(in.TargetType)) {
 		case "task":
-			if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = 'Done' WHERE id = ?`, in.TargetID); err != nil {
-				return fmt.Errorf("close task: %w", err)
+			if err := closeTaskTx(ctx, tx, in.TargetID, in.ForceClose, in.AuthorRole); err != nil {
+				return err
 			}
 		case "project":
-			if _, err := tx.ExecContext(ctx, `UPDATE projects SET status = 'Закрыт' WHERE id = ?`, in.TargetID); err != nil {
+			before, err := auditState(ctx, tx, auditProject, in.TargetID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE projects SET status = 'Закрыт' WHERE id = ? AND deleted_at IS NULL`, in.TargetID); err != nil {
 				return fmt.Errorf("close project: %w", err)
 			}
-			if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = 'Done' WHERE project_id = ?`, in.TargetID); err != nil {
-				return fmt.Errorf("close project tasks: %w", err)
+			if err := closeProjectTasksTx(ctx, tx, in.TargetID); err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, tx, "project.close", auditProject, in.TargetID, before); err != nil {
+				return err
 			}
 		default:
 			return errors.New("неподдерживаемый тип отчета")
@@ -1107,6 +1431,24 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	return nil
 }
 
+// closeProjectTasksTx переводит все незакрытые задачи проекта в конечный статус его процесса.
+func closeProjectTasksTx(ctx context.Context, tx *sql.Tx, projectID int64) error {
+	wf, err := projectWorkflow(ctx, tx, projectID)
+	if err != nil {
+		return err
+	}
+	tasks, err := auditStates(ctx, tx, auditTask, `SELECT t.id FROM tasks t WHERE t.project_id = ? AND t.deleted_at IS NULL AND NOT `+taskTerminalSQL("t")+` ORDER BY t.id`, projectID)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = ? WHERE id = ?`, closeStatus(wf), task.id); err != nil {
+			return fmt.Errorf("close project tasks: %w", err)
+		}
+	}
+	return recordAuditAll(ctx, tx, "task.close", auditTask, tasks)
+}
+
 func (r *Repository) Reports(ctx context.Context) ([]models.Report, error) {
 	rows, err := r.db.QueryContext(ctx, `
 SELECT r.id,
@@ -1128,6 +1470,7 @@ SELECT r.id,
 FROM reports r
 JOIN users u ON u.id = r.author_user_id
 WHERE lower(trim(r.result_status)) != lower('Промежуточный отчет')
+  AND `+liveReportSQL("r")+`
 ORDER BY r.id ASC
 `)
 	if err != nil {
@@ -1146,7 +1489,8 @@ ORDER BY r.id ASC
 	return result, rows.Err()
 }
 
-func (r *Repository) ReportsByDepartment(ctx context.Context, departmentID int64) ([]models.Report, error) {
+func (r *Repository) ReportsByDepartment(ctx context.Context, departmentIDs ...int64) ([]models.Report, error) {
+	placeholders, args := int64Placeholders(departmentIDs)
 	rows, err := r.db.QueryContext(ctx, `
 SELECT r.id,
        r.target_type,
@@ -1170,13 +1514,14 @@ LEFT JOIN tasks t ON lower(r.target_type) = 'task' AND t.id = r.target_id
 LEFT JOIN projects pt ON pt.id = t.project_id
 LEFT JOIN projects pp ON lower(r.target_type) = 'project' AND pp.id = r.target_id
 WHERE lower(trim(r.result_status)) != lower('Промежуточный отчет')
+  AND `+liveReportSQL("r")+`
   AND CASE
   WHEN lower(r.target_type) = 'task' THEN COALESCE(pt.department_id, 0)
   WHEN lower(r.target_type) = 'project' THEN COALESCE(pp.department_id, 0)
   ELSE 0
-END = ?
+END IN (`+placeholders+`)
 ORDER BY r.id ASC
-`, departmentID)
+`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query reports by department: %w", err)
 	}
@@ -1220,6 +1565,7 @@ LEFT JOIN projects pp ON lower(r.target_type) = 'project' AND pp.id = r.target_i
 LEFT JOIN project_assignees pa ON pa.project_id = pp.id
 LEFT JOIN project_curators pc ON pc.project_id = pp.id
 WHERE lower(trim(r.result_status)) != lower('Промежуточный отчет')
+  AND `+liveReportSQL("r")+`
   AND ((lower(r.target_type) = 'task' AND (ta.user_id = ? OR tc.user_id = ?))
    OR (lower(r.target_type) = 'project' AND (pa.user_id = ? OR pc.user_id = ?))
   )
@@ -1241,37 +1587,28 @@ ORDER BY r.id ASC
 	return result, rows.Err()
 }
 
-func (r *Repository) ReportFilePath(ctx context.Context, reportID int64) (string, string, error) {
-	var filePath, fileName string
-	err := r.db.QueryRowContext(ctx, `SELECT file_path, file_name FROM reports WHERE id = ?`, reportID).Scan(&filePath, &fileName)
+func (r *Repository) ReportFilePath(ctx context.Context, reportID int64) (filePath, fileName, fileType string, err error) {
+	err = r.db.QueryRowContext(ctx, `SELECT r.file_path, r.file_name, r.file_type FROM reports r WHERE r.id = ? AND `+liveReportSQL("r"), reportID).Scan(&filePath, &fileName, &fileType)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", "", errors.New("отчет не найден")
+			return "", "", "", errors.New("отчет не найден")
 		}
-		return "", "", fmt.Errorf("get report file: %w", err)
+		return "", "", "", fmt.Errorf("get report file: %w", err)
 	}
 	if strings.TrimSpace(filePath) == "" {
-		return "", "", errors.New("файл не прикреплен")
+		return "", "", "", errors.New("файл не прикреплен")
 	}
-	return filePath, fileName, nil
+	return filePath, fileName, fileType, nil
 }
 
-func (r *Repository) Departments(ctx context.Context) ([]models.Department, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT id, name FROM departments ORDER BY id`)
-	if err != nil {
-		return nil, fmt.Errorf("query departments: %w", err)
-	}
-	defer rows.Close()
-
-	items := make([]models.Department, 0)
-	for rows.Next() {
-		var d models.Department
-		if err := rows.Scan(&d.ID, &d.Name); err != nil {
-			return nil, fmt.Errorf("scan department: %w", err)
-		}
-		items = append(items, d)
+func int64Placeholders(ids []int64) (string, []any) {
+	placeholders := make([]string, 0, len(ids))
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
 	}
-	return items, rows.Err()
+	return strings.Join(placeholders, ","), args
 }
 
 func (r *Repository) UserIDsBelongToDepartment(ctx context.Context, userIDs []int64, departmentID int64) (bool, error) {
@@ -1285,7 +1622,7 @@ func (r *Repository) UserIDsBelongToDepartment(ctx context.Context, userIDs []in
 		args = append(args, id)
 	}
 	args = append(args, departmentID)
-	query := `SELECT COUNT(DISTINCT id) FROM users WHERE id IN (` + strings.Join(placeholders, ",") + `) AND department_id = ?`
+	query := `SELECT COUNT(DISTINCT id) FROM users WHERE id IN (` + strings.Join(placeholders, ",") + `) AND department_id = ? AND registration_status = 'approved'`
 	var cnt int
 	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&cnt); err != nil {
 		return false, fmt.Errorf("check users department: %w", err)
@@ -1303,7 +1640,7 @@ func (r *Repository) SaveReportFile(baseDir, originalName string, content []byte
 	ext := filepath.Ext(originalName)
 	filename := fmt.Sprintf("report_%d%s", time.Now().UnixNano(), ext)
 	fullPath := filepath.Join(baseDir, filename)
-	if err := os.WriteFile(fullPath, content, 0o644); err != nil {
+	if err := r.files.WriteFile(fullPath, content, 0o644); err != nil {
 		return "", 0, fmt.Errorf("save report file: %w", err)
 	}
 	return fullPath, int64(len(content)), nil
@@ -1319,42 +1656,43 @@ func (r *Repository) SaveAvatarFile(baseDir, originalName string, content []byte
 	ext := filepath.Ext(originalName)
 	filename := fmt.Sprintf("avatar_%d%s", time.Now().UnixNano(), ext)
 	fullPath := filepath.Join(baseDir, filename)
-	if err := os.WriteFile(fullPath, content, 0o644); err != nil {
+	if err := r.files.WriteFile(fullPath, content, 0o644); err != nil {
 		return "", 0, fmt.Errorf("save avatar file: %w", err)
 	}
 	return fullPath, int64(len(content)), nil
 }
 
-func (r *Repository) UpdateUserAvatar(ctx context.Context, userID int64, avatarPath string) error {
-	res, err := r.db.ExecContext(ctx, `UPDATE users SET avatar_path = ? WHERE id = ?`, strings.TrimSpace(avatarPath), userID)
-	if err != nil {
-		return fmt.Errorf("update user avatar: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("пользователь не найден")
-	}
-	return nil
+func (r *Repository) UpdateUserAvatar(ctx context.Context, userID int64, avatarPath, avatarType string) error {
+	return r.auditedUpdate(ctx, "user.avatar", auditUser, userID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE users SET avatar_path = ?, avatar_type = ? WHERE id = ?`, strings.TrimSpace(avatarPath), avatarType, userID)
+		if err != nil {
+			return fmt.Errorf("update user avatar: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("пользователь не найден")
+		}
+		return nil
+	})
 }
 
-func (r *Repository) UserAvatarPath(ctx context.Context, userID int64) (string, error) {
-	var avatarPath string
-	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(avatar_path, '') FROM users WHERE id = ?`, userID).Scan(&avatarPath)
+func (r *Repository) UserAvatarPath(ctx context.Context, userID int64) (avatarPath, avatarType string, err error) {
+	err = r.db.QueryRowContext(ctx, `SELECT COALESCE(avatar_path, ''), avatar_type FROM users WHERE id = ?`, userID).Scan(&avatarPath, &avatarType)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", errors.New("пользователь не найден")
+			return "", "", errors.New("пользователь не найден")
 		}
-		return "", fmt.Errorf("get avatar path: %w", err)
+		return "", "", fmt.Errorf("get avatar path: %w", err)
 	}
-	return avatarPath, nil
+	return avatarPath, avatarType, nil
 }
 
 func (r *Repository) ProjectDepartmentID(ctx context.Context, projectID int64) (int64, error) {
 	var departmentID int64
-	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(department_id, 1) FROM projects WHERE id = ?`, projectID).Scan(&departmentID)
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(department_id, 1) FROM projects WHERE id = ? AND deleted_at IS NULL`, projectID).Scan(&departmentID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, errors.New("проект не найден")
@@ -1370,7 +1708,7 @@ func (r *Repository) TaskDepartmentID(ctx context.Context, taskID int64) (int64,
 SELECT COALESCE(p.department_id, 1)
 FROM tasks t
 JOIN projects p ON p.id = t.project_id
-WHERE t.id = ?
+WHERE t.id = ? AND t.deleted_at IS NULL AND p.deleted_at IS NULL
 `, taskID).Scan(&departmentID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -1435,26 +1773,32 @@ ORDER BY m.id ASC
 	return items, rows.Err()
 }
 
-func (r *Repository) CreateDepartmentMessage(ctx context.Context, departmentID, authorID int64, body, fileName, filePath string, fileSize int64) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_size)
-VALUES ('department', ?, ?, ?, ?, ?, ?)
-`, departmentID, authorID, strings.TrimSpace(body), strings.TrimSpace(fileName), strings.TrimSpace(filePath), fileSize)
-	if err != nil {
-		return fmt.Errorf("insert department message: %w", err)
-	}
-	return nil
+func (r *Repository) CreateDepartmentMessage(ctx context.Context, departmentID, authorID int64, body, fileName, filePath, fileType string, fileSize int64) error {
+	_, err := r.auditedInsert(ctx, "message.create", auditMessage, func(tx *sql.Tx) (sql.Result, error) {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_type, file_size)
+VALUES ('department', ?, ?, ?, ?, ?, ?, ?)
+`, departmentID, authorID, strings.TrimSpace(body), strings.TrimSpace(fileName), strings.TrimSpace(filePath), fileType, fileSize)
+		if err != nil {
+			return nil, fmt.Errorf("insert department message: %w", err)
+		}
+		return res, nil
+	})
+	return err
 }
 
-func (r *Repository) CreateTaskMessage(ctx context.Context, taskID, authorID int64, body, fileName, filePath string, fileSize int64) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_size)
-VALUES ('task', ?, ?, ?, ?, ?, ?)
-`, taskID, authorID, strings.TrimSpace(body), strings.TrimSpace(fileName), strings.TrimSpace(filePath), fileSize)
-	if err != nil {
-		return fmt.Errorf("insert task message: %w", err)
-	}
-	return nil
+func (r *Repository) CreateTaskMessage(ctx context.Context, taskID, authorID int64, body, fileName, filePath, fileType string, fileSize int64) error {
+	_, err := r.auditedInsert(ctx, "message.create", auditMessage, func(tx *sql.Tx) (sql.Result, error) {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO chat_messages (scope_type, scope_id, author_user_id, body, file_name, file_path, file_type, file_size)
+VALUES ('task', ?, ?, ?, ?, ?, ?, ?)
+`, taskID, authorID, strings.TrimSpace(body), strings.TrimSpace(fileName), strings.TrimSpace(filePath), fileType, fileSize)
+		if err != nil {
+			return nil, fmt.Errorf("insert task message: %w", err)
+		}
+		return res, nil
+	})
+	return err
 }
 
 func (r *Repository) SaveChatFile(baseDir, originalName string, content []byte) (string, int64, error) {
@@ -1467,31 +1811,28 @@ func (r *Repository) SaveChatFile(baseDir, originalName string, content []byte)
 	ext := filepath.Ext(originalName)
 	filename := fmt.Sprintf("msg_%d%s", time.Now().UnixNano(), ext)
 	fullPath := filepath.Join(baseDir, filename)
-	if err := os.WriteFile(fullPath, content, 0o644); err != nil {
+	if err := r.files.WriteFile(fullPath, content, 0o644); err != nil {
 		return "", 0, fmt.Errorf("save message file: %w", err)
 	}
 	return fullPath, int64(len(content)), nil
 }
 
-func (r *Repository) MessageFilePath(ctx context.Context, messageID int64) (string, int64, string, string, int64, error) {
-	var scopeID, fileSize int64
-	var scopeType string
-	var filePath, fileName string
-	err := r.db.QueryRowContext(ctx, `
-SELECT scope_type, scope_id, file_path, file_name, file_size
+func (r *Repository) MessageFilePath(ctx context.Context, messageID int64) (scopeType string, scopeID int64, filePath, fileName, fileType string, err error) {
+	err = r.db.QueryRowContext(ctx, `
+SELECT scope_type, scope_id, file_path, file_name, file_type
 FROM chat_messages
 WHERE id = ?
-`, messageID).Scan(&scopeType, &scopeID, &filePath, &fileName, &fileSize)
+`, messageID).Scan(&scopeType, &scopeID, &filePath, &fileName, &fileType)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", 0, "", "", 0, errors.New("сообщение не найдено")
+			return "", 0, "", "", "", errors.New("сообщение не найдено")
 		}
-		return "", 0, "", "", 0, fmt.Errorf("get message file: %w", err)
+		return "", 0, "", "", "", fmt.Errorf("get message file: %w", err)
 	}
 	if strings.TrimSpace(filePath) == "" {
-		return "", 0, "", "", 0, errors.New("вложение не найдено")
+		return "", 0, "", "", "", errors.New("вложение не найдено")
 	}
-	return scopeType, scopeID, filePath, fileName, fileSize, nil
+	return scopeType, scopeID, filePath, fileName, fileType, nil
 }
 
 func (r *Repository) ChatMessageMeta(ctx context.Context, messageID int64) (scopeType string, scopeID, authorID int64, filePath string, err error) {
@@ -1510,18 +1851,20 @@ WHERE id = ?
 }
 
 func (r *Repository) DeleteChatMessage(ctx context.Context, messageID int64) error {
-	res, err := r.db.ExecContext(ctx, `DELETE FROM chat_messages WHERE id = ?`, messageID)
-	if err != nil {
-		return fmt.Errorf("delete chat message: %w", err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("rows affected for chat message delete: %w", err)
-	}
-	if affected == 0 {
-		return errors.New("сообщение не найдено")
-	}
-	return nil
+	return r.auditedUpdate(ctx, "message.delete", auditMessage, messageID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM chat_messages WHERE id = ?`, messageID)
+		if err != nil {
+			return fmt.Errorf("delete chat message: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected for chat message delete: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("сообщение не найдено")
+		}
+		return nil
+	})
 }
 
 func (r *Repository) taskAssignees(ctx context.Context, taskID int64) ([]models.User, error) {
@@ -1606,81 +1949,3 @@ func usersToNames(users []models.User) string {
 	}
 	return strings.Join(parts, ", ")
 }
-
-func nextFreeTaskID(ctx context.Context, tx *sql.Tx) (int64, error) {
-	rows, err := tx.QueryContext(ctx, `SELECT id FROM tasks ORDER BY id`)
-	if err != nil {
-		return 0, fmt.Errorf("query task ids: %w", err)
-	}
-	defer rows.Close()
-
-	var expected int64 = 1
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return 0, fmt.Errorf("scan task id: %w", err)
-		}
-		if id > expected {
-			break
-		}
-		if id == expected {
-			expected++
-		}
-	}
-	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("iterate task ids: %w", err)
-	}
-	return expected, nil
-}
-
-func nextFreeProjectID(ctx context.Context, tx *sql.Tx) (int64, error) {
-	rows, err := tx.QueryContext(ctx, `SELECT id FROM projects ORDER BY id`)
-	if err != nil {
-		return 0, fmt.Errorf("query project ids: %w", err)
-	}
-	defer rows.Close()
-
-	var expected int64 = 1
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return 0, fmt.Errorf("scan project id: %w", err)
-		}
-		if id > expected {
-			break
-		}
-		if id == expected {
-			expected++
-		}
-	}
-	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("iterate project ids: %w", err)
-	}
-	return expected, nil
-}
-
-func nextFreeReportID(ctx context.Context, tx *sql.Tx) (int64, error) {
-	rows, err := tx.QueryContext(ctx, `SELECT id FROM reports ORDER BY id`)
-	if err != nil {
-		return 0, fmt.Errorf("query report ids: %w", err)
-	}
-	defer rows.Close()
-
-	var expected int64 = 1
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return 0, fmt.Errorf("scan report id: %w", err)
-		}
-		if id > expected {
-			break
-		}
-		if id == expected {
-			expected++
-		}
-	}
-	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("iterate report ids: %w", err)
-	}
-	return expected, nil
-}
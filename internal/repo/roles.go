@@ -0,0 +1,310 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/authz"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+var errBuiltInRole = errors.New("встроенную роль нельзя изменить или удалить")
+
+// AccessPolicy загружает все роли, их права и дерево подразделений.
+func (r *Repository) AccessPolicy(ctx context.Context) (*authz.Policy, error) {
+	roles, err := r.Roles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departments, err := r.Departments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	units := make([]authz.OrgUnit, 0, len(departments))
+	for _, d := range departments {
+		units = append(units, authz.OrgUnit{ID: d.ID, ParentID: d.ParentID, HeadUserID: d.HeadUserID})
+	}
+	names := make([]string, 0, len(roles))
+	grants := make([]authz.Grant, 0)
+	for _, role := range roles {
+		names = append(names, role.Name)
+		for _, perm := range role.Permissions {
+			scope, _ := authz.ParseScope(perm.Scope)
+			grants = append(grants, authz.Grant{
+				Role:      role.Name,
+				Action:    authz.Action(perm.Action),
+				Scope:     scope,
+				Condition: perm.Condition,
+			})
+		}
+	}
+	return authz.NewPolicy(names, grants, authz.NewOrg(units)), nil
+}
+
+func (r *Repository) Roles(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT ro.id, ro.name, ro.title, ro.description, ro.built_in,
+       (SELECT COUNT(*) FROM users u WHERE u.role = ro.name COLLATE NOCASE)
+FROM roles ro
+ORDER BY ro.built_in DESC, ro.id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query roles: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.Role, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		var item models.Role
+		var builtIn int
+		if err := rows.Scan(&item.ID, &item.Name, &item.Title, &item.Description, &builtIn, &item.UsersCount); err != nil {
+			return nil, fmt.Errorf("scan role: %w", err)
+		}
+		item.BuiltIn = builtIn == 1
+		item.Permissions = make([]models.RolePermission, 0)
+		index[item.ID] = len(items)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	permRows, err := r.db.QueryContext(ctx, `SELECT role_id, action, scope, condition FROM role_permissions ORDER BY role_id, id`)
+	if err != nil {
+		return nil, fmt.Errorf("query role permissions: %w", err)
+	}
+	defer permRows.Close()
+	for permRows.Next() {
+		var roleID int64
+		var perm models.RolePermission
+		if err := permRows.Scan(&roleID, &perm.Action, &perm.Scope, &perm.Condition); err != nil {
+			return nil, fmt.Errorf("scan role permission: %w", err)
+		}
+		if i, ok := index[roleID]; ok {
+			items[i].Permissions = append(items[i].Permissions, perm)
+		}
+	}
+	return items, permRows.Err()
+}
+
+func (r *Repository) Role(ctx context.Context, roleID int64) (models.Role, error) {
+	items, err := r.Roles(ctx)
+	if err != nil {
+		return models.Role{}, err
+	}
+	for _, item := range items {
+		if item.ID == roleID {
+			return item, nil
+		}
+	}
+	return models.Role{}, errors.New("роль не найдена")
+}
+
+// roleRankSQL упорядочивает пользователей по силе роли: сначала роли с большим числом прав,
+// при равенстве — созданные раньше, так что встроенные идут в порядке authz.BuiltInRoles.
+func roleRankSQL(column string) string {
+	return `(SELECT COUNT(*) FROM role_permissions rp JOIN roles ro ON ro.id = rp.role_id WHERE ro.name = ` + column + ` COLLATE NOCASE) DESC,
+  COALESCE((SELECT ro.id FROM roles ro WHERE ro.name = ` + column + ` COLLATE NOCASE), 9223372036854775807)`
+}
+
+// roleNarrowsTx сообщает, что роль newRole не дает какого-либо права роли oldRole.
+func roleNarrowsTx(ctx context.Context, tx *sql.Tx, oldRole, newRole string) (bool, error) {
+	if strings.EqualFold(strings.TrimSpace(oldRole), strings.TrimSpace(newRole)) {
+		return false, nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+SELECT ro.name, rp.action, rp.scope, rp.condition
+FROM role_permissions rp
+JOIN roles ro ON ro.id = rp.role_id
+WHERE ro.name COLLATE NOCASE IN (?, ?)
+`, strings.TrimSpace(oldRole), strings.TrimSpace(newRole))
+	if err != nil {
+		return false, fmt.Errorf("query role grants: %w", err)
+	}
+	defer rows.Close()
+	var grants, previous []authz.Grant
+	for rows.Next() {
+		var grant authz.Grant
+		var action, scope string
+		if err := rows.Scan(&grant.Role, &action, &scope, &grant.Condition); err != nil {
+			return false, fmt.Errorf("scan role grant: %w", err)
+		}
+		grant.Action = authz.Action(action)
+		grant.Scope, _ = authz.ParseScope(scope)
+		grants = append(grants, grant)
+		if strings.EqualFold(grant.Role, strings.TrimSpace(oldRole)) {
+			previous = append(previous, grant)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	policy := authz.NewPolicy([]string{oldRole, newRole}, grants, nil)
+	for _, grant := range previous {
+		if !policy.CanGrant(models.User{Role: newRole}, grant) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func validateRolePermissions(perms []models.RolePermission) error {
+	for _, perm := range perms {
+		if !authz.ValidAction(authz.Action(perm.Action)) {
+			return fmt.Errorf("неизвестное действие %q", perm.Action)
+		}
+		if _, ok := authz.ParseScope(perm.Scope); !ok {
+			return fmt.Errorf("некорректная область %q: допустимы all, department, own", perm.Scope)
+		}
+		if !authz.ValidCondition(perm.Condition) {
+			return fmt.Errorf("неизвестное условие %q", perm.Condition)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) CreateRole(ctx context.Context, in models.RoleInput) (int64, error) {
+	if strings.TrimSpace(in.Name) == "" || strings.TrimSpace(in.Title) == "" {
+		return 0, errors.New("укажите код и название роли")
+	}
+	if err := validateRolePermissions(in.Permissions); err != nil {
+		return 0, err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO roles (name, title, description) VALUES (?, ?, ?)`,
+		strings.TrimSpace(in.Name), strings.TrimSpace(in.Title), strings.TrimSpace(in.Description))
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return 0, errors.New("роль с таким кодом уже существует")
+		}
+		return 0, fmt.Errorf("insert role: %w", err)
+	}
+	roleID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("role id: %w", err)
+	}
+	if err := insertRolePermissionsTx(ctx, tx, roleID, in.Permissions); err != nil {
+		return 0, err
+	}
+	if err := recordAudit(ctx, tx, "role.create", auditRole, roleID, nil); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return roleID, nil
+}
+
+// UpdateRole меняет название, описание и права пользовательской роли. Код роли не меняется:
+// по нему роль записана у пользователей.
+func (r *Repository) UpdateRole(ctx context.Context, roleID int64, in models.RoleInput) error {
+	if strings.TrimSpace(in.Title) == "" {
+		return errors.New("укажите название роли")
+	}
+	if err := validateRolePermissions(in.Permissions); err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := customRoleTx(ctx, tx, roleID); err != nil {
+		return err
+	}
+	before, err := auditState(ctx, tx, auditRole, roleID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE roles SET title = ?, description = ? WHERE id = ?`,
+		strings.TrimSpace(in.Title), strings.TrimSpace(in.Description), roleID); err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = ?`, roleID); err != nil {
+		return fmt.Errorf("delete role permissions: %w", err)
+	}
+	if err := insertRolePermissionsTx(ctx, tx, roleID, in.Permissions); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "role.update", auditRole, roleID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteRole(ctx context.Context, roleID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := customRoleTx(ctx, tx, roleID); err != nil {
+		return err
+	}
+	var users int
+	if err := tx.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM users WHERE role = (SELECT name FROM roles WHERE id = ?) COLLATE NOCASE
+`, roleID).Scan(&users); err != nil {
+		return fmt.Errorf("count role users: %w", err)
+	}
+	if users > 0 {
+		return fmt.Errorf("роль назначена пользователям (%d), сначала смените им роль", users)
+	}
+	before, err := auditState(ctx, tx, auditRole, roleID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM roles WHERE id = ?`, roleID); err != nil {
+		return fmt.Errorf("delete role: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "role.delete", auditRole, roleID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func customRoleTx(ctx context.Context, tx *sql.Tx, roleID int64) error {
+	var builtIn int
+	if err := tx.QueryRowContext(ctx, `SELECT built_in FROM roles WHERE id = ?`, roleID).Scan(&builtIn); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("роль не найдена")
+		}
+		return fmt.Errorf("query role: %w", err)
+	}
+	if builtIn == 1 {
+		return errBuiltInRole
+	}
+	return nil
+}
+
+func insertRolePermissionsTx(ctx context.Context, tx *sql.Tx, roleID int64, perms []models.RolePermission) error {
+	for _, perm := range perms {
+		scope, _ := authz.ParseScope(perm.Scope)
+		if _, err := tx.ExecContext(ctx, `
+INSERT OR IGNORE INTO role_permissions (role_id, action, scope, condition) VALUES (?, ?, ?, ?)
+`, roleID, perm.Action, scope.String(), perm.Condition); err != nil {
+			return fmt.Errorf("insert role permission: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Формат CURRENT_TIMESTAMP: даты в этом виде можно сравнивать прямо в SQL.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+var errSessionNotFound = errors.New("сессия не найдена или истекла")
+
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate session token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *Repository) CreateSession(ctx context.Context, userID int64, ttl time.Duration, ip, userAgent, device string) (string, time.Time, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+		return "", time.Time{}, fmt.Errorf("purge expired sessions: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, `
+INSERT INTO sessions (token_hash, user_id, expires_at, ip, user_agent, device, last_seen_at)
+VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+`, hashSessionToken(token), userID, expiresAt.Format(sqliteTimeLayout), ip, userAgent, device); err != nil {
+		return "", time.Time{}, fmt.Errorf("insert session: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func (r *Repository) UserBySession(ctx context.Context, token string) (models.User, error) {
+	if token == "" {
+		return models.User{}, errSessionNotFound
+	}
+	var u models.User
+	var sessionID int64
+	err := r.db.QueryRowContext(ctx, `
+SELECT s.id, u.id, u.login, u.full_name, u.position, u.role,
+       COALESCE(u.department_id, 1),
+       COALESCE(d.name, 'Отдел не указан'),
+       COALESCE(u.avatar_path, ''), u.must_change_password
+FROM sessions s
+JOIN users u ON u.id = s.user_id
+LEFT JOIN departments d ON d.id = u.department_id
+WHERE s.token_hash = ? AND s.expires_at > CURRENT_TIMESTAMP AND u.is_active = 1
+`, hashSessionToken(token)).Scan(&sessionID, &u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath, &u.MustChangePassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, errSessionNotFound
+		}
+		return models.User{}, fmt.Errorf("query user by session: %w", err)
+	}
+	// last_seen_at обновляется не чаще раза в минуту, чтобы не писать в БД на каждый запрос.
+	if _, err := r.db.ExecContext(ctx, `
+UPDATE sessions
+SET last_seen_at = CURRENT_TIMESTAMP
+WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < datetime('now', '-1 minute'))
+`, sessionID); err != nil {
+		return models.User{}, fmt.Errorf("touch session: %w", err)
+	}
+	return u, nil
+}
+
+func (r *Repository) SessionIDByToken(ctx context.Context, token string) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+SELECT id FROM sessions WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP
+`, hashSessionToken(token)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errSessionNotFound
+		}
+		return 0, fmt.Errorf("query session id: %w", err)
+	}
+	return id, nil
+}
+
+func (r *Repository) UserSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, user_id, device, ip, user_agent, datetime(created_at), datetime(COALESCE(last_seen_at, created_at)), datetime(expires_at)
+FROM sessions
+WHERE user_id = ? AND expires_at > CURRENT_TIMESTAMP
+ORDER BY COALESCE(last_seen_at, created_at) DESC, id DESC
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.Session, 0)
+	for rows.Next() {
+		var item models.Session
+		if err := rows.Scan(&item.ID, &item.UserID, &item.Device, &item.IP, &item.UserAgent, &item.CreatedAt, &item.LastSeenAt, &item.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (r *Repository) RevokeUserSession(ctx context.Context, userID, sessionID int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errors.New("сессия не найдена")
+	}
+	return nil
+}
+
+// RevokeUserSessions завершает все сессии пользователя, кроме exceptSessionID (0 — без исключений).
+func (r *Repository) RevokeUserSessions(ctx context.Context, userID, exceptSessionID int64) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, exceptSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return affected, nil
+}
+
+func (r *Repository) RefreshSession(ctx context.Context, token string, ttl time.Duration) (string, time.Time, error) {
+	if token == "" {
+		return "", time.Time{}, errSessionNotFound
+	}
+	next, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	res, err := r.db.ExecContext(ctx, `
+UPDATE sessions
+SET token_hash = ?, expires_at = ?, last_seen_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP
+`, hashSessionToken(next), expiresAt.Format(sqliteTimeLayout), hashSessionToken(token))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("refresh session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return "", time.Time{}, errSessionNotFound
+	}
+	return next, expiresAt, nil
+}
+
+func (r *Repository) DeleteSession(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, hashSessionToken(token)); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
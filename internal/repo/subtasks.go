@@ -0,0 +1,242 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// taskRollups считает сводку по подзадачам любой глубины для каждой задачи, у которой они есть.
+// Завершенными считаются подзадачи в конечном статусе процесса, в работе — вышедшие из начального.
+// Статус сводки берется из процесса проекта задачи.
+func (r *Repository) taskRollups(ctx context.Context) (map[int64]models.TaskRollup, error) {
+	rows, err := r.db.QueryContext(ctx, `
+WITH RECURSIVE tree(root_id, id) AS (
+  SELECT parent_task_id, id FROM tasks WHERE parent_task_id IS NOT NULL AND deleted_at IS NULL
+  UNION
+  SELECT tree.root_id, t.id FROM tasks t JOIN tree ON t.parent_task_id = tree.id WHERE t.deleted_at IS NULL
+)
+SELECT tree.root_id, rp.workflow_id, COUNT(*), SUM(COALESCE(ws.is_terminal, 0)), SUM(COALESCE(ws.is_terminal = 0 AND ws.is_initial = 0, 1))
+FROM tree
+JOIN tasks root ON root.id = tree.root_id
+JOIN projects rp ON rp.id = root.project_id
+JOIN tasks t ON t.id = tree.id
+JOIN projects p ON p.id = t.project_id
+LEFT JOIN workflow_statuses ws ON ws.workflow_id = p.workflow_id AND ws.name = t.status
+GROUP BY tree.root_id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query task rollups: %w", err)
+	}
+	rollups := make(map[int64]models.TaskRollup)
+	workflowIDs := make(map[int64]int64)
+	for rows.Next() {
+		var taskID, workflowID int64
+		var rollup models.TaskRollup
+		if err := rows.Scan(&taskID, &workflowID, &rollup.Total, &rollup.Done, &rollup.InProgress); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task rollup: %w", err)
+		}
+		rollup.Progress = rollup.Done * 100 / rollup.Total
+		rollups[taskID] = rollup
+		workflowIDs[taskID] = workflowID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	workflows := make(map[int64]models.Workflow)
+	for taskID, rollup := range rollups {
+		wf, ok := workflows[workflowIDs[taskID]]
+		if !ok {
+			if wf, err = loadWorkflow(ctx, r.db, workflowIDs[taskID]); err != nil {
+				return nil, err
+			}
+			workflows[workflowIDs[taskID]] = wf
+		}
+		switch {
+		case rollup.Done == rollup.Total:
+			rollup.Status = closeStatus(wf)
+		case rollup.Done > 0 || rollup.InProgress > 0:
+			rollup.Status = progressStatus(wf)
+		default:
+			rollup.Status = initialStatus(wf)
+		}
+		rollups[taskID] = rollup
+	}
+	return rollups, nil
+}
+
+// taskWithinTx сообщает, является ли ancestorID самой задачей taskID или одним из ее предков.
+func taskWithinTx(ctx context.Context, tx *sql.Tx, taskID, ancestorID int64) (bool, error) {
+	var found int
+	err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE chain(id) AS (
+  SELECT ?
+  UNION
+  SELECT t.parent_task_id FROM tasks t JOIN chain c ON t.id = c.id WHERE t.parent_task_id IS NOT NULL
+)
+SELECT COUNT(*) FROM chain WHERE id = ?
+`, taskID, ancestorID).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("query task chain: %w", err)
+	}
+	return found > 0, nil
+}
+
+// validateTaskParentTx проверяет, что parentID может стать родителем задачи taskID из проекта
+// projectID: родитель существует, лежит в том же проекте и не является самой задачей или ее
+// подзадачей. Для новой задачи taskID равен 0.
+func validateTaskParentTx(ctx context.Context, tx *sql.Tx, taskID, parentID, projectID int64) error {
+	var parentProjectID int64
+	if err := tx.QueryRowContext(ctx, `SELECT project_id FROM tasks WHERE id = ? AND deleted_at IS NULL`, parentID).Scan(&parentProjectID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("родительская задача не найдена")
+		}
+		return fmt.Errorf("query parent task: %w", err)
+	}
+	if parentProjectID != projectID {
+		return errors.New("подзадача должна быть в том же проекте, что и родительская задача")
+	}
+	if taskID == 0 {
+		return nil
+	}
+	cycle, err := taskWithinTx(ctx, tx, parentID, taskID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errors.New("задачу нельзя сделать подзадачей ее самой или ее подзадачи")
+	}
+	return nil
+}
+
+// openSubtasksTx считает незакрытые подзадачи задачи на любой глубине.
+func openSubtasksTx(ctx context.Context, tx *sql.Tx, taskID int64) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, `
+WITH RECURSIVE tree(id) AS (
+  SELECT id FROM tasks WHERE parent_task_id = ? AND deleted_at IS NULL
+  UNION
+  SELECT t.id FROM tasks t JOIN tree ON t.parent_task_id = tree.id WHERE t.deleted_at IS NULL
+)
+SELECT COUNT(*) FROM tasks t WHERE t.id IN (SELECT id FROM tree) AND NOT `+taskTerminalSQL("t")+`
+`, taskID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count open subtasks: %w", err)
+	}
+	return count, nil
+}
+
+// closeTaskTx переводит задачу в конечный статус процесса ее проекта, если роль role может
+// выполнить этот переход. Пока у задачи есть незакрытые подзадачи, закрытие отклоняется;
+// с force подзадачи закрываются вместе с ней. Уже закрытая задача не меняется.
+func closeTaskTx(ctx context.Context, tx *sql.Tx, taskID int64, force bool, role string) error {
+	before, err := auditState(ctx, tx, auditTask, taskID)
+	if err != nil {
+		return err
+	}
+	if before == nil || before["deleted_at"] != nil {
+		return errors.New("задача не найдена")
+	}
+	projectID, _ := before["project_id"].(int64)
+	status, _ := before["status"].(string)
+	wf, err := projectWorkflow(ctx, tx, projectID)
+	if err != nil {
+		return err
+	}
+	if st, ok := findWorkflowStatus(wf.Statuses, status); ok && st.Terminal {
+		return nil
+	}
+	target := closeStatus(wf)
+	if err := checkTransition(wf, status, target, role); err != nil {
+		return err
+	}
+
+	open, err := openSubtasksTx(ctx, tx, taskID)
+	if err != nil {
+		return err
+	}
+	if open > 0 && !force {
+		return fmt.Errorf("у задачи есть незакрытые подзадачи (%d): закройте их или закройте задачу принудительно", open)
+	}
+
+	subtasks, err := auditStates(ctx, tx, auditTask, `
+WITH RECURSIVE tree(id) AS (
+  SELECT id FROM tasks WHERE parent_task_id = ? AND deleted_at IS NULL
+  UNION
+  SELECT t.id FROM tasks t JOIN tree ON t.parent_task_id = tree.id WHERE t.deleted_at IS NULL
+)
+SELECT t.id FROM tasks t WHERE t.id IN (SELECT id FROM tree) AND NOT `+taskTerminalSQL("t")+` ORDER BY t.id
+`, taskID)
+	if err != nil {
+		return err
+	}
+	// Каждая подзадача закрывается конечным статусом процесса своего проекта и только
+	// разрешенным ей переходом: принудительное закрытие не обходит процесс.
+	workflows := map[int64]models.Workflow{projectID: wf}
+	targets := make([]string, len(subtasks))
+	for i, subtask := range subtasks {
+		subtaskProjectID, _ := subtask.state["project_id"].(int64)
+		subtaskWF, ok := workflows[subtaskProjectID]
+		if !ok {
+			if subtaskWF, err = projectWorkflow(ctx, tx, subtaskProjectID); err != nil {
+				return err
+			}
+			workflows[subtaskProjectID] = subtaskWF
+		}
+		from, _ := subtask.state["status"].(string)
+		targets[i] = closeStatus(subtaskWF)
+		if err := checkTransition(subtaskWF, from, targets[i], role); err != nil {
+			key, _ := subtask.state["key"].(string)
+			return fmt.Errorf("подзадачу %s нельзя закрыть: %w", key, err)
+		}
+	}
+	for i, subtask := range subtasks {
+		if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = ? WHERE id = ?`, targets[i], subtask.id); err != nil {
+			return fmt.Errorf("close subtask: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = ? WHERE id = ?`, target, taskID); err != nil {
+		return fmt.Errorf("close task: %w", err)
+	}
+	if err := recordAuditAll(ctx, tx, "task.close", auditTask, subtasks); err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, "task.close", auditTask, taskID, before)
+}
+
+// updateTaskParentTx меняет родителя задачи при правке. Родитель в UpdateTaskInput не указан —
+// остается прежний, но и он проверяется, если задачу переносят в другой проект.
+func updateTaskParentTx(ctx context.Context, tx *sql.Tx, taskID int64, before map[string]any, in models.UpdateTaskInput) error {
+	parentID, _ := before["parent_task_id"].(int64)
+	if in.ParentTaskID != nil {
+		parentID = *in.ParentTaskID
+	}
+	projectID, _ := before["project_id"].(int64)
+	if in.ProjectID != projectID {
+		var children int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks WHERE parent_task_id = ? AND deleted_at IS NULL`, taskID).Scan(&children); err != nil {
+			return fmt.Errorf("count subtasks: %w", err)
+		}
+		if children > 0 {
+			return errors.New("задачу с подзадачами нельзя перенести в другой проект")
+		}
+	}
+
+	var parent any
+	if parentID > 0 {
+		if err := validateTaskParentTx(ctx, tx, taskID, parentID, in.ProjectID); err != nil {
+			return err
+		}
+		parent = parentID
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET parent_task_id = ? WHERE id = ?`, parent, taskID); err != nil {
+		return fmt.Errorf("update task parent: %w", err)
+	}
+	return nil
+}
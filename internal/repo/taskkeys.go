@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// TaskIDByKey находит задачу по текущему ключу или по одному из прежних.
+func (r *Repository) TaskIDByKey(ctx context.Context, key string) (int64, error) {
+	var taskID int64
+	err := r.db.QueryRowContext(ctx, `
+SELECT t.id FROM (
+  SELECT id FROM tasks WHERE key = ?1
+  UNION ALL
+  SELECT task_id FROM task_key_aliases WHERE key = ?1
+) k
+JOIN tasks t ON t.id = k.id
+WHERE `+liveTaskSQL("t")+`
+LIMIT 1
+`, strings.TrimSpace(key)).Scan(&taskID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.New("задача не найдена")
+		}
+		return 0, fmt.Errorf("query task by key: %w", err)
+	}
+	return taskID, nil
+}
+
+// nextTaskKeyTx выдает следующий ключ задачи проекта вида {ключ проекта}-{n} по счетчику
+// проекта. Номера, ключ которых уже занят задачей или остался чьим-то прежним ключом, пропускаются.
+func nextTaskKeyTx(ctx context.Context, tx *sql.Tx, projectID int64) (string, error) {
+	for {
+		var projectKey string
+		var seq int64
+		err := tx.QueryRowContext(ctx, `
+UPDATE projects SET task_seq = task_seq + 1 WHERE id = ? RETURNING key, task_seq
+`, projectID).Scan(&projectKey, &seq)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return "", errors.New("проект не найден")
+			}
+			return "", fmt.Errorf("next task key: %w", err)
+		}
+		key := fmt.Sprintf("%s-%d", projectKey, seq)
+		taken, err := taskKeyTakenTx(ctx, tx, key, 0)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return key, nil
+		}
+	}
+}
+
+// taskKeyTakenTx сообщает, занят ли ключ другой задачей — как текущий или как прежний ключ.
+// Собственные прежние ключи задачи taskID занятыми не считаются: к ним можно вернуться.
+func taskKeyTakenTx(ctx context.Context, tx *sql.Tx, key string, taskID int64) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, `
+SELECT (SELECT COUNT(*) FROM tasks WHERE key = ?1 AND id <> ?2)
+     + (SELECT COUNT(*) FROM task_key_aliases WHERE key = ?1 AND task_id <> ?2)
+`, key, taskID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("check task key: %w", err)
+	}
+	return count > 0, nil
+}
+
+// rekeyTaskTx меняет ключ задачи; прежний ключ остается ее псевдонимом.
+func rekeyTaskTx(ctx context.Context, tx *sql.Tx, taskID int64, oldKey, newKey string) error {
+	taken, err := taskKeyTakenTx(ctx, tx, newKey, taskID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("ключ задачи %s уже занят", newKey)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM task_key_aliases WHERE key = ?`, newKey); err != nil {
+		return fmt.Errorf("delete task key alias: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET key = ? WHERE id = ?`, newKey, taskID); err != nil {
+		return fmt.Errorf("update task key: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO task_key_aliases (key, task_id) VALUES (?, ?)`, oldKey, taskID); err != nil {
+		return fmt.Errorf("insert task key alias: %w", err)
+	}
+	return nil
+}
+
+// rekeyProjectTasksTx переносит задачи проекта с ключами вида {oldKey}-{n} на новый ключ
+// проекта: {newKey}-{n}. Задачи с ключами другого вида не меняются.
+func rekeyProjectTasksTx(ctx context.Context, tx *sql.Tx, projectID int64, oldKey, newKey string) error {
+	prefix := oldKey + "-"
+	tasks, err := auditStates(ctx, tx, auditTask, `
+SELECT id FROM tasks WHERE project_id = ?1 AND substr(key, 1, length(?2)) = ?2 ORDER BY id
+`, projectID, prefix)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		key, _ := task.state["key"].(string)
+		if err := rekeyTaskTx(ctx, tx, task.id, key, newKey+"-"+strings.TrimPrefix(key, prefix)); err != nil {
+			return err
+		}
+	}
+	return recordAuditAll(ctx, tx, "task.rekey", auditTask, tasks)
+}
+
+// updateTaskKeyTx меняет ключ задачи при правке: явно указанный ключ заменяет текущий, а при
+// переносе в другой проект без явного ключа задача получает следующий ключ нового проекта.
+func updateTaskKeyTx(ctx context.Context, tx *sql.Tx, taskID int64, before map[string]any, in models.UpdateTaskInput) error {
+	oldKey, _ := before["key"].(string)
+	projectID, _ := before["project_id"].(int64)
+	newKey := strings.TrimSpace(in.Key)
+	if newKey == "" && in.ProjectID != projectID {
+		var err error
+		if newKey, err = nextTaskKeyTx(ctx, tx, in.ProjectID); err != nil {
+			return err
+		}
+	}
+	if newKey == "" || newKey == oldKey {
+		return nil
+	}
+	return rekeyTaskTx(ctx, tx, taskID, oldKey, newKey)
+}
@@ -0,0 +1,223 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// Префикс позволяет отличить токен доступа от сессионного и находить утекшие токены поиском по коду.
+const apiTokenPrefix = "tfp_"
+
+var errAPITokenInvalid = errors.New("токен доступа недействителен или истек")
+
+func (r *Repository) CreateAPIToken(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (models.APIToken, string, error) {
+	secret, err := newSessionToken()
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	token := apiTokenPrefix + secret
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format(sqliteTimeLayout)
+	}
+	id, err := r.auditedInsert(ctx, "api_token.create", auditAPIToken, func(tx *sql.Tx) (sql.Result, error) {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`, userID, strings.TrimSpace(name), hashSessionToken(token), token[:len(apiTokenPrefix)+6], strings.Join(scopes, " "), expires)
+		if err != nil {
+			return nil, fmt.Errorf("insert api token: %w", err)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	item, err := r.APIToken(ctx, id)
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	return item, token, nil
+}
+
+const apiTokenColumns = `
+SELECT t.id, t.user_id, u.login, u.is_service, t.name, t.token_prefix, t.scopes,
+       datetime(t.expires_at), datetime(t.last_used_at), t.last_used_ip, datetime(t.created_at)
+FROM api_tokens t
+JOIN users u ON u.id = t.user_id
+`
+
+func scanAPIToken(scanner interface{ Scan(...any) error }) (models.APIToken, error) {
+	var item models.APIToken
+	var service int
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullString
+	if err := scanner.Scan(&item.ID, &item.UserID, &item.UserLogin, &service, &item.Name, &item.Prefix, &scopes, &expiresAt, &lastUsedAt, &item.LastUsedIP, &item.CreatedAt); err != nil {
+		return models.APIToken{}, err
+	}
+	item.ServiceAccount = service == 1
+	item.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		item.ExpiresAt = &expiresAt.String
+	}
+	if lastUsedAt.Valid {
+		item.LastUsedAt = &lastUsedAt.String
+	}
+	return item, nil
+}
+
+func (r *Repository) APIToken(ctx context.Context, tokenID int64) (models.APIToken, error) {
+	item, err := scanAPIToken(r.db.QueryRowContext(ctx, apiTokenColumns+` WHERE t.id = ?`, tokenID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIToken{}, errors.New("токен не найден")
+		}
+		return models.APIToken{}, fmt.Errorf("query api token: %w", err)
+	}
+	return item, nil
+}
+
+// APITokens возвращает токены пользователя и, если includeService, токены всех сервисных учетных записей.
+func (r *Repository) APITokens(ctx context.Context, userID int64, includeService bool) ([]models.APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, apiTokenColumns+`
+WHERE t.user_id = ? OR (? = 1 AND u.is_service = 1)
+ORDER BY t.id DESC
+`, userID, boolToInt(includeService))
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.APIToken, 0)
+	for rows.Next() {
+		item, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (r *Repository) RevokeAPIToken(ctx context.Context, tokenID int64) error {
+	return r.auditedUpdate(ctx, "api_token.revoke", auditAPIToken, tokenID, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, tokenID)
+		if err != nil {
+			return fmt.Errorf("revoke api token: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("токен не найден")
+		}
+		return nil
+	})
+}
+
+// UserByAPIToken проверяет токен и возвращает владельца вместе с областями действия токена.
+func (r *Repository) UserByAPIToken(ctx context.Context, token, ip string) (models.User, []string, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return models.User{}, nil, errAPITokenInvalid
+	}
+	var u models.User
+	var tokenID int64
+	var scopes string
+	err := r.db.QueryRowContext(ctx, `
+SELECT t.id, t.scopes, u.id, u.login, u.full_name, u.position, u.role,
+       COALESCE(u.department_id, 1),
+       COALESCE(d.name, 'Отдел не указан'),
+       COALESCE(u.avatar_path, ''), u.must_change_password
+FROM api_tokens t
+JOIN users u ON u.id = t.user_id
+LEFT JOIN departments d ON d.id = u.department_id
+WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP) AND u.is_active = 1
+`, hashSessionToken(token)).Scan(&tokenID, &scopes, &u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath, &u.MustChangePassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, nil, errAPITokenInvalid
+		}
+		return models.User{}, nil, fmt.Errorf("query user by api token: %w", err)
+	}
+	// Как и у сессий, отметка об использовании пишется не чаще раза в минуту.
+	if _, err := r.db.ExecContext(ctx, `
+UPDATE api_tokens
+SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?
+WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute') OR last_used_ip <> ?)
+`, ip, tokenID, ip); err != nil {
+		return models.User{}, nil, fmt.Errorf("touch api token: %w", err)
+	}
+	return u, strings.Fields(scopes), nil
+}
+
+func (r *Repository) CreateServiceAccount(ctx context.Context, in models.CreateServiceAccountInput) (models.User, error) {
+	id, err := r.auditedInsert(ctx, "user.service_create", auditUser, func(tx *sql.Tx) (sql.Result, error) {
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO users (login, password_hash, full_name, position, role, department_id, is_service)
+VALUES (?, '', ?, 'Сервисная учетная запись', ?, ?, 1)
+`, strings.TrimSpace(in.Login), strings.TrimSpace(in.FullName), strings.TrimSpace(in.Role), in.DepartmentID)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				return nil, errors.New("логин уже существует")
+			}
+			return nil, fmt.Errorf("insert service account: %w", err)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return r.UserByID(ctx, id)
+}
+
+func (r *Repository) ServiceAccounts(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT u.id, u.login, u.full_name, u.position, u.role,
+       COALESCE(u.department_id, 1),
+       COALESCE(d.name, 'Отдел не указан'),
+       COALESCE(u.avatar_path, '')
+FROM users u
+LEFT JOIN departments d ON d.id = u.department_id
+WHERE u.is_service = 1
+ORDER BY u.id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("query service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.User, 0)
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Login, &u.FullName, &u.Position, &u.Role, &u.DepartmentID, &u.DepartmentName, &u.AvatarPath); err != nil {
+			return nil, fmt.Errorf("scan service account: %w", err)
+		}
+		result = append(result, u)
+	}
+	return result, rows.Err()
+}
+
+func (r *Repository) IsServiceAccount(ctx context.Context, userID int64) (bool, error) {
+	var service int
+	if err := r.db.QueryRowContext(ctx, `SELECT is_service FROM users WHERE id = ?`, userID).Scan(&service); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, errors.New("пользователь не найден")
+		}
+		return false, fmt.Errorf("query service flag: %w", err)
+	}
+	return service == 1, nil
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
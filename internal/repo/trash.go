@@ -0,0 +1,345 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+var errNotInTrash = errors.New("в корзине нет такого элемента")
+
+// trashTables — таблицы сущностей, которые удаляются в корзину; ключ совпадает с типом сущности в журнале.
+var trashTables = map[string]string{
+	auditTask:    "tasks",
+	auditProject: "projects",
+	auditReport:  "reports",
+}
+
+// liveTaskSQL — условие «задача alias не в корзине, как и ее проект».
+func liveTaskSQL(alias string) string {
+	return alias + `.deleted_at IS NULL
+  AND NOT EXISTS (SELECT 1 FROM projects dp WHERE dp.id = ` + alias + `.project_id AND dp.deleted_at IS NOT NULL)`
+}
+
+// liveReportSQL — условие «отчет alias не в корзине, как и задача или проект, к которым он относится».
+func liveReportSQL(alias string) string {
+	return alias + `.deleted_at IS NULL
+  AND NOT EXISTS (SELECT 1 FROM tasks dt WHERE lower(` + alias + `.target_type) = 'task' AND dt.id = ` + alias + `.target_id AND NOT (` + liveTaskSQL("dt") + `))
+  AND NOT EXISTS (SELECT 1 FROM projects dp WHERE lower(` + alias + `.target_type) = 'project' AND dp.id = ` + alias + `.target_id AND dp.deleted_at IS NOT NULL)`
+}
+
+// trashItemsSQL выбирает тип, id, подпись и сведения об удалении всех элементов корзины.
+const trashItemsSQL = `
+SELECT 'task' AS type, id, key || ' ' || title AS label, deleted_at, deleted_by FROM tasks WHERE deleted_at IS NOT NULL
+UNION ALL
+SELECT 'project', id, key || ' ' || name, deleted_at, deleted_by FROM projects WHERE deleted_at IS NOT NULL
+UNION ALL
+SELECT 'report', id, title, deleted_at, deleted_by FROM reports WHERE deleted_at IS NOT NULL
+`
+
+// TrashItems возвращает содержимое корзины, сначала недавно удаленное. При retention > 0
+// у элементов указано, когда они будут удалены окончательно.
+func (r *Repository) TrashItems(ctx context.Context, retention time.Duration) ([]models.TrashItem, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT x.type, x.id, x.label, x.deleted_at, x.deleted_by, COALESCE(u.full_name, ''),
+       CASE WHEN ?1 > 0 THEN strftime('%Y-%m-%dT%H:%M:%SZ', x.deleted_at, '+' || ?1 || ' seconds') ELSE '' END
+FROM (`+trashItemsSQL+`) x
+LEFT JOIN users u ON u.id = x.deleted_by
+ORDER BY x.deleted_at DESC, x.type, x.id DESC
+`, int64(retention/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("query trash: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]models.TrashItem, 0)
+	for rows.Next() {
+		var item models.TrashItem
+		if err := rows.Scan(&item.Type, &item.ID, &item.Label, &item.DeletedAt, &item.DeletedBy, &item.DeletedByName, &item.PurgeAt); err != nil {
+			return nil, fmt.Errorf("scan trash item: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RestoreTrashItem возвращает элемент из корзины. Задача восстанавливается только вместе со своим
+// проектом и родительской задачей, отчет — вместе со своей задачей или проектом. Если родитель
+// задачи за это время перенесен в другой проект, задача восстанавливается без родителя.
+func (r *Repository) RestoreTrashItem(ctx context.Context, itemType string, id int64) error {
+	table, ok := trashTables[itemType]
+	if !ok {
+		return errors.New("неизвестный тип элемента корзины")
+	}
+	return r.auditedUpdate(ctx, itemType+".restore", itemType, id, func(tx *sql.Tx) error {
+		if err := inTrashTx(ctx, tx, table, id); err != nil {
+			return err
+		}
+		switch itemType {
+		case auditTask:
+			if err := checkTaskRestoreTx(ctx, tx, id); err != nil {
+				return err
+			}
+		case auditReport:
+			if err := checkReportRestoreTx(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET deleted_at = NULL, deleted_by = 0 WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("restore %s: %w", itemType, err)
+		}
+		return nil
+	})
+}
+
+func inTrashTx(ctx context.Context, tx *sql.Tx, table string, id int64) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE id = ? AND deleted_at IS NOT NULL`, id).Scan(&count); err != nil {
+		return fmt.Errorf("query trash item: %w", err)
+	}
+	if count == 0 {
+		return errNotInTrash
+	}
+	return nil
+}
+
+func checkTaskRestoreTx(ctx context.Context, tx *sql.Tx, taskID int64) error {
+	var projectDeleted, parentDeleted, parentMoved bool
+	err := tx.QueryRowContext(ctx, `
+SELECT p.deleted_at IS NOT NULL,
+       COALESCE(pt.deleted_at IS NOT NULL, 0),
+       COALESCE(pt.project_id <> t.project_id, 0)
+FROM tasks t
+JOIN projects p ON p.id = t.project_id
+LEFT JOIN tasks pt ON pt.id = t.parent_task_id
+WHERE t.id = ?
+`, taskID).Scan(&projectDeleted, &parentDeleted, &parentMoved)
+	if err != nil {
+		return fmt.Errorf("query restored task: %w", err)
+	}
+	switch {
+	case projectDeleted:
+		return errors.New("сначала восстановите проект задачи")
+	case parentDeleted:
+		return errors.New("сначала восстановите родительскую задачу")
+	case parentMoved:
+		if _, err := tx.ExecContext(ctx, `UPDATE tasks SET parent_task_id = NULL WHERE id = ?`, taskID); err != nil {
+			return fmt.Errorf("detach restored task: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkReportRestoreTx(ctx context.Context, tx *sql.Tx, reportID int64) error {
+	var targetType string
+	var targetDeleted bool
+	err := tx.QueryRowContext(ctx, `
+SELECT lower(r.target_type),
+       EXISTS (SELECT 1 FROM tasks t WHERE lower(r.target_type) = 'task' AND t.id = r.target_id AND NOT (`+liveTaskSQL("t")+`))
+       OR EXISTS (SELECT 1 FROM projects p WHERE lower(r.target_type) = 'project' AND p.id = r.target_id AND p.deleted_at IS NOT NULL)
+FROM reports r
+WHERE r.id = ?
+`, reportID).Scan(&targetType, &targetDeleted)
+	if err != nil {
+		return fmt.Errorf("query restored report: %w", err)
+	}
+	if !targetDeleted {
+		return nil
+	}
+	if targetType == "project" {
+		return errors.New("сначала восстановите проект отчета")
+	}
+	return errors.New("сначала восстановите задачу отчета и ее проект")
+}
+
+// PurgeTrashItem удаляет элемент корзины окончательно: задачу — вместе с подзадачами, отчетами
+// и чатом, проект — со всеми задачами и отчетами. Возвращает пути файлов удаленных отчетов и
+// сообщений; удалить их с диска — забота вызывающего.
+func (r *Repository) PurgeTrashItem(ctx context.Context, itemType string, id int64) ([]string, error) {
+	table, ok := trashTables[itemType]
+	if !ok {
+		return nil, errors.New("неизвестный тип элемента корзины")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := inTrashTx(ctx, tx, table, id); err != nil {
+		return nil, err
+	}
+	var files []string
+	switch itemType {
+	case auditTask:
+		files, err = purgeTasksTx(ctx, tx, `
+WITH RECURSIVE tree(id) AS (
+  SELECT ?
+  UNION
+  SELECT t.id FROM tasks t JOIN tree ON t.parent_task_id = tree.id
+)
+SELECT id FROM tree ORDER BY id
+`, id)
+	case auditProject:
+		files, err = purgeProjectTx(ctx, tx, id)
+	case auditReport:
+		files, err = purgeReportsTx(ctx, tx, `SELECT id FROM reports WHERE id = ?`, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return files, nil
+}
+
+// PurgeExpiredTrash окончательно удаляет то, что лежит в корзине дольше retention. Возвращает
+// число удаленных элементов и пути файлов, которые нужно удалить с диска.
+func (r *Repository) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, []string, error) {
+	// Сначала отчеты, затем задачи и проекты: элемент, удаленный вместе с родителем, пропускается.
+	rows, err := r.db.QueryContext(ctx, `
+SELECT type, id FROM (`+trashItemsSQL+`)
+WHERE deleted_at <= datetime('now', ?)
+ORDER BY CASE type WHEN 'report' THEN 0 WHEN 'task' THEN 1 ELSE 2 END, id
+`, fmt.Sprintf("-%d seconds", int64(retention/time.Second)))
+	if err != nil {
+		return 0, nil, fmt.Errorf("query expired trash: %w", err)
+	}
+	type trashKey struct {
+		itemType string
+		id       int64
+	}
+	expired := make([]trashKey, 0)
+	for rows.Next() {
+		var key trashKey
+		if err := rows.Scan(&key.itemType, &key.id); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("scan expired trash: %w", err)
+		}
+		expired = append(expired, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	purged := 0
+	files := make([]string, 0)
+	for _, key := range expired {
+		removed, err := r.PurgeTrashItem(ctx, key.itemType, key.id)
+		if errors.Is(err, errNotInTrash) {
+			continue
+		}
+		if err != nil {
+			return purged, files, err
+		}
+		purged++
+		files = append(files, removed...)
+	}
+	return purged, files, nil
+}
+
+// purgeProjectTx удаляет проект вместе со всеми его задачами и отчетами по нему.
+func purgeProjectTx(ctx context.Context, tx *sql.Tx, projectID int64) ([]string, error) {
+	files, err := purgeTasksTx(ctx, tx, `SELECT id FROM tasks WHERE project_id = ? ORDER BY id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	reportFiles, err := purgeReportsTx(ctx, tx, `SELECT id FROM reports WHERE lower(target_type) = 'project' AND target_id = ? ORDER BY id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, reportFiles...)
+
+	before, err := auditState(ctx, tx, auditProject, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM projects WHERE id = ?`, projectID); err != nil {
+		return nil, fmt.Errorf("purge project: %w", err)
+	}
+	if err := recordAudit(ctx, tx, "project.purge", auditProject, projectID, before); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// purgeTasksTx удаляет задачи, которые выбирает idsQuery, вместе с их отчетами и сообщениями чата.
+func purgeTasksTx(ctx context.Context, tx *sql.Tx, idsQuery string, args ...any) ([]string, error) {
+	tasks, err := auditStates(ctx, tx, auditTask, idsQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.id)
+	}
+	placeholders, idArgs := int64Placeholders(ids)
+
+	files, err := purgeReportsTx(ctx, tx, `SELECT id FROM reports WHERE lower(target_type) = 'task' AND target_id IN (`+placeholders+`) ORDER BY id`, idArgs...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT file_path FROM chat_messages WHERE scope_type = 'task' AND scope_id IN (`+placeholders+`) AND file_path <> ''`, idArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("query task chat files: %w", err)
+	}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task chat file: %w", err)
+		}
+		files = append(files, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chat_messages WHERE scope_type = 'task' AND scope_id IN (`+placeholders+`)`, idArgs...); err != nil {
+		return nil, fmt.Errorf("purge task chat: %w", err)
+	}
+	// parent_task_id объявлен без каскада: ссылки на удаляемые задачи снимаются у всех задач,
+	// включая оставшиеся подзадачи, иначе внешний ключ не даст удалить родителя.
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET parent_task_id = NULL WHERE parent_task_id IN (`+placeholders+`)`, idArgs...); err != nil {
+		return nil, fmt.Errorf("detach purged tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id IN (`+placeholders+`)`, idArgs...); err != nil {
+		return nil, fmt.Errorf("purge tasks: %w", err)
+	}
+	if err := recordAuditAll(ctx, tx, "task.purge", auditTask, tasks); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// purgeReportsTx удаляет отчеты, которые выбирает idsQuery, и возвращает пути их файлов.
+func purgeReportsTx(ctx context.Context, tx *sql.Tx, idsQuery string, args ...any) ([]string, error) {
+	reports, err := auditStates(ctx, tx, auditReport, idsQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, report := range reports {
+		if path, _ := report.state["file_path"].(string); strings.TrimSpace(path) != "" {
+			files = append(files, path)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM reports WHERE id = ?`, report.id); err != nil {
+			return nil, fmt.Errorf("purge report: %w", err)
+		}
+	}
+	if err := recordAuditAll(ctx, tx, "report.purge", auditReport, reports); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
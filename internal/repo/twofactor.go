@@ -0,0 +1,300 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mvd/taskflow/internal/auth"
+	"github.com/mvd/taskflow/internal/models"
+)
+
+const (
+	loginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+)
+
+var errInvalidSecondFactor = errors.New("неверный код подтверждения")
+
+func (r *Repository) TwoFactorStatus(ctx context.Context, userID int64) (models.TwoFactorStatus, error) {
+	var status models.TwoFactorStatus
+	var enabled int
+	err := r.db.QueryRowContext(ctx, `
+SELECT u.totp_enabled,
+       (SELECT COUNT(1) FROM recovery_codes rc WHERE rc.user_id = u.id AND rc.used_at IS NULL)
+FROM users u
+WHERE u.id = ?
+`, userID).Scan(&enabled, &status.RecoveryCodesLeft)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TwoFactorStatus{}, errors.New("пользователь не найден")
+		}
+		return models.TwoFactorStatus{}, fmt.Errorf("query two-factor status: %w", err)
+	}
+	status.Enabled = enabled == 1
+	return status, nil
+}
+
+func (r *Repository) StartTwoFactorSetup(ctx context.Context, userID int64) (string, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	res, err := r.db.ExecContext(ctx, `
+UPDATE users SET totp_secret = ?, totp_last_step = 0
+WHERE id = ? AND totp_enabled = 0
+`, secret, userID)
+	if err != nil {
+		return "", fmt.Errorf("store totp secret: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return "", errors.New("двухфакторная аутентификация уже включена")
+	}
+	return secret, nil
+}
+
+func (r *Repository) EnableTwoFactor(ctx context.Context, userID int64, code string) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled int
+	if err := tx.QueryRowContext(ctx, `SELECT totp_secret, totp_enabled FROM users WHERE id = ?`, userID).Scan(&secret, &enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("пользователь не найден")
+		}
+		return nil, fmt.Errorf("load totp secret: %w", err)
+	}
+	if enabled == 1 {
+		return nil, errors.New("двухфакторная аутентификация уже включена")
+	}
+	if strings.TrimSpace(secret) == "" {
+		return nil, errors.New("сначала получите секрет для приложения-аутентификатора")
+	}
+	step, ok := auth.ValidateTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errInvalidSecondFactor
+	}
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?`, step, userID); err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	codes, err := replaceRecoveryCodesTx(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAudit(ctx, tx, "user.2fa_enable", auditUser, userID, before); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return codes, nil
+}
+
+func (r *Repository) DisableTwoFactor(ctx context.Context, userID int64) error {
+	return r.disableTwoFactor(ctx, userID, "user.2fa_disable")
+}
+
+func (r *Repository) disableTwoFactor(ctx context.Context, userID int64, action string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := auditState(ctx, tx, auditUser, userID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errors.New("пользователь не найден")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	if err := recordAudit(ctx, tx, action, auditUser, userID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// ResetTwoFactor — сброс администратором: отключает 2FA и завершает все сессии пользователя.
+func (r *Repository) ResetTwoFactor(ctx context.Context, userID int64) error {
+	if err := r.disableTwoFactor(ctx, userID, "user.2fa_reset"); err != nil {
+		return err
+	}
+	if _, err := r.RevokeUserSessions(ctx, userID, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodesTx(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAuditFact(ctx, tx, "user.recovery_codes", auditUser, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return codes, nil
+}
+
+func replaceRecoveryCodesTx(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, auth.HashRecoveryCode(code)); err != nil {
+			return nil, fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor принимает либо код из приложения, либо одноразовый код восстановления.
+func (r *Repository) VerifySecondFactor(ctx context.Context, userID int64, code, recoveryCode string) error {
+	if strings.TrimSpace(recoveryCode) != "" {
+		res, err := r.db.ExecContext(ctx, `
+UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+WHERE id = (
+  SELECT id FROM recovery_codes
+  WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+  LIMIT 1
+)
+`, userID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			return fmt.Errorf("use recovery code: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	var secret string
+	var enabled int
+	var lastStep int64
+	if err := r.db.QueryRowContext(ctx, `SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?`, userID).Scan(&secret, &enabled, &lastStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("пользователь не найден")
+		}
+		return fmt.Errorf("load totp secret: %w", err)
+	}
+	if enabled != 1 {
+		return errors.New("двухфакторная аутентификация не включена")
+	}
+	step, ok := auth.ValidateTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("store totp step: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+func (r *Repository) CreateLoginChallenge(ctx context.Context, userID int64) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+		return "", fmt.Errorf("purge login challenges: %w", err)
+	}
+	expiresAt := time.Now().UTC().Add(loginChallengeTTL)
+	if _, err := r.db.ExecContext(ctx, `
+INSERT INTO login_challenges (token_hash, user_id, expires_at)
+VALUES (?, ?, ?)
+`, hashSessionToken(token), userID, expiresAt.Format(sqliteTimeLayout)); err != nil {
+		return "", fmt.Errorf("insert login challenge: %w", err)
+	}
+	return token, nil
+}
+
+// UserByLoginChallenge засчитывает попытку и возвращает пользователя, если
+// вызов еще действителен и лимит попыток не исчерпан.
+func (r *Repository) UserByLoginChallenge(ctx context.Context, token string) (models.User, error) {
+	expired := errors.New("время подтверждения входа истекло, войдите заново")
+	res, err := r.db.ExecContext(ctx, `
+UPDATE login_challenges SET attempts = attempts + 1
+WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP AND attempts < ?
+`, hashSessionToken(token), loginChallengeMaxAttempts)
+	if err != nil {
+		return models.User{}, fmt.Errorf("update login challenge: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return models.User{}, fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return models.User{}, expired
+	}
+	var userID int64
+	if err := r.db.QueryRowContext(ctx, `SELECT user_id FROM login_challenges WHERE token_hash = ?`, hashSessionToken(token)).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, expired
+		}
+		return models.User{}, fmt.Errorf("query login challenge: %w", err)
+	}
+	return r.UserByID(ctx, userID)
+}
+
+func (r *Repository) DeleteLoginChallenge(ctx context.Context, token string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE token_hash = ?`, hashSessionToken(token)); err != nil {
+		return fmt.Errorf("delete login challenge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mvd/taskflow/internal/models"
+)
+
+// taskTerminalSQL — условие «задача alias в конечном статусе процесса своего проекта».
+func taskTerminalSQL(alias string) string {
+	return `EXISTS (SELECT 1 FROM projects wp JOIN workflow_statuses ws ON ws.workflow_id = wp.workflow_id
+WHERE wp.id = ` + alias + `.project_id AND ws.name = ` + alias + `.status AND ws.is_terminal = 1)`
+}
+
+// Workflows возвращает все процессы вместе с проектами, которым они назначены.
+func (r *Repository) Workflows(ctx context.Context) ([]models.Workflow, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id FROM workflows ORDER BY is_default DESC, id`)
+	if err != nil {
+		return nil, fmt.Errorf("query workflows: %w", err)
+	}
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan workflow: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make([]models.Workflow, 0, len(ids))
+	for _, id := range ids {
+		item, err := loadWorkflow(ctx, r.db, id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (r *Repository) Workflow(ctx context.Context, workflowID int64) (models.Workflow, error) {
+	return loadWorkflow(ctx, r.db, workflowID)
+}
+
+// ProjectWorkflow возвращает процесс, назначенный проекту.
+func (r *Repository) ProjectWorkflow(ctx context.Context, projectID int64) (models.Workflow, error) {
+	return projectWorkflow(ctx, r.db, projectID)
+}
+
+func projectWorkflow(ctx context.Context, q querier, projectID int64) (models.Workflow, error) {
+	var workflowID int64
+	if err := q.QueryRowContext(ctx, `SELECT workflow_id FROM projects WHERE id = ?`, projectID).Scan(&workflowID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Workflow{}, errors.New("проект не найден")
+		}
+		return models.Workflow{}, fmt.Errorf("query project workflow: %w", err)
+	}
+	return loadWorkflow(ctx, q, workflowID)
+}
+
+func loadWorkflow(ctx context.Context, q querier, workflowID int64) (models.Workflow, error) {
+	var item models.Workflow
+	var isDefault int
+	err := q.QueryRowContext(ctx, `SELECT id, name, is_default FROM workflows WHERE id = ?`, workflowID).Scan(&item.ID, &item.Name, &isDefault)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Workflow{}, errors.New("процесс не найден")
+		}
+		return models.Workflow{}, fmt.Errorf("query workflow: %w", err)
+	}
+	item.IsDefault = isDefault == 1
+
+	rows, err := q.QueryContext(ctx, `
+SELECT name, title, is_initial, is_terminal FROM workflow_statuses WHERE workflow_id = ? ORDER BY position, name
+`, workflowID)
+	if err != nil {
+		return models.Workflow{}, fmt.Errorf("query workflow statuses: %w", err)
+	}
+	item.Statuses = make([]models.WorkflowStatus, 0)
+	for rows.Next() {
+		var st models.WorkflowStatus
+		var initial, terminal int
+		if err := rows.Scan(&st.Name, &st.Title, &initial, &terminal); err != nil {
+			rows.Close()
+			return models.Workflow{}, fmt.Errorf("scan workflow status: %w", err)
+		}
+		st.Initial = initial == 1
+		st.Terminal = terminal == 1
+		item.Statuses = append(item.Statuses, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.Workflow{}, err
+	}
+
+	rows, err = q.QueryContext(ctx, `
+SELECT t.from_status, t.to_status, t.roles
+FROM workflow_transitions t
+LEFT JOIN workflow_statuses f ON f.workflow_id = t.workflow_id AND f.name = t.from_status
+LEFT JOIN workflow_statuses s ON s.workflow_id = t.workflow_id AND s.name = t.to_status
+WHERE t.workflow_id = ?
+ORDER BY f.position, s.position
+`, workflowID)
+	if err != nil {
+		return models.Workflow{}, fmt.Errorf("query workflow transitions: %w", err)
+	}
+	item.Transitions = make([]models.WorkflowTransition, 0)
+	for rows.Next() {
+		var tr models.WorkflowTransition
+		var roles string
+		if err := rows.Scan(&tr.From, &tr.To, &roles); err != nil {
+			rows.Close()
+			return models.Workflow{}, fmt.Errorf("scan workflow transition: %w", err)
+		}
+		tr.Roles = make([]string, 0)
+		if roles != "" {
+			tr.Roles = strings.Split(roles, ",")
+		}
+		item.Transitions = append(item.Transitions, tr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.Workflow{}, err
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT id FROM projects WHERE workflow_id = ? ORDER BY id`, workflowID)
+	if err != nil {
+		return models.Workflow{}, fmt.Errorf("query workflow projects: %w", err)
+	}
+	defer rows.Close()
+	item.ProjectIDs = make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return models.Workflow{}, fmt.Errorf("scan workflow project: %w", err)
+		}
+		item.ProjectIDs = append(item.ProjectIDs, id)
+	}
+	return item, rows.Err()
+}
+
+// CreateWorkflow создает процесс и возвращает его id.
+func (r *Repository) CreateWorkflow(ctx context.Context, in models.WorkflowInput) (int64, error) {
+	return r.auditedInsert(ctx, "workflow.create", auditWorkflow, func(tx *sql.Tx) (sql.Result, error) {
+		in, err := normalizeWorkflowInputTx(ctx, tx, in)
+		if err != nil {
+			return nil, err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO workflows (name) VALUES (?)`, in.Name)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				return nil, errors.New("процесс с таким названием уже есть")
+			}
+			return nil, fmt.Errorf("insert workflow: %w", err)
+		}
+		workflowID, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("last insert id: %w", err)
+		}
+		if err := writeWorkflowTx(ctx, tx, workflowID, in); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+}
+
+// UpdateWorkflow заменяет статусы и переходы процесса. Статус, в котором находятся задачи
+// проектов с этим процессом, убрать нельзя.
+func (r *Repository) UpdateWorkflow(ctx context.Context, workflowID int64, in models.WorkflowInput) error {
+	return r.auditedUpdate(ctx, "workflow.update", auditWorkflow, workflowID, func(tx *sql.Tx) error {
+		in, err := normalizeWorkflowInputTx(ctx, tx, in)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE workflows SET name = ? WHERE id = ?`, in.Name, workflowID)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				return errors.New("процесс с таким названием уже есть")
+			}
+			return fmt.Errorf("update workflow: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("процесс не найден")
+		}
+		if err := checkTaskStatusesTx(ctx, tx, `
+SELECT DISTINCT t.status FROM tasks t JOIN projects p ON p.id = t.project_id WHERE p.workflow_id = ? ORDER BY t.status
+`, workflowID, in.Statuses); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM workflow_statuses WHERE workflow_id = ?`, workflowID); err != nil {
+			return fmt.Errorf("clear workflow statuses: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM workflow_transitions WHERE workflow_id = ?`, workflowID); err != nil {
+			return fmt.Errorf("clear workflow transitions: %w", err)
+		}
+		return writeWorkflowTx(ctx, tx, workflowID, in)
+	})
+}
+
+// DeleteWorkflow удаляет процесс, который не назначен ни одному проекту. Основной процесс не удаляется.
+func (r *Repository) DeleteWorkflow(ctx context.Context, workflowID int64) error {
+	return r.auditedUpdate(ctx, "workflow.delete", auditWorkflow, workflowID, func(tx *sql.Tx) error {
+		var isDefault, projects int
+		err := tx.QueryRowContext(ctx, `
+SELECT is_default, (SELECT COUNT(*) FROM projects WHERE workflow_id = w.id) FROM workflows w WHERE id = ?
+`, workflowID).Scan(&isDefault, &projects)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errors.New("процесс не найден")
+			}
+			return fmt.Errorf("query workflow: %w", err)
+		}
+		if isDefault == 1 {
+			return errors.New("основной процесс нельзя удалить")
+		}
+		if projects > 0 {
+			return fmt.Errorf("процесс назначен проектам (%d): сначала назначьте им другой процесс", projects)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM workflows WHERE id = ?`, workflowID); err != nil {
+			return fmt.Errorf("delete workflow: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetProjectWorkflow назначает проекту процесс. Все статусы задач проекта должны в нем быть.
+func (r *Repository) SetProjectWorkflow(ctx context.Context, projectID, workflowID int64) error {
+	return r.auditedUpdate(ctx, "project.workflow", auditProject, projectID, func(tx *sql.Tx) error {
+		wf, err := loadWorkflow(ctx, tx, workflowID)
+		if err != nil {
+			return err
+		}
+		if err := checkTaskStatusesTx(ctx, tx, `
+SELECT DISTINCT status FROM tasks WHERE project_id = ? ORDER BY status
+`, projectID, wf.Statuses); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE projects SET workflow_id = ? WHERE id = ?`, workflowID, projectID)
+		if err != nil {
+			return fmt.Errorf("update project workflow: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			return errors.New("проект не найден")
+		}
+		return nil
+	})
+}
+
+// checkTaskStatusesTx проверяет, что все статусы, которые вернул query, есть среди statuses.
+func checkTaskStatusesTx(ctx context.Context, tx *sql.Tx, query string, id int64, statuses []models.WorkflowStatus) error {
+	rows, err := tx.QueryContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("query task statuses: %w", err)
+	}
+	defer rows.Close()
+	missing := make([]string, 0)
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return fmt.Errorf("scan task status: %w", err)
+		}
+		if _, ok := findWorkflowStatus(statuses, status); !ok {
+			missing = append(missing, status)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("в процессе нет статусов, в которых находятся задачи: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// normalizeWorkflowInputTx проверяет описание процесса: статусы уникальны, начальный ровно
+// один и он не конечный, конечный есть хотя бы один, переходы соединяют статусы процесса,
+// а роли существуют. Названия ролей приводятся к написанию из справочника.
+func normalizeWorkflowInputTx(ctx context.Context, tx *sql.Tx, in models.WorkflowInput) (models.WorkflowInput, error) {
+	out := models.WorkflowInput{Name: strings.TrimSpace(in.Name)}
+	if out.Name == "" {
+		return out, errors.New("укажите название процесса")
+	}
+	if len(in.Statuses) == 0 {
+		return out, errors.New("в процессе должен быть хотя бы один статус")
+	}
+	initial, terminal := 0, 0
+	for _, st := range in.Statuses {
+		st.Name = strings.TrimSpace(st.Name)
+		st.Title = strings.TrimSpace(st.Title)
+		if st.Name == "" || strings.Contains(st.Name, ",") {
+			return out, errors.New("название статуса не может быть пустым или содержать запятую")
+		}
+		if _, dup := findWorkflowStatus(out.Statuses, st.Name); dup {
+			return out, fmt.Errorf("статус %q указан дважды", st.Name)
+		}
+		if st.Title == "" {
+			st.Title = st.Name
+		}
+		if st.Initial && st.Terminal {
+			return out, fmt.Errorf("статус %q не может быть одновременно начальным и конечным", st.Name)
+		}
+		if st.Initial {
+			initial++
+		}
+		if st.Terminal {
+			terminal++
+		}
+		out.Statuses = append(out.Statuses, st)
+	}
+	if initial != 1 {
+		return out, errors.New("в процессе должен быть ровно один начальный статус")
+	}
+	if terminal == 0 {
+		return out, errors.New("в процессе должен быть хотя бы один конечный статус")
+	}
+
+	seen := make(map[[2]string]bool, len(in.Transitions))
+	for _, tr := range in.Transitions {
+		tr.From = strings.TrimSpace(tr.From)
+		tr.To = strings.TrimSpace(tr.To)
+		if _, ok := findWorkflowStatus(out.Statuses, tr.From); !ok {
+			return out, fmt.Errorf("переход из неизвестного статуса %q", tr.From)
+		}
+		if _, ok := findWorkflowStatus(out.Statuses, tr.To); !ok {
+			return out, fmt.Errorf("переход в неизвестный статус %q", tr.To)
+		}
+		if tr.From == tr.To {
+			return out, fmt.Errorf("переход из статуса %q в него же не нужен", tr.From)
+		}
+		if seen[[2]string{tr.From, tr.To}] {
+			return out, fmt.Errorf("переход %q → %q указан дважды", tr.From, tr.To)
+		}
+		seen[[2]string{tr.From, tr.To}] = true
+		roles := make([]string, 0, len(tr.Roles))
+		for _, role := range tr.Roles {
+			var name string
+			err := tx.QueryRowContext(ctx, `SELECT name FROM roles WHERE name = ? COLLATE NOCASE`, strings.TrimSpace(role)).Scan(&name)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return out, fmt.Errorf("роль %q не найдена", role)
+				}
+				return out, fmt.Errorf("query role: %w", err)
+			}
+			roles = append(roles, name)
+		}
+		tr.Roles = roles
+		out.Transitions = append(out.Transitions, tr)
+	}
+	return out, nil
+}
+
+func writeWorkflowTx(ctx context.Context, tx *sql.Tx, workflowID int64, in models.WorkflowInput) error {
+	for i, st := range in.Statuses {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO workflow_statuses (workflow_id, name, title, is_initial, is_terminal, position) VALUES (?, ?, ?, ?, ?, ?)
+`, workflowID, st.Name, st.Title, boolToInt(st.Initial), boolToInt(st.Terminal), i+1); err != nil {
+			return fmt.Errorf("insert workflow status: %w", err)
+		}
+	}
+	for _, tr := range in.Transitions {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO workflow_transitions (workflow_id, from_status, to_status, roles) VALUES (?, ?, ?, ?)
+`, workflowID, tr.From, tr.To, strings.Join(tr.Roles, ",")); err != nil {
+			return fmt.Errorf("insert workflow transition: %w", err)
+		}
+	}
+	return nil
+}
+
+func findWorkflowStatus(statuses []models.WorkflowStatus, name string) (models.WorkflowStatus, bool) {
+	for _, st := range statuses {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return models.WorkflowStatus{}, false
+}
+
+// initialStatus — начальный статус процесса.
+func initialStatus(wf models.Workflow) string {
+	for _, st := range wf.Statuses {
+		if st.Initial {
+			return st.Name
+		}
+	}
+	return ""
+}
+
+// progressStatus — статус начатой работы: первый промежуточный статус процесса, а если
+// промежуточных нет — начальный.
+func progressStatus(wf models.Workflow) string {
+	for _, st := range wf.Statuses {
+		if !st.Initial && !st.Terminal {
+			return st.Name
+		}
+	}
+	return initialStatus(wf)
+}
+
+// closeStatus — статус, в который задачу переводит закрытие: первый конечный статус процесса.
+func closeStatus(wf models.Workflow) string {
+	for _, st := range wf.Statuses {
+		if st.Terminal {
+			return st.Name
+		}
+	}
+	return ""
+}
+
+// checkTransition проверяет переход задачи из статуса from в статус to ролью role. Задача,
+// чей статус в процессе отсутствует (например, после переноса из другого проекта), может
+// перейти в любой статус процесса.
+func checkTransition(wf models.Workflow, from, to, role string) error {
+	if _, ok := findWorkflowStatus(wf.Statuses, to); !ok {
+		return fmt.Errorf("статуса %q нет в процессе «%s»", to, wf.Name)
+	}
+	if from == to {
+		return nil
+	}
+	if _, ok := findWorkflowStatus(wf.Statuses, from); !ok {
+		return nil
+	}
+	for _, tr := range wf.Transitions {
+		if tr.From != from || tr.To != to {
+			continue
+		}
+		if len(tr.Roles) == 0 {
+			return nil
+		}
+		for _, allowed := range tr.Roles {
+			if strings.EqualFold(allowed, role) {
+				return nil
+			}
+		}
+		return fmt.Errorf("переход %q → %q доступен только ролям: %s", from, to, strings.Join(tr.Roles, ", "))
+	}
+	return fmt.Errorf("процесс «%s» не разрешает переход %q → %q", wf.Name, from, to)
+}
+
+// checkTaskStatusChangeTx проверяет новый статус задачи при правке по процессу ее (возможно,
+// нового) проекта. В конечный статус нельзя перейти, пока не закрыты подзадачи.
+func checkTaskStatusChangeTx(ctx context.Context, tx *sql.Tx, taskID int64, before map[string]any, in models.UpdateTaskInput) error {
+	wf, err := projectWorkflow(ctx, tx, in.ProjectID)
+	if err != nil {
+		return err
+	}
+	from, _ := before["status"].(string)
+	to := strings.TrimSpace(in.Status)
+	if err := checkTransition(wf, from, to, in.ActorRole); err != nil {
+		return err
+	}
+	if st, _ := findWorkflowStatus(wf.Statuses, to); !st.Terminal || from == to {
+		return nil
+	}
+	open, err := openSubtasksTx(ctx, tx, taskID)
+	if err != nil {
+		return err
+	}
+	if open > 0 {
+		return fmt.Errorf("у задачи есть незакрытые подзадачи (%d): закройте их или закройте задачу принудительно", open)
+	}
+	return nil
+}
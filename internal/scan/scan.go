@@ -0,0 +1,126 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Result — вердикт проверки. Threat заполнен, только если файл заражен.
+type Result struct {
+	Clean  bool
+	Threat string
+}
+
+// Scanner проверяет содержимое файла до того, как он будет сохранен и станет доступен другим.
+// Ошибка означает, что проверить файл не удалось; такой файл считается непроверенным.
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+const clamdChunkSize = 32 << 10
+
+// Clamd проверяет файлы через clamd по протоколу INSTREAM.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd принимает адрес вида tcp://host:3310, host:3310 или unix:///run/clamav/clamd.ctl.
+func NewClamd(addr string, timeout time.Duration) (*Clamd, error) {
+	c := &Clamd{network: "tcp", address: addr, timeout: timeout}
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "unix:"):
+		c.network, c.address = "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "tcp://"):
+		c.address = strings.TrimPrefix(addr, "tcp://")
+	}
+	if c.address == "" {
+		return nil, errors.New("empty clamd address")
+	}
+	return c, nil
+}
+
+func (c *Clamd) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return Result{}, fmt.Errorf("set clamd deadline: %w", err)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("write clamd command: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := content.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Result{}, fmt.Errorf("write clamd chunk: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				// clamd закрывает соединение при превышении StreamMaxLength и пишет причину в ответ.
+				if reply, replyErr := readReply(conn); replyErr == nil {
+					return parseReply(reply)
+				}
+				return Result{}, fmt.Errorf("write clamd chunk: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, fmt.Errorf("read content: %w", readErr)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Result{}, fmt.Errorf("write clamd end: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		return "", fmt.Errorf("read clamd reply: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply разбирает ответы "stream: OK", "stream: Eicar-Signature FOUND" и "... ERROR".
+func parseReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case reply == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
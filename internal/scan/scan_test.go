@@ -0,0 +1,178 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd принимает INSTREAM, собирает поток и отвечает reply(содержимое).
+func fakeClamd(t *testing.T, reply func(content []byte) string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveInstream(conn, reply)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveInstream(conn net.Conn, reply func(content []byte) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+	var content bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+			return
+		}
+	}
+	conn.Write([]byte(reply(content.Bytes()) + "\x00"))
+}
+
+func TestClamdScan(t *testing.T) {
+	addr := fakeClamd(t, func(content []byte) string {
+		switch {
+		case bytes.Contains(content, []byte("EICAR")):
+			return "stream: Eicar-Test-Signature FOUND"
+		case bytes.Contains(content, []byte("broken")):
+			return "INSTREAM size limit exceeded. ERROR"
+		default:
+			return "stream: OK"
+		}
+	})
+	clamd, err := NewClamd("tcp://"+addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("new clamd: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    Result
+		wantErr string
+	}{
+		{"clean", "обычный отчет", Result{Clean: true}, ""},
+		{"clean across chunks", strings.Repeat("a", 3*clamdChunkSize+17), Result{Clean: true}, ""},
+		{"infected", "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*", Result{Threat: "Eicar-Test-Signature"}, ""},
+		{"infected after first chunk", strings.Repeat("b", clamdChunkSize) + "EICAR", Result{Threat: "Eicar-Test-Signature"}, ""},
+		{"error reply", "broken", Result{}, "size limit exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clamd.Scan(context.Background(), strings.NewReader(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Scan() error = %v, want %q", err, tt.wantErr)
+				}
+				if got.Clean {
+					t.Fatalf("Scan() = %+v, error reply must not be clean", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Scan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Недоступный clamd — ошибка, а не чистый результат: файл не должен пройти без проверки.
+func TestClamdScanFailsClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	clamd, err := NewClamd(addr, time.Second)
+	if err != nil {
+		t.Fatalf("new clamd: %v", err)
+	}
+	got, err := clamd.Scan(context.Background(), strings.NewReader("файл"))
+	if err == nil || got.Clean {
+		t.Fatalf("Scan() = %+v, %v; want error for unreachable clamd", got, err)
+	}
+}
+
+// Clamd, который принял соединение и молчит, не должен подвешивать загрузку дольше таймаута.
+func TestClamdScanTimesOut(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	clamd, err := NewClamd(ln.Addr().String(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new clamd: %v", err)
+	}
+	got, err := clamd.Scan(context.Background(), strings.NewReader("файл"))
+	if err == nil || got.Clean {
+		t.Fatalf("Scan() = %+v, %v; want timeout error", got, err)
+	}
+}
+
+func TestNewClamdAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"tcp://clamav:3310", "tcp", "clamav:3310"},
+		{"clamav:3310", "tcp", "clamav:3310"},
+		{"unix:///run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"unix:/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+	}
+	for _, tt := range tests {
+		c, err := NewClamd(tt.addr, time.Second)
+		if err != nil {
+			t.Fatalf("NewClamd(%q): %v", tt.addr, err)
+		}
+		if c.network != tt.network || c.address != tt.address {
+			t.Errorf("NewClamd(%q) = %s %s, want %s %s", tt.addr, c.network, c.address, tt.network, tt.address)
+		}
+	}
+	if _, err := NewClamd("tcp://", time.Second); err == nil {
+		t.Error("NewClamd(tcp://) must fail")
+	}
+}
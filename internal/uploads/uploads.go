@@ -0,0 +1,245 @@
+package uploads
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Списки типов по умолчанию для каждого вида загрузок. Элемент вида "image/*" разрешает
+// все поддерживаемые типы с этим префиксом.
+var (
+	AvatarTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+	documentTypes = []string{
+		"application/pdf",
+		"application/rtf",
+		"text/plain",
+		"text/csv",
+		"application/msword",
+		"application/vnd.ms-excel",
+		"application/vnd.ms-powerpoint",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"application/vnd.oasis.opendocument.text",
+		"application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.presentation",
+	}
+	archiveTypes = []string{"application/zip", "application/x-7z-compressed", "application/x-rar-compressed", "application/gzip"}
+
+	ReportTypes = concat(documentTypes, archiveTypes)
+	ChatTypes   = concat(documentTypes, archiveTypes, []string{"image/*"})
+)
+
+// extensions — допустимые расширения для каждого распознаваемого типа, первое подставляется,
+// если у файла расширения нет.
+var extensions = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+	"image/bmp":  {".bmp"},
+
+	"application/pdf":               {".pdf"},
+	"application/rtf":               {".rtf"},
+	"text/plain":                    {".txt", ".log", ".md"},
+	"text/csv":                      {".csv"},
+	"application/msword":            {".doc"},
+	"application/vnd.ms-excel":      {".xls"},
+	"application/vnd.ms-powerpoint": {".ppt"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx"},
+	"application/vnd.oasis.opendocument.text":                                   {".odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {".ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {".odp"},
+
+	"application/zip":              {".zip"},
+	"application/x-7z-compressed":  {".7z"},
+	"application/x-rar-compressed": {".rar"},
+	"application/gzip":             {".gz", ".tgz"},
+}
+
+// Старые форматы Office хранятся в одном контейнере OLE2, тип документа внутри без разбора
+// не различить, поэтому он берется по расширению.
+var oleTypes = map[string]string{
+	".doc": "application/msword",
+	".xls": "application/vnd.ms-excel",
+	".ppt": "application/vnd.ms-powerpoint",
+}
+
+var (
+	oleSignature      = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+	sevenZipSignature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+	zipSignature      = []byte("PK\x03\x04")
+)
+
+const maxNameBytes = 200
+
+// File — проверенный файл: нормализованное имя и тип по содержимому.
+type File struct {
+	Name        string
+	ContentType string
+}
+
+// Check определяет тип по содержимому, проверяет его по списку allowed и сверяет с
+// расширением имени. Если расширения нет, оно добавляется по типу.
+func Check(name string, content []byte, allowed []string) (File, error) {
+	name = NormalizeName(name)
+	ext := strings.ToLower(filepath.Ext(name))
+	contentType := Detect(content, ext)
+	exts, ok := extensions[contentType]
+	if !ok {
+		return File{}, fmt.Errorf("тип файла %s не поддерживается", contentType)
+	}
+	if !Allowed(contentType, allowed) {
+		return File{}, fmt.Errorf("файлы типа %s здесь загружать нельзя", contentType)
+	}
+	switch {
+	case ext == "":
+		name += exts[0]
+	case !contains(exts, ext):
+		return File{}, fmt.Errorf("расширение %s не соответствует содержимому файла (%s)", ext, contentType)
+	}
+	return File{Name: name, ContentType: contentType}, nil
+}
+
+// Detect определяет MIME-тип по первым байтам содержимого. Расширение используется только
+// там, где сигнатура одна на несколько форматов: OLE2 и CSV.
+func Detect(content []byte, ext string) string {
+	ext = strings.ToLower(ext)
+	switch {
+	case bytes.HasPrefix(content, oleSignature):
+		if contentType, ok := oleTypes[ext]; ok {
+			return contentType
+		}
+		return "application/x-ole-storage"
+	case bytes.HasPrefix(content, sevenZipSignature):
+		return "application/x-7z-compressed"
+	case bytes.HasPrefix(content, []byte(`{\rtf`)):
+		return "application/rtf"
+	case bytes.HasPrefix(content, zipSignature):
+		return detectZip(content)
+	}
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(content), ";")
+	switch contentType {
+	case "application/x-gzip":
+		return "application/gzip"
+	case "text/plain":
+		if !utf8.Valid(content) {
+			return "application/octet-stream"
+		}
+		if ext == ".csv" {
+			return "text/csv"
+		}
+	}
+	return contentType
+}
+
+// detectZip отличает документы OOXML и OpenDocument от обычного zip-архива по их содержимому.
+func detectZip(content []byte) string {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	hasContentTypes := false
+	prefixes := map[string]bool{}
+	for _, f := range archive.File {
+		if f.Name == "mimetype" {
+			if rc, err := f.Open(); err == nil {
+				raw, _ := io.ReadAll(io.LimitReader(rc, 128))
+				rc.Close()
+				if mimeType := strings.TrimSpace(string(raw)); strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument.") {
+					return mimeType
+				}
+			}
+		}
+		if f.Name == "[Content_Types].xml" {
+			hasContentTypes = true
+		}
+		if top, _, ok := strings.Cut(f.Name, "/"); ok {
+			prefixes[top] = true
+		}
+	}
+	if hasContentTypes {
+		switch {
+		case prefixes["word"]:
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case prefixes["xl"]:
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case prefixes["ppt"]:
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		}
+	}
+	return "application/zip"
+}
+
+// Allowed проверяет тип по списку, элемент "type/*" разрешает все подтипы.
+func Allowed(contentType string, allowed []string) bool {
+	for _, item := range allowed {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(item, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeName оставляет от присланного имени только базовое имя без управляющих символов и
+// символов, недопустимых в именах файлов, ограничивает длину и приводит расширение к нижнему регистру.
+func NormalizeName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r) || r == utf8.RuneError:
+			return -1
+		case strings.ContainsRune(`<>:"/|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.Join(strings.Fields(name), " "), " .")
+
+	ext := strings.ToLower(filepath.Ext(name))
+	base := strings.TrimRight(strings.TrimSuffix(name, filepath.Ext(name)), " .")
+	if len(ext) > 16 {
+		base, ext = name, ""
+	}
+	for len(base)+len(ext) > maxNameBytes {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	if base == "" {
+		base = "file"
+	}
+	return base + ext
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func concat(lists ...[]string) []string {
+	items := make([]string, 0)
+	for _, list := range lists {
+		items = append(items, list...)
+	}
+	return items
+}
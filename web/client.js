@@ -1,6 +1,7 @@
 (function () {
   const SESSION_KEY = 'taskflow_session';
   const SETTINGS_KEY = 'taskflow_settings';
+  const SESSION_REFRESH_MS = 15 * 60 * 1000;
   const UCS_VIRTUAL_ID = 'ucs';
   const UCS_VIRTUAL_FULL_ID = 'ucs_full';
   const UCS_VIRTUAL_SHORT_NAME = 'УЦС';
@@ -9,36 +10,8 @@
     'Начальник УЦС',
     'Заместитель начальника УЦС'
   ];
-  const POSITIONS_BY_DEPARTMENT = {
-    1: [
-      'Начальник Отдела Поддержки текущих сервисов',
-      'Ведущий системный аналитик отдела Поддержки текущих сервисов',
-      'Системный аналитик отдела Поддержки текущих сервисов',
-      'Ведущий разработчик отдела Поддержки текущих сервисов',
-      'Разработчик отдела Поддержки текущих сервисов',
-      'Тестировщик отдела Поддержки текущих сервисов'
-    ],
-    2: [
-      'Начальник отдела поддержки и развития инфраструктуры',
-      'Ведущий системный администратор',
-      'Системный администратор',
-      'Ведущий сетевой инженер',
-      'Сетевой инженер',
-      'Главный специалист'
-    ],
-    3: [
-      'Начальник отдела технической поддержки',
-      'Главный специалист технической поддержки',
-      'Специалист технической поддержки'
-    ],
-    4: [
-      'Начальник отдела ООИБ',
-      'Зам. нач. отдела ООИБ по бумагам',
-      'Зам. нач. отдела ООИБ по тех. части',
-      'Главный инспектор ООИБ',
-      'Инспектор ООИБ'
-    ]
-  };
+  // Должности отделов приходят из справочника /api/v1/departments.
+  const POSITIONS_BY_DEPARTMENT = {};
 
   function getSession() {
     const raw = localStorage.getItem(SESSION_KEY);
@@ -50,6 +23,16 @@
     localStorage.setItem(SESSION_KEY, JSON.stringify(user));
   }
 
+  function clearSession() {
+    localStorage.removeItem(SESSION_KEY);
+  }
+
+  function handleUnauthorized(path, status) {
+    if (status !== 401 || String(path).startsWith('/api/v1/auth/')) return;
+    clearSession();
+    window.location.href = '/login.html';
+  }
+
   function initials(text) {
     const parts = String(text || '').trim().split(/\s+/).filter(Boolean);
     if (!parts.length) return 'U';
@@ -77,24 +60,17 @@
     avatarWrap.textContent = initials(user.full_name);
   }
 
-  function actorHeaders() {
-    const session = getSession();
-    if (!session || !session.login) return {};
-    return { 'X-Actor-Login': session.login };
-  }
-
   async function api(path, options) {
-    const useActor = !(options && options.noActor === true);
-    const actor = useActor ? actorHeaders() : {};
     const res = await fetch(path, {
       ...options,
+      credentials: 'same-origin',
       headers: {
         'Content-Type': 'application/json',
-        ...actor,
         ...(options && options.headers ? options.headers : {})
       }
     });
     const payload = await res.json().catch(() => ({}));
+    handleUnauthorized(path, res.status);
     if (!res.ok) throw new Error(payload.error || `HTTP ${res.status}`);
     return payload;
   }
@@ -102,33 +78,91 @@
   async function apiMultipart(path, formData) {
     const res = await fetch(path, {
       method: 'POST',
-      headers: {
-        ...actorHeaders()
-      },
+      credentials: 'same-origin',
       body: formData
     });
     const payload = await res.json().catch(() => ({}));
+    handleUnauthorized(path, res.status);
     if (!res.ok) throw new Error(payload.error || `HTTP ${res.status}`);
     return payload;
   }
 
+  async function logout() {
+    try {
+      await api('/api/v1/auth/logout', { method: 'POST' });
+    } catch (_) {
+      // сеанс мог уже истечь — выходим в любом случае
+    }
+    clearSession();
+    window.location.href = '/login.html';
+  }
+
   function initLoginPage() {
     const btn = document.getElementById('login-btn');
     if (!btn) return;
 
+    let challenge = '';
+    const message = document.getElementById('login-message');
+    const params = new URLSearchParams(window.location.search);
+    if (params.get('sso_error')) {
+      message.textContent = params.get('sso_error');
+    }
+    if (params.get('sso') === '1') {
+      // сессию уже выставил сервер в callback'е, осталось получить профиль
+      (async () => {
+        try {
+          const profile = await api('/api/v1/profile');
+          const twoFactor = await api('/api/v1/profile/2fa');
+          setSession({ ...profile.item, two_factor_setup_required: twoFactor.required && !twoFactor.enabled });
+          window.location.href = '/app.html';
+        } catch (e) {
+          message.textContent = e.message;
+        }
+      })();
+    }
+    api('/api/v1/auth/providers').then((data) => {
+      if (!data.oidc) return;
+      document.getElementById('sso-btn').textContent = data.oidc_label || 'Войти через SSO';
+      document.getElementById('sso-block').hidden = false;
+    }).catch(() => {});
+
     btn.addEventListener('click', async () => {
       const login = document.getElementById('login-input').value.trim();
       const password = document.getElementById('password-input').value;
-      const message = document.getElementById('login-message');
       try {
-        const data = await api('/api/v1/auth/login', {
-          method: 'POST',
-          body: JSON.stringify({ login, password })
+        let data;
+        if (challenge) {
+          const code = document.getElementById('twofa-input').value.trim();
+          // коды восстановления имеют вид xxxxx-xxxxx, коды приложения — 6 цифр
+          const payload = /^\d{6}$/.test(code.replace(/\s/g, '')) ? { challenge, code } : { challenge, recovery_code: code };
+          data = await api('/api/v1/auth/login/2fa', { method: 'POST', body: JSON.stringify(payload) });
+        } else {
+          data = await api('/api/v1/auth/login', {
+            method: 'POST',
+            body: JSON.stringify({ login, password })
+          });
+        }
+        if (data.two_factor_required) {
+          challenge = data.challenge;
+          document.getElementById('twofa-block').hidden = false;
+          document.getElementById('twofa-input').focus();
+          btn.textContent = 'Подтвердить';
+          message.textContent = 'Введите код подтверждения';
+          return;
+        }
+        setSession({
+          ...data.user,
+          two_factor_setup_required: Boolean(data.two_factor_setup_required),
+          password_change_required: Boolean(data.password_change_required)
         });
-        setSession(data.user);
         window.location.href = '/app.html';
       } catch (e) {
         message.textContent = e.message;
+        if (challenge && /истекло/.test(e.message)) {
+          challenge = '';
+          document.getElementById('twofa-block').hidden = true;
+          btn.textContent = 'Войти';
+        }
       }
     });
   }
@@ -142,8 +176,9 @@
 
     async function loadRegisterMeta() {
       try {
-        const data = await api('/api/v1/departments', { noActor: true });
+        const data = await api('/api/v1/departments');
         const items = data.items || [];
+        rememberDepartmentPositions(items);
         fillDepartmentSelect(depSelect, items, true);
         fillPositionSelect(posSelect, '', '', true);
         if (posSelect.options.length) posSelect.options[0].textContent = '';
@@ -176,12 +211,17 @@
       try {
         if (!payload.department_id) throw new Error('Выберите отдел');
         if (!payload.position) throw new Error('Выберите должность');
-        await api('/api/v1/auth/register', {
+        const data = await api('/api/v1/auth/register', {
           method: 'POST',
           body: JSON.stringify(payload)
         });
-        msg.textContent = 'Пользователь зарегистрирован. Переход на вход...';
-        setTimeout(() => { window.location.href = '/login.html'; }, 700);
+        msg.textContent = data.message;
+        if (!data.pending) {
+          // сотрудника завел руководитель из приложения — возвращаем его обратно
+          setTimeout(() => { window.location.href = '/app.html'; }, 1200);
+          return;
+        }
+        form.querySelectorAll('button, input, select').forEach((el) => { el.disabled = true; });
       } catch (e) {
         msg.textContent = e.message;
       }
@@ -190,6 +230,40 @@
     loadRegisterMeta();
   }
 
+  function initResetPasswordPage() {
+    const btn = document.getElementById('reset-btn');
+    if (!btn) return;
+    const msg = document.getElementById('reset-message');
+    const token = new URLSearchParams(window.location.hash.slice(1)).get('token') || '';
+    // убираем токен из адресной строки и истории браузера
+    history.replaceState(null, '', window.location.pathname);
+
+    api(`/api/v1/auth/password-reset?token=${encodeURIComponent(token)}`).then((data) => {
+      document.getElementById('reset-login').value = data.login || '';
+    }).catch((e) => {
+      msg.textContent = e.message;
+      btn.disabled = true;
+    });
+
+    btn.addEventListener('click', async () => {
+      const payload = {
+        token,
+        password: document.getElementById('reset-password').value,
+        repeat_password: document.getElementById('reset-password-repeat').value
+      };
+      try {
+        if (!payload.password) throw new Error('Введите новый пароль');
+        if (payload.password !== payload.repeat_password) throw new Error('Пароли не совпадают');
+        const data = await api('/api/v1/auth/password-reset', { method: 'POST', body: JSON.stringify(payload) });
+        msg.textContent = data.message;
+        btn.disabled = true;
+        setTimeout(() => { window.location.href = '/login.html'; }, 1200);
+      } catch (e) {
+        msg.textContent = e.message;
+      }
+    });
+  }
+
   function setView(view) {
     document.querySelectorAll('.view').forEach(v => v.classList.remove('active'));
     document.getElementById(`view-${view}`)?.classList.add('active');
@@ -223,6 +297,13 @@
     return Number.isFinite(num) ? num : 0;
   }
 
+  function rememberDepartmentPositions(items) {
+    Object.keys(POSITIONS_BY_DEPARTMENT).forEach((key) => { delete POSITIONS_BY_DEPARTMENT[key]; });
+    (items || []).forEach((item) => {
+      POSITIONS_BY_DEPARTMENT[Number(item.id)] = (item.positions || []).map(pos => pos.name);
+    });
+  }
+
   function positionOptionsByDepartment(departmentID) {
     const key = String(departmentID || '').trim().toLowerCase();
     if (key === UCS_VIRTUAL_ID || key === UCS_VIRTUAL_FULL_ID) return UCS_TOP_POSITIONS.slice();
@@ -351,34 +432,52 @@
     return 'К выполнению';
   }
 
+  // справочник ролей с сервера, включая пользовательские: названия и права
+  const roleTitles = {};
+  const roleCatalog = { items: [], assignable: [], defaultRole: '' };
+
+  function applyRoleCatalog(data) {
+    roleCatalog.items = data.items || [];
+    roleCatalog.assignable = data.assignable || [];
+    roleCatalog.defaultRole = data.default_role || '';
+    roleCatalog.items.forEach((role) => {
+      roleTitles[String(role.name).toLowerCase()] = role.title;
+    });
+  }
+
   function roleLabel(value) {
-    const v = normalizeRoleValue(value).toLowerCase();
-    if (v === 'owner') return 'Владелец';
-    if (v === 'admin') return 'Начальник УЦС';
-    if (v === 'deputy admin') return 'Заместитель начальника УЦС';
-    if (v === 'project manager') return 'Начальник отдела';
-    if (v === 'guest') return 'Высшее руководство';
-    return 'Сотрудник отдела';
+    const raw = normalizeRoleValue(value);
+    return roleTitles[raw.toLowerCase()] || raw;
   }
 
+  // старые сессии могли сохранить название роли вместо кода
   function normalizeRoleValue(value) {
     const raw = String(value || '').trim();
-    const lower = raw.toLowerCase();
-    if (lower === 'владелец') return 'Owner';
-    if (lower === 'начальник уцс') return 'Admin';
-    if (lower === 'заместитель начальника уцс') return 'Deputy Admin';
-    if (lower === 'начальник отдела') return 'Project Manager';
-    if (lower === 'сотрудник отдела') return 'Member';
-    if (lower === 'высшее руководство') return 'Guest';
-    return raw;
+    const byTitle = roleCatalog.items.find(role => String(role.title).toLowerCase() === raw.toLowerCase());
+    return byTitle ? byTitle.name : raw;
+  }
+
+  // roleGrants — права роли из справочника; области сравниваются по ширине
+  const SCOPE_RANK = { own: 1, department: 2, all: 3 };
+
+  function roleGrants(role) {
+    const lower = String(role || '').toLowerCase();
+    const item = roleCatalog.items.find(r => String(r.name).toLowerCase() === lower);
+    return item ? (item.permissions || []) : [];
+  }
+
+  function widestScope(grants, action) {
+    return grants
+      .filter(g => g.action === action)
+      .reduce((best, g) => ((SCOPE_RANK[g.scope] || 0) > (SCOPE_RANK[best] || 0) ? g.scope : best), '');
   }
 
   function routeStageLabel(stage) {
     const s = Number(stage || 0);
     if (s <= 1) return 'У Начальника УЦС';
     if (s === 2) return 'У Заместителя начальника УЦС';
-    if (s === 3) return 'У Начальника отдела';
-    return 'У сотрудника отдела';
+    if (s === 3) return 'У руководителя подразделения';
+    return 'У сотрудника подразделения';
   }
 
   function fillDepartmentSelect(select, items, withEmpty) {
@@ -393,25 +492,47 @@
       empty.textContent = '';
       select.appendChild(empty);
     }
-    const ucsShortOption = document.createElement('option');
-    ucsShortOption.value = UCS_VIRTUAL_ID;
-    ucsShortOption.textContent = UCS_VIRTUAL_SHORT_NAME;
-    select.appendChild(ucsShortOption);
-    const ucsFullOption = document.createElement('option');
-    ucsFullOption.value = UCS_VIRTUAL_FULL_ID;
-    ucsFullOption.textContent = UCS_VIRTUAL_NAME;
-    select.appendChild(ucsFullOption);
+    // Пока в справочнике нет корневого управления, УЦС показывается виртуальным пунктом.
+    const hasRoot = departments.some(d => !Number(d.parent_id || 0));
+    if (!hasRoot) {
+      const ucsShortOption = document.createElement('option');
+      ucsShortOption.value = UCS_VIRTUAL_ID;
+      ucsShortOption.textContent = UCS_VIRTUAL_SHORT_NAME;
+      select.appendChild(ucsShortOption);
+      const ucsFullOption = document.createElement('option');
+      ucsFullOption.value = UCS_VIRTUAL_FULL_ID;
+      ucsFullOption.textContent = UCS_VIRTUAL_NAME;
+      select.appendChild(ucsFullOption);
+    }
     const group = document.createElement('optgroup');
     group.label = 'Отделы и подразделения';
-    departments.forEach((item) => {
+    departmentTree(departments).forEach(({ item, depth }) => {
       const option = document.createElement('option');
       option.value = item.id;
-      option.textContent = item.name;
+      option.textContent = `${'— '.repeat(depth)}${item.name}`;
       group.appendChild(option);
     });
     select.appendChild(group);
   }
 
+  // departmentTree упорядочивает подразделения обходом дерева и возвращает глубину каждого.
+  function departmentTree(items) {
+    const list = items || [];
+    const ids = new Set(list.map(d => Number(d.id)));
+    const out = [];
+    const visit = (parentID, depth) => {
+      list.filter(d => Number(d.parent_id || 0) === parentID).forEach((item) => {
+        out.push({ item, depth });
+        visit(Number(item.id), depth + 1);
+      });
+    };
+    list.filter(d => !ids.has(Number(d.parent_id || 0))).forEach((item) => {
+      out.push({ item, depth: 0 });
+      visit(Number(item.id), 1);
+    });
+    return out;
+  }
+
   function departmentLabel(departmentID, departments) {
     const found = (departments || []).find(d => Number(d.id) === Number(departmentID));
     return found ? found.name : 'Все отделы';
@@ -476,12 +597,27 @@
       return;
     }
 
+    try {
+      applyRoleCatalog(await api('/api/v1/roles'));
+    } catch (_) {
+      // до смены пароля справочник недоступен, интерфейс откроет только профиль
+    }
     session.role = normalizeRoleValue(session.role);
     renderSessionUser(session);
-    const canManageUsersOnly = ['Owner', 'Admin', 'Deputy Admin', 'Project Manager'].includes(session.role);
-    const canManageWorkItems = ['Owner', 'Admin', 'Deputy Admin'].includes(session.role);
-    const isSuper = ['Owner', 'Admin', 'Deputy Admin'].includes(session.role);
-    const isScopedRole = ['Member', 'Guest'].includes(session.role);
+    document.getElementById('nav-logout')?.addEventListener('click', (e) => {
+      e.preventDefault();
+      logout();
+    });
+    setInterval(() => {
+      api('/api/v1/auth/refresh', { method: 'POST' }).catch(() => handleUnauthorized('', 401));
+    }, SESSION_REFRESH_MS);
+    // возможности интерфейса выводятся из прав роли; окончательно права проверяет сервер
+    const ownGrants = roleGrants(session.role);
+    const canManageUsersOnly = widestScope(ownGrants, 'user.manage') !== '';
+    const canManageWorkItems = widestScope(ownGrants, 'project.manage') !== '' || widestScope(ownGrants, 'task.manage') !== '';
+    const isSuper = widestScope(ownGrants, 'task.read') === 'all';
+    const isScopedRole = widestScope(ownGrants, 'task.read') === 'own' && widestScope(ownGrants, 'user.list') === '';
+    const userDepartmentLocked = widestScope(ownGrants, 'user.create') !== 'all';
 
     let users = [];
     let departments = [];
@@ -641,21 +777,25 @@
       fillPositionSelect(document.getElementById('user-position'), depRaw, selectedValue || '', false);
     }
 
+    function defaultAssignableRole() {
+      if (roleCatalog.assignable.includes(roleCatalog.defaultRole)) return roleCatalog.defaultRole;
+      return roleCatalog.assignable[0] || '';
+    }
+
     function applyUserEditorPermissions() {
       if (!canManageUsersOnly) return;
       const roleSelect = document.getElementById('user-role');
       const departmentSelect = document.getElementById('user-department');
-      if (session.role !== 'Project Manager') {
-        roleSelect.disabled = false;
-        departmentSelect.disabled = false;
-        return;
-      }
-      const allowedRoles = new Set(['Member', 'Guest']);
+      const allowedRoles = new Set(roleCatalog.assignable);
       Array.from(roleSelect.options).forEach((opt) => {
         opt.hidden = !allowedRoles.has(opt.value);
       });
-      if (!allowedRoles.has(roleSelect.value)) roleSelect.value = 'Member';
+      if (!allowedRoles.has(roleSelect.value)) roleSelect.value = defaultAssignableRole();
       roleSelect.disabled = false;
+      if (!userDepartmentLocked) {
+        departmentSelect.disabled = false;
+        return;
+      }
       departmentSelect.value = String(session.department_id || '');
       departmentSelect.disabled = true;
     }
@@ -698,7 +838,7 @@
       document.getElementById('user-fullname').value = '';
       document.getElementById('user-department').value = String(session.department_id || 1);
       refreshUserPositionOptions('');
-      document.getElementById('user-role').value = 'Member';
+      document.getElementById('user-role').value = defaultAssignableRole();
       document.getElementById('delete-user-editor-btn')?.classList.add('hidden');
       applyUserEditorPermissions();
       document.getElementById('user-editor-message').textContent = '';
@@ -1061,18 +1201,10 @@
     }
 
     async function loadDepartments() {
-      if (isScopedRole) {
-        departments = [{
-          id: Number(session.department_id || 1),
-          name: session.department_name || 'Мой отдел'
-        }];
-        fillSelect(document.getElementById('project-department'), departments, 'id', 'name', true);
-        fillDepartmentSelect(document.getElementById('user-department'), departments, false);
-        fillMessengerSelectors();
-        return;
-      }
+      // Для ограниченных ролей сервер сам возвращает только собственный отдел.
       const data = await api('/api/v1/departments');
       departments = data.items || [];
+      rememberDepartmentPositions(departments);
       fillSelect(document.getElementById('project-department'), departments, 'id', 'name', true);
       fillDepartmentSelect(document.getElementById('user-department'), departments, false);
       fillMessengerSelectors();
@@ -1091,6 +1223,22 @@
       renderPickerRows('taskAssignees', 'task-assignees-wrap');
     }
 
+    async function loadRoles() {
+      applyRoleCatalog(await api('/api/v1/roles'));
+      const select = document.getElementById('user-role');
+      if (!select) return;
+      const current = select.value;
+      select.innerHTML = '';
+      roleCatalog.items.forEach((role) => {
+        const opt = document.createElement('option');
+        opt.value = role.name;
+        opt.textContent = role.title;
+        select.appendChild(opt);
+      });
+      if (current) select.value = current;
+      applyUserEditorPermissions();
+    }
+
     async function loadUsers() {
       const data = await api('/api/v1/users');
       users = (data.items || []).slice().sort((a, b) => Number(a.id) - Number(b.id));
@@ -1113,6 +1261,42 @@
       });
     }
 
+    async function loadRegistrations() {
+      const data = await api('/api/v1/registrations');
+      const tbody = document.querySelector('#registrations-table tbody');
+      if (!tbody) return;
+      tbody.innerHTML = '';
+      const items = data.items || [];
+      if (!items.length) {
+        tbody.innerHTML = '<tr><td colspan="6" class="sub">Новых заявок нет</td></tr>';
+        return;
+      }
+      items.forEach((item) => {
+        const tr = document.createElement('tr');
+        tr.innerHTML = `<td>${escapeHTML(item.login)}</td><td>${escapeHTML(item.full_name)}</td><td>${escapeHTML(item.position)}</td><td>${escapeHTML(item.department_name || '—')}</td><td>${escapeHTML(item.created_at)}</td><td><button class="btn btn-sm btn-success approve-registration-btn" data-id="${item.id}">Подтвердить</button> <button class="btn btn-sm btn-secondary reject-registration-btn" data-id="${item.id}">Отклонить</button></td>`;
+        tbody.appendChild(tr);
+      });
+      tbody.querySelectorAll('.approve-registration-btn').forEach((btn) => {
+        btn.addEventListener('click', async () => {
+          try {
+            await api(`/api/v1/registrations/${btn.dataset.id}/approve`, { method: 'POST' });
+            await loadRegistrations();
+            await loadUsers();
+          } catch (e) { alert(e.message); }
+        });
+      });
+      tbody.querySelectorAll('.reject-registration-btn').forEach((btn) => {
+        btn.addEventListener('click', async () => {
+          const reason = (prompt('Причина отклонения заявки') || '').trim();
+          if (!reason) return;
+          try {
+            await api(`/api/v1/registrations/${btn.dataset.id}/reject`, { method: 'POST', body: JSON.stringify({ reason }) });
+            await loadRegistrations();
+          } catch (e) { alert(e.message); }
+        });
+      });
+    }
+
     async function loadProjects() {
       const qs = selectedDepartmentID ? `?department_id=${selectedDepartmentID}` : '';
       const data = await api(`/api/v1/projects${qs}`);
@@ -1168,12 +1352,14 @@
         }
         const stage = Number(t.route_stage || 4);
         const routeOwnerID = Number(t.route_owner_user_id || 0);
-        const normalizedRole = normalizeRoleValue(session.role || '');
-        const sameDepartment = Number(t.department_id || 0) === Number(session.department_id || 0);
         const isRouteOwner = routeOwnerID === Number(session.id || 0);
-        const canRouteTask = (isSuper && stage <= 2) ||
-          (normalizedRole === 'Deputy Admin' && stage === 2 && (routeOwnerID === 0 || isRouteOwner)) ||
-          (normalizedRole === 'Project Manager' && sameDepartment && (stage >= 3 || (stage >= 2 && isRouteOwner)));
+        // кнопка повторяет права task.route роли; окончательно права проверяет сервер
+        const canRouteTask = ownGrants.filter(g => g.action === 'task.route').some((g) => {
+          if (g.condition === 'unassigned') return routeOwnerID === 0;
+          if (g.condition === 'department_stage') return stage >= 3;
+          if (g.scope === 'own') return isRouteOwner;
+          return g.scope === 'all' && !g.condition && stage <= 2;
+        });
         if (canRouteTask) {
           baseActions.push(`<button class="btn btn-sm btn-secondary route-task-btn" data-id="${t.id}">Расписать</button>`);
         }
@@ -1198,22 +1384,9 @@
       if (!id) return;
       const task = tasks.find((t) => Number(t.id) === id);
       if (!task) throw new Error('Задача не найдена');
-      const stage = Number(task.route_stage || 4);
-      let candidates = [];
-      const normalizedRole = normalizeRoleValue(session.role || '');
-      if (isSuper && stage <= 2) {
-        candidates = users.filter((u) => {
-          const role = normalizeRoleValue(u.role || '').toLowerCase();
-          return role === 'deputy admin' || role === 'project manager' || role === 'member';
-        });
-      } else if (normalizedRole === 'Deputy Admin' && stage === 2) {
-        candidates = users.filter((u) => {
-          const role = normalizeRoleValue(u.role || '').toLowerCase();
-          return role === 'project manager' || role === 'member';
-        });
-      } else {
-        candidates = users.filter((u) => normalizeRoleValue(u.role || '').toLowerCase() === 'member' && Number(u.department_id) === Number(task.department_id));
-      }
+      // Получателей считает сервер: маршрут идет вниз по дереву подразделений.
+      const data = await api(`/api/v1/tasks/${id}/route`);
+      const candidates = data.items || [];
       if (!candidates.length) throw new Error('Нет доступных получателей для этапа СЭД');
       const list = candidates.map((u, i) => `${i + 1}. ${u.full_name} (${u.position})`).join('\n');
       const raw = prompt(`Передача задачи по СЭД:\n${list}\n\nВведите номер сотрудника:`);
@@ -1397,11 +1570,11 @@
         document.getElementById('user-department')?.dataset?.ucsFallbackId || 0
       );
       let role = document.getElementById('user-role').value;
-      if (session.role === 'Project Manager') {
+      if (userDepartmentLocked) {
         departmentID = Number(session.department_id || 0);
-        if (role !== 'Member' && role !== 'Guest') {
-          role = 'Member';
-        }
+      }
+      if (!roleCatalog.assignable.includes(role)) {
+        role = defaultAssignableRole();
       }
       const payload = {
         login: document.getElementById('user-login').value.trim(),
@@ -1453,6 +1626,172 @@
         }
       }
       document.getElementById('profile-message').textContent = '';
+      if (session.password_change_required) {
+        // до смены пароля сервер отклоняет все остальные запросы
+        document.getElementById('profile-message').textContent = 'Задайте новый пароль, чтобы продолжить работу';
+        document.getElementById('profile-password').focus();
+        return;
+      }
+      await loadTwoFactor();
+      await loadTokens();
+      await loadSessions();
+    }
+
+    async function loadTokens() {
+      const data = await api('/api/v1/profile/tokens');
+      const tbody = document.querySelector('#tokens-table tbody');
+      if (!tbody) return;
+      tbody.innerHTML = '';
+      (data.items || []).forEach((item) => {
+        const tr = document.createElement('tr');
+        const used = item.last_used_at ? `${escapeHTML(item.last_used_at)} (${escapeHTML(item.last_used_ip)})` : '—';
+        const account = item.service_account ? `${escapeHTML(item.user_login)} <span class="sub">(сервисная)</span>` : escapeHTML(item.user_login);
+        tr.innerHTML = `<td title="${escapeAttr(item.prefix)}…">${escapeHTML(item.name)}</td><td>${account}</td><td>${escapeHTML((item.scopes || []).join(', '))}</td><td>${escapeHTML(item.expires_at || 'бессрочно')}</td><td>${used}</td><td><button class="btn btn-sm btn-secondary revoke-token-btn" data-id="${item.id}">Отозвать</button></td>`;
+        tbody.appendChild(tr);
+      });
+      tbody.querySelectorAll('.revoke-token-btn').forEach((btn) => {
+        btn.addEventListener('click', async () => {
+          if (!confirm('Отозвать токен? Использующие его интеграции перестанут работать.')) return;
+          try {
+            await api(`/api/v1/profile/tokens/${btn.dataset.id}`, { method: 'DELETE' });
+            await loadTokens();
+          } catch (e) { alert(e.message); }
+        });
+      });
+
+      const accountSelect = document.getElementById('token-account');
+      if (isSuper && accountSelect) {
+        const accounts = await api('/api/v1/service-accounts');
+        accountSelect.innerHTML = '';
+        const own = document.createElement('option');
+        own.value = '';
+        own.textContent = 'Моя учетная запись';
+        accountSelect.appendChild(own);
+        (accounts.items || []).forEach((item) => {
+          const option = document.createElement('option');
+          option.value = String(item.id);
+          option.textContent = `${item.login} — ${item.full_name}`;
+          accountSelect.appendChild(option);
+        });
+        document.getElementById('token-account-wrap').hidden = !(accounts.items || []).length;
+      }
+    }
+
+    async function createToken() {
+      const msg = document.getElementById('token-message');
+      const output = document.getElementById('token-value');
+      const payload = {
+        name: document.getElementById('token-name').value.trim(),
+        scopes: Array.from(document.querySelectorAll('#token-scopes input:checked')).map((el) => el.value),
+        expires_at: document.getElementById('token-expires').value,
+        service_account_id: Number(document.getElementById('token-account')?.value || 0)
+      };
+      try {
+        if (!payload.name) throw new Error('Укажите название токена');
+        if (!payload.scopes.length) throw new Error('Выберите хотя бы одну область действия');
+        const data = await api('/api/v1/profile/tokens', { method: 'POST', body: JSON.stringify(payload) });
+        output.value = data.token;
+        output.hidden = false;
+        output.select();
+        msg.textContent = data.message;
+        document.getElementById('token-name').value = '';
+        await loadTokens();
+      } catch (e) {
+        msg.textContent = e.message;
+      }
+    }
+
+    async function loadTwoFactor() {
+      const status = await api('/api/v1/profile/2fa');
+      const label = status.enabled
+        ? `Включена, осталось кодов восстановления: ${status.recovery_codes_left}`
+        : (status.required ? 'Обязательна для вашей роли — настройте ее, чтобы продолжить работу' : 'Отключена');
+      document.getElementById('twofa-status').textContent = label;
+      document.getElementById('twofa-setup-btn').hidden = status.enabled;
+      document.getElementById('twofa-recovery-btn').hidden = !status.enabled;
+      document.getElementById('twofa-disable-btn').hidden = !status.enabled || status.required;
+      if (status.enabled) {
+        document.getElementById('twofa-setup').hidden = true;
+        document.getElementById('twofa-enable-btn').hidden = true;
+      }
+    }
+
+    function showRecoveryCodes(codes) {
+      const box = document.getElementById('twofa-recovery-codes');
+      box.textContent = `Сохраните коды восстановления, они показываются один раз:\n${(codes || []).join('\n')}`;
+      box.hidden = false;
+    }
+
+    async function twoFactorAction(action) {
+      const msg = document.getElementById('twofa-message');
+      const codeInput = document.getElementById('twofa-code');
+      const code = codeInput.value.trim();
+      try {
+        if (action === 'setup') {
+          const data = await api('/api/v1/profile/2fa/setup', { method: 'POST' });
+          document.getElementById('twofa-secret').value = data.secret;
+          document.getElementById('twofa-uri').value = data.provisioning_uri;
+          document.getElementById('twofa-setup').hidden = false;
+          document.getElementById('twofa-enable-btn').hidden = false;
+          msg.textContent = '';
+          return;
+        }
+        if (!code) throw new Error('Введите код из приложения');
+        if (action === 'enable') {
+          const data = await api('/api/v1/profile/2fa/enable', { method: 'POST', body: JSON.stringify({ code }) });
+          showRecoveryCodes(data.recovery_codes);
+          const current = getSession();
+          if (current && current.two_factor_setup_required) {
+            setSession({ ...current, two_factor_setup_required: false });
+            msg.textContent = 'Двухфакторная аутентификация включена, обновите страницу для продолжения работы';
+          } else {
+            msg.textContent = data.message;
+          }
+        } else if (action === 'recovery') {
+          const data = await api('/api/v1/profile/2fa/recovery-codes', { method: 'POST', body: JSON.stringify({ code }) });
+          showRecoveryCodes(data.recovery_codes);
+          msg.textContent = data.message;
+        } else if (action === 'disable') {
+          if (!confirm('Отключить двухфакторную аутентификацию?')) return;
+          const data = await api('/api/v1/profile/2fa/disable', { method: 'POST', body: JSON.stringify({ code }) });
+          document.getElementById('twofa-recovery-codes').hidden = true;
+          msg.textContent = data.message;
+        }
+        codeInput.value = '';
+        await loadTwoFactor();
+      } catch (e) {
+        msg.textContent = e.message;
+      }
+    }
+
+    async function loadSessions() {
+      const data = await api('/api/v1/profile/sessions');
+      const tbody = document.querySelector('#sessions-table tbody');
+      if (!tbody) return;
+      tbody.innerHTML = '';
+      (data.items || []).forEach((item) => {
+        const tr = document.createElement('tr');
+        const device = `${escapeHTML(item.device)}${item.current ? ' <span class="sub">(текущая)</span>' : ''}`;
+        const action = item.current ? '—' : `<button class="btn btn-sm btn-secondary revoke-session-btn" data-id="${item.id}">Завершить</button>`;
+        tr.innerHTML = `<td title="${escapeAttr(item.user_agent)}">${device}</td><td>${escapeHTML(item.ip)}</td><td>${escapeHTML(item.created_at)}</td><td>${escapeHTML(item.last_seen_at)}</td><td>${action}</td>`;
+        tbody.appendChild(tr);
+      });
+      tbody.querySelectorAll('.revoke-session-btn').forEach((btn) => {
+        btn.addEventListener('click', async () => {
+          try {
+            await api(`/api/v1/profile/sessions/${btn.dataset.id}`, { method: 'DELETE' });
+            await loadSessions();
+          } catch (e) { alert(e.message); }
+        });
+      });
+    }
+
+    async function revokeOtherSessions() {
+      if (!confirm('Завершить все сессии, кроме текущей?')) return;
+      try {
+        await api('/api/v1/profile/sessions', { method: 'DELETE' });
+        await loadSessions();
+      } catch (e) { alert(e.message); }
     }
 
     async function saveProfile() {
@@ -1465,6 +1804,7 @@
       const msg = document.getElementById('profile-message');
       try {
         if (!payload.full_name || !payload.position) throw new Error('Заполните ФИО и должность');
+        if (session.password_change_required && !payload.password) throw new Error('Задайте новый пароль');
         if (avatarFile && avatarFile.size > 5 * 1024 * 1024) throw new Error('Размер фото профиля не должен превышать 5 МБ');
         const data = await api('/api/v1/profile', { method: 'PUT', body: JSON.stringify(payload) });
         if (avatarFile) {
@@ -1473,6 +1813,11 @@
           const avatarData = await apiMultipart('/api/v1/profile/avatar', formData);
           data.user.avatar_url = avatarData.avatar_url || data.user.avatar_url || '';
         }
+        if (session.password_change_required) {
+          setSession({ ...data.user, two_factor_setup_required: Boolean(session.two_factor_setup_required) });
+          window.location.reload();
+          return;
+        }
         setSession(data.user);
         renderSessionUser(data.user);
         document.getElementById('profile-password').value = '';
@@ -1818,6 +2163,12 @@
       setView(closeReportDraft.backView || 'tasks');
     });
     document.getElementById('save-profile-btn')?.addEventListener('click', saveProfile);
+    document.getElementById('revoke-other-sessions-btn')?.addEventListener('click', revokeOtherSessions);
+    document.getElementById('create-token-btn')?.addEventListener('click', createToken);
+    document.getElementById('twofa-setup-btn')?.addEventListener('click', () => twoFactorAction('setup'));
+    document.getElementById('twofa-enable-btn')?.addEventListener('click', () => twoFactorAction('enable'));
+    document.getElementById('twofa-recovery-btn')?.addEventListener('click', () => twoFactorAction('recovery'));
+    document.getElementById('twofa-disable-btn')?.addEventListener('click', () => twoFactorAction('disable'));
     document.getElementById('profile-avatar')?.addEventListener('change', (e) => {
       const file = e.target?.files?.[0];
       const preview = document.getElementById('profile-avatar-preview');
@@ -1906,10 +2257,18 @@
       pagination.reports.page += 1;
       await loadReports();
     });
+    if (session.password_change_required || session.two_factor_setup_required) {
+      // до смены пароля или настройки обязательной 2FA сервер отклоняет все запросы, кроме профиля
+      setView('profile');
+      try { await loadProfile(); } catch (e) { alert(e.message); }
+      return;
+    }
     try {
       await loadDepartments();
       if (canManageUsersOnly) {
+        await loadRoles();
         await loadUsers();
+        await loadRegistrations();
       }
       await loadProjects();
       await loadTasks();
@@ -1927,5 +2286,17 @@
     }
   }
 
-  window.TaskFlowClient = { initLoginPage, initRegisterPage, initAppPage };
+  window.TaskFlowClient = { initLoginPage, initRegisterPage, initResetPasswordPage, initAppPage };
+
+  // Страница выбирается атрибутом data-page у тега script: inline-скрипты запрещены CSP.
+  const pageInits = {
+    app: initAppPage,
+    login: initLoginPage,
+    register: initRegisterPage,
+    'reset-password': initResetPasswordPage,
+  };
+  const currentPage = document.currentScript && document.currentScript.dataset.page;
+  if (currentPage && pageInits[currentPage]) {
+    pageInits[currentPage]();
+  }
 })();